@@ -0,0 +1,140 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupPhase is the lifecycle phase of an NFSBackup
+type BackupPhase string
+
+const (
+	// BackupPhasePending means the backup Job has not been created yet
+	BackupPhasePending BackupPhase = "Pending"
+	// BackupPhaseRunning means the backup Job is running
+	BackupPhaseRunning BackupPhase = "Running"
+	// BackupPhaseSucceeded means the backup Job finished successfully
+	BackupPhaseSucceeded BackupPhase = "Succeeded"
+	// BackupPhaseFailed means the backup Job failed
+	BackupPhaseFailed BackupPhase = "Failed"
+)
+
+// NFSBackupSpec defines the desired state of NFSBackup
+type NFSBackupSpec struct {
+	// NFSProvisioner is the name of the NFSProvisioner in the same namespace whose export is backed up.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS Provisioner",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	NFSProvisioner string `json:"nfsProvisioner"`
+
+	// Repository is the S3-compatible object storage that holds the backup archive.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Repository"
+	Repository BackupRepository `json:"repository"`
+
+	// Restore turns this NFSBackup into a restore of an existing snapshot from the repository.
+	// +optional
+	Restore *BackupRestore `json:"restore,omitempty"`
+
+	// Image is the backup tool (restic) image. By default, defaults.BackupImage is used.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// BackupRepository describes where the archive is stored
+type BackupRepository struct {
+	// Endpoint is the URL of the S3-compatible service, e.g. http://minio.minio.svc:9000
+	Endpoint string `json:"endpoint"`
+
+	// Bucket is the bucket the repository lives in.
+	Bucket string `json:"bucket"`
+
+	// Prefix is the path inside the bucket. By default, it is <namespace>/<nfsProvisioner>.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecret is the Secret that holds AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and RESTIC_PASSWORD.
+	CredentialsSecret string `json:"credentialsSecret"`
+
+	// InsecureSkipTLSVerify disables TLS certificate verification for the endpoint.
+	// +optional
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// BackupRestore selects what to restore from the repository
+type BackupRestore struct {
+	// Snapshot is the snapshot ID to restore. By default, the latest snapshot is used.
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Path is the directory relative to the export (e.g. a PV directory) to restore.
+	// The whole export is restored when it is empty.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// NFSBackupStatus defines the observed state of NFSBackup
+type NFSBackupStatus struct {
+	// Phase is the current phase of the backup or restore
+	Phase BackupPhase `json:"phase,omitempty"`
+	// JobName is the Job that runs the backup or restore
+	JobName string `json:"jobName,omitempty"`
+	// StartTime is when the Job was created
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the Job finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// SnapshotID is the snapshot created by the backup
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// FilesNew is the number of files added since the previous snapshot
+	FilesNew int64 `json:"filesNew,omitempty"`
+	// FilesChanged is the number of files changed since the previous snapshot
+	FilesChanged int64 `json:"filesChanged,omitempty"`
+	// BytesAdded is the deduplicated size uploaded to the repository
+	BytesAdded int64 `json:"bytesAdded,omitempty"`
+	// BytesProcessed is the total size of the files read from the export
+	BytesProcessed int64 `json:"bytesProcessed,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type=string,JSONPath=`.spec.nfsProvisioner`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Snapshot",type=string,JSONPath=`.status.snapshotID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NFSBackup is the Schema for the nfsbackups API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Backup",resources={{Job,v1,nfsbackup}}
+type NFSBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSBackupSpec   `json:"spec,omitempty"`
+	Status NFSBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSBackupList contains a list of NFSBackup
+type NFSBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSBackup{}, &NFSBackupList{})
+}
@@ -0,0 +1,89 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NFSBackupScheduleSpec defines the desired state of NFSBackupSchedule
+type NFSBackupScheduleSpec struct {
+	// Schedule is the cron expression the backup runs on, e.g. "0 2 * * *"
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Schedule",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	Schedule string `json:"schedule"`
+
+	// Suspend stops new backups from being scheduled
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// KeepLast is the number of snapshots kept in the repository after each backup.
+	// By default, it keeps 7.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// NFSProvisioner is the name of the NFSProvisioner in the same namespace whose export is backed up.
+	NFSProvisioner string `json:"nfsProvisioner"`
+
+	// Repository is the S3-compatible object storage that holds the backup archive.
+	Repository BackupRepository `json:"repository"`
+
+	// Image is the backup tool (restic) image. By default, defaults.BackupImage is used.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// NFSBackupScheduleStatus defines the observed state of NFSBackupSchedule
+type NFSBackupScheduleStatus struct {
+	// CronJobName is the CronJob that runs the scheduled backups
+	CronJobName string `json:"cronJobName,omitempty"`
+	// LastScheduleTime is the last time a backup was scheduled
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// LastSuccessfulTime is the last time a scheduled backup finished successfully
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type=string,JSONPath=`.spec.nfsProvisioner`
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulTime`
+
+// NFSBackupSchedule is the Schema for the nfsbackupschedules API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Backup Schedule",resources={{CronJob,v1,nfsbackup}}
+type NFSBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSBackupScheduleSpec   `json:"spec,omitempty"`
+	Status NFSBackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSBackupScheduleList contains a list of NFSBackupSchedule
+type NFSBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSBackupSchedule{}, &NFSBackupScheduleList{})
+}
@@ -0,0 +1,183 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BenchmarkPhase is the lifecycle phase of an NFSBenchmark
+type BenchmarkPhase string
+
+const (
+	// BenchmarkPhasePending means the workload has not been started yet
+	BenchmarkPhasePending BenchmarkPhase = "Pending"
+	// BenchmarkPhaseRunning means the workload pods are running
+	BenchmarkPhaseRunning BenchmarkPhase = "Running"
+	// BenchmarkPhaseSucceeded means the results are recorded
+	BenchmarkPhaseSucceeded BenchmarkPhase = "Succeeded"
+	// BenchmarkPhaseFailed means the workload or the parsing of its output failed
+	BenchmarkPhaseFailed BenchmarkPhase = "Failed"
+)
+
+// BenchmarkPattern is the IO pattern of the workload
+// +kubebuilder:validation:Enum=SequentialRead;SequentialWrite;RandomRead;RandomWrite;RandomReadWrite
+type BenchmarkPattern string
+
+const (
+	// BenchmarkSequentialRead reads the files sequentially
+	BenchmarkSequentialRead BenchmarkPattern = "SequentialRead"
+	// BenchmarkSequentialWrite writes the files sequentially
+	BenchmarkSequentialWrite BenchmarkPattern = "SequentialWrite"
+	// BenchmarkRandomRead reads blocks at random offsets
+	BenchmarkRandomRead BenchmarkPattern = "RandomRead"
+	// BenchmarkRandomWrite writes blocks at random offsets
+	BenchmarkRandomWrite BenchmarkPattern = "RandomWrite"
+	// BenchmarkRandomReadWrite mixes random reads and writes
+	BenchmarkRandomReadWrite BenchmarkPattern = "RandomReadWrite"
+)
+
+// NFSBenchmarkSpec defines the desired state of NFSBenchmark
+type NFSBenchmarkSpec struct {
+	// NFSProvisioner is the name of the NFSProvisioner in the same namespace whose StorageClass is benchmarked.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS Provisioner",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	NFSProvisioner string `json:"nfsProvisioner"`
+
+	// Profile is the workload that fio runs in each pod
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Profile"
+	Profile BenchmarkProfile `json:"profile"`
+
+	// Pods is the number of workload pods that run at the same time on the temporary PVC. Default value is 1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=32
+	// +optional
+	Pods int32 `json:"pods,omitempty"`
+
+	// NodeSelector selects the nodes the workload pods run on
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Image is an image with a shell and fio. By default, defaults.BenchmarkImage is used.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// BenchmarkProfile is a fio workload
+type BenchmarkProfile struct {
+	// Pattern is the IO pattern
+	Pattern BenchmarkPattern `json:"pattern"`
+
+	// BlockSize is the size of each IO, e.g. 4k or 1m. Default value is `1m` for sequential and `4k` for random patterns
+	// +kubebuilder:validation:Pattern=`^[0-9]+[kKmM]?$`
+	// +optional
+	BlockSize string `json:"blockSize,omitempty"`
+
+	// Parallelism is the number of fio jobs in each pod, each with its own file. Default value is 1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=64
+	// +optional
+	Parallelism int32 `json:"parallelism,omitempty"`
+
+	// IODepth is the number of IOs in flight for each fio job. Default value is 16
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=256
+	// +optional
+	IODepth int32 `json:"ioDepth,omitempty"`
+
+	// FileSize is the size of the file of each fio job. Default value is `1Gi`
+	// +optional
+	FileSize *resource.Quantity `json:"fileSize,omitempty"`
+
+	// Duration is how long the IOs run, after the files are laid out. Default value is `60s`
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// BenchmarkResult is the performance of one direction of IO, summed over the workload pods
+type BenchmarkResult struct {
+	// BandwidthBytesPerSecond is the throughput of all pods
+	BandwidthBytesPerSecond int64 `json:"bandwidthBytesPerSecond"`
+	// IOPS is the number of IOs per second of all pods
+	IOPS int64 `json:"iops"`
+	// LatencyMeanMicroseconds is the mean completion latency of the IOs
+	LatencyMeanMicroseconds int64 `json:"latencyMeanMicroseconds"`
+	// LatencyP50Microseconds is the median completion latency. With several pods, it is the highest of the pods
+	LatencyP50Microseconds int64 `json:"latencyP50Microseconds"`
+	// LatencyP90Microseconds is the 90th percentile of the completion latency. With several pods, it is the highest of the pods
+	LatencyP90Microseconds int64 `json:"latencyP90Microseconds"`
+	// LatencyP99Microseconds is the 99th percentile of the completion latency. With several pods, it is the highest of the pods
+	LatencyP99Microseconds int64 `json:"latencyP99Microseconds"`
+	// LatencyP999Microseconds is the 99.9th percentile of the completion latency. With several pods, it is the highest of the pods
+	LatencyP999Microseconds int64 `json:"latencyP999Microseconds"`
+}
+
+// NFSBenchmarkStatus defines the observed state of NFSBenchmark
+type NFSBenchmarkStatus struct {
+	// Phase is the current phase of the benchmark
+	Phase BenchmarkPhase `json:"phase,omitempty"`
+	// StorageClass is the StorageClass of the temporary PVC
+	StorageClass string `json:"storageClass,omitempty"`
+	// PVC is the temporary PVC the workload runs on. It is deleted when the benchmark finished
+	PVC string `json:"pvc,omitempty"`
+	// JobName is the Job of the workload pods
+	JobName string `json:"jobName,omitempty"`
+	// Nodes are the nodes the workload pods ran on
+	Nodes []string `json:"nodes,omitempty"`
+	// StartTime is when the Job was created
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the results were recorded
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Read is the performance of the reads
+	Read *BenchmarkResult `json:"read,omitempty"`
+	// Write is the performance of the writes
+	Write *BenchmarkResult `json:"write,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type=string,JSONPath=`.spec.nfsProvisioner`
+// +kubebuilder:printcolumn:name="Pattern",type=string,JSONPath=`.spec.profile.pattern`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Read IOPS",type=integer,JSONPath=`.status.read.iops`
+// +kubebuilder:printcolumn:name="Write IOPS",type=integer,JSONPath=`.status.write.iops`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NFSBenchmark is the Schema for the nfsbenchmarks API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Benchmark",resources={{Job,v1,nfsbenchmark},{PersistentVolumeClaim,v1,nfsbenchmark}}
+type NFSBenchmark struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSBenchmarkSpec   `json:"spec,omitempty"`
+	Status NFSBenchmarkStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSBenchmarkList contains a list of NFSBenchmark
+type NFSBenchmarkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSBenchmark `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSBenchmark{}, &NFSBenchmarkList{})
+}
@@ -0,0 +1,131 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImportPhase is the lifecycle phase of an NFSImport
+type ImportPhase string
+
+const (
+	// ImportPhasePending means the scan Job has not been created yet
+	ImportPhasePending ImportPhase = "Pending"
+	// ImportPhaseScanning means the scan Job is running
+	ImportPhaseScanning ImportPhase = "Scanning"
+	// ImportPhaseScanned means the directories are listed in the status and wait for spec.apply
+	ImportPhaseScanned ImportPhase = "Scanned"
+	// ImportPhaseCompleted means the PVs (and PVCs) were created
+	ImportPhaseCompleted ImportPhase = "Completed"
+	// ImportPhaseFailed means the scan failed
+	ImportPhaseFailed ImportPhase = "Failed"
+)
+
+// NFSImportSpec defines the desired state of NFSImport
+type NFSImportSpec struct {
+	// NFSProvisioner is the name of the NFSProvisioner in the same namespace whose export is scanned.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS Provisioner",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	NFSProvisioner string `json:"nfsProvisioner"`
+
+	// Apply creates the PVs of the scanned directories. Leave it false to review the scan result in the status first.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Apply",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Apply bool `json:"apply,omitempty"`
+
+	// CreateClaims creates a PVC bound to each imported PV in its original namespace when it is known.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Create Claims",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	CreateClaims bool `json:"createClaims,omitempty"`
+
+	// Directories limits the import to these directories of the export. All directories are imported when it is empty.
+	// +optional
+	Directories []string `json:"directories,omitempty"`
+
+	// StorageClassName is set on the imported PVs that do not record one. By default, the StorageClass of the NFSProvisioner is used.
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+}
+
+// ImportedVolume is a directory found on the export
+type ImportedVolume struct {
+	// Directory is the directory relative to the export
+	Directory string `json:"directory"`
+	// SizeBytes is the disk usage of the directory
+	SizeBytes int64 `json:"sizeBytes"`
+	// PersistentVolume is the PV name that is used for the directory
+	PersistentVolume string `json:"persistentVolume,omitempty"`
+	// ClaimNamespace is the namespace of the original PVC when it is known
+	ClaimNamespace string `json:"claimNamespace,omitempty"`
+	// ClaimName is the name of the original PVC when it is known
+	ClaimName string `json:"claimName,omitempty"`
+	// Capacity is the capacity of the PV
+	Capacity string `json:"capacity,omitempty"`
+	// AccessModes are the access modes of the PV
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// StorageClassName is the StorageClass of the PV
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// MountOptions are the mount options of the PV
+	MountOptions []string `json:"mountOptions,omitempty"`
+	// Imported is true when the PV was created by this import
+	Imported bool `json:"imported,omitempty"`
+	// Message explains why the directory was skipped
+	Message string `json:"message,omitempty"`
+}
+
+// NFSImportStatus defines the observed state of NFSImport
+type NFSImportStatus struct {
+	// Phase is the current phase of the import
+	Phase ImportPhase `json:"phase,omitempty"`
+	// JobName is the Job that scans the export
+	JobName string `json:"jobName,omitempty"`
+	// Volumes are the directories found on the export
+	Volumes []ImportedVolume `json:"volumes,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type=string,JSONPath=`.spec.nfsProvisioner`
+// +kubebuilder:printcolumn:name="Apply",type=boolean,JSONPath=`.spec.apply`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NFSImport is the Schema for the nfsimports API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Import",resources={{Job,v1,nfsimport},{PersistentVolume,v1,nfsimport},{PersistentVolumeClaim,v1,nfsimport}}
+type NFSImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSImportSpec   `json:"spec,omitempty"`
+	Status NFSImportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSImportList contains a list of NFSImport
+type NFSImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSImport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSImport{}, &NFSImportList{})
+}
@@ -18,15 +18,60 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ProvisionerMode selects where the NFS server comes from
+// +kubebuilder:validation:Enum=Internal;External
+type ProvisionerMode string
+
+const (
+	// ModeInternal deploys an NFS server and its provisioner
+	ModeInternal ProvisionerMode = "Internal"
+	// ModeExternal uses an existing NFS server and deploys only a subdirectory provisioner
+	ModeExternal ProvisionerMode = "External"
+)
+
+// ServerImplementation is the NFS server the operator deploys
+// +kubebuilder:validation:Enum=ganesha;go
+type ServerImplementation string
+
+const (
+	// ServerImplementationGanesha deploys the NFS-Ganesha provisioner image
+	ServerImplementationGanesha ServerImplementation = "ganesha"
+	// ServerImplementationGo deploys the userspace NFSv3 server of this repository, which runs unprivileged
+	ServerImplementationGo ServerImplementation = "go"
+)
+
 // NFSProvisionerSpec defines the desired state of NFSProvisioner
+// +kubebuilder:validation:XValidation:rule="(has(self.serverImplementation) ? self.serverImplementation : 'ganesha') == (has(oldSelf.serverImplementation) ? oldSelf.serverImplementation : 'ganesha')",message="serverImplementation is immutable"
 type NFSProvisionerSpec struct {
+	// Mode is Internal to deploy an NFS server, or External to use an existing NFS server. Default value is `Internal`
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Mode",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:select:Internal","urn:alm:descriptor:com.tectonic.ui:select:External"}
+	// +optional
+	Mode ProvisionerMode `json:"mode,omitempty"`
+
+	// ServerImplementation is ganesha for the NFS-Ganesha provisioner image, or go for the userspace NFSv3 server of the operator,
+	// which runs under the restricted SCC and Pod Security Standard. Default value is `ganesha`
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Server Implementation",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:select:ganesha","urn:alm:descriptor:com.tectonic.ui:select:go"}
+	// +optional
+	ServerImplementation ServerImplementation `json:"serverImplementation,omitempty"`
+
+	// External is the existing NFS server that is used in External mode
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="External NFS Server"
+	// +optional
+	External *ExternalNFSServer `json:"external,omitempty"`
+
 	// HostPathDir is the direcotry where NFS server will use.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="HostPath directory",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:string", "urn:alm:descriptor:io.kubernetes:custom"}
 	HostPathDir string `json:"hostPathDir,omitempty"`
 
+	// HostPathPreparation creates HostPathDir on the node and applies its SELinux context before the NFS server starts
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="HostPath Preparation"
+	// +optional
+	HostPathPreparation *HostPathPreparation `json:"hostPathPreparation,omitempty"`
+
 	// PVC Name is the PVC resource that already created for NFS server.
 	// Do not set StorageClass name with this param. Then, operator will fail to deploy NFS Server.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="PVC Name",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:string", "urn:alm:descriptor:io.kubernetes:custom"}
@@ -46,15 +91,149 @@ type NFSProvisionerSpec struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
 
+	// NodeSelection chooses the node of the NFS server in hostPath mode
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Node Selection"
+	// +optional
+	NodeSelection *NodeSelection `json:"nodeSelection,omitempty"`
+
+	// Topology restricts the StorageClass to the zone of the node the NFS server runs on
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Topology"
+	// +optional
+	Topology *TopologyConfiguration `json:"topology,omitempty"`
+
+	// Standby runs a second NFS server on another node that the export is replicated to, and fails over to it
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Standby"
+	// +optional
+	Standby *StandbyConfiguration `json:"standby,omitempty"`
+
+	// CSI deploys the CSI driver of the operator alongside the external provisioner, with its own StorageClass
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="CSI"
+	// +optional
+	CSI *CSIConfiguration `json:"csi,omitempty"`
+
 	// StorageClass Name for NFS Provisioner is the StorageClass name that NFS Provisioner will use. Default value is `nfs`
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="StorageClass Name for NFS Provisioner",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:string","urn:alm:descriptor:io.kubernetes:custom"}
 	SCForNFSProvisioner string `json:"scForNFS,omitempty"` //https://golang.org/pkg/encoding/json/
 
+	// ProvisionerName is the provisioner of the StorageClass. Default value is `example.com/nfs`
+	// +optional
+	ProvisionerName string `json:"provisionerName,omitempty"`
+
 	// NFSImageConfigurations hold the image configuration
 	// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Image Configuration,resources={{pod,v1,test}}"
 	NFSImageConfiguration *ImageConfiguration `json:"nfsImageConfiguration,omitempty"`
+
+	// Upgrade controls how a change of the image of the NFS server is rolled out
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Upgrade"
+	// +optional
+	Upgrade *UpgradeConfiguration `json:"upgrade,omitempty"`
+
+	// OrphanAudit finds directories on the export that no PV references, and optionally reclaims them
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Orphan Audit"
+	// +optional
+	OrphanAudit *OrphanAuditConfiguration `json:"orphanAudit,omitempty"`
+
+	// Quota enforces the requested size of each volume with XFS project quotas
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Quota"
+	// +optional
+	Quota *QuotaConfiguration `json:"quota,omitempty"`
+
+	// UsageReport measures the disk usage of every volume periodically and summarises it per namespace
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Usage Report"
+	// +optional
+	UsageReport *UsageReportConfiguration `json:"usageReport,omitempty"`
+
+	// Canary provisions, writes and reads a volume of the StorageClass periodically and reports the result in the CanaryHealthy condition
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Canary"
+	// +optional
+	Canary *CanaryConfiguration `json:"canary,omitempty"`
+
+	// Paused stops the operator from changing the resources of this NFSProvisioner, e.g. during a manual change of the storage.
+	// The status is still updated. The nfsprovisioner.jhouse.com/paused annotation set to "true" has the same effect
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Paused",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Maintenance scales the NFS server to zero and refuses new PVCs of the StorageClass. The operator is paused meanwhile
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Maintenance"
+	// +optional
+	Maintenance *MaintenanceConfiguration `json:"maintenance,omitempty"`
+
+	// Admission limits the PVCs that are created with the StorageClass of this NFSProvisioner
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Admission"
+	// +optional
+	Admission *AdmissionConfiguration `json:"admission,omitempty"`
+
+	// Ganesha overrides the export options of the NFS-Ganesha server. The image defaults are used when it is empty
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS-Ganesha Configuration"
+	// +optional
+	Ganesha *GaneshaConfiguration `json:"ganesha,omitempty"`
+
+	// LogLevel sets the log level of NFS-Ganesha and the verbosity of the provisioner
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Log Level",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:select:Error","urn:alm:descriptor:com.tectonic.ui:select:Warning","urn:alm:descriptor:com.tectonic.ui:select:Info","urn:alm:descriptor:com.tectonic.ui:select:Debug","urn:alm:descriptor:com.tectonic.ui:select:Trace"}
+	// +optional
+	LogLevel LogLevel `json:"logLevel,omitempty"`
+
+	// ExtraArgs are added to the arguments of the provisioner, e.g. `-failed-retry-threshold=5`.
+	// Only known flags are accepted unless AllowUnknownArgs is set.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra Arguments"
+	// +optional
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+
+	// AllowUnknownArgs accepts ExtraArgs that are not known flags of the provisioner. Flags the operator sets are still refused
+	// +optional
+	AllowUnknownArgs bool `json:"allowUnknownArgs,omitempty"`
+
+	// ExtraEnv are added to the environment of the NFS server container
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra Environment Variables"
+	// +optional
+	ExtraEnv []corev1.EnvVar `json:"extraEnv,omitempty"`
+
+	// ExtraContainers are added to the NFS server pod, e.g. a log shipping sidecar.
+	// The schema is not embedded in the CRD to keep it small, so the containers are validated when the pod is created.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra Containers"
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	ExtraContainers []corev1.Container `json:"extraContainers,omitempty"`
+
+	// InitContainers run before the NFS server starts
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Init Containers"
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// ExtraVolumes are added to the NFS server pod
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra Volumes"
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	ExtraVolumes []corev1.Volume `json:"extraVolumes,omitempty"`
+
+	// ExtraVolumeMounts are added to the NFS server container, e.g. a CA bundle from ExtraVolumes
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra Volume Mounts"
+	// +optional
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty"`
 }
 
+// LogLevel is the amount of logs of the NFS server
+// +kubebuilder:validation:Enum=Error;Warning;Info;Debug;Trace
+type LogLevel string
+
+const (
+	// LogLevelError only logs errors
+	LogLevelError LogLevel = "Error"
+	// LogLevelWarning logs warnings and errors
+	LogLevelWarning LogLevel = "Warning"
+	// LogLevelInfo is the default of the image
+	LogLevelInfo LogLevel = "Info"
+	// LogLevelDebug logs details for troubleshooting
+	LogLevelDebug LogLevel = "Debug"
+	// LogLevelTrace logs everything
+	LogLevelTrace LogLevel = "Trace"
+)
+
 // NFSProvisionerStatus defines the observed state of NFSProvisioner
 type NFSProvisionerStatus struct {
 
@@ -62,14 +241,773 @@ type NFSProvisionerStatus struct {
 	Nodes []string `json:"nodes"`
 	// Error show error messages briefly
 	Error string `json:"error"`
+
+	// Pvc is the operator managed PVC that backs the export after a storage migration.
+	// When it is empty, the default PVC name is used.
+	// +optional
+	Pvc string `json:"pvc,omitempty"`
+
+	// Migration shows the progress of the last storage migration
+	// +optional
+	Migration *MigrationStatus `json:"migration,omitempty"`
+
+	// OrphanAudit shows the result of the last orphaned directory audit
+	// +optional
+	OrphanAudit *OrphanAuditStatus `json:"orphanAudit,omitempty"`
+
+	// Quota shows the usage of the volumes when quotas are enabled
+	// +optional
+	Quota *QuotaStatus `json:"quota,omitempty"`
+
+	// Usage shows the largest volumes and the usage per namespace of the last scan of the usage report
+	// +optional
+	Usage *UsageStatus `json:"usage,omitempty"`
+
+	// Canary shows the result of the last canary run
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+
+	// Upgrade shows the image the NFS server runs and the steps of the last image upgrade
+	// +optional
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// Pause shows who paused the operator, when and whether the NFS server is stopped for maintenance
+	// +optional
+	Pause *PauseStatus `json:"pause,omitempty"`
+
+	// Export shows the capacity of the export when admission checks the free space
+	// +optional
+	Export *ExportCapacityStatus `json:"export,omitempty"`
+
+	// SelectedNode is the node the NFS server runs on in hostPath mode
+	// +optional
+	SelectedNode string `json:"selectedNode,omitempty"`
+
+	// Topology shows the zone the StorageClass is restricted to
+	// +optional
+	Topology *TopologyStatus `json:"topology,omitempty"`
+
+	// Standby shows the replication to the standby and which server is active
+	// +optional
+	Standby *StandbyStatus `json:"standby,omitempty"`
+
+	// CSI shows the CSI driver and its StorageClass
+	// +optional
+	CSI *CSIStatus `json:"csi,omitempty"`
+
+	// Conditions represent the latest available observations of the NFSProvisioner
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors={"urn:alm:descriptor:io.kubernetes.conditions"}
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// MigrationPhase is the step a storage migration is in
+type MigrationPhase string
+
+const (
+	// MigrationPhasePending means the storage spec changed but the migration is not acknowledged yet
+	MigrationPhasePending MigrationPhase = "Pending"
+	// MigrationPhaseProvisioning means the target volume is being provisioned
+	MigrationPhaseProvisioning MigrationPhase = "Provisioning"
+	// MigrationPhaseScalingDown means the NFS server is being stopped
+	MigrationPhaseScalingDown MigrationPhase = "ScalingDown"
+	// MigrationPhaseCopying means the data is being copied and verified
+	MigrationPhaseCopying MigrationPhase = "Copying"
+	// MigrationPhaseSwitching means the NFS server is being switched to the target volume
+	MigrationPhaseSwitching MigrationPhase = "Switching"
+	// MigrationPhaseAwaitingConfirmation means the server runs on the target volume and the source volume is kept until the user confirms
+	MigrationPhaseAwaitingConfirmation MigrationPhase = "AwaitingConfirmation"
+	// MigrationPhaseCompleted means the migration finished
+	MigrationPhaseCompleted MigrationPhase = "Completed"
+	// MigrationPhaseFailed means the migration failed and the NFS server was restored on the source volume
+	MigrationPhaseFailed MigrationPhase = "Failed"
+)
+
+// MigrationVolume describes one side of a storage migration
+type MigrationVolume struct {
+	// HostPathDir is set when the volume is a hostPath directory
+	HostPathDir string `json:"hostPathDir,omitempty"`
+	// Pvc is set when the volume is a PVC
+	Pvc string `json:"pvc,omitempty"`
+	// Managed is true when the PVC is created and owned by the operator
+	Managed bool `json:"managed,omitempty"`
+}
+
+// QuotaConfiguration configures XFS project quotas on the export
+type QuotaConfiguration struct {
+	// Enabled passes -enable-xfs-quota to the provisioner once the export is verified to be XFS mounted with prjquota
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// UsageInterval is how often the usage of the volumes is measured. Default value is `1h`
+	// +optional
+	UsageInterval *metav1.Duration `json:"usageInterval,omitempty"`
+}
+
+// VolumeUsage is the disk usage of a volume against its requested size, and the PVC it belongs to
+type VolumeUsage struct {
+	// PersistentVolume is the PV of the directory
+	PersistentVolume string `json:"persistentVolume"`
+	// ClaimNamespace is the namespace of the PVC of the PV
+	// +optional
+	ClaimNamespace string `json:"claimNamespace,omitempty"`
+	// ClaimName is the PVC of the PV
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
+	// Directory is the directory relative to the export
+	Directory string `json:"directory"`
+	// CapacityBytes is the capacity of the PV
+	CapacityBytes int64 `json:"capacityBytes"`
+	// UsedBytes is the disk usage of the directory
+	UsedBytes int64 `json:"usedBytes"`
+}
+
+// QuotaStatus shows the usage of the volumes
+type QuotaStatus struct {
+	// LastUsageTime is when the usage was last measured
+	LastUsageTime *metav1.Time `json:"lastUsageTime,omitempty"`
+	// Volumes is the usage of each volume of the export
+	Volumes []VolumeUsage `json:"volumes,omitempty"`
+}
+
+// UsageReportConfiguration configures the periodic scan of the disk usage of the volumes
+type UsageReportConfiguration struct {
+	// Enabled scans the export with a low priority Job every Interval
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Interval is how often the export is scanned. Default value is `6h`
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// TopConsumers is how many of the largest volumes are listed in the status. Default value is 10
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	TopConsumers *int32 `json:"topConsumers,omitempty"`
+}
+
+// UsageReportFormat is the format of the usage report ConfigMap
+// +kubebuilder:validation:Enum=csv;json
+type UsageReportFormat string
+
+const (
+	// UsageReportCSV writes one line per volume
+	UsageReportCSV UsageReportFormat = "csv"
+	// UsageReportJSON writes the volumes and the totals per namespace
+	UsageReportJSON UsageReportFormat = "json"
+)
+
+// NamespaceUsage is the disk usage of the volumes claimed in a namespace
+type NamespaceUsage struct {
+	// Namespace of the PVCs. It is empty for the PVs that are not claimed
+	Namespace string `json:"namespace"`
+	// Volumes is the number of volumes
+	Volumes int32 `json:"volumes"`
+	// CapacityBytes is the capacity of the volumes
+	CapacityBytes int64 `json:"capacityBytes"`
+	// UsedBytes is the disk usage of the volumes
+	UsedBytes int64 `json:"usedBytes"`
+}
+
+// UsageStatus summarises the disk usage of the volumes
+type UsageStatus struct {
+	// LastScanTime is when the usage was last measured
+	// +optional
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+	// Volumes is the number of volumes found by the last scan
+	// +optional
+	Volumes int32 `json:"volumes,omitempty"`
+	// CapacityBytes is the capacity of all volumes
+	// +optional
+	CapacityBytes int64 `json:"capacityBytes,omitempty"`
+	// UsedBytes is the disk usage of all volumes
+	// +optional
+	UsedBytes int64 `json:"usedBytes,omitempty"`
+	// TopConsumers are the largest volumes, largest first
+	// +optional
+	TopConsumers []VolumeUsage `json:"topConsumers,omitempty"`
+	// Namespaces is the usage per namespace, largest first
+	// +optional
+	Namespaces []NamespaceUsage `json:"namespaces,omitempty"`
+	// Report is the last report requested with the usage-report annotation
+	// +optional
+	Report *UsageReportStatus `json:"report,omitempty"`
+	// Message explains why the last scan or report failed
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// UsageReportStatus points to the ConfigMap of a usage report
+type UsageReportStatus struct {
+	// ConfigMap holds the report in the key usage.csv or usage.json
+	ConfigMap string `json:"configMap"`
+	// Format of the report
+	Format UsageReportFormat `json:"format"`
+	// ScanTime is when the usage in the report was measured
+	ScanTime metav1.Time `json:"scanTime"`
+}
+
+// CanaryConfiguration configures the synthetic check of the StorageClass
+type CanaryConfiguration struct {
+	// Enabled runs the canary every Interval
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Interval is the pause between the start of two runs. Default value is `10m`
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Timeout is how long a run may take from the creation of the PVC to the checksum. Default value is `5m`
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// CanaryStatus shows the canary run in progress and the result of the last one
+type CanaryStatus struct {
+	// Run is the name of the PVC and the Job of the run in progress
+	// +optional
+	Run string `json:"run,omitempty"`
+	// LastRunTime is when the last run started
+	// +optional
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+	// LastSuccessTime is when the last successful run started
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// ConsecutiveFailures is the number of failed runs since the last successful one
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+	// ProvisionMilliseconds is the time from the creation of the PVC to the creation of its PV in the last successful run
+	// +optional
+	ProvisionMilliseconds int64 `json:"provisionMilliseconds,omitempty"`
+	// WriteMilliseconds is the time to write and sync the test file in the last successful run
+	// +optional
+	WriteMilliseconds int64 `json:"writeMilliseconds,omitempty"`
+	// ReadMilliseconds is the time to read the test file back in the last successful run
+	// +optional
+	ReadMilliseconds int64 `json:"readMilliseconds,omitempty"`
+}
+
+// UpgradeConfiguration configures the managed upgrade of the image of the NFS server
+type UpgradeConfiguration struct {
+	// Window restricts the rollout of a new image to a daily maintenance window. The image is checked right away
+	// +optional
+	Window *UpgradeWindow `json:"window,omitempty"`
+
+	// Backup takes a NFSBackup of the export to this repository before the rollout. The upgrade stops when the backup fails
+	// +optional
+	Backup *BackupRepository `json:"backup,omitempty"`
+
+	// Timeout is how long the image check, the rollout and the verification may each take. Default value is `10m`
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// UpgradeWindow is a daily time window
+type UpgradeWindow struct {
+	// Start is the time of the day the window opens, in UTC, e.g. `02:00`
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Duration is how long the window stays open
+	Duration metav1.Duration `json:"duration"`
+}
+
+// UpgradePhase is the step an image upgrade is in
+type UpgradePhase string
+
+const (
+	// UpgradePhasePullCheck runs a pod with the new image to check that it can be pulled
+	UpgradePhasePullCheck UpgradePhase = "PullCheck"
+	// UpgradePhaseWaitingForWindow waits for the maintenance window
+	UpgradePhaseWaitingForWindow UpgradePhase = "WaitingForWindow"
+	// UpgradePhaseBackup waits for the pre-upgrade backup of the export
+	UpgradePhaseBackup UpgradePhase = "Backup"
+	// UpgradePhaseRolling rolls the NFS server to the new image and waits for it to be ready
+	UpgradePhaseRolling UpgradePhase = "Rolling"
+	// UpgradePhaseVerifying waits for a canary run on the new image
+	UpgradePhaseVerifying UpgradePhase = "Verifying"
+	// UpgradePhaseRollingBack rolls the NFS server back to the previous image
+	UpgradePhaseRollingBack UpgradePhase = "RollingBack"
+	// UpgradePhaseSucceeded means the NFS server runs the new image
+	UpgradePhaseSucceeded UpgradePhase = "Succeeded"
+	// UpgradePhaseFailed means the upgrade stopped before the NFS server was changed
+	UpgradePhaseFailed UpgradePhase = "Failed"
+	// UpgradePhaseRolledBack means the NFS server runs the previous image again
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+)
+
+// UpgradeStep is a step of an image upgrade
+type UpgradeStep struct {
+	// Phase is the phase the upgrade entered
+	Phase UpgradePhase `json:"phase"`
+	// Time is when the upgrade entered the phase
+	Time metav1.Time `json:"time"`
+	// Message explains the step
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// UpgradeStatus shows the image of the NFS server and the progress of an image upgrade
+type UpgradeStatus struct {
+	// CurrentImage is the image the NFS server runs, and rolls back to
+	CurrentImage string `json:"currentImage"`
+	// ServerImplementation is the implementation of CurrentImage. Switching the implementation replaces the server without an upgrade
+	// +optional
+	ServerImplementation ServerImplementation `json:"serverImplementation,omitempty"`
+	// TargetImage is the image of the upgrade in progress or of the last one
+	// +optional
+	TargetImage string `json:"targetImage,omitempty"`
+	// PreviousImage is the image before the last successful upgrade
+	// +optional
+	PreviousImage string `json:"previousImage,omitempty"`
+	// Phase is the current step of the upgrade
+	// +optional
+	Phase UpgradePhase `json:"phase,omitempty"`
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+	// StartTime is when the upgrade started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the upgrade finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Backup is the NFSBackup taken before the rollout
+	// +optional
+	Backup string `json:"backup,omitempty"`
+	// Steps are the steps of the upgrade, oldest first
+	// +optional
+	Steps []UpgradeStep `json:"steps,omitempty"`
+}
+
+// MaintenanceConfiguration stops the NFS server for a maintenance of its storage
+type MaintenanceConfiguration struct {
+	// Enabled scales the NFS server to zero. It is scaled back when maintenance is disabled
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Reason is shown in the status and in the message of the refused PVCs
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// PauseMode is why the operator does not change the resources
+type PauseMode string
+
+const (
+	// PauseModePaused only stops the reconciliation of the resources
+	PauseModePaused PauseMode = "Paused"
+	// PauseModeMaintenance also stops the NFS server and refuses new PVCs
+	PauseModeMaintenance PauseMode = "Maintenance"
+)
+
+// PauseStatus shows since when and by whom the NFSProvisioner is paused
+type PauseStatus struct {
+	// Mode is Paused or Maintenance
+	Mode PauseMode `json:"mode"`
+	// Since is when the operator saw the NFSProvisioner paused
+	// +optional
+	Since *metav1.Time `json:"since,omitempty"`
+	// By is the user who paused the NFSProvisioner, as recorded by the admission webhook
+	// +optional
+	By string `json:"by,omitempty"`
+	// Reason is the reason of the maintenance
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// ServerStopped is true when all the pods of the NFS server are gone during a maintenance
+	// +optional
+	ServerStopped bool `json:"serverStopped,omitempty"`
+}
+
+// HostPathPreparation configures how HostPathDir is prepared on the node
+type HostPathPreparation struct {
+	// Enabled runs a privileged Job on the node that creates the directory
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	Enabled bool `json:"enabled,omitempty"`
+
+	// UID is the owner of the directory. Default value is `0`
+	// +optional
+	UID *int64 `json:"uid,omitempty"`
+
+	// GID is the group of the directory. Default value is `0`
+	// +optional
+	GID *int64 `json:"gid,omitempty"`
+
+	// Mode is the octal permission of the directory, e.g. `0775`. The mode is kept when it is empty
+	// +kubebuilder:validation:Pattern=`^0?[0-7]{3}$`
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// SELinuxType is the SELinux type the directory is labelled with on SELinux enabled nodes. Default value is `container_file_t`
+	// +optional
+	SELinuxType string `json:"selinuxType,omitempty"`
+
+	// SkipSELinuxRelabel leaves the SELinux context of the directory as it is
+	// +optional
+	SkipSELinuxRelabel bool `json:"skipSELinuxRelabel,omitempty"`
+}
+
+// FailoverPolicy is whether the operator fails over to the standby by itself
+// +kubebuilder:validation:Enum=Automatic;Manual
+type FailoverPolicy string
+
+const (
+	// FailoverAutomatic fails over when the primary is unavailable for FailoverAfter
+	FailoverAutomatic FailoverPolicy = "Automatic"
+	// FailoverManual fails over only when the failover annotation is set
+	FailoverManual FailoverPolicy = "Manual"
+)
+
+// StandbyConfiguration configures the standby NFS server
+type StandbyConfiguration struct {
+	// Enabled runs the standby NFS server and the replication
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	Enabled bool `json:"enabled,omitempty"`
+
+	// StorageSize is the size of the PVC of the standby. Default value is the storageSize of the primary
+	// +optional
+	StorageSize string `json:"storageSize,omitempty"`
+
+	// SCForNFSPvc is the StorageClass of the PVC of the standby. Default value is the scForNFSPvc of the primary
+	// +optional
+	SCForNFSPvc string `json:"scForNFSPvc,omitempty"`
+
+	// SyncInterval is the pause between two replication runs. Default value is `1m`
+	// +optional
+	SyncInterval *metav1.Duration `json:"syncInterval,omitempty"`
+
+	// FailoverAfter is how long the primary is unavailable before an automatic failover. Default value is `2m`
+	// +optional
+	FailoverAfter *metav1.Duration `json:"failoverAfter,omitempty"`
+
+	// Failover is Automatic or Manual. Default value is `Automatic`
+	// +optional
+	Failover FailoverPolicy `json:"failover,omitempty"`
+}
+
+// StandbyRole is the server that exports the volume
+type StandbyRole string
+
+const (
+	// StandbyRolePrimary means the primary NFS server is active
+	StandbyRolePrimary StandbyRole = "Primary"
+	// StandbyRoleStandby means the NFS server failed over to the standby
+	StandbyRoleStandby StandbyRole = "Standby"
+)
+
+// StandbyPhase is the state of the standby
+type StandbyPhase string
+
+const (
+	// StandbyPhaseReplicating means the export is replicated to the standby
+	StandbyPhaseReplicating StandbyPhase = "Replicating"
+	// StandbyPhaseDegraded means the primary or the replication is unavailable
+	StandbyPhaseDegraded StandbyPhase = "Degraded"
+	// StandbyPhaseFencing means the primary is being stopped before the standby is promoted
+	StandbyPhaseFencing StandbyPhase = "Fencing"
+	// StandbyPhaseFailedOver means the standby is the NFS server
+	StandbyPhaseFailedOver StandbyPhase = "FailedOver"
+)
+
+// StandbyStatus shows the replication to the standby
+type StandbyStatus struct {
+	// Active is the server the Service points to
+	Active StandbyRole `json:"active,omitempty"`
+	// Phase is Replicating, Degraded, Fencing or FailedOver
+	Phase StandbyPhase `json:"phase,omitempty"`
+	// LastSyncTime is when the last replication run finished
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LagSeconds is the age of the data on the standby
+	// +optional
+	LagSeconds int64 `json:"lagSeconds,omitempty"`
+	// PrimaryUnavailableSince is when the primary became unavailable
+	// +optional
+	PrimaryUnavailableSince *metav1.Time `json:"primaryUnavailableSince,omitempty"`
+	// FailoverTime is when the standby was promoted
+	// +optional
+	FailoverTime *metav1.Time `json:"failoverTime,omitempty"`
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// CSIConfiguration configures the CSI driver
+type CSIConfiguration struct {
+	// Enabled deploys the CSI controller next to the NFS server and the CSI node plugin on the nodes,
+	// registers the CSIDriver and creates its StorageClass
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	Enabled bool `json:"enabled,omitempty"`
+
+	// StorageClassName is the StorageClass of the CSI driver. Default value is the scForNFS with the suffix `-csi`
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// Image is the image of the CSI plugin. Default value is the operator image
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// NodeTolerations let the node plugin run on tainted nodes, e.g. control plane nodes
+	// +optional
+	NodeTolerations []corev1.Toleration `json:"nodeTolerations,omitempty"`
+}
+
+// CSIStatus shows the CSI driver
+type CSIStatus struct {
+	// Driver is the name of the CSIDriver, the provisioner of its StorageClass
+	Driver string `json:"driver,omitempty"`
+	// StorageClass is the StorageClass of the CSI driver
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+	// NodesReady is the number of nodes the node plugin is ready on
+	// +optional
+	NodesReady int32 `json:"nodesReady,omitempty"`
+	// NodesDesired is the number of nodes the node plugin should run on
+	// +optional
+	NodesDesired int32 `json:"nodesDesired,omitempty"`
+}
+
+// NodeSelectionMode is how the node of the NFS server is chosen in hostPath mode
+// +kubebuilder:validation:Enum=Selector;NodeName;Auto
+type NodeSelectionMode string
+
+const (
+	// NodeSelectionSelector schedules the NFS server with NodeSelector
+	NodeSelectionSelector NodeSelectionMode = "Selector"
+	// NodeSelectionNodeName labels the node NodeName and schedules the NFS server on it
+	NodeSelectionNodeName NodeSelectionMode = "NodeName"
+	// NodeSelectionAuto lets the operator pick and label a node
+	NodeSelectionAuto NodeSelectionMode = "Auto"
+)
+
+// NodeSelection configures how the node of the NFS server is chosen
+type NodeSelection struct {
+	// Mode is Selector, NodeName or Auto. Default value is `Selector`
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Mode"
+	// +optional
+	Mode NodeSelectionMode `json:"mode,omitempty"`
+
+	// NodeName is the node of the NFS server in NodeName mode
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// TopologyConfiguration configures the allowed topologies of the StorageClass
+type TopologyConfiguration struct {
+	// Enabled sets allowedTopologies and volumeBindingMode WaitForFirstConsumer on the StorageClass
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Enabled",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Key is the node label that holds the zone. Default value is `topology.kubernetes.io/zone`
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// TopologyStatus shows the topology the StorageClass is restricted to
+type TopologyStatus struct {
+	// Node is the node the NFS server runs on
+	Node string `json:"node,omitempty"`
+	// Key is the node label that holds the zone
+	Key string `json:"key,omitempty"`
+	// Value is the zone of the node. It is empty when the node has no such label, and the StorageClass is not restricted
+	Value string `json:"value,omitempty"`
+}
+
+// SquashMode maps the users of the clients to the anonymous user
+// +kubebuilder:validation:Enum=None;Root;All
+type SquashMode string
+
+const (
+	// SquashNone keeps the users of the clients
+	SquashNone SquashMode = "None"
+	// SquashRoot maps root to the anonymous user
+	SquashRoot SquashMode = "Root"
+	// SquashAll maps all users to the anonymous user
+	SquashAll SquashMode = "All"
+)
+
+// ClientAccess is the access of a client to the exports
+// +kubebuilder:validation:Enum=RW;RO
+type ClientAccess string
+
+const (
+	// ClientAccessRW allows reading and writing
+	ClientAccessRW ClientAccess = "RW"
+	// ClientAccessRO allows reading only
+	ClientAccessRO ClientAccess = "RO"
+)
+
+// GaneshaConfiguration holds the NFS-Ganesha export options
+type GaneshaConfiguration struct {
+	// Protocols are the NFS versions the server accepts. Default value is `["3", "4"]`
+	// +optional
+	Protocols []NFSProtocol `json:"protocols,omitempty"`
+
+	// Squash maps the users of the clients to the anonymous user. Default value is `None`
+	// +optional
+	Squash SquashMode `json:"squash,omitempty"`
+
+	// AnonymousUID is the uid that squashed users are mapped to
+	// +optional
+	AnonymousUID *int64 `json:"anonymousUid,omitempty"`
+
+	// AnonymousGID is the gid that squashed users are mapped to
+	// +optional
+	AnonymousGID *int64 `json:"anonymousGid,omitempty"`
+
+	// Clients are the networks that can mount the exports. Any client can mount them when it is empty
+	// +optional
+	Clients []GaneshaClient `json:"clients,omitempty"`
+
+	// RawConfig is appended to the rendered configuration as it is, e.g. to set a LOG block
+	// +optional
+	RawConfig string `json:"rawConfig,omitempty"`
+}
+
+// NFSProtocol is a NFS version
+// +kubebuilder:validation:Enum="3";"4"
+type NFSProtocol string
+
+// GaneshaClient is a network that can mount the exports
+type GaneshaClient struct {
+	// CIDR is the network of the clients, e.g. 10.128.0.0/14. A single address is also accepted
+	CIDR string `json:"cidr"`
+
+	// Access is RW or RO. Default value is `RW`
+	// +optional
+	Access ClientAccess `json:"access,omitempty"`
+}
+
+// AdmissionConfiguration holds the limits the PVC webhook enforces
+type AdmissionConfiguration struct {
+	// MaxClaimSize is the largest storage request of a single PVC
+	// +optional
+	MaxClaimSize *resource.Quantity `json:"maxClaimSize,omitempty"`
+
+	// MaxNamespaceCapacity is the largest total storage request of the PVCs of a namespace
+	// +optional
+	MaxNamespaceCapacity *resource.Quantity `json:"maxNamespaceCapacity,omitempty"`
+
+	// AllowedAccessModes are the access modes a PVC can request. All access modes are allowed when it is empty
+	// +optional
+	AllowedAccessModes []corev1.PersistentVolumeAccessMode `json:"allowedAccessModes,omitempty"`
+
+	// RequireFreeSpace refuses PVCs that request more than the free space of the export
+	// +optional
+	RequireFreeSpace bool `json:"requireFreeSpace,omitempty"`
+
+	// CapacityCheckInterval is how often the free space of the export is measured. Default value is `10m`
+	// +optional
+	CapacityCheckInterval *metav1.Duration `json:"capacityCheckInterval,omitempty"`
+}
+
+// ExportCapacityStatus is the size of the filesystem of the export
+type ExportCapacityStatus struct {
+	// LastCheckTime is when the capacity was last measured
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// TotalBytes is the size of the filesystem
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+	// AvailableBytes is the free space of the filesystem
+	AvailableBytes int64 `json:"availableBytes,omitempty"`
+}
+
+// OrphanAction is what happens to an orphaned directory once its grace period is over
+// +kubebuilder:validation:Enum=Report;Archive;Delete
+type OrphanAction string
+
+const (
+	// OrphanActionReport only reports orphaned directories
+	OrphanActionReport OrphanAction = "Report"
+	// OrphanActionArchive moves orphaned directories to defaults.OrphanArchiveDir on the export
+	OrphanActionArchive OrphanAction = "Archive"
+	// OrphanActionDelete deletes orphaned directories
+	OrphanActionDelete OrphanAction = "Delete"
+)
+
+// OrphanAuditConfiguration configures the orphaned directory audit
+type OrphanAuditConfiguration struct {
+	// Schedule is the cron schedule of the audit. The audit only runs on demand when it is empty.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Action is Report, Archive or Delete. Default value is `Report`
+	// +optional
+	Action OrphanAction `json:"action,omitempty"`
+
+	// GracePeriod is how long a directory must stay orphaned before it is archived or deleted. Default value is `168h`
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// OrphanedDirectory is a directory on the export that no PV references
+type OrphanedDirectory struct {
+	// Directory is the directory relative to the export
+	Directory string `json:"directory"`
+	// SizeBytes is the disk usage of the directory
+	SizeBytes int64 `json:"sizeBytes"`
+	// FirstSeen is the first audit that found the directory orphaned
+	FirstSeen metav1.Time `json:"firstSeen"`
+}
+
+// OrphanAuditStatus shows the result of the last orphaned directory audit
+type OrphanAuditStatus struct {
+	// LastAuditTime is when the last audit finished
+	LastAuditTime *metav1.Time `json:"lastAuditTime,omitempty"`
+	// Orphans are the orphaned directories found by the last audit
+	Orphans []OrphanedDirectory `json:"orphans,omitempty"`
+	// OrphanedBytes is the total size of the orphaned directories
+	OrphanedBytes int64 `json:"orphanedBytes,omitempty"`
+	// ReclaimJobName is the Job that archives or deletes orphaned directories
+	ReclaimJobName string `json:"reclaimJobName,omitempty"`
+	// Reclaiming are the directories the reclaim Job is working on
+	Reclaiming []string `json:"reclaiming,omitempty"`
+	// LastReclaimTime is when orphaned directories were last archived or deleted
+	LastReclaimTime *metav1.Time `json:"lastReclaimTime,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// MigrationStatus shows the progress of a storage migration
+type MigrationStatus struct {
+	// Phase is the current step of the migration
+	Phase MigrationPhase `json:"phase"`
+	// Source is the volume the data is copied from
+	Source MigrationVolume `json:"source,omitempty"`
+	// Target is the volume the data is copied to
+	Target MigrationVolume `json:"target,omitempty"`
+	// JobName is the rsync Job that copies the data
+	JobName string `json:"jobName,omitempty"`
+	// StartTime is when the migration was acknowledged
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Message show the details of the current step briefly
+	Message string `json:"message,omitempty"`
+}
+
+// ExternalNFSServer is an NFS server that is not deployed by the operator
+type ExternalNFSServer struct {
+	// Server is the hostname or IP address of the NFS server
+	Server string `json:"server"`
+	// Path is the exported directory. A subdirectory is created in it for each PV.
+	Path string `json:"path"`
+	// Image is the subdirectory provisioner image. By default, defaults.SubdirProvisionerImage is used.
+	// +optional
+	Image string `json:"image,omitempty"`
 }
 
 // ImageConfiguration holds configuration of the image to use
 type ImageConfiguration struct {
-	// Set nfs provisioner operator image
-	// +kubebuilder:default="k8s.gcr.io/sig-storage/nfs-provisioner@sha256:e943bb77c7df05ebdc8c7888b2db289b13bf9f012d6a3a5a74f14d4d5743d439"
+	// Set nfs provisioner operator image. By default, the image of the operator is used: the RELATED_IMAGE_NFS_PROVISIONER
+	// environment variable of the operator, or the built-in NFS-Ganesha image
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS Provisioner Image",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
-	Image *string `json:"image"`
+	// +optional
+	Image *string `json:"image,omitempty"`
 	// Image PullPolicy is for nfs provisioner operator image.
 	// +kubebuilder:default="IfNotPresent"
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Pull Policy",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:imagePullPolicy"}
@@ -0,0 +1,110 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NFSProvisionerPoolSpec defines the desired state of NFSProvisionerPool
+type NFSProvisionerPoolSpec struct {
+	// Servers is the number of NFS servers in the pool
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Servers",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:podCount"}
+	Servers int32 `json:"servers"`
+
+	// Template is the NFSProvisioner of each server. Every server gets its own PVC, so hostPathDir, pvc and External mode can not be set.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Server Template"
+	Template NFSProvisionerSpec `json:"template"`
+
+	// StorageClassName is the StorageClass of the pool. Default value is the name of the NFSProvisionerPool
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="storageClassName is immutable"
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// ReclaimPolicy of the PVs of the pool. Default value is `Delete`
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +optional
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+}
+
+// PoolServerStatus shows a server of the pool
+type PoolServerStatus struct {
+	// Index is the number of the server
+	Index int32 `json:"index"`
+	// Namespace holds the NFSProvisioner of the server
+	Namespace string `json:"namespace"`
+	// Ready is true when the NFS server is available
+	Ready bool `json:"ready"`
+	// Draining is true when the server is beyond spec.servers and waits for its volumes to be deleted
+	Draining bool `json:"draining,omitempty"`
+	// Volumes is the number of PVs of the pool on the server
+	Volumes int32 `json:"volumes"`
+	// RequestedBytes is the sum of the capacity of the PVs on the server
+	RequestedBytes int64 `json:"requestedBytes"`
+	// TotalBytes is the size of the export
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+	// AvailableBytes is the free space of the export
+	AvailableBytes int64 `json:"availableBytes,omitempty"`
+	// Message shows why the server is not ready
+	Message string `json:"message,omitempty"`
+}
+
+// NFSProvisionerPoolStatus defines the observed state of NFSProvisionerPool
+type NFSProvisionerPoolStatus struct {
+	// StorageClass is the StorageClass of the pool
+	StorageClass string `json:"storageClass,omitempty"`
+	// ReadyServers is the number of servers that are ready
+	ReadyServers int32 `json:"readyServers"`
+	// Servers shows each server of the pool
+	Servers []PoolServerStatus `json:"servers,omitempty"`
+	// PendingClaims are the PVCs that can not be placed, with the reason
+	PendingClaims []string `json:"pendingClaims,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Servers",type=integer,JSONPath=`.spec.servers`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyServers`
+// +kubebuilder:printcolumn:name="StorageClass",type=string,JSONPath=`.status.storageClass`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NFSProvisionerPool is the Schema for the nfsprovisionerpools API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Provisioner Pool",resources={{NFSProvisioner,v1alpha1,nfsprovisionerpool},{Namespace,v1,nfsprovisionerpool},{StorageClass,v1,nfsprovisionerpool},{PersistentVolume,v1,nfsprovisionerpool},{Job,v1,nfsprovisionerpool}}
+type NFSProvisionerPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSProvisionerPoolSpec   `json:"spec,omitempty"`
+	Status NFSProvisionerPoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSProvisionerPoolList contains a list of NFSProvisionerPool
+type NFSProvisionerPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSProvisionerPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSProvisionerPool{}, &NFSProvisionerPoolList{})
+}
@@ -0,0 +1,135 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SharePhase is the lifecycle phase of an NFSShare
+type SharePhase string
+
+const (
+	// SharePhasePending means the directory or the NFS server is not ready yet
+	SharePhasePending SharePhase = "Pending"
+	// SharePhaseReady means the PV and PVC exist in every target namespace
+	SharePhaseReady SharePhase = "Ready"
+	// SharePhaseFailed means the share can not be created
+	SharePhaseFailed SharePhase = "Failed"
+)
+
+// DirectoryPolicy decides what happens to the shared directory when the NFSShare is deleted
+// +kubebuilder:validation:Enum=Retain;Delete
+type DirectoryPolicy string
+
+const (
+	// DirectoryPolicyRetain keeps the directory and its data
+	DirectoryPolicyRetain DirectoryPolicy = "Retain"
+	// DirectoryPolicyDelete removes the directory and its data
+	DirectoryPolicyDelete DirectoryPolicy = "Delete"
+)
+
+// NFSShareSpec defines the desired state of NFSShare
+// +kubebuilder:validation:XValidation:rule="self.nfsProvisioner == oldSelf.nfsProvisioner && self.path == oldSelf.path",message="nfsProvisioner and path are immutable"
+type NFSShareSpec struct {
+	// NFSProvisioner is the name of the NFSProvisioner in the same namespace that serves the share.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NFS Provisioner",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	NFSProvisioner string `json:"nfsProvisioner"`
+
+	// Path is the shared directory relative to the export. It is created when it does not exist.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Path",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:text"}
+	Path string `json:"path"`
+
+	// Namespaces are the namespaces where a PVC bound to the share is created.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Target Namespaces"
+	Namespaces []string `json:"namespaces"`
+
+	// AccessMode of the PVs and PVCs. Default value is `ReadOnlyMany`
+	// +kubebuilder:validation:Enum=ReadOnlyMany;ReadWriteMany
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="accessMode is immutable"
+	// +optional
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+
+	// ClaimName is the name of the PVC in each target namespace. By default, the name of the NFSShare is used.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="claimName is immutable"
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
+
+	// Capacity is the size shown on the PVs and PVCs. NFS does not enforce it. Default value is `1Gi`
+	// +optional
+	Capacity string `json:"capacity,omitempty"`
+
+	// DirectoryPolicy is Retain to keep the directory when the NFSShare is deleted, or Delete to remove it. Default value is `Retain`
+	// +optional
+	DirectoryPolicy DirectoryPolicy `json:"directoryPolicy,omitempty"`
+}
+
+// ShareTarget is the PV and PVC of a target namespace
+type ShareTarget struct {
+	// Namespace is the target namespace
+	Namespace string `json:"namespace"`
+	// PersistentVolume is the static PV that points at the share
+	PersistentVolume string `json:"persistentVolume"`
+	// Bound is true when the PVC is bound to the PV
+	Bound bool `json:"bound"`
+}
+
+// NFSShareStatus defines the observed state of NFSShare
+type NFSShareStatus struct {
+	// Phase is the current phase of the share
+	Phase SharePhase `json:"phase,omitempty"`
+	// Server is the NFS server address used in the PVs
+	Server string `json:"server,omitempty"`
+	// Path is the exported path used in the PVs
+	Path string `json:"path,omitempty"`
+	// Targets are the PVs and PVCs created for the target namespaces
+	Targets []ShareTarget `json:"targets,omitempty"`
+	// Message show error messages briefly
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type=string,JSONPath=`.spec.nfsProvisioner`
+// +kubebuilder:printcolumn:name="Path",type=string,JSONPath=`.spec.path`
+// +kubebuilder:printcolumn:name="Access",type=string,JSONPath=`.spec.accessMode`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NFSShare is the Schema for the nfsshares API
+// +operator-sdk:csv:customresourcedefinitions:displayName="NFS Share",resources={{PersistentVolume,v1,nfsshare},{PersistentVolumeClaim,v1,nfsshare},{Job,v1,nfsshare}}
+type NFSShare struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NFSShareSpec   `json:"spec,omitempty"`
+	Status NFSShareStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NFSShareList contains a list of NFSShare
+type NFSShareList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NFSShare `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NFSShare{}, &NFSShareList{})
+}
@@ -21,10 +21,310 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdmissionConfiguration) DeepCopyInto(out *AdmissionConfiguration) {
+	*out = *in
+	if in.MaxClaimSize != nil {
+		in, out := &in.MaxClaimSize, &out.MaxClaimSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxNamespaceCapacity != nil {
+		in, out := &in.MaxNamespaceCapacity, &out.MaxNamespaceCapacity
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.AllowedAccessModes != nil {
+		in, out := &in.AllowedAccessModes, &out.AllowedAccessModes
+		*out = make([]corev1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.CapacityCheckInterval != nil {
+		in, out := &in.CapacityCheckInterval, &out.CapacityCheckInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdmissionConfiguration.
+func (in *AdmissionConfiguration) DeepCopy() *AdmissionConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(AdmissionConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRepository) DeepCopyInto(out *BackupRepository) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRepository.
+func (in *BackupRepository) DeepCopy() *BackupRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRestore) DeepCopyInto(out *BackupRestore) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRestore.
+func (in *BackupRestore) DeepCopy() *BackupRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BenchmarkProfile) DeepCopyInto(out *BenchmarkProfile) {
+	*out = *in
+	if in.FileSize != nil {
+		in, out := &in.FileSize, &out.FileSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BenchmarkProfile.
+func (in *BenchmarkProfile) DeepCopy() *BenchmarkProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(BenchmarkProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BenchmarkResult) DeepCopyInto(out *BenchmarkResult) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BenchmarkResult.
+func (in *BenchmarkResult) DeepCopy() *BenchmarkResult {
+	if in == nil {
+		return nil
+	}
+	out := new(BenchmarkResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CSIConfiguration) DeepCopyInto(out *CSIConfiguration) {
+	*out = *in
+	if in.NodeTolerations != nil {
+		in, out := &in.NodeTolerations, &out.NodeTolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CSIConfiguration.
+func (in *CSIConfiguration) DeepCopy() *CSIConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(CSIConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CSIStatus) DeepCopyInto(out *CSIStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CSIStatus.
+func (in *CSIStatus) DeepCopy() *CSIStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CSIStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryConfiguration) DeepCopyInto(out *CanaryConfiguration) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryConfiguration.
+func (in *CanaryConfiguration) DeepCopy() *CanaryConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryStatus) DeepCopyInto(out *CanaryStatus) {
+	*out = *in
+	if in.LastRunTime != nil {
+		in, out := &in.LastRunTime, &out.LastRunTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryStatus.
+func (in *CanaryStatus) DeepCopy() *CanaryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExportCapacityStatus) DeepCopyInto(out *ExportCapacityStatus) {
+	*out = *in
+	if in.LastCheckTime != nil {
+		in, out := &in.LastCheckTime, &out.LastCheckTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExportCapacityStatus.
+func (in *ExportCapacityStatus) DeepCopy() *ExportCapacityStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ExportCapacityStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalNFSServer) DeepCopyInto(out *ExternalNFSServer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalNFSServer.
+func (in *ExternalNFSServer) DeepCopy() *ExternalNFSServer {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalNFSServer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GaneshaClient) DeepCopyInto(out *GaneshaClient) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GaneshaClient.
+func (in *GaneshaClient) DeepCopy() *GaneshaClient {
+	if in == nil {
+		return nil
+	}
+	out := new(GaneshaClient)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GaneshaConfiguration) DeepCopyInto(out *GaneshaConfiguration) {
+	*out = *in
+	if in.Protocols != nil {
+		in, out := &in.Protocols, &out.Protocols
+		*out = make([]NFSProtocol, len(*in))
+		copy(*out, *in)
+	}
+	if in.AnonymousUID != nil {
+		in, out := &in.AnonymousUID, &out.AnonymousUID
+		*out = new(int64)
+		**out = **in
+	}
+	if in.AnonymousGID != nil {
+		in, out := &in.AnonymousGID, &out.AnonymousGID
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Clients != nil {
+		in, out := &in.Clients, &out.Clients
+		*out = make([]GaneshaClient, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GaneshaConfiguration.
+func (in *GaneshaConfiguration) DeepCopy() *GaneshaConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(GaneshaConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPathPreparation) DeepCopyInto(out *HostPathPreparation) {
+	*out = *in
+	if in.UID != nil {
+		in, out := &in.UID, &out.UID
+		*out = new(int64)
+		**out = **in
+	}
+	if in.GID != nil {
+		in, out := &in.GID, &out.GID
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPathPreparation.
+func (in *HostPathPreparation) DeepCopy() *HostPathPreparation {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPathPreparation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageConfiguration) DeepCopyInto(out *ImageConfiguration) {
 	*out = *in
@@ -35,7 +335,7 @@ func (in *ImageConfiguration) DeepCopyInto(out *ImageConfiguration) {
 	}
 	if in.ImagePullPolicy != nil {
 		in, out := &in.ImagePullPolicy, &out.ImagePullPolicy
-		*out = new(v1.PullPolicy)
+		*out = new(corev1.PullPolicy)
 		**out = **in
 	}
 }
@@ -51,7 +351,83 @@ func (in *ImageConfiguration) DeepCopy() *ImageConfiguration {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NFSProvisioner) DeepCopyInto(out *NFSProvisioner) {
+func (in *ImportedVolume) DeepCopyInto(out *ImportedVolume) {
+	*out = *in
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]corev1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.MountOptions != nil {
+		in, out := &in.MountOptions, &out.MountOptions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportedVolume.
+func (in *ImportedVolume) DeepCopy() *ImportedVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportedVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceConfiguration) DeepCopyInto(out *MaintenanceConfiguration) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceConfiguration.
+func (in *MaintenanceConfiguration) DeepCopy() *MaintenanceConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MigrationStatus) DeepCopyInto(out *MigrationStatus) {
+	*out = *in
+	out.Source = in.Source
+	out.Target = in.Target
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MigrationStatus.
+func (in *MigrationStatus) DeepCopy() *MigrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MigrationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MigrationVolume) DeepCopyInto(out *MigrationVolume) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MigrationVolume.
+func (in *MigrationVolume) DeepCopy() *MigrationVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(MigrationVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBackup) DeepCopyInto(out *NFSBackup) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -59,18 +435,18 @@ func (in *NFSProvisioner) DeepCopyInto(out *NFSProvisioner) {
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisioner.
-func (in *NFSProvisioner) DeepCopy() *NFSProvisioner {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackup.
+func (in *NFSBackup) DeepCopy() *NFSBackup {
 	if in == nil {
 		return nil
 	}
-	out := new(NFSProvisioner)
+	out := new(NFSBackup)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *NFSProvisioner) DeepCopyObject() runtime.Object {
+func (in *NFSBackup) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -78,31 +454,31 @@ func (in *NFSProvisioner) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NFSProvisionerList) DeepCopyInto(out *NFSProvisionerList) {
+func (in *NFSBackupList) DeepCopyInto(out *NFSBackupList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]NFSProvisioner, len(*in))
+		*out = make([]NFSBackup, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerList.
-func (in *NFSProvisionerList) DeepCopy() *NFSProvisionerList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupList.
+func (in *NFSBackupList) DeepCopy() *NFSBackupList {
 	if in == nil {
 		return nil
 	}
-	out := new(NFSProvisionerList)
+	out := new(NFSBackupList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *NFSProvisionerList) DeepCopyObject() runtime.Object {
+func (in *NFSBackupList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -110,48 +486,1299 @@ func (in *NFSProvisionerList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NFSProvisionerSpec) DeepCopyInto(out *NFSProvisionerSpec) {
+func (in *NFSBackupSchedule) DeepCopyInto(out *NFSBackupSchedule) {
 	*out = *in
-	if in.NodeSelector != nil {
-		in, out := &in.NodeSelector, &out.NodeSelector
-		*out = make(map[string]string, len(*in))
-		for key, val := range *in {
-			(*out)[key] = val
-		}
-	}
-	if in.NFSImageConfiguration != nil {
-		in, out := &in.NFSImageConfiguration, &out.NFSImageConfiguration
-		*out = new(ImageConfiguration)
-		(*in).DeepCopyInto(*out)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupSchedule.
+func (in *NFSBackupSchedule) DeepCopy() *NFSBackupSchedule {
+	if in == nil {
+		return nil
 	}
+	out := new(NFSBackupSchedule)
+	in.DeepCopyInto(out)
+	return out
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerSpec.
-func (in *NFSProvisionerSpec) DeepCopy() *NFSProvisionerSpec {
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSBackupSchedule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBackupScheduleList) DeepCopyInto(out *NFSBackupScheduleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSBackupSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupScheduleList.
+func (in *NFSBackupScheduleList) DeepCopy() *NFSBackupScheduleList {
 	if in == nil {
 		return nil
 	}
-	out := new(NFSProvisionerSpec)
+	out := new(NFSBackupScheduleList)
 	in.DeepCopyInto(out)
 	return out
 }
 
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSBackupScheduleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NFSProvisionerStatus) DeepCopyInto(out *NFSProvisionerStatus) {
+func (in *NFSBackupScheduleSpec) DeepCopyInto(out *NFSBackupScheduleSpec) {
+	*out = *in
+	out.Repository = in.Repository
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupScheduleSpec.
+func (in *NFSBackupScheduleSpec) DeepCopy() *NFSBackupScheduleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBackupScheduleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBackupScheduleStatus) DeepCopyInto(out *NFSBackupScheduleStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupScheduleStatus.
+func (in *NFSBackupScheduleStatus) DeepCopy() *NFSBackupScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBackupScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBackupSpec) DeepCopyInto(out *NFSBackupSpec) {
+	*out = *in
+	out.Repository = in.Repository
+	if in.Restore != nil {
+		in, out := &in.Restore, &out.Restore
+		*out = new(BackupRestore)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupSpec.
+func (in *NFSBackupSpec) DeepCopy() *NFSBackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBackupStatus) DeepCopyInto(out *NFSBackupStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBackupStatus.
+func (in *NFSBackupStatus) DeepCopy() *NFSBackupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBackupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBenchmark) DeepCopyInto(out *NFSBenchmark) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBenchmark.
+func (in *NFSBenchmark) DeepCopy() *NFSBenchmark {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBenchmark)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSBenchmark) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBenchmarkList) DeepCopyInto(out *NFSBenchmarkList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSBenchmark, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBenchmarkList.
+func (in *NFSBenchmarkList) DeepCopy() *NFSBenchmarkList {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBenchmarkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSBenchmarkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBenchmarkSpec) DeepCopyInto(out *NFSBenchmarkSpec) {
+	*out = *in
+	in.Profile.DeepCopyInto(&out.Profile)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBenchmarkSpec.
+func (in *NFSBenchmarkSpec) DeepCopy() *NFSBenchmarkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSBenchmarkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSBenchmarkStatus) DeepCopyInto(out *NFSBenchmarkStatus) {
 	*out = *in
 	if in.Nodes != nil {
 		in, out := &in.Nodes, &out.Nodes
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Read != nil {
+		in, out := &in.Read, &out.Read
+		*out = new(BenchmarkResult)
+		**out = **in
+	}
+	if in.Write != nil {
+		in, out := &in.Write, &out.Write
+		*out = new(BenchmarkResult)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerStatus.
-func (in *NFSProvisionerStatus) DeepCopy() *NFSProvisionerStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSBenchmarkStatus.
+func (in *NFSBenchmarkStatus) DeepCopy() *NFSBenchmarkStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(NFSProvisionerStatus)
+	out := new(NFSBenchmarkStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSImport) DeepCopyInto(out *NFSImport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSImport.
+func (in *NFSImport) DeepCopy() *NFSImport {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSImport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSImportList) DeepCopyInto(out *NFSImportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSImport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSImportList.
+func (in *NFSImportList) DeepCopy() *NFSImportList {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSImportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSImportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSImportSpec) DeepCopyInto(out *NFSImportSpec) {
+	*out = *in
+	if in.Directories != nil {
+		in, out := &in.Directories, &out.Directories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSImportSpec.
+func (in *NFSImportSpec) DeepCopy() *NFSImportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSImportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSImportStatus) DeepCopyInto(out *NFSImportStatus) {
+	*out = *in
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]ImportedVolume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSImportStatus.
+func (in *NFSImportStatus) DeepCopy() *NFSImportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSImportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisioner) DeepCopyInto(out *NFSProvisioner) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisioner.
+func (in *NFSProvisioner) DeepCopy() *NFSProvisioner {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisioner)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSProvisioner) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerList) DeepCopyInto(out *NFSProvisionerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSProvisioner, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerList.
+func (in *NFSProvisionerList) DeepCopy() *NFSProvisionerList {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSProvisionerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerPool) DeepCopyInto(out *NFSProvisionerPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerPool.
+func (in *NFSProvisionerPool) DeepCopy() *NFSProvisionerPool {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSProvisionerPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerPoolList) DeepCopyInto(out *NFSProvisionerPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSProvisionerPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerPoolList.
+func (in *NFSProvisionerPoolList) DeepCopy() *NFSProvisionerPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSProvisionerPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerPoolSpec) DeepCopyInto(out *NFSProvisionerPoolSpec) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerPoolSpec.
+func (in *NFSProvisionerPoolSpec) DeepCopy() *NFSProvisionerPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerPoolStatus) DeepCopyInto(out *NFSProvisionerPoolStatus) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]PoolServerStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingClaims != nil {
+		in, out := &in.PendingClaims, &out.PendingClaims
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerPoolStatus.
+func (in *NFSProvisionerPoolStatus) DeepCopy() *NFSProvisionerPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerSpec) DeepCopyInto(out *NFSProvisionerSpec) {
+	*out = *in
+	if in.External != nil {
+		in, out := &in.External, &out.External
+		*out = new(ExternalNFSServer)
+		**out = **in
+	}
+	if in.HostPathPreparation != nil {
+		in, out := &in.HostPathPreparation, &out.HostPathPreparation
+		*out = new(HostPathPreparation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodeSelection != nil {
+		in, out := &in.NodeSelection, &out.NodeSelection
+		*out = new(NodeSelection)
+		**out = **in
+	}
+	if in.Topology != nil {
+		in, out := &in.Topology, &out.Topology
+		*out = new(TopologyConfiguration)
+		**out = **in
+	}
+	if in.Standby != nil {
+		in, out := &in.Standby, &out.Standby
+		*out = new(StandbyConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CSI != nil {
+		in, out := &in.CSI, &out.CSI
+		*out = new(CSIConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NFSImageConfiguration != nil {
+		in, out := &in.NFSImageConfiguration, &out.NFSImageConfiguration
+		*out = new(ImageConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(UpgradeConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OrphanAudit != nil {
+		in, out := &in.OrphanAudit, &out.OrphanAudit
+		*out = new(OrphanAuditConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = new(QuotaConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UsageReport != nil {
+		in, out := &in.UsageReport, &out.UsageReport
+		*out = new(UsageReportConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = new(MaintenanceConfiguration)
+		**out = **in
+	}
+	if in.Admission != nil {
+		in, out := &in.Admission, &out.Admission
+		*out = new(AdmissionConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ganesha != nil {
+		in, out := &in.Ganesha, &out.Ganesha
+		*out = new(GaneshaConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExtraArgs != nil {
+		in, out := &in.ExtraArgs, &out.ExtraArgs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraEnv != nil {
+		in, out := &in.ExtraEnv, &out.ExtraEnv
+		*out = make([]corev1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraContainers != nil {
+		in, out := &in.ExtraContainers, &out.ExtraContainers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InitContainers != nil {
+		in, out := &in.InitContainers, &out.InitContainers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraVolumes != nil {
+		in, out := &in.ExtraVolumes, &out.ExtraVolumes
+		*out = make([]corev1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraVolumeMounts != nil {
+		in, out := &in.ExtraVolumeMounts, &out.ExtraVolumeMounts
+		*out = make([]corev1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerSpec.
+func (in *NFSProvisionerSpec) DeepCopy() *NFSProvisionerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSProvisionerStatus) DeepCopyInto(out *NFSProvisionerStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(MigrationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OrphanAudit != nil {
+		in, out := &in.OrphanAudit, &out.OrphanAudit
+		*out = new(OrphanAuditStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = new(QuotaStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Usage != nil {
+		in, out := &in.Usage, &out.Usage
+		*out = new(UsageStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(UpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Pause != nil {
+		in, out := &in.Pause, &out.Pause
+		*out = new(PauseStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Export != nil {
+		in, out := &in.Export, &out.Export
+		*out = new(ExportCapacityStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Topology != nil {
+		in, out := &in.Topology, &out.Topology
+		*out = new(TopologyStatus)
+		**out = **in
+	}
+	if in.Standby != nil {
+		in, out := &in.Standby, &out.Standby
+		*out = new(StandbyStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CSI != nil {
+		in, out := &in.CSI, &out.CSI
+		*out = new(CSIStatus)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSProvisionerStatus.
+func (in *NFSProvisionerStatus) DeepCopy() *NFSProvisionerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSProvisionerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSShare) DeepCopyInto(out *NFSShare) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSShare.
+func (in *NFSShare) DeepCopy() *NFSShare {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSShare)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSShare) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSShareList) DeepCopyInto(out *NFSShareList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NFSShare, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSShareList.
+func (in *NFSShareList) DeepCopy() *NFSShareList {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSShareList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NFSShareList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSShareSpec) DeepCopyInto(out *NFSShareSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSShareSpec.
+func (in *NFSShareSpec) DeepCopy() *NFSShareSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSShareSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NFSShareStatus) DeepCopyInto(out *NFSShareStatus) {
+	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]ShareTarget, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NFSShareStatus.
+func (in *NFSShareStatus) DeepCopy() *NFSShareStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NFSShareStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceUsage) DeepCopyInto(out *NamespaceUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceUsage.
+func (in *NamespaceUsage) DeepCopy() *NamespaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSelection) DeepCopyInto(out *NodeSelection) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSelection.
+func (in *NodeSelection) DeepCopy() *NodeSelection {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSelection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanAuditConfiguration) DeepCopyInto(out *OrphanAuditConfiguration) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanAuditConfiguration.
+func (in *OrphanAuditConfiguration) DeepCopy() *OrphanAuditConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanAuditConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanAuditStatus) DeepCopyInto(out *OrphanAuditStatus) {
+	*out = *in
+	if in.LastAuditTime != nil {
+		in, out := &in.LastAuditTime, &out.LastAuditTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Orphans != nil {
+		in, out := &in.Orphans, &out.Orphans
+		*out = make([]OrphanedDirectory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Reclaiming != nil {
+		in, out := &in.Reclaiming, &out.Reclaiming
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastReclaimTime != nil {
+		in, out := &in.LastReclaimTime, &out.LastReclaimTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanAuditStatus.
+func (in *OrphanAuditStatus) DeepCopy() *OrphanAuditStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanAuditStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanedDirectory) DeepCopyInto(out *OrphanedDirectory) {
+	*out = *in
+	in.FirstSeen.DeepCopyInto(&out.FirstSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanedDirectory.
+func (in *OrphanedDirectory) DeepCopy() *OrphanedDirectory {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanedDirectory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PauseStatus) DeepCopyInto(out *PauseStatus) {
+	*out = *in
+	if in.Since != nil {
+		in, out := &in.Since, &out.Since
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PauseStatus.
+func (in *PauseStatus) DeepCopy() *PauseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PauseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolServerStatus) DeepCopyInto(out *PoolServerStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolServerStatus.
+func (in *PoolServerStatus) DeepCopy() *PoolServerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolServerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaConfiguration) DeepCopyInto(out *QuotaConfiguration) {
+	*out = *in
+	if in.UsageInterval != nil {
+		in, out := &in.UsageInterval, &out.UsageInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaConfiguration.
+func (in *QuotaConfiguration) DeepCopy() *QuotaConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaStatus) DeepCopyInto(out *QuotaStatus) {
+	*out = *in
+	if in.LastUsageTime != nil {
+		in, out := &in.LastUsageTime, &out.LastUsageTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]VolumeUsage, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaStatus.
+func (in *QuotaStatus) DeepCopy() *QuotaStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShareTarget) DeepCopyInto(out *ShareTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShareTarget.
+func (in *ShareTarget) DeepCopy() *ShareTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(ShareTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StandbyConfiguration) DeepCopyInto(out *StandbyConfiguration) {
+	*out = *in
+	if in.SyncInterval != nil {
+		in, out := &in.SyncInterval, &out.SyncInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.FailoverAfter != nil {
+		in, out := &in.FailoverAfter, &out.FailoverAfter
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StandbyConfiguration.
+func (in *StandbyConfiguration) DeepCopy() *StandbyConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(StandbyConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StandbyStatus) DeepCopyInto(out *StandbyStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PrimaryUnavailableSince != nil {
+		in, out := &in.PrimaryUnavailableSince, &out.PrimaryUnavailableSince
+		*out = (*in).DeepCopy()
+	}
+	if in.FailoverTime != nil {
+		in, out := &in.FailoverTime, &out.FailoverTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StandbyStatus.
+func (in *StandbyStatus) DeepCopy() *StandbyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(StandbyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TopologyConfiguration) DeepCopyInto(out *TopologyConfiguration) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopologyConfiguration.
+func (in *TopologyConfiguration) DeepCopy() *TopologyConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(TopologyConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TopologyStatus) DeepCopyInto(out *TopologyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopologyStatus.
+func (in *TopologyStatus) DeepCopy() *TopologyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TopologyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeConfiguration) DeepCopyInto(out *UpgradeConfiguration) {
+	*out = *in
+	if in.Window != nil {
+		in, out := &in.Window, &out.Window
+		*out = new(UpgradeWindow)
+		**out = **in
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(BackupRepository)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeConfiguration.
+func (in *UpgradeConfiguration) DeepCopy() *UpgradeConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeStatus) DeepCopyInto(out *UpgradeStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]UpgradeStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeStatus.
+func (in *UpgradeStatus) DeepCopy() *UpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeStep) DeepCopyInto(out *UpgradeStep) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeStep.
+func (in *UpgradeStep) DeepCopy() *UpgradeStep {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeWindow) DeepCopyInto(out *UpgradeWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeWindow.
+func (in *UpgradeWindow) DeepCopy() *UpgradeWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageReportConfiguration) DeepCopyInto(out *UsageReportConfiguration) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TopConsumers != nil {
+		in, out := &in.TopConsumers, &out.TopConsumers
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageReportConfiguration.
+func (in *UsageReportConfiguration) DeepCopy() *UsageReportConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageReportConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageReportStatus) DeepCopyInto(out *UsageReportStatus) {
+	*out = *in
+	in.ScanTime.DeepCopyInto(&out.ScanTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageReportStatus.
+func (in *UsageReportStatus) DeepCopy() *UsageReportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageReportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageStatus) DeepCopyInto(out *UsageStatus) {
+	*out = *in
+	if in.LastScanTime != nil {
+		in, out := &in.LastScanTime, &out.LastScanTime
+		*out = (*in).DeepCopy()
+	}
+	if in.TopConsumers != nil {
+		in, out := &in.TopConsumers, &out.TopConsumers
+		*out = make([]VolumeUsage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]NamespaceUsage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Report != nil {
+		in, out := &in.Report, &out.Report
+		*out = new(UsageReportStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageStatus.
+func (in *UsageStatus) DeepCopy() *UsageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeUsage) DeepCopyInto(out *VolumeUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeUsage.
+func (in *VolumeUsage) DeepCopy() *VolumeUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeUsage)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// csi-driver is the CSI plugin of a NFSProvisioner with csi enabled.
+// Next to the NFS server it serves the controller service on the export, on the nodes it serves the node service.
+package main
+
+import (
+	"flag"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/jooho/nfs-provisioner-operator/csidriver"
+)
+
+// version is set at build time
+var version = "dev"
+
+func main() {
+	var endpoint string
+	var driverName string
+	var exportPath string
+	var nodeID string
+
+	flag.StringVar(&endpoint, "endpoint", "unix:///csi/csi.sock", "The CSI endpoint the sidecars connect to.")
+	flag.StringVar(&driverName, "driver-name", "nfs.csi.jhouse.com", "The name of the CSIDriver.")
+	flag.StringVar(&exportPath, "export", "", "The mounted export. The controller service is served when it is set.")
+	flag.StringVar(&nodeID, "node-id", "", "The node the plugin runs on. The node service is served when it is set.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	log := ctrl.Log.WithName("csi-driver")
+
+	if exportPath == "" && nodeID == "" {
+		log.Error(nil, "--export or --node-id must be set")
+		os.Exit(1)
+	}
+
+	driver := &csidriver.Driver{
+		Name:       driverName,
+		Version:    version,
+		ExportPath: exportPath,
+		NodeID:     nodeID,
+		Mounter:    csidriver.NewMounter(),
+		Log:        log,
+	}
+	if err := driver.Run(ctrl.SetupSignalHandler(), endpoint); err != nil {
+		log.Error(err, "problem running csi-driver")
+		os.Exit(1)
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	apiruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -34,7 +36,9 @@ import (
 
 	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
 	"github.com/jooho/nfs-provisioner-operator/controllers"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
 	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+	"github.com/jooho/nfs-provisioner-operator/webhooks"
 	securityv1 "github.com/openshift/api/security/v1"
 	// +kubebuilder:scaffold:imports
 )
@@ -44,6 +48,9 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// webhookCertDir is where the webhook server reads its serving certificate, where OLM and cert-manager mount it
+var webhookCertDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -64,6 +71,7 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var isDevelopmentEnv bool
+	var operatorConfig string
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -71,6 +79,7 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 
 	flag.BoolVar(&isDevelopmentEnv, "development", false, "Enable/Disable running operator in development environment")
+	flag.StringVar(&operatorConfig, "operator-config", "", "The configuration file of the operator with the image mirrors and pull secrets of the operands.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(isDevelopmentEnv)))
@@ -79,10 +88,19 @@ func main() {
 
 	setupLog.Info(fmt.Sprintf("Running in development mode: %v", isDevelopmentEnv))
 
+	// The CSV sets RELATED_IMAGE_* to the operand images, which may be mirrored for a disconnected install
+	for env, image := range defaults.LoadRelatedImages(os.Getenv) {
+		setupLog.Info("Using a related image", "env", env, "image", image)
+	}
+	if err := defaults.LoadOperatorConfig(operatorConfig); err != nil {
+		setupLog.Error(err, "unable to load the operator configuration", "path", operatorConfig)
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                        scheme,
 		Metrics:                       server.Options{BindAddress: metricsAddr},
-		WebhookServer:                 webhook.NewServer(webhook.Options{Port: 9443}),
+		WebhookServer:                 webhook.NewServer(webhook.Options{Port: 9443, CertDir: webhookCertDir}),
 		HealthProbeBindAddress:        ":8081",
 		LeaderElection:                enableLeaderElection,
 		LeaderElectionID:              "nfs-provisioner-lock",
@@ -104,16 +122,82 @@ func main() {
 	// 	os.Exit(1)
 	// }
 
+	// Pod logs are read with a clientset because the manager client does not support them
+	kubeClient := kubernetes.NewForConfigOrDie(mgr.GetConfig())
+
 	// Setup all Controllers
 	if err = (&controllers.NFSProvisionerReconciler{
 		Client:          mgr.GetClient(),
 		Log:             ctrl.Log.WithName("controllers").WithName("NFSProvisioner"),
 		Scheme:          mgrScheme,
-		ResourceManager: resources.NewResourceManagerSet(mgr.GetClient(), ctrl.Log.WithName("resources"), mgrScheme),
+		ResourceManager: resources.NewResourceManagerSet(mgr.GetClient(), kubeClient, ctrl.Log.WithName("resources"), mgrScheme),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NFSProvisioner")
 		os.Exit(1)
 	}
+	if err = (&controllers.NFSBackupReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("NFSBackup"),
+		Scheme: mgrScheme,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.NFSBackupScheduleReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("NFSBackupSchedule"),
+		Scheme: mgrScheme,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSBackupSchedule")
+		os.Exit(1)
+	}
+	if err = (&controllers.NFSShareReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("NFSShare"),
+		Scheme: mgrScheme,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSShare")
+		os.Exit(1)
+	}
+	if err = (&controllers.NFSImportReconciler{
+		Client:     mgr.GetClient(),
+		Log:        ctrl.Log.WithName("controllers").WithName("NFSImport"),
+		Scheme:     mgrScheme,
+		KubeClient: kubeClient,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSImport")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.NFSProvisionerPoolReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("NFSProvisionerPool"),
+		Scheme: mgrScheme,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSProvisionerPool")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.NFSBenchmarkReconciler{
+		Client:     mgr.GetClient(),
+		Log:        ctrl.Log.WithName("controllers").WithName("NFSBenchmark"),
+		Scheme:     mgrScheme,
+		KubeClient: kubeClient,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NFSBenchmark")
+		os.Exit(1)
+	}
+
+	// The webhook server needs a serving certificate. OLM mounts it, and so does config/default with the [WEBHOOK] and
+	// [CERTMANAGER] sections. Without it the operator runs without webhooks, and ENABLE_WEBHOOKS=false turns them off.
+	if os.Getenv("ENABLE_WEBHOOKS") == "false" {
+		setupLog.Info("Webhooks are disabled by ENABLE_WEBHOOKS")
+	} else if _, err := os.Stat(filepath.Join(webhookCertDir, "tls.crt")); err != nil {
+		setupLog.Info("Webhooks are not served, there is no serving certificate", "dir", webhookCertDir)
+	} else {
+		webhooks.SetupPersistentVolumeClaimWebhook(mgr)
+		webhooks.SetupNFSProvisionerWebhook(mgr)
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,121 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// nfs-server serves NFSv3 from the export in userspace and provisions a directory on it for each claim of its StorageClasses.
+// It is the operand of a NFSProvisioner with serverImplementation go.
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/jooho/nfs-provisioner-operator/nfsserver"
+)
+
+var (
+	scheme   = apiruntime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+}
+
+func main() {
+	var listenAddr string
+	var exportPath string
+	var provisioner string
+
+	flag.StringVar(&listenAddr, "listen", ":2049", "The address NFS and MOUNT are served on.")
+	flag.StringVar(&exportPath, "export", "/export", "The directory that is exported.")
+	flag.StringVar(&provisioner, "provisioner", "example.com/nfs", "The provisioner of the StorageClasses to provision claims for.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	// The Service and the namespace are set the same way as for the NFS-Ganesha provisioner
+	namespace := os.Getenv("POD_NAMESPACE")
+	service := os.Getenv("SERVICE_NAME")
+	if namespace == "" || service == "" {
+		setupLog.Error(nil, "POD_NAMESPACE and SERVICE_NAME must be set")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                server.Options{BindAddress: "0"},
+		HealthProbeBindAddress: ":8081",
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	if err = (&nfsserver.Provisioner{
+		Client:     mgr.GetClient(),
+		APIReader:  mgr.GetAPIReader(),
+		Log:        ctrl.Log.WithName("provisioner"),
+		Name:       provisioner,
+		ExportPath: exportPath,
+		Namespace:  namespace,
+		Service:    service,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Provisioner")
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		setupLog.Error(err, "unable to listen", "address", listenAddr)
+		os.Exit(1)
+	}
+	handler := nfsserver.NewHandler(exportPath)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		setupLog.Info("serving NFSv3", "address", listenAddr, "export", exportPath)
+		return nfsserver.Serve(ctx, listener, handler)
+	})); err != nil {
+		setupLog.Error(err, "unable to add the NFS server")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting nfs-server")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running nfs-server")
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,8 @@
 package defaults
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -34,10 +36,110 @@ const (
 	Service = "nfs-provisioner"
 	//SCForNFSProvisioner is for NFS Provisioner
 	SCForNFSProvisioner = "nfs"
-	// NFSImage is for setting anoter nfs provisioner operator image
-	NFSImage = "k8s.gcr.io/sig-storage/nfs-provisioner@sha256:e943bb77c7df05ebdc8c7888b2db289b13bf9f012d6a3a5a74f14d4d5743d439"
 	// NFSImage PullPolicy is to change pullpolicy for nfs provisioner operator image.
 	NFSImagePullPolicy = corev1.PullAlways
+	//PVMetadataFile is the file in a PV directory that holds the JSON of the original PV. It is read by NFSImport.
+	PVMetadataFile = ".nfs-provisioner-pv.json"
+	//OrphanAuditAnnotation runs an orphaned directory audit on demand when it is set to "true"
+	OrphanAuditAnnotation = "nfsprovisioner.jhouse.com/orphan-audit"
+	//OrphanAuditLabel marks the audit Jobs with the name of their NFSProvisioner
+	OrphanAuditLabel = "nfsprovisioner.jhouse.com/orphan-audit"
+	//OrphanGracePeriod is how long a directory stays orphaned before it is reclaimed by default
+	OrphanGracePeriod = 7 * 24 * time.Hour
+	//OrphanArchiveDir is the hidden directory on the export where archived orphans are moved
+	OrphanArchiveDir = ".orphans"
+	//QuotaUsageInterval is how often the usage of the volumes is measured by default
+	QuotaUsageInterval = time.Hour
+	//SELinuxType is the SELinux type of the hostPath directory, which containers can read and write
+	SELinuxType = "container_file_t"
+	//GaneshaConfigMap holds the NFS-Ganesha configuration rendered from the NFSProvisioner
+	GaneshaConfigMap = "nfs-provisioner-ganesha"
+	//GaneshaConfigHashAnnotation is the hash of the rendered configuration on the pod template, so that the pod rolls when it changes
+	GaneshaConfigHashAnnotation = "nfsprovisioner.jhouse.com/ganesha-config-hash"
+	//CapacityCheckLabel marks the Jobs that measure the free space of the export
+	CapacityCheckLabel = "nfsprovisioner.jhouse.com/capacity-check"
+	//CapacityCheckInterval is how often the free space of the export is measured by default
+	CapacityCheckInterval = 10 * time.Minute
+	//ShareCapacity is the capacity shown on the PVs and PVCs of a NFSShare by default
+	ShareCapacity = "1Gi"
+	//BackupKeepLast is the number of snapshots a NFSBackupSchedule keeps by default
+	BackupKeepLast = 7
+	//MigrationAcknowledgedAnnotation must be set to "true" on the NFSProvisioner to start a storage migration
+	MigrationAcknowledgedAnnotation = "nfsprovisioner.jhouse.com/migration-acknowledged"
+	//MigrationConfirmedAnnotation must be set to "true" on the NFSProvisioner to release the source volume after a storage migration
+	MigrationConfirmedAnnotation = "nfsprovisioner.jhouse.com/migration-confirmed"
+	//SubdirProvisionerDeployment is the provisioner for an external NFS server
+	SubdirProvisionerDeployment = "nfs-subdir-provisioner"
+	//Provisioner is the provisioner name of the StorageClass
+	Provisioner = "example.com/nfs"
+	//SelectedNodeLabelPrefix is the prefix of the label the operator puts on the node it selects for a NFSProvisioner
+	SelectedNodeLabelPrefix = "nfsprovisioner.jhouse.com/"
+	//ExportPath is where the export volume is mounted in NFS server and job pods
+	ExportPath = "/export"
+	//GoNFSUser is the user of the operator image, and the group of the export of the go NFS server outside OpenShift
+	GoNFSUser = 65532
+	//StandbyPvc is the storage of the standby NFS server
+	StandbyPvc = "nfs-server-standby"
+	//StandbyDeployment is the standby NFS server
+	StandbyDeployment = "nfs-provisioner-standby"
+	//StandbyService receives the replication while the primary is active
+	StandbyService = "nfs-provisioner-standby"
+	//StandbySyncInterval is the pause between two replication runs
+	StandbySyncInterval = time.Minute
+	//StandbyFailoverAfter is how long the primary is unavailable before an automatic failover
+	StandbyFailoverAfter = 2 * time.Minute
+	//FailoverAnnotation on the NFSProvisioner fails over to the standby
+	FailoverAnnotation = "nfsprovisioner.jhouse.com/failover"
+	//CSIDriverSuffix ends the name of the CSIDriver of each NFSProvisioner
+	CSIDriverSuffix = "nfs.csi.jhouse.com"
+	//CSIStorageClassSuffix ends the default name of the StorageClass of the CSI driver
+	CSIStorageClassSuffix = "-csi"
+	//CSINodeDaemonSet runs the CSI node plugin on every node
+	CSINodeDaemonSet = "nfs-csi-node"
+	//CSIRole lets the CSI sidecars elect a leader
+	CSIRole = "nfs-csi"
+	//CSIRoleBinding binds CSIRole to the service account
+	CSIRoleBinding = "nfs-csi"
+	//KubeletDir is the directory of the kubelet on the nodes
+	KubeletDir = "/var/lib/kubelet"
+	//ClonePhaseAnnotation on a PVC with another PVC as data source records the copy of the source directory
+	ClonePhaseAnnotation = "nfsprovisioner.jhouse.com/clone-phase"
+	//CloneLabel marks the Jobs that copy the directory of a cloned PVC
+	CloneLabel = "nfsprovisioner.jhouse.com/clone"
+	//UsageScanLabel marks the Jobs that measure the usage of the volumes for the quotas and the usage report
+	UsageScanLabel = "nfsprovisioner.jhouse.com/usage-scan"
+	//UsageScanInterval is how often the export is scanned for the usage report by default
+	UsageScanInterval = 6 * time.Hour
+	//UsageTopConsumers is how many volumes are listed in status.usage.topConsumers by default
+	UsageTopConsumers = 10
+	//UsageReportAnnotation on the NFSProvisioner writes the usage of every volume to a ConfigMap, in the format of its value: csv or json
+	UsageReportAnnotation = "nfsprovisioner.jhouse.com/usage-report"
+	//CanaryLabel marks the PVCs and Jobs of the canary with the name of their NFSProvisioner
+	CanaryLabel = "nfsprovisioner.jhouse.com/canary"
+	//CanaryInterval is the pause between two canary runs by default
+	CanaryInterval = 10 * time.Minute
+	//CanaryTimeout is how long a canary run may take by default, from the PVC to the checksum
+	CanaryTimeout = 5 * time.Minute
+	//PausedAnnotation pauses the operator for a NFSProvisioner when it is set to "true", like spec.paused
+	PausedAnnotation = "nfsprovisioner.jhouse.com/paused"
+	//PausedByAnnotation is set by the admission webhook to the user who paused a NFSProvisioner
+	PausedByAnnotation = "nfsprovisioner.jhouse.com/paused-by"
+	//MaintenanceAnnotation marks the StorageClass of a NFSProvisioner under maintenance, with the reason as value
+	MaintenanceAnnotation = "nfsprovisioner.jhouse.com/maintenance"
+	//MaintenanceReplicasAnnotation keeps the replicas of a Deployment scaled to zero for a maintenance
+	MaintenanceReplicasAnnotation = "nfsprovisioner.jhouse.com/maintenance-replicas"
+	//UpgradeRetryAnnotation retries a failed upgrade to the same image when it is set to "true"
+	UpgradeRetryAnnotation = "nfsprovisioner.jhouse.com/retry-upgrade"
+	//UpgradeLabel marks the pods that check the image of an upgrade with the name of their NFSProvisioner
+	UpgradeLabel = "nfsprovisioner.jhouse.com/upgrade"
+	//UpgradeTimeout is how long each step of an image upgrade may take by default
+	UpgradeTimeout = 10 * time.Minute
+	//BenchmarkLabel marks the PVC and Job of a NFSBenchmark with its name
+	BenchmarkLabel = "nfsprovisioner.jhouse.com/benchmark"
+	//BenchmarkDuration is how long the IOs of a NFSBenchmark run by default
+	BenchmarkDuration = time.Minute
+	//BenchmarkFileSize is the size of the file of each fio job by default
+	BenchmarkFileSize = "1Gi"
 )
 
 var (
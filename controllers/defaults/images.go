@@ -0,0 +1,149 @@
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// BuiltinNFSImage is the NFS-Ganesha image of this build. It was the CRD default of spec.nfsImageConfiguration.image,
+// so NFSProvisioners created with it carry it in their spec and are treated as if the image was not set.
+const BuiltinNFSImage = "k8s.gcr.io/sig-storage/nfs-provisioner@sha256:e943bb77c7df05ebdc8c7888b2db289b13bf9f012d6a3a5a74f14d4d5743d439"
+
+// The images of the operands. LoadRelatedImages replaces them with the RELATED_IMAGE_* environment variables of the CSV.
+var (
+	// NFSImage is the NFS-Ganesha provisioner image
+	NFSImage = BuiltinNFSImage
+	//BackupImage is the restic image that backs up and restores the export
+	BackupImage = "docker.io/restic/restic:0.17.3"
+	//UtilityImage runs small shell commands on the export, e.g. creating a directory
+	UtilityImage = "docker.io/library/busybox:1.36"
+	//RsyncImage is the image that copies the export during a storage migration
+	RsyncImage = "docker.io/instrumentisto/rsync-ssh:alpine3.20"
+	//SubdirProvisionerImage is the provisioner that creates a subdirectory per PV on an external NFS server
+	SubdirProvisionerImage = "registry.k8s.io/sig-storage/nfs-subdir-external-provisioner:v4.0.2"
+	//GoNFSImage is the operator image, which ships the userspace NFS server of serverImplementation go.
+	//It has no default, the manifests set RELATED_IMAGE_GO_NFS_SERVER to the image the operator runs.
+	GoNFSImage = ""
+	//CSIDriverImage is the operator image, which ships the CSI plugin.
+	//It has no default, the manifests set RELATED_IMAGE_CSI_DRIVER to the image the operator runs.
+	CSIDriverImage = ""
+	//CSIProvisionerImage creates and deletes the volumes of the claims
+	CSIProvisionerImage = "registry.k8s.io/sig-storage/csi-provisioner:v5.0.2"
+	//CSIResizerImage expands the volumes of the claims
+	CSIResizerImage = "registry.k8s.io/sig-storage/csi-resizer:v1.11.2"
+	//CSISnapshotterImage creates and deletes the snapshots of the VolumeSnapshots
+	CSISnapshotterImage = "registry.k8s.io/sig-storage/csi-snapshotter:v8.0.1"
+	//CSINodeDriverRegistrarImage registers the node plugin with the kubelet
+	CSINodeDriverRegistrarImage = "registry.k8s.io/sig-storage/csi-node-driver-registrar:v2.11.1"
+	//BenchmarkImage is an image with a shell and fio that runs the workload of a NFSBenchmark.
+	//The tag pins the nixpkgs release the image is built from, and so the version of fio.
+	BenchmarkImage = "nixery.dev/shell/fio:nixos-24.05"
+)
+
+// relatedImages maps the RELATED_IMAGE_* environment variables to the images they replace
+var relatedImages = map[string]*string{
+	"RELATED_IMAGE_NFS_PROVISIONER":           &NFSImage,
+	"RELATED_IMAGE_RESTIC":                    &BackupImage,
+	"RELATED_IMAGE_UTILITY":                   &UtilityImage,
+	"RELATED_IMAGE_RSYNC":                     &RsyncImage,
+	"RELATED_IMAGE_NFS_SUBDIR_PROVISIONER":    &SubdirProvisionerImage,
+	"RELATED_IMAGE_GO_NFS_SERVER":             &GoNFSImage,
+	"RELATED_IMAGE_CSI_DRIVER":                &CSIDriverImage,
+	"RELATED_IMAGE_CSI_PROVISIONER":           &CSIProvisionerImage,
+	"RELATED_IMAGE_CSI_RESIZER":               &CSIResizerImage,
+	"RELATED_IMAGE_CSI_SNAPSHOTTER":           &CSISnapshotterImage,
+	"RELATED_IMAGE_CSI_NODE_DRIVER_REGISTRAR": &CSINodeDriverRegistrarImage,
+	"RELATED_IMAGE_FIO":                       &BenchmarkImage,
+}
+
+// LoadRelatedImages replaces the operand images with the RELATED_IMAGE_* environment variables that are set,
+// and returns the variables it applied
+func LoadRelatedImages(getenv func(string) string) map[string]string {
+	applied := map[string]string{}
+	for env, image := range relatedImages {
+		if value := strings.TrimSpace(getenv(env)); value != "" {
+			*image = value
+			applied[env] = value
+		}
+	}
+	return applied
+}
+
+// ImageMirror points the images of the repository Source to the first of Mirrors.
+// It has the fields of the entries of an ImageDigestMirrorSet or an ImageTagMirrorSet, so they can be copied over.
+type ImageMirror struct {
+	// Source is a registry, or a repository with its registry, e.g. registry.k8s.io/sig-storage
+	Source string `json:"source"`
+	// Mirrors are the registries or repositories that replace Source. Only the first one is used
+	Mirrors []string `json:"mirrors"`
+}
+
+// OperatorConfig is the configuration file of the operator, usually mounted from a ConfigMap
+type OperatorConfig struct {
+	// ImageDigestMirrors replace the repository of the images referenced by digest, like an ImageDigestMirrorSet
+	ImageDigestMirrors []ImageMirror `json:"imageDigestMirrors,omitempty"`
+	// ImageTagMirrors replace the repository of the images referenced by tag, like an ImageTagMirrorSet
+	ImageTagMirrors []ImageMirror `json:"imageTagMirrors,omitempty"`
+	// ImagePullSecrets are added to every operand pod. The secrets must exist in the namespace of each NFSProvisioner
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// Config is the configuration of the operator loaded at start up
+var Config = OperatorConfig{}
+
+// LoadOperatorConfig reads the configuration file of the operator into Config. An empty path keeps the empty configuration.
+func LoadOperatorConfig(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	config := OperatorConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return fmt.Errorf("failed to parse the operator configuration %s: %w", path, err)
+	}
+	for _, mirror := range append(append([]ImageMirror{}, config.ImageDigestMirrors...), config.ImageTagMirrors...) {
+		if mirror.Source == "" || len(mirror.Mirrors) == 0 || mirror.Mirrors[0] == "" {
+			return fmt.Errorf("the image mirror of %q in %s needs a source and a mirror", mirror.Source, path)
+		}
+	}
+	for _, secret := range config.ImagePullSecrets {
+		if secret.Name == "" {
+			return fmt.Errorf("an image pull secret in %s has no name", path)
+		}
+	}
+	Config = config
+	return nil
+}
+
+// ResolveImage returns the image to pull for image, after the image mirrors of Config
+func ResolveImage(image string) string {
+	mirrors := Config.ImageTagMirrors
+	repository, reference := image, ""
+	if i := strings.Index(image, "@"); i >= 0 {
+		mirrors = Config.ImageDigestMirrors
+		repository, reference = image[:i], image[i:]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repository, reference = image[:i], image[i:]
+	}
+
+	// The most specific source wins
+	sources := make([]ImageMirror, len(mirrors))
+	copy(sources, mirrors)
+	sort.SliceStable(sources, func(i, j int) bool { return len(sources[i].Source) > len(sources[j].Source) })
+	for _, mirror := range sources {
+		source := strings.TrimSuffix(mirror.Source, "/")
+		if repository == source || strings.HasPrefix(repository, source+"/") {
+			return strings.TrimSuffix(mirror.Mirrors[0], "/") + repository[len(source):] + reference
+		}
+	}
+	return image
+}
@@ -0,0 +1,159 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// NFSBackupReconciler reconciles a NFSBackup object
+type NFSBackupReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackups/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile runs the backup or restore Job of a NFSBackup and records its result
+func (r *NFSBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsbackup", req.NamespacedName)
+
+	backup := &cachev1alpha1.NFSBackup{}
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSBackup resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSBackup")
+		return ctrl.Result{}, err
+	}
+
+	// A finished backup is never run again
+	if backup.Status.Phase == cachev1alpha1.BackupPhaseSucceeded || backup.Status.Phase == cachev1alpha1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	if backup.Spec.Restore != nil {
+		if err := resources.ValidateRestorePath(backup.Spec.Restore.Path); err != nil {
+			return r.updateStatus(ctx, backup, cachev1alpha1.BackupPhaseFailed, err.Error())
+		}
+	}
+
+	nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+	err := r.Get(ctx, types.NamespacedName{Name: backup.Spec.NFSProvisioner, Namespace: backup.Namespace}, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisioner for NFSBackup not found", "NFSProvisioner.Name", backup.Spec.NFSProvisioner)
+			if _, err := r.updateStatus(ctx, backup, cachev1alpha1.BackupPhasePending, "NFSProvisioner "+backup.Spec.NFSProvisioner+" not found"); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure the Job exists
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: resources.BackupJobName(backup.Name), Namespace: backup.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = resources.BuildBackupJob(nfsprovisioner, backup)
+		if err := ctrl.SetControllerReference(backup, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := r.Create(ctx, job); err != nil {
+			log.Error(err, "Failed to create a Job for NFSBackup", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return ctrl.Result{}, err
+		}
+
+		now := metav1.Now()
+		backup.Status.JobName = job.Name
+		backup.Status.StartTime = &now
+		return r.updateStatus(ctx, backup, cachev1alpha1.BackupPhaseRunning, "")
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	finished, succeeded := resources.JobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+
+	now := metav1.Now()
+	backup.Status.CompletionTime = &now
+
+	message, err := resources.JobTerminationMessage(ctx, r.Client, job)
+	if err != nil {
+		log.Error(err, "Failed to read the termination message of the Job", "Job.Name", job.Name)
+	}
+
+	if !succeeded {
+		return r.updateStatus(ctx, backup, cachev1alpha1.BackupPhaseFailed, message)
+	}
+
+	// Restores do not produce a summary
+	if backup.Spec.Restore == nil {
+		summary, err := resources.ParseBackupSummary(message)
+		if err != nil {
+			return r.updateStatus(ctx, backup, cachev1alpha1.BackupPhaseFailed, err.Error())
+		}
+		backup.Status.SnapshotID = summary.SnapshotID
+		backup.Status.FilesNew = summary.FilesNew
+		backup.Status.FilesChanged = summary.FilesChanged
+		backup.Status.BytesAdded = summary.DataAdded
+		backup.Status.BytesProcessed = summary.TotalBytesProcessed
+	}
+
+	return r.updateStatus(ctx, backup, cachev1alpha1.BackupPhaseSucceeded, "")
+}
+
+// updateStatus records the phase and message of the backup
+func (r *NFSBackupReconciler) updateStatus(ctx context.Context, backup *cachev1alpha1.NFSBackup, phase cachev1alpha1.BackupPhase, message string) (ctrl.Result, error) {
+	backup.Status.Phase = phase
+	backup.Status.Message = message
+	if err := r.Status().Update(ctx, backup); err != nil {
+		r.Log.Error(err, "Failed to update nfsbackup status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager return error
+func (r *NFSBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// NFSBackupScheduleReconciler reconciles a NFSBackupSchedule object
+type NFSBackupScheduleReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackupschedules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackupschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbackupschedules/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile keeps the backup CronJob of a NFSBackupSchedule in sync with its spec
+func (r *NFSBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsbackupschedule", req.NamespacedName)
+
+	schedule := &cachev1alpha1.NFSBackupSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, schedule); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSBackupSchedule resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSBackupSchedule")
+		return ctrl.Result{}, err
+	}
+
+	nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+	err := r.Get(ctx, types.NamespacedName{Name: schedule.Spec.NFSProvisioner, Namespace: schedule.Namespace}, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisioner for NFSBackupSchedule not found", "NFSProvisioner.Name", schedule.Spec.NFSProvisioner)
+			schedule.Status.Message = "NFSProvisioner " + schedule.Spec.NFSProvisioner + " not found"
+			if err := r.Status().Update(ctx, schedule); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	cronJob := resources.BuildBackupCronJob(nfsprovisioner, schedule)
+	if err := ctrl.SetControllerReference(schedule, cronJob, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cronJobFound := &batchv1.CronJob{}
+	err = r.Get(ctx, types.NamespacedName{Name: cronJob.Name, Namespace: cronJob.Namespace}, cronJobFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new CronJob", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+		if err := r.Create(ctx, cronJob); err != nil {
+			log.Error(err, "Failed to create a CronJob for NFSBackupSchedule", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+			return ctrl.Result{}, err
+		}
+		cronJobFound = cronJob
+	} else if err != nil {
+		return ctrl.Result{}, err
+	} else if !equality.Semantic.DeepDerivative(cronJob.Spec, cronJobFound.Spec) {
+		log.Info("Updating the CronJob", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+		cronJobFound.Spec = cronJob.Spec
+		if err := r.Update(ctx, cronJobFound); err != nil {
+			log.Error(err, "Failed to update the CronJob for NFSBackupSchedule", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	schedule.Status.CronJobName = cronJobFound.Name
+	schedule.Status.LastScheduleTime = cronJobFound.Status.LastScheduleTime
+	schedule.Status.LastSuccessfulTime = cronJobFound.Status.LastSuccessfulTime
+	schedule.Status.Message = ""
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		log.Error(err, "Failed to update nfsbackupschedule status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager return error
+func (r *NFSBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSBackupSchedule{}).
+		Owns(&batchv1.CronJob{}).
+		Complete(r)
+}
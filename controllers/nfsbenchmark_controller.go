@@ -0,0 +1,179 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// NFSBenchmarkReconciler reconciles a NFSBenchmark object
+type NFSBenchmarkReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// KubeClient reads the fio output of the workload pods
+	KubeClient kubernetes.Interface
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbenchmarks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbenchmarks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsbenchmarks/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+
+// Reconcile runs the fio workload of a NFSBenchmark on a temporary PVC and records its results
+func (r *NFSBenchmarkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsbenchmark", req.NamespacedName)
+
+	benchmark := &cachev1alpha1.NFSBenchmark{}
+	if err := r.Get(ctx, req.NamespacedName, benchmark); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSBenchmark resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSBenchmark")
+		return ctrl.Result{}, err
+	}
+
+	// A finished benchmark is never run again, a new NFSBenchmark is created to compare runs
+	if benchmark.Status.Phase == cachev1alpha1.BenchmarkPhaseSucceeded || benchmark.Status.Phase == cachev1alpha1.BenchmarkPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	profile, err := resources.BenchmarkProfile(benchmark)
+	if err != nil {
+		return r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhaseFailed, err.Error())
+	}
+
+	nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+	err = r.Get(ctx, types.NamespacedName{Name: benchmark.Spec.NFSProvisioner, Namespace: benchmark.Namespace}, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisioner for NFSBenchmark not found", "NFSProvisioner.Name", benchmark.Spec.NFSProvisioner)
+			if _, err := r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhasePending, "NFSProvisioner "+benchmark.Spec.NFSProvisioner+" not found"); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure the temporary PVC and the Job exist. The pods wait for the PVC to be bound.
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: resources.BenchmarkName(benchmark.Name), Namespace: benchmark.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		pvc := resources.BuildBenchmarkPVC(nfsprovisioner, benchmark, profile)
+		if err := ctrl.SetControllerReference(benchmark, pvc, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Creating a new PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		if err := r.Create(ctx, pvc); err != nil && !errors.IsAlreadyExists(err) {
+			log.Error(err, "Failed to create a PVC for NFSBenchmark", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+			return ctrl.Result{}, err
+		}
+
+		job = resources.BuildBenchmarkJob(nfsprovisioner, benchmark, profile)
+		if err := ctrl.SetControllerReference(benchmark, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := r.Create(ctx, job); err != nil {
+			log.Error(err, "Failed to create a Job for NFSBenchmark", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return ctrl.Result{}, err
+		}
+
+		now := metav1.Now()
+		benchmark.Status.StorageClass = *pvc.Spec.StorageClassName
+		benchmark.Status.PVC = pvc.Name
+		benchmark.Status.JobName = job.Name
+		benchmark.Status.StartTime = &now
+		return r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhaseRunning, "")
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	finished, succeeded := resources.JobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+
+	logs, nodes, err := resources.JobPodLogs(ctx, r.Client, r.KubeClient, job)
+	if err != nil {
+		log.Error(err, "Failed to read the logs of the Job", "Job.Name", job.Name)
+		return ctrl.Result{}, err
+	}
+	benchmark.Status.Nodes = nodes
+
+	// The files of the workload are not kept
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: resources.BenchmarkName(benchmark.Name), Namespace: benchmark.Namespace}}
+	if err := r.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the PVC of NFSBenchmark", "PVC.Name", pvc.Name)
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	benchmark.Status.CompletionTime = &now
+
+	if !succeeded {
+		message := resources.BenchmarkFailureMessage(ctx, r.Client, job)
+		return r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhaseFailed, message)
+	}
+
+	read, write, err := resources.AggregateBenchmarkResults(logs)
+	if err != nil {
+		return r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhaseFailed, err.Error())
+	}
+	benchmark.Status.Read = read
+	benchmark.Status.Write = write
+
+	return r.updateStatus(ctx, benchmark, cachev1alpha1.BenchmarkPhaseSucceeded, "")
+}
+
+// updateStatus records the phase and message of the benchmark
+func (r *NFSBenchmarkReconciler) updateStatus(ctx context.Context, benchmark *cachev1alpha1.NFSBenchmark, phase cachev1alpha1.BenchmarkPhase, message string) (ctrl.Result, error) {
+	benchmark.Status.Phase = phase
+	benchmark.Status.Message = message
+	if err := r.Status().Update(ctx, benchmark); err != nil {
+		r.Log.Error(err, "Failed to update nfsbenchmark status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager return error
+func (r *NFSBenchmarkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSBenchmark{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,275 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// NFSImportReconciler reconciles a NFSImport object
+type NFSImportReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// KubeClient reads the logs of the scan Job
+	KubeClient kubernetes.Interface
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsimports,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsimports/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsimports/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// Reconcile scans the export of a NFSImport and, once applied, recreates the PVs of the directories it found
+func (r *NFSImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsimport", req.NamespacedName)
+
+	nfsImport := &cachev1alpha1.NFSImport{}
+	if err := r.Get(ctx, req.NamespacedName, nfsImport); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSImport resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSImport")
+		return ctrl.Result{}, err
+	}
+
+	// A finished import is never run again
+	if nfsImport.Status.Phase == cachev1alpha1.ImportPhaseCompleted || nfsImport.Status.Phase == cachev1alpha1.ImportPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+	err := r.Get(ctx, types.NamespacedName{Name: nfsImport.Spec.NFSProvisioner, Namespace: nfsImport.Namespace}, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisioner for NFSImport not found", "NFSProvisioner.Name", nfsImport.Spec.NFSProvisioner)
+			return r.requeueStatus(ctx, nfsImport, "NFSProvisioner "+nfsImport.Spec.NFSProvisioner+" not found")
+		}
+		return ctrl.Result{}, err
+	}
+
+	switch nfsImport.Status.Phase {
+	case "", cachev1alpha1.ImportPhasePending:
+		return r.scan(ctx, nfsprovisioner, nfsImport)
+	case cachev1alpha1.ImportPhaseScanning:
+		return r.readScan(ctx, nfsprovisioner, nfsImport)
+	case cachev1alpha1.ImportPhaseScanned:
+		if !nfsImport.Spec.Apply {
+			return ctrl.Result{}, nil
+		}
+		return r.apply(ctx, nfsprovisioner, nfsImport)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// scan creates the Job that lists the directories of the export
+func (r *NFSImportReconciler) scan(ctx context.Context, nfsprovisioner *cachev1alpha1.NFSProvisioner, nfsImport *cachev1alpha1.NFSImport) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsimport", types.NamespacedName{Name: nfsImport.Name, Namespace: nfsImport.Namespace})
+
+	job := resources.BuildImportScanJob(nfsprovisioner, nfsImport)
+	if err := ctrl.SetControllerReference(nfsImport, job, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create a Job for NFSImport", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return ctrl.Result{}, err
+	}
+
+	nfsImport.Status.JobName = job.Name
+	return r.updateStatus(ctx, nfsImport, cachev1alpha1.ImportPhaseScanning, "")
+}
+
+// readScan records the directories reported by the finished scan Job
+func (r *NFSImportReconciler) readScan(ctx context.Context, nfsprovisioner *cachev1alpha1.NFSProvisioner, nfsImport *cachev1alpha1.NFSImport) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsimport", types.NamespacedName{Name: nfsImport.Name, Namespace: nfsImport.Namespace})
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nfsImport.Status.JobName, Namespace: nfsImport.Namespace}, job); err != nil {
+		if errors.IsNotFound(err) {
+			return r.updateStatus(ctx, nfsImport, cachev1alpha1.ImportPhasePending, "The scan Job was deleted")
+		}
+		return ctrl.Result{}, err
+	}
+
+	finished, succeeded := resources.JobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+	if !succeeded {
+		message, err := resources.JobTerminationMessage(ctx, r.Client, job)
+		if err != nil {
+			log.Error(err, "Failed to read the termination message of the Job", "Job.Name", job.Name)
+		}
+		return r.updateStatus(ctx, nfsImport, cachev1alpha1.ImportPhaseFailed, message)
+	}
+
+	logs, err := resources.JobLogs(ctx, r.Client, r.KubeClient, job)
+	if err != nil {
+		log.Error(err, "Failed to read the logs of the Job", "Job.Name", job.Name)
+		return ctrl.Result{}, err
+	}
+
+	selected := map[string]bool{}
+	for _, dir := range nfsImport.Spec.Directories {
+		selected[dir] = true
+	}
+
+	storageClassName := nfsImport.Spec.StorageClassName
+	if storageClassName == "" {
+		storageClassName = resources.StorageClassName(nfsprovisioner)
+	}
+
+	// The namespaces tell the namespace from the PVC name in the directory names
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaceList); err != nil {
+		return ctrl.Result{}, err
+	}
+	namespaces := map[string]bool{}
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.Name] = true
+	}
+
+	volumes := []cachev1alpha1.ImportedVolume{}
+	for _, dir := range resources.ParseImportScan(logs) {
+		if len(selected) > 0 && !selected[dir.Directory] {
+			continue
+		}
+		volumes = append(volumes, resources.PlanImportedVolume(dir, storageClassName, namespaces))
+	}
+
+	nfsImport.Status.Volumes = volumes
+	return r.updateStatus(ctx, nfsImport, cachev1alpha1.ImportPhaseScanned, "")
+}
+
+// apply creates the PVs of the scanned directories and, when requested, their PVCs
+func (r *NFSImportReconciler) apply(ctx context.Context, nfsprovisioner *cachev1alpha1.NFSProvisioner, nfsImport *cachev1alpha1.NFSImport) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsimport", types.NamespacedName{Name: nfsImport.Name, Namespace: nfsImport.Namespace})
+
+	server, exportPath, err := resources.NFSServerAddress(ctx, r.Client, nfsprovisioner)
+	if err != nil {
+		return r.requeueStatus(ctx, nfsImport, err.Error())
+	}
+
+	for i := range nfsImport.Status.Volumes {
+		volume := &nfsImport.Status.Volumes[i]
+		// Directories with a planning problem are listed but not imported
+		if volume.PersistentVolume == "" || volume.Imported || volume.Message != "" {
+			continue
+		}
+
+		pv, err := resources.BuildImportedPV(*volume, server, exportPath)
+		if err != nil {
+			volume.Message = err.Error()
+			continue
+		}
+
+		err = r.Get(ctx, types.NamespacedName{Name: pv.Name}, &corev1.PersistentVolume{})
+		if err == nil {
+			volume.Message = "PersistentVolume already exists"
+			continue
+		} else if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Creating a new PersistentVolume", "PersistentVolume.Name", pv.Name)
+		if err := r.Create(ctx, pv); err != nil {
+			log.Error(err, "Failed to create a PersistentVolume for NFSImport", "PersistentVolume.Name", pv.Name)
+			volume.Message = err.Error()
+			continue
+		}
+		volume.Imported = true
+		volume.Message = ""
+
+		if nfsImport.Spec.CreateClaims && pv.Spec.ClaimRef != nil {
+			volume.Message = r.createClaim(ctx, pv)
+		}
+	}
+
+	return r.updateStatus(ctx, nfsImport, cachev1alpha1.ImportPhaseCompleted, "")
+}
+
+// createClaim creates the PVC of an imported PV and returns why it was skipped, if it was
+func (r *NFSImportReconciler) createClaim(ctx context.Context, pv *corev1.PersistentVolume) string {
+	pvc := resources.BuildImportedPVC(pv)
+
+	if err := r.Get(ctx, types.NamespacedName{Name: pvc.Namespace}, &corev1.Namespace{}); err != nil {
+		return "PVC not created: namespace " + pvc.Namespace + " not found"
+	}
+
+	err := r.Get(ctx, types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, &corev1.PersistentVolumeClaim{})
+	if err == nil {
+		return "PVC not created: it already exists"
+	} else if !errors.IsNotFound(err) {
+		return "PVC not created: " + err.Error()
+	}
+
+	r.Log.Info("Creating a new PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+	if err := r.Create(ctx, pvc); err != nil {
+		r.Log.Error(err, "Failed to create a PVC for NFSImport", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		return "PVC not created: " + err.Error()
+	}
+	return ""
+}
+
+// requeueStatus records a pending import and checks it again later
+func (r *NFSImportReconciler) requeueStatus(ctx context.Context, nfsImport *cachev1alpha1.NFSImport, message string) (ctrl.Result, error) {
+	nfsImport.Status.Message = message
+	if err := r.Status().Update(ctx, nfsImport); err != nil {
+		r.Log.Error(err, "Failed to update nfsimport status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// updateStatus records the phase and message of the import
+func (r *NFSImportReconciler) updateStatus(ctx context.Context, nfsImport *cachev1alpha1.NFSImport, phase cachev1alpha1.ImportPhase, message string) (ctrl.Result, error) {
+	nfsImport.Status.Phase = phase
+	nfsImport.Status.Message = message
+	if err := r.Status().Update(ctx, nfsImport); err != nil {
+		r.Log.Error(err, "Failed to update nfsimport status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager return error
+func (r *NFSImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSImport{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -19,8 +19,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -48,6 +50,111 @@ func validate(m *cachev1alpha1.NFSProvisioner) error {
 	pvc := m.Spec.Pvc
 	sc := m.Spec.SCForNFSPvc
 	hostPathDir := m.Spec.HostPathDir
+
+	if m.Spec.Mode == cachev1alpha1.ModeExternal {
+		if m.Spec.External == nil || m.Spec.External.Server == "" || m.Spec.External.Path == "" {
+			return fmt.Errorf("external.server and external.path must be set in External mode")
+		}
+		if pvc != "" || sc != "" || hostPathDir != "" {
+			return fmt.Errorf("Pvc, scForPvc or hostPathDir can not set in External mode")
+		}
+		if m.Spec.Quota != nil && m.Spec.Quota.Enabled {
+			return fmt.Errorf("quota can not be enabled in External mode")
+		}
+		if m.Spec.Ganesha != nil {
+			return fmt.Errorf("ganesha can not set in External mode")
+		}
+		if m.Spec.LogLevel != "" || len(m.Spec.ExtraArgs) > 0 || len(m.Spec.ExtraEnv) > 0 {
+			return fmt.Errorf("logLevel, extraArgs or extraEnv can not set in External mode")
+		}
+		if len(m.Spec.ExtraContainers) > 0 || len(m.Spec.InitContainers) > 0 || len(m.Spec.ExtraVolumes) > 0 || len(m.Spec.ExtraVolumeMounts) > 0 {
+			return fmt.Errorf("extraContainers, initContainers, extraVolumes or extraVolumeMounts can not set in External mode")
+		}
+		if m.Spec.Topology != nil && m.Spec.Topology.Enabled {
+			return fmt.Errorf("topology can not be enabled in External mode")
+		}
+		if m.Spec.Standby != nil && m.Spec.Standby.Enabled {
+			return fmt.Errorf("standby can not be enabled in External mode")
+		}
+		if m.Spec.ServerImplementation == cachev1alpha1.ServerImplementationGo {
+			return fmt.Errorf("serverImplementation go can not set in External mode")
+		}
+		if m.Spec.CSI != nil && m.Spec.CSI.Enabled {
+			return fmt.Errorf("csi can not be enabled in External mode")
+		}
+		if m.Spec.Upgrade != nil {
+			return fmt.Errorf("upgrade can not set in External mode")
+		}
+		return nil
+	}
+
+	if m.Spec.External != nil {
+		return fmt.Errorf("external can only set in External mode")
+	}
+
+	if m.Spec.HostPathPreparation != nil && m.Spec.HostPathPreparation.Enabled && hostPathDir == "" {
+		return fmt.Errorf("hostPathPreparation can only set with hostPathDir")
+	}
+
+	if m.Spec.NodeSelection != nil {
+		if hostPathDir == "" {
+			return fmt.Errorf("nodeSelection can only set with hostPathDir")
+		}
+		if m.Spec.NodeSelection.Mode == cachev1alpha1.NodeSelectionNodeName && m.Spec.NodeSelection.NodeName == "" {
+			return fmt.Errorf("nodeName must be set in NodeName mode")
+		}
+		if m.Spec.NodeSelection.Mode != cachev1alpha1.NodeSelectionNodeName && m.Spec.NodeSelection.NodeName != "" {
+			return fmt.Errorf("nodeName can only set in NodeName mode")
+		}
+	}
+
+	// The go NFS server runs unprivileged, and has none of the NFS-Ganesha specific options
+	if m.Spec.ServerImplementation == cachev1alpha1.ServerImplementationGo {
+		if hostPathDir != "" {
+			return fmt.Errorf("hostPathDir can not set with serverImplementation go, hostPath volumes are not allowed for an unprivileged pod")
+		}
+		if m.Spec.Quota != nil && m.Spec.Quota.Enabled {
+			return fmt.Errorf("quota can not be enabled with serverImplementation go")
+		}
+		if m.Spec.Ganesha != nil {
+			return fmt.Errorf("ganesha can not set with serverImplementation go")
+		}
+		if len(m.Spec.ExtraArgs) > 0 {
+			return fmt.Errorf("extraArgs can not set with serverImplementation go")
+		}
+		if m.Spec.Standby != nil && m.Spec.Standby.Enabled {
+			return fmt.Errorf("standby can not be enabled with serverImplementation go")
+		}
+	}
+
+	// Both StorageClasses exist side by side
+	if m.Spec.CSI != nil && m.Spec.CSI.StorageClassName != "" && m.Spec.CSI.StorageClassName == resources.StorageClassName(m) {
+		return fmt.Errorf("csi.storageClassName must differ from scForNFS")
+	}
+
+	// The promoted standby is the NFS server, there is nothing to fail back to
+	if (m.Spec.Standby == nil || !m.Spec.Standby.Enabled) && m.Status.Standby != nil && m.Status.Standby.Active == cachev1alpha1.StandbyRoleStandby {
+		return fmt.Errorf("standby can not be disabled after a failover to the standby")
+	}
+
+	if err := resources.ValidateExtraArgs(m); err != nil {
+		return err
+	}
+	if err := resources.ValidateExtraEnv(m); err != nil {
+		return err
+	}
+	if err := resources.ValidatePodExtras(m); err != nil {
+		return err
+	}
+
+	if m.Spec.Ganesha != nil {
+		for _, client := range m.Spec.Ganesha.Clients {
+			if _, _, err := net.ParseCIDR(client.CIDR); err != nil && net.ParseIP(client.CIDR) == nil {
+				return fmt.Errorf("ganesha client %q is not a CIDR or an IP address", client.CIDR)
+			}
+		}
+	}
+
 	if pvc != "" && (sc != "" || hostPathDir != "") {
 		return fmt.Errorf("scForPvc or hostPathDir can not set with Pvc")
 	}
@@ -75,13 +182,27 @@ func validate(m *cachev1alpha1.NFSProvisioner) error {
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=policy,resources=podsecuritypolicies,verbs=use
 // +kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=csidrivers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=csinodes;volumeattachments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims/status,verbs=update;patch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotclasses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots;volumesnapshotcontents,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotcontents/status,verbs=update;patch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is main method for operator
 func (r *NFSProvisionerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -107,7 +228,7 @@ func (r *NFSProvisionerReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	if err = validate(nfsprovisioner); err != nil {
 		log.Error(err, fmt.Sprintf("pvc: %s | sc: %s | hostPathDir: %s", nfsprovisioner.Spec.Pvc, nfsprovisioner.Spec.SCForNFSPvc, nfsprovisioner.Spec.HostPathDir))
 
-		nfsprovisioner.Status.Error = fmt.Sprintf("pvc: %s | sc: %s | hostPathDir: %s", nfsprovisioner.Spec.Pvc, nfsprovisioner.Spec.SCForNFSPvc, nfsprovisioner.Spec.HostPathDir)
+		nfsprovisioner.Status.Error = fmt.Sprintf("%s (pvc: %s | sc: %s | hostPathDir: %s)", err, nfsprovisioner.Spec.Pvc, nfsprovisioner.Spec.SCForNFSPvc, nfsprovisioner.Spec.HostPathDir)
 		nfsprovisioner.Status.Nodes = []string{}
 		err := r.Status().Update(ctx, nfsprovisioner)
 		if err != nil {
@@ -119,9 +240,20 @@ func (r *NFSProvisionerReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	}
 
 	// Ensure required resources using resource managers
-	if err := r.ResourceManager.EnsureAllResources(ctx, nfsprovisioner); err != nil {
-		log.Error(err, "Failed to ensure required resources")
-		return ctrl.Result{}, err
+	// Resource managers record their progress in the status, which is persisted afterwards
+	originalStatus := nfsprovisioner.Status.DeepCopy()
+	// The spec is valid again, so an error of a previous validation is cleared
+	nfsprovisioner.Status.Error = ""
+	ensureErr := r.ResourceManager.EnsureAllResources(ctx, nfsprovisioner)
+	if !equality.Semantic.DeepEqual(originalStatus, &nfsprovisioner.Status) {
+		if err := r.Status().Update(ctx, nfsprovisioner); err != nil {
+			log.Error(err, "Failed to update nfsprovisioner status")
+			return ctrl.Result{}, err
+		}
+	}
+	if ensureErr != nil {
+		log.Error(ensureErr, "Failed to ensure required resources")
+		return ctrl.Result{}, ensureErr
 	}
 
 	// Delete Logic
@@ -212,6 +344,11 @@ func (r *NFSProvisionerReconciler) deleteExternalResources(m *cachev1alpha1.NFSP
 		}
 	}
 
+	if err := resources.RemoveNodeLabels(ctx, r.Client, m, ""); err != nil {
+		log.Error(err, "Failed to remove the node label for NFSProvisioner", "Label", resources.SelectedNodeLabel(m))
+		return err
+	}
+
 	return nil
 }
 
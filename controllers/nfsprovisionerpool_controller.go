@@ -0,0 +1,654 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// poolFinalizerName removes the servers and the StorageClass of a NFSProvisionerPool before it is deleted
+const poolFinalizerName = "nfsprovisionerpool.finalizers.jhouse.io"
+
+// NFSProvisionerPoolReconciler reconciles a NFSProvisionerPool object
+type NFSProvisionerPoolReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// poolServer is a server of the pool with its status
+type poolServer struct {
+	status         *cachev1alpha1.PoolServerStatus
+	nfsProvisioner *cachev1alpha1.NFSProvisioner
+	// terminating is true while the namespace of the server is being deleted
+	terminating bool
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsprovisionerpools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsprovisionerpools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsprovisionerpools/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsprovisioners,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile deploys the servers of a NFSProvisionerPool and provisions the PVs of its StorageClass on them
+func (r *NFSProvisionerPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsprovisionerpool", req.NamespacedName)
+
+	pool := &cachev1alpha1.NFSProvisionerPool{}
+	if err := r.Get(ctx, req.NamespacedName, pool); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisionerPool resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSProvisionerPool")
+		return ctrl.Result{}, err
+	}
+
+	if !pool.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, pool)
+	}
+
+	if !controllerutil.ContainsFinalizer(pool, poolFinalizerName) {
+		log.Info("Adding Finalizer for the NFSProvisionerPool")
+		controllerutil.AddFinalizer(pool, poolFinalizerName)
+		if err := r.Update(ctx, pool); err != nil {
+			log.Error(err, "Failed to update CR NFSProvisionerPool to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := resources.ValidatePoolTemplate(pool); err != nil {
+		pool.Status.Message = err.Error()
+		return ctrl.Result{}, r.updateStatus(ctx, pool)
+	}
+
+	if err := r.ensureStorageClass(ctx, pool); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	servers, err := r.ensureServers(ctx, pool)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := r.List(ctx, pvs, client.MatchingLabels(resources.PoolLabels(pool))); err != nil {
+		return ctrl.Result{}, err
+	}
+	countVolumes(servers, pvs.Items)
+
+	busy, err := r.reclaimVolumes(ctx, pool, servers, pvs.Items)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// A server beyond spec.servers is removed once its volumes are gone
+	for _, server := range servers {
+		if !server.status.Draining || server.status.Volumes > 0 || server.terminating {
+			continue
+		}
+		log.Info("Deleting the namespace of a drained server", "Namespace", server.status.Namespace)
+		if err := r.Delete(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: server.status.Namespace}}); err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		server.terminating = true
+		server.status.Message = "Deleting the server"
+	}
+
+	pending, provisioning, err := r.provisionClaims(ctx, pool, servers)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pool.Status.StorageClass = resources.PoolStorageClassName(pool)
+	pool.Status.Servers = []cachev1alpha1.PoolServerStatus{}
+	pool.Status.ReadyServers = 0
+	for _, server := range servers {
+		pool.Status.Servers = append(pool.Status.Servers, *server.status)
+		if server.status.Ready && !server.status.Draining {
+			pool.Status.ReadyServers++
+		}
+	}
+	pool.Status.PendingClaims = pending
+	pool.Status.Message = ""
+	if err := r.updateStatus(ctx, pool); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if busy || provisioning || len(pending) > 0 || pool.Status.ReadyServers < pool.Spec.Servers {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	// The capacity of the servers is refreshed periodically
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// ensureStorageClass creates the StorageClass of the pool
+func (r *NFSProvisionerPoolReconciler) ensureStorageClass(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool) error {
+	sc := resources.BuildPoolStorageClass(pool)
+	err := r.Get(ctx, types.NamespacedName{Name: sc.Name}, &storagev1.StorageClass{})
+	if err != nil && errors.IsNotFound(err) {
+		r.Log.Info("Creating a new Storageclass", "Storageclass.Name", sc.Name)
+		if err := r.Create(ctx, sc); err != nil {
+			r.Log.Error(err, "Failed to create a Storageclass for NFSProvisionerPool", "Storageclass.Name", sc.Name)
+			return err
+		}
+		return nil
+	}
+	return err
+}
+
+// ensureServers creates the namespace and the NFSProvisioner of each server, and returns every server of the pool with its health
+func (r *NFSProvisionerPoolReconciler) ensureServers(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool) ([]*poolServer, error) {
+	log := r.Log.WithValues("nfsprovisionerpool", types.NamespacedName{Name: pool.Name, Namespace: pool.Namespace})
+
+	indexes := map[int32]bool{}
+	for i := int32(0); i < pool.Spec.Servers; i++ {
+		indexes[i] = true
+	}
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabels(resources.PoolLabels(pool))); err != nil {
+		return nil, err
+	}
+	terminating := map[string]bool{}
+	for _, namespace := range namespaces.Items {
+		if index, err := strconv.Atoi(namespace.Labels[resources.PoolServerLabel]); err == nil {
+			indexes[int32(index)] = true
+		}
+		terminating[namespace.Name] = !namespace.DeletionTimestamp.IsZero()
+	}
+	sorted := []int32{}
+	for index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	servers := []*poolServer{}
+	for _, index := range sorted {
+		server := &poolServer{status: &cachev1alpha1.PoolServerStatus{
+			Index:     index,
+			Namespace: resources.PoolServerNamespace(pool, index),
+			Draining:  index >= pool.Spec.Servers,
+		}}
+		servers = append(servers, server)
+		if terminating[server.status.Namespace] {
+			server.terminating = true
+			server.status.Message = "Deleting the server"
+			continue
+		}
+
+		if !server.status.Draining {
+			namespace := resources.BuildPoolNamespace(pool, index)
+			err := r.Get(ctx, types.NamespacedName{Name: namespace.Name}, &corev1.Namespace{})
+			if err != nil && errors.IsNotFound(err) {
+				log.Info("Creating a new Namespace for a server", "Namespace", namespace.Name)
+				if err := r.Create(ctx, namespace); err != nil {
+					log.Error(err, "Failed to create a Namespace for NFSProvisionerPool", "Namespace", namespace.Name)
+					return nil, err
+				}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		desired := resources.BuildPoolServer(pool, index)
+		found := &cachev1alpha1.NFSProvisioner{}
+		err := r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, found)
+		if err != nil && errors.IsNotFound(err) {
+			if server.status.Draining {
+				server.status.Message = "NFSProvisioner not found"
+				continue
+			}
+			log.Info("Creating a new NFSProvisioner for a server", "NFSProvisioner.Namespace", desired.Namespace)
+			if err := r.Create(ctx, desired); err != nil {
+				log.Error(err, "Failed to create a NFSProvisioner for NFSProvisionerPool", "NFSProvisioner.Namespace", desired.Namespace)
+				return nil, err
+			}
+			server.status.Message = "Creating the server"
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		server.nfsProvisioner = found
+
+		// The servers follow the template
+		if !server.status.Draining && !equality.Semantic.DeepEqual(found.Spec, desired.Spec) {
+			log.Info("Updating the NFSProvisioner of a server", "NFSProvisioner.Namespace", found.Namespace)
+			found.Spec = desired.Spec
+			if err := r.Update(ctx, found); err != nil {
+				return nil, err
+			}
+		}
+
+		if found.Status.Export != nil {
+			server.status.TotalBytes = found.Status.Export.TotalBytes
+			server.status.AvailableBytes = found.Status.Export.AvailableBytes
+		}
+		if err := r.checkServer(ctx, server); err != nil {
+			return nil, err
+		}
+	}
+	return servers, nil
+}
+
+// checkServer marks the server ready when its NFS server is available
+func (r *NFSProvisionerPoolReconciler) checkServer(ctx context.Context, server *poolServer) error {
+	if server.nfsProvisioner.Status.Error != "" {
+		server.status.Message = server.nfsProvisioner.Status.Error
+		return nil
+	}
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: server.status.Namespace}, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			server.status.Message = "NFS server not deployed"
+			return nil
+		}
+		return err
+	}
+	if deployment.Status.AvailableReplicas == 0 {
+		server.status.Message = "NFS server not available"
+		return nil
+	}
+	server.status.Ready = true
+	return nil
+}
+
+// countVolumes adds the PVs of the pool to their servers
+func countVolumes(servers []*poolServer, pvs []corev1.PersistentVolume) {
+	for _, pv := range pvs {
+		server := findPoolServer(servers, pv.Labels[resources.PoolServerLabel])
+		if server == nil {
+			continue
+		}
+		server.status.Volumes++
+		capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+		server.status.RequestedBytes += capacity.Value()
+	}
+}
+
+// findPoolServer returns the server with the index label value, or nil
+func findPoolServer(servers []*poolServer, index string) *poolServer {
+	for _, server := range servers {
+		if strconv.Itoa(int(server.status.Index)) == index {
+			return server
+		}
+	}
+	return nil
+}
+
+// reclaimVolumes removes the directories of released PVs whose reclaim policy is Delete, then the PVs.
+// It reports whether a deletion is still running.
+func (r *NFSProvisionerPoolReconciler) reclaimVolumes(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool, servers []*poolServer, pvs []corev1.PersistentVolume) (bool, error) {
+	log := r.Log.WithValues("nfsprovisionerpool", types.NamespacedName{Name: pool.Name, Namespace: pool.Namespace})
+
+	busy := false
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Status.Phase != corev1.VolumeReleased || pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		busy = true
+
+		server := findPoolServer(servers, pv.Labels[resources.PoolServerLabel])
+		if server != nil && server.nfsProvisioner != nil && server.status.Ready {
+			job := &batchv1.Job{}
+			jobName := resources.PoolJobName(string(pv.Spec.ClaimRef.UID), true)
+			err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: server.status.Namespace}, job)
+			if err != nil && errors.IsNotFound(err) {
+				job = resources.BuildPoolDirectoryJob(server.nfsProvisioner, pool, server.status.Index, string(pv.Spec.ClaimRef.UID), pv.Annotations[resources.PoolDirectoryAnnotation], true)
+				if err := ctrl.SetControllerReference(server.nfsProvisioner, job, r.Scheme); err != nil {
+					return busy, err
+				}
+				log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				if err := r.Create(ctx, job); err != nil {
+					return busy, err
+				}
+				continue
+			} else if err != nil {
+				return busy, err
+			}
+			finished, succeeded := resources.JobFinished(job)
+			if !finished {
+				continue
+			}
+			if !succeeded {
+				message, _ := resources.JobTerminationMessage(ctx, r.Client, job)
+				log.Info("Deleting the directory of the PersistentVolume failed", "PersistentVolume.Name", pv.Name, "Message", message)
+				continue
+			}
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+				return busy, err
+			}
+		} else if server != nil && !server.status.Draining {
+			// The directory is removed once the server is back
+			continue
+		}
+
+		// A server that is gone took the directory with it
+		log.Info("Deleting the PersistentVolume", "PersistentVolume.Name", pv.Name)
+		if err := r.Delete(ctx, pv); err != nil && !errors.IsNotFound(err) {
+			return busy, err
+		}
+		if server != nil {
+			server.status.Volumes--
+			capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+			server.status.RequestedBytes -= capacity.Value()
+		}
+	}
+	return busy, nil
+}
+
+// provisionClaims creates the directory and the PV of each pending claim of the StorageClass on a server.
+// It returns the claims that can not be placed and whether a directory is being created.
+func (r *NFSProvisionerPoolReconciler) provisionClaims(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool, servers []*poolServer) ([]string, bool, error) {
+	log := r.Log.WithValues("nfsprovisionerpool", types.NamespacedName{Name: pool.Name, Namespace: pool.Namespace})
+
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims); err != nil {
+		return nil, false, err
+	}
+	sort.Slice(claims.Items, func(i, j int) bool {
+		return claims.Items[i].CreationTimestamp.Before(&claims.Items[j].CreationTimestamp)
+	})
+
+	pending := []string{}
+	provisioning := false
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName != resources.PoolStorageClassName(pool) ||
+			claim.Spec.VolumeName != "" || !claim.DeletionTimestamp.IsZero() {
+			continue
+		}
+		// The PV exists and waits to be bound
+		err := r.Get(ctx, types.NamespacedName{Name: resources.PoolVolumeName(claim)}, &corev1.PersistentVolume{})
+		if err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return nil, false, err
+		}
+
+		job, server, err := r.claimJob(ctx, claim, servers)
+		if err != nil {
+			return nil, false, err
+		}
+		if job == nil {
+			var reason string
+			server, reason, err = r.placeClaim(ctx, pool, claim, servers)
+			if err != nil {
+				return nil, false, err
+			}
+			if server == nil {
+				pending = append(pending, fmt.Sprintf("%s/%s: %s", claim.Namespace, claim.Name, reason))
+				continue
+			}
+
+			job = resources.BuildPoolDirectoryJob(server.nfsProvisioner, pool, server.status.Index, string(claim.UID), resources.PoolVolumeDirectory(claim), false)
+			if err := ctrl.SetControllerReference(server.nfsProvisioner, job, r.Scheme); err != nil {
+				return nil, false, err
+			}
+			log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			if err := r.Create(ctx, job); err != nil {
+				return nil, false, err
+			}
+			// The next claims see the capacity of this one
+			capacity := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+			server.status.Volumes++
+			server.status.RequestedBytes += capacity.Value()
+			provisioning = true
+			continue
+		}
+
+		finished, succeeded := resources.JobFinished(job)
+		if !finished {
+			provisioning = true
+			continue
+		}
+		if !succeeded {
+			message, _ := resources.JobTerminationMessage(ctx, r.Client, job)
+			pending = append(pending, fmt.Sprintf("%s/%s: creating the directory failed: %s", claim.Namespace, claim.Name, message))
+			// The claim is placed again on the next reconcile
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+				return nil, false, err
+			}
+			continue
+		}
+
+		address, exportPath, err := resources.NFSServerAddress(ctx, r.Client, server.nfsProvisioner)
+		if err != nil {
+			pending = append(pending, fmt.Sprintf("%s/%s: %s", claim.Namespace, claim.Name, err))
+			continue
+		}
+		pv := resources.BuildPoolPV(pool, claim, server.status.Index, address, exportPath)
+		log.Info("Creating a new PersistentVolume", "PersistentVolume.Name", pv.Name, "Server", server.status.Index)
+		if err := r.Create(ctx, pv); err != nil {
+			log.Error(err, "Failed to create a PersistentVolume for NFSProvisionerPool", "PersistentVolume.Name", pv.Name)
+			return nil, false, err
+		}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return nil, false, err
+		}
+	}
+	return pending, provisioning, nil
+}
+
+// claimJob returns the Job that creates the directory of the claim and its server, if the claim was placed already
+func (r *NFSProvisionerPoolReconciler) claimJob(ctx context.Context, claim *corev1.PersistentVolumeClaim, servers []*poolServer) (*batchv1.Job, *poolServer, error) {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.MatchingLabels{resources.PoolClaimLabel: string(claim.UID)}); err != nil {
+		return nil, nil, err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name != resources.PoolJobName(string(claim.UID), false) {
+			continue
+		}
+		server := findPoolServer(servers, job.Labels[resources.PoolServerLabel])
+		if server == nil || server.nfsProvisioner == nil {
+			return nil, nil, nil
+		}
+		return job, server, nil
+	}
+	return nil, nil, nil
+}
+
+// placeClaim returns the server for the claim, following its placement hints, or the reason it can not be placed
+func (r *NFSProvisionerPoolReconciler) placeClaim(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool, claim *corev1.PersistentVolumeClaim, servers []*poolServer) (*poolServer, string, error) {
+	index := claim.Annotations[resources.PoolServerAnnotation]
+
+	if other := claim.Annotations[resources.PoolColocateAnnotation]; other != "" && index == "" {
+		otherClaim := &corev1.PersistentVolumeClaim{}
+		err := r.Get(ctx, types.NamespacedName{Name: other, Namespace: claim.Namespace}, otherClaim)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, "PVC " + other + " to colocate with not found", nil
+			}
+			return nil, "", err
+		}
+		if otherClaim.Spec.VolumeName == "" {
+			return nil, "PVC " + other + " to colocate with is not bound", nil
+		}
+		pv := &corev1.PersistentVolume{}
+		if err := r.Get(ctx, types.NamespacedName{Name: otherClaim.Spec.VolumeName}, pv); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, "PV of PVC " + other + " not found", nil
+			}
+			return nil, "", err
+		}
+		if pv.Labels[resources.PoolNamespaceLabel] != pool.Namespace || pv.Labels[resources.PoolNameLabel] != pool.Name {
+			return nil, "PVC " + other + " is not in the pool", nil
+		}
+		index = pv.Labels[resources.PoolServerLabel]
+	}
+
+	if index != "" {
+		server := findPoolServer(servers, index)
+		if server == nil || server.status.Draining {
+			return nil, "server " + index + " is not in the pool", nil
+		}
+		if !server.status.Ready {
+			return nil, "server " + index + " is not ready", nil
+		}
+		return server, "", nil
+	}
+
+	statuses := []cachev1alpha1.PoolServerStatus{}
+	for _, server := range servers {
+		statuses = append(statuses, *server.status)
+	}
+	picked, ok := resources.PickPoolServer(statuses)
+	if !ok {
+		return nil, "no server is ready", nil
+	}
+	return findPoolServer(servers, strconv.Itoa(int(picked))), "", nil
+}
+
+// finalize waits for the PVs of the pool to be deleted, then removes the servers and the StorageClass
+func (r *NFSProvisionerPoolReconciler) finalize(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsprovisionerpool", types.NamespacedName{Name: pool.Name, Namespace: pool.Namespace})
+
+	if !controllerutil.ContainsFinalizer(pool, poolFinalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := r.List(ctx, pvs, client.MatchingLabels(resources.PoolLabels(pool))); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(pvs.Items) > 0 {
+		// The directories of released PVs are removed while the servers still run
+		servers, err := r.ensureServers(ctx, pool)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if _, err := r.reclaimVolumes(ctx, pool, servers, pvs.Items); err != nil {
+			return ctrl.Result{}, err
+		}
+		pool.Status.Message = fmt.Sprintf("Waiting for %d PVs of the pool to be deleted", len(pvs.Items))
+		if err := r.updateStatus(ctx, pool); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabels(resources.PoolLabels(pool))); err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range namespaces.Items {
+		log.Info("Deleting the namespace of a server", "Namespace", namespaces.Items[i].Name)
+		if err := r.Delete(ctx, &namespaces.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	sc := &storagev1.StorageClass{}
+	err := r.Get(ctx, types.NamespacedName{Name: resources.PoolStorageClassName(pool)}, sc)
+	if err == nil && sc.Provisioner == resources.PoolProvisionerName(pool) {
+		log.Info("Deleting the Storageclass", "Storageclass.Name", sc.Name)
+		if err := r.Delete(ctx, sc); err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	} else if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Removing Finalizer for the NFSProvisionerPool")
+	controllerutil.RemoveFinalizer(pool, poolFinalizerName)
+	if err := r.Update(ctx, pool); err != nil {
+		log.Error(err, "Failed to update CR NFSProvisionerPool with finalizer to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// updateStatus records the status of the pool
+func (r *NFSProvisionerPoolReconciler) updateStatus(ctx context.Context, pool *cachev1alpha1.NFSProvisionerPool) error {
+	if err := r.Status().Update(ctx, pool); err != nil {
+		r.Log.Error(err, "Failed to update nfsprovisionerpool status")
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager return error
+func (r *NFSProvisionerPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSProvisionerPool{}).
+		Watches(&corev1.PersistentVolumeClaim{}, handler.EnqueueRequestsFromMapFunc(r.poolForClaim)).
+		Watches(&corev1.PersistentVolume{}, handler.EnqueueRequestsFromMapFunc(poolForObject)).
+		Watches(&cachev1alpha1.NFSProvisioner{}, handler.EnqueueRequestsFromMapFunc(poolForObject)).
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(poolForObject)).
+		Complete(r)
+}
+
+// poolForClaim maps a PVC to the pools that serve its StorageClass
+func (r *NFSProvisionerPoolReconciler) poolForClaim(ctx context.Context, obj client.Object) []reconcile.Request {
+	claim, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok || claim.Spec.StorageClassName == nil {
+		return nil
+	}
+	pools := &cachev1alpha1.NFSProvisionerPoolList{}
+	if err := r.List(ctx, pools); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range pools.Items {
+		if resources.PoolStorageClassName(&pools.Items[i]) == *claim.Spec.StorageClassName {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pools.Items[i].Name, Namespace: pools.Items[i].Namespace}})
+		}
+	}
+	return requests
+}
+
+// poolForObject maps a labelled object to its NFSProvisionerPool
+func poolForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, namespace := labels[resources.PoolNameLabel], labels[resources.PoolNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
@@ -0,0 +1,351 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// shareFinalizerName removes the PVs and PVCs of a NFSShare before it is deleted
+const shareFinalizerName = "nfsshare.finalizers.jhouse.io"
+
+// NFSShareReconciler reconciles a NFSShare object
+type NFSShareReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsshares,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsshares/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.jhouse.com,resources=nfsshares/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the shared directory and a static PV with a pre-bound PVC in each target namespace of a NFSShare
+func (r *NFSShareReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsshare", req.NamespacedName)
+
+	share := &cachev1alpha1.NFSShare{}
+	if err := r.Get(ctx, req.NamespacedName, share); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSShare resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get NFSShare")
+		return ctrl.Result{}, err
+	}
+
+	if !share.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, share)
+	}
+
+	if !controllerutil.ContainsFinalizer(share, shareFinalizerName) {
+		log.Info("Adding Finalizer for the NFSShare")
+		controllerutil.AddFinalizer(share, shareFinalizerName)
+		if err := r.Update(ctx, share); err != nil {
+			log.Error(err, "Failed to update CR NFSShare to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := resources.ValidateSharePath(share.Spec.Path); err != nil {
+		return r.updateStatus(ctx, share, cachev1alpha1.SharePhaseFailed, err.Error())
+	}
+
+	nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+	err := r.Get(ctx, types.NamespacedName{Name: share.Spec.NFSProvisioner, Namespace: share.Namespace}, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("NFSProvisioner for NFSShare not found", "NFSProvisioner.Name", share.Spec.NFSProvisioner)
+			return r.requeueStatus(ctx, share, "NFSProvisioner "+share.Spec.NFSProvisioner+" not found")
+		}
+		return ctrl.Result{}, err
+	}
+
+	server, exportPath, err := resources.NFSServerAddress(ctx, r.Client, nfsprovisioner)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.requeueStatus(ctx, share, "NFS server Service not found")
+		}
+		return r.requeueStatus(ctx, share, err.Error())
+	}
+
+	// The directory Job and the NFS path of the PVs can not follow a new path, so a changed path is refused
+	sharePath := path.Join(exportPath, share.Spec.Path)
+	if share.Status.Path != "" && share.Status.Path != sharePath {
+		log.Info("The path of NFSShare changed", "Status.Path", share.Status.Path, "Path", sharePath)
+		return r.updateStatus(ctx, share, cachev1alpha1.SharePhaseFailed, "The path can not change from "+share.Status.Path+", recreate the NFSShare to share "+sharePath)
+	}
+
+	// Ensure the shared directory exists
+	done, err := r.runDirectoryJob(ctx, nfsprovisioner, share, false)
+	if err != nil {
+		return r.updateStatus(ctx, share, cachev1alpha1.SharePhaseFailed, err.Error())
+	}
+	if !done {
+		return r.updateStatus(ctx, share, cachev1alpha1.SharePhasePending, "Creating the shared directory")
+	}
+
+	// Ensure the PV and PVC of each target namespace
+	targets := []cachev1alpha1.ShareTarget{}
+	wanted := map[string]bool{}
+	for _, namespace := range share.Spec.Namespaces {
+		if wanted[namespace] {
+			continue
+		}
+		wanted[namespace] = true
+
+		target, err := r.ensureTarget(ctx, share, server, exportPath, namespace)
+		if err != nil {
+			log.Error(err, "Failed to ensure the PV and PVC of NFSShare", "Namespace", namespace)
+			return r.updateStatus(ctx, share, cachev1alpha1.SharePhaseFailed, err.Error())
+		}
+		targets = append(targets, target)
+	}
+
+	// Remove the PVs and PVCs of namespaces that are no longer listed
+	if err := r.removeTargets(ctx, share, wanted); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	share.Status.Server = server
+	share.Status.Path = sharePath
+	share.Status.Targets = targets
+	return r.updateStatus(ctx, share, cachev1alpha1.SharePhaseReady, "")
+}
+
+// ensureTarget creates the static PV and the pre-bound PVC of the namespace
+func (r *NFSShareReconciler) ensureTarget(ctx context.Context, share *cachev1alpha1.NFSShare, server, exportPath, namespace string) (cachev1alpha1.ShareTarget, error) {
+	log := r.Log.WithValues("nfsshare", types.NamespacedName{Name: share.Name, Namespace: share.Namespace})
+	target := cachev1alpha1.ShareTarget{Namespace: namespace, PersistentVolume: resources.SharePVName(share, namespace)}
+
+	pv, err := resources.BuildSharePV(share, server, exportPath, namespace)
+	if err != nil {
+		return target, err
+	}
+	pvFound := &corev1.PersistentVolume{}
+	err = r.Get(ctx, types.NamespacedName{Name: pv.Name}, pvFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new PersistentVolume", "PersistentVolume.Name", pv.Name)
+		if err := r.Create(ctx, pv); err != nil {
+			log.Error(err, "Failed to create a PersistentVolume for NFSShare", "PersistentVolume.Name", pv.Name)
+			return target, err
+		}
+	} else if err != nil {
+		return target, err
+	} else if pvFound.Status.Phase == corev1.VolumeReleased {
+		// The PVC was deleted, so the PV is made available again for the PVC that is recreated below
+		log.Info("Releasing the PersistentVolume for a new PVC", "PersistentVolume.Name", pv.Name)
+		pvFound.Spec.ClaimRef = pv.Spec.ClaimRef
+		if err := r.Update(ctx, pvFound); err != nil {
+			return target, err
+		}
+	}
+
+	pvc, err := resources.BuildSharePVC(share, namespace)
+	if err != nil {
+		return target, err
+	}
+	pvcFound := &corev1.PersistentVolumeClaim{}
+	err = r.Get(ctx, types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, pvcFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		if err := r.Create(ctx, pvc); err != nil {
+			log.Error(err, "Failed to create a PVC for NFSShare", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+			return target, err
+		}
+		return target, nil
+	} else if err != nil {
+		return target, err
+	}
+
+	target.Bound = pvcFound.Status.Phase == corev1.ClaimBound && pvcFound.Spec.VolumeName == pv.Name
+	return target, nil
+}
+
+// removeTargets deletes the PVs and PVCs of the NFSShare whose namespace is not wanted
+func (r *NFSShareReconciler) removeTargets(ctx context.Context, share *cachev1alpha1.NFSShare, wanted map[string]bool) error {
+	log := r.Log.WithValues("nfsshare", types.NamespacedName{Name: share.Name, Namespace: share.Namespace})
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.MatchingLabels(resources.ShareLabels(share))); err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if wanted[pvc.Namespace] {
+			continue
+		}
+		log.Info("Deleting the PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		if err := r.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := r.List(ctx, pvs, client.MatchingLabels(resources.ShareLabels(share))); err != nil {
+		return err
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.ClaimRef != nil && wanted[pv.Spec.ClaimRef.Namespace] {
+			continue
+		}
+		log.Info("Deleting the PersistentVolume", "PersistentVolume.Name", pv.Name)
+		if err := r.Delete(ctx, pv); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runDirectoryJob runs the Job that creates or deletes the shared directory and reports whether it succeeded
+func (r *NFSShareReconciler) runDirectoryJob(ctx context.Context, nfsprovisioner *cachev1alpha1.NFSProvisioner, share *cachev1alpha1.NFSShare, remove bool) (bool, error) {
+	log := r.Log.WithValues("nfsshare", types.NamespacedName{Name: share.Name, Namespace: share.Namespace})
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: resources.ShareJobName(share, remove), Namespace: share.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = resources.BuildShareDirectoryJob(nfsprovisioner, share, remove)
+		if err := ctrl.SetControllerReference(share, job, r.Scheme); err != nil {
+			return false, err
+		}
+
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := r.Create(ctx, job); err != nil {
+			log.Error(err, "Failed to create a Job for NFSShare", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return false, err
+		}
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	finished, succeeded := resources.JobFinished(job)
+	if !finished {
+		return false, nil
+	}
+	if !succeeded {
+		message, err := resources.JobTerminationMessage(ctx, r.Client, job)
+		if err != nil {
+			log.Error(err, "Failed to read the termination message of the Job", "Job.Name", job.Name)
+		}
+		return false, fmt.Errorf("job %s failed: %s", job.Name, message)
+	}
+	return true, nil
+}
+
+// finalize removes the PVs and PVCs, and the directory when the policy is Delete, then releases the NFSShare
+func (r *NFSShareReconciler) finalize(ctx context.Context, share *cachev1alpha1.NFSShare) (ctrl.Result, error) {
+	log := r.Log.WithValues("nfsshare", types.NamespacedName{Name: share.Name, Namespace: share.Namespace})
+
+	if !controllerutil.ContainsFinalizer(share, shareFinalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.removeTargets(ctx, share, map[string]bool{}); err != nil {
+		log.Error(err, "Failed to delete the PVs and PVCs of NFSShare")
+		return ctrl.Result{}, err
+	}
+
+	if share.Spec.DirectoryPolicy == cachev1alpha1.DirectoryPolicyDelete && resources.ValidateSharePath(share.Spec.Path) == nil {
+		nfsprovisioner := &cachev1alpha1.NFSProvisioner{}
+		err := r.Get(ctx, types.NamespacedName{Name: share.Spec.NFSProvisioner, Namespace: share.Namespace}, nfsprovisioner)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		// The directory is gone with the NFSProvisioner
+		if err == nil && nfsprovisioner.DeletionTimestamp.IsZero() {
+			done, err := r.runDirectoryJob(ctx, nfsprovisioner, share, true)
+			if err != nil {
+				log.Error(err, "Failed to delete the shared directory")
+				return ctrl.Result{}, err
+			}
+			if !done {
+				return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+			}
+		}
+	}
+
+	log.Info("Removing Finalizer for the NFSShare")
+	controllerutil.RemoveFinalizer(share, shareFinalizerName)
+	if err := r.Update(ctx, share); err != nil {
+		log.Error(err, "Failed to update CR NFSShare with finalizer to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// requeueStatus records a pending share and checks it again later
+func (r *NFSShareReconciler) requeueStatus(ctx context.Context, share *cachev1alpha1.NFSShare, message string) (ctrl.Result, error) {
+	if _, err := r.updateStatus(ctx, share, cachev1alpha1.SharePhasePending, message); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// updateStatus records the phase and message of the share
+func (r *NFSShareReconciler) updateStatus(ctx context.Context, share *cachev1alpha1.NFSShare, phase cachev1alpha1.SharePhase, message string) (ctrl.Result, error) {
+	share.Status.Phase = phase
+	share.Status.Message = message
+	if err := r.Status().Update(ctx, share); err != nil {
+		r.Log.Error(err, "Failed to update nfsshare status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager return error
+func (r *NFSShareReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.NFSShare{}).
+		Owns(&batchv1.Job{}).
+		Watches(&corev1.PersistentVolumeClaim{}, handler.EnqueueRequestsFromMapFunc(shareForObject)).
+		Complete(r)
+}
+
+// shareForObject maps a labelled PVC to its NFSShare so that the bound state is refreshed
+func shareForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, namespace := labels[resources.ShareNameLabel], labels[resources.ShareNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
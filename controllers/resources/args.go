@@ -0,0 +1,125 @@
+package resources
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+)
+
+// operatorFlags are set by the operator and can not be overridden by ExtraArgs
+var operatorFlags = map[string]bool{
+	"provisioner":      true,
+	"enable-xfs-quota": true,
+	"ganesha-config":   true,
+	"run-server":       true,
+	"use-ganesha":      true,
+	"master":           true,
+	"kubeconfig":       true,
+}
+
+// knownFlags are the flags of the provisioner that ExtraArgs accept without AllowUnknownArgs
+var knownFlags = map[string]bool{
+	"failed-retry-threshold": true,
+	"grace-period":           true,
+	"leader-elect":           true,
+	"root-squash":            true,
+	"server-hostname":        true,
+	"device-based-fsids":     true,
+	"v":                      true,
+	"vmodule":                true,
+}
+
+// operatorEnv are the environment variables the operator sets on the NFS server container
+var operatorEnv = map[string]bool{
+	"POD_IP":        true,
+	"SERVICE_NAME":  true,
+	"POD_NAMESPACE": true,
+}
+
+// provisionerVerbosity is the -v flag of the provisioner for each log level
+var provisionerVerbosity = map[cachev1alpha1.LogLevel]int{
+	cachev1alpha1.LogLevelError:   0,
+	cachev1alpha1.LogLevelWarning: 0,
+	cachev1alpha1.LogLevelInfo:    2,
+	cachev1alpha1.LogLevelDebug:   4,
+	cachev1alpha1.LogLevelTrace:   6,
+}
+
+// ganeshaLogLevel is the Default_Log_Level of NFS-Ganesha for each log level
+var ganeshaLogLevel = map[cachev1alpha1.LogLevel]string{
+	cachev1alpha1.LogLevelError:   "CRIT",
+	cachev1alpha1.LogLevelWarning: "WARN",
+	cachev1alpha1.LogLevelInfo:    "EVENT",
+	cachev1alpha1.LogLevelDebug:   "DEBUG",
+	cachev1alpha1.LogLevelTrace:   "FULL_DEBUG",
+}
+
+// ProvisionerArgs returns the arguments of the provisioner container
+func ProvisionerArgs(nfsProvisioner *cachev1alpha1.NFSProvisioner) []string {
+	args := []string{"-provisioner=" + ProvisionerName(nfsProvisioner)}
+	// XFS project quotas are only turned on once the export has been checked, otherwise the provisioner fails to start
+	if quotaReady(nfsProvisioner) {
+		args = append(args, "-enable-xfs-quota=true")
+	}
+	if nfsProvisioner.Spec.LogLevel != "" {
+		args = append(args, "-v="+strconv.Itoa(provisionerVerbosity[nfsProvisioner.Spec.LogLevel]))
+	}
+	return append(args, nfsProvisioner.Spec.ExtraArgs...)
+}
+
+// ValidateExtraArgs checks that ExtraArgs are flags the operator does not set, and known flags unless AllowUnknownArgs is set
+func ValidateExtraArgs(nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	for _, arg := range nfsProvisioner.Spec.ExtraArgs {
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("extraArgs %q is not a flag", arg)
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if operatorFlags[name] {
+			return fmt.Errorf("extraArgs %q is set by the operator", arg)
+		}
+		if name == "v" && nfsProvisioner.Spec.LogLevel != "" {
+			return fmt.Errorf("extraArgs %q can not set with logLevel", arg)
+		}
+		if !knownFlags[name] && !nfsProvisioner.Spec.AllowUnknownArgs {
+			return fmt.Errorf("extraArgs %q is not a known flag of the provisioner, set allowUnknownArgs to pass it anyway", arg)
+		}
+	}
+	return nil
+}
+
+// ValidateExtraEnv checks that ExtraEnv does not override the environment the operator sets
+func ValidateExtraEnv(nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	for _, env := range nfsProvisioner.Spec.ExtraEnv {
+		if operatorEnv[env.Name] {
+			return fmt.Errorf("extraEnv %s is set by the operator", env.Name)
+		}
+	}
+	return nil
+}
+
+// provisionerEnv returns the environment of the provisioner container
+func provisionerEnv(nfsProvisioner *cachev1alpha1.NFSProvisioner) []corev1.EnvVar {
+	env := []corev1.EnvVar{{
+		Name: "POD_IP",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: "status.podIP",
+			},
+		},
+	}, {
+		Name:  "SERVICE_NAME",
+		Value: "nfs-provisioner",
+	}, {
+		Name: "POD_NAMESPACE",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: "metadata.namespace",
+			},
+		},
+	}}
+	return append(env, nfsProvisioner.Spec.ExtraEnv...)
+}
@@ -0,0 +1,60 @@
+package resources
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Provisioner arguments", func() {
+	var nfsProvisioner *cachev1alpha1.NFSProvisioner
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				LogLevel:  cachev1alpha1.LogLevelDebug,
+				ExtraArgs: []string{"-failed-retry-threshold=5", "-leader-elect"},
+				ExtraEnv:  []corev1.EnvVar{{Name: "TZ", Value: "UTC"}},
+			},
+		}
+	})
+
+	It("should add the log level and the extra arguments", func() {
+		Expect(ProvisionerArgs(nfsProvisioner)).To(Equal([]string{
+			"-provisioner=" + defaults.Provisioner, "-v=4", "-failed-retry-threshold=5", "-leader-elect",
+		}))
+		Expect(provisionerEnv(nfsProvisioner)).To(ContainElement(corev1.EnvVar{Name: "TZ", Value: "UTC"}))
+		Expect(RenderGaneshaConfig(nfsProvisioner)[ganeshaConfigKey]).To(ContainSubstring("Default_Log_Level = DEBUG;"))
+	})
+
+	It("should only accept known flags the operator does not set", func() {
+		Expect(ValidateExtraArgs(nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.ExtraArgs = []string{"-provisioner=other.com/nfs"}
+		Expect(ValidateExtraArgs(nfsProvisioner)).To(MatchError(ContainSubstring("set by the operator")))
+
+		nfsProvisioner.Spec.ExtraArgs = []string{"-v=6"}
+		Expect(ValidateExtraArgs(nfsProvisioner)).To(MatchError(ContainSubstring("logLevel")))
+
+		nfsProvisioner.Spec.ExtraArgs = []string{"--new-flag=true"}
+		Expect(ValidateExtraArgs(nfsProvisioner)).To(MatchError(ContainSubstring("allowUnknownArgs")))
+		nfsProvisioner.Spec.AllowUnknownArgs = true
+		Expect(ValidateExtraArgs(nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.ExtraEnv = []corev1.EnvVar{{Name: "POD_IP", Value: "10.0.0.1"}}
+		Expect(ValidateExtraEnv(nfsProvisioner)).To(HaveOccurred())
+	})
+
+	It("should detect removed arguments in the pod template", func() {
+		found := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nfs-provisioner", Args: ProvisionerArgs(nfsProvisioner)}}}}
+		nfsProvisioner.Spec.ExtraArgs = nil
+		desired := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nfs-provisioner", Args: ProvisionerArgs(nfsProvisioner)}}}}
+		Expect(podTemplateChanged(desired, found)).To(BeTrue())
+		Expect(podTemplateChanged(found, found.DeepCopy())).To(BeFalse())
+	})
+})
@@ -0,0 +1,187 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// BackupSummary is the summary line restic prints at the end of `restic backup --json`
+type BackupSummary struct {
+	MessageType         string `json:"message_type"`
+	FilesNew            int64  `json:"files_new"`
+	FilesChanged        int64  `json:"files_changed"`
+	DataAdded           int64  `json:"data_added"`
+	TotalBytesProcessed int64  `json:"total_bytes_processed"`
+	SnapshotID          string `json:"snapshot_id"`
+}
+
+// ParseBackupSummary parses the restic summary that the backup container writes as its termination message
+func ParseBackupSummary(message string) (*BackupSummary, error) {
+	summary := &BackupSummary{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(message)), summary); err != nil {
+		return nil, fmt.Errorf("failed to parse backup summary: %w", err)
+	}
+	if summary.MessageType != "summary" || summary.SnapshotID == "" {
+		return nil, fmt.Errorf("unexpected backup summary: %s", message)
+	}
+	return summary, nil
+}
+
+// ValidateRestorePath checks that the restore path stays inside the export
+func ValidateRestorePath(p string) error {
+	if p == "" {
+		return nil
+	}
+	// Only a ".." component leaves the export, names like "..data" stay inside it
+	if c := path.Clean(p); path.IsAbs(p) || c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf("restore path %q must be relative to the export", p)
+	}
+	return nil
+}
+
+// BackupRepositoryURL returns the restic repository URL for the given repository
+func BackupRepositoryURL(repo cachev1alpha1.BackupRepository, namespace, nfsProvisioner string) string {
+	prefix := repo.Prefix
+	if prefix == "" {
+		prefix = namespace + "/" + nfsProvisioner
+	}
+	return "s3:" + strings.TrimSuffix(repo.Endpoint, "/") + "/" + path.Join(repo.Bucket, prefix)
+}
+
+// BuildBackupJob creates a Job that backs up the export, or restores it when backup.Spec.Restore is set
+func BuildBackupJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, backup *cachev1alpha1.NFSBackup) *batchv1.Job {
+	script := backupScript(nfsProvisioner.Name, 0)
+	if backup.Spec.Restore != nil {
+		script = restoreScript(nfsProvisioner.Name)
+	}
+
+	container := backupContainer(nfsProvisioner, backup.Spec.Repository, backup.Spec.Image, script)
+	if restore := backup.Spec.Restore; restore != nil {
+		// The snapshot and path are passed as environment variables so they are never interpreted by the shell
+		snapshot := restore.Snapshot
+		if snapshot == "" {
+			snapshot = "latest"
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: "RESTORE_SNAPSHOT", Value: snapshot})
+		if restore.Path != "" {
+			container.Env = append(container.Env, corev1.EnvVar{Name: "RESTORE_INCLUDE", Value: path.Join(defaults.ExportPath, path.Clean(restore.Path))})
+		}
+	}
+	return BuildExportJob(nfsProvisioner, BackupJobName(backup.Name), container)
+}
+
+// BuildBackupCronJob creates a CronJob that backs up the export on the schedule and prunes old snapshots
+func BuildBackupCronJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, schedule *cachev1alpha1.NFSBackupSchedule) *batchv1.CronJob {
+	keepLast := int32(defaults.BackupKeepLast)
+	if schedule.Spec.KeepLast > 0 {
+		keepLast = schedule.Spec.KeepLast
+	}
+
+	container := backupContainer(nfsProvisioner, schedule.Spec.Repository, schedule.Spec.Image, backupScript(nfsProvisioner.Name, keepLast))
+	job := BuildExportJob(nfsProvisioner, BackupJobName(schedule.Name), container)
+	suspend := schedule.Spec.Suspend
+
+	cronJobMeta := job.ObjectMeta
+	cronJobMeta.Name = truncateCronJobName(cronJobMeta.Name)
+
+	return &batchv1.CronJob{
+		ObjectMeta: cronJobMeta,
+		Spec: batchv1.CronJobSpec{
+			Schedule:          schedule.Spec.Schedule,
+			Suspend:           &suspend,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: job.Labels},
+				Spec:       job.Spec,
+			},
+		},
+	}
+}
+
+// BackupJobName returns the name of the Job or CronJob of a backup
+func BackupJobName(name string) string {
+	return truncateName("nfsbackup-" + name)
+}
+
+// backupContainer returns the restic container with the repository configuration
+func backupContainer(nfsProvisioner *cachev1alpha1.NFSProvisioner, repo cachev1alpha1.BackupRepository, image string, script string) corev1.Container {
+	if image == "" {
+		image = defaults.BackupImage
+	}
+
+	env := []corev1.EnvVar{{
+		Name:  "RESTIC_REPOSITORY",
+		Value: BackupRepositoryURL(repo, nfsProvisioner.Namespace, nfsProvisioner.Name),
+	}, {
+		Name:  "RESTIC_CACHE_DIR",
+		Value: "/tmp/restic-cache",
+	}}
+	if repo.InsecureSkipTLSVerify {
+		env = append(env, corev1.EnvVar{Name: "RESTIC_INSECURE_TLS", Value: "true"})
+	}
+
+	return corev1.Container{
+		Name:    "restic",
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", script},
+		Env:     env,
+		EnvFrom: []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: repo.CredentialsSecret},
+			},
+		}},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+}
+
+// backupScript initializes the repository when needed and writes the restic summary as termination message
+func backupScript(host string, keepLast int32) string {
+	script := `set -eo pipefail
+flags=""
+[ "$RESTIC_INSECURE_TLS" = "true" ] && flags="--insecure-tls"
+restic $flags cat config >/dev/null 2>&1 || restic $flags init
+restic $flags backup --json --host ` + host + ` --tag nfs-provisioner ` + defaults.ExportPath + ` | grep '"message_type":"summary"' > /dev/termination-log
+`
+	if keepLast > 0 {
+		script += fmt.Sprintf("restic $flags forget --host %s --keep-last %d --prune\n", host, keepLast)
+	}
+	return script
+}
+
+// restoreScript restores either the whole export or the directory in RESTORE_INCLUDE
+func restoreScript(host string) string {
+	return `set -eo pipefail
+flags=""
+[ "$RESTIC_INSECURE_TLS" = "true" ] && flags="--insecure-tls"
+if [ -n "$RESTORE_INCLUDE" ]; then
+  restic $flags restore "$RESTORE_SNAPSHOT" --host ` + host + ` --target / --include "$RESTORE_INCLUDE"
+else
+  restic $flags restore "$RESTORE_SNAPSHOT" --host ` + host + ` --target /
+fi
+`
+}
+
+// truncateName keeps generated names within the 63 character limit of labels such as job-name
+func truncateName(name string) string {
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-.")
+	}
+	return name
+}
+
+// truncateCronJobName keeps CronJob names within 52 characters, because the controller appends a suffix to the names of their Jobs
+func truncateCronJobName(name string) string {
+	if len(name) > 52 {
+		name = strings.TrimRight(name[:52], "-.")
+	}
+	return name
+}
@@ -0,0 +1,113 @@
+package resources
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Backup", func() {
+	var (
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		backup         *cachev1alpha1.NFSBackup
+	)
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-nfs",
+				Namespace: "test-namespace",
+			},
+		}
+		backup = &cachev1alpha1.NFSBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-backup",
+				Namespace: "test-namespace",
+			},
+			Spec: cachev1alpha1.NFSBackupSpec{
+				NFSProvisioner: "test-nfs",
+				Repository: cachev1alpha1.BackupRepository{
+					Endpoint:          "http://minio.minio.svc:9000/",
+					Bucket:            "nfs-backup",
+					CredentialsSecret: "nfs-backup-credentials",
+				},
+			},
+		}
+	})
+
+	It("should build the repository URL with a default prefix", func() {
+		Expect(BackupRepositoryURL(backup.Spec.Repository, "test-namespace", "test-nfs")).To(Equal("s3:http://minio.minio.svc:9000/nfs-backup/test-namespace/test-nfs"))
+	})
+
+	It("should build a backup Job that mounts the export", func() {
+		job := BuildBackupJob(nfsProvisioner, backup)
+		Expect(job.Name).To(Equal("nfsbackup-test-backup"))
+
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(defaults.Pvc))
+		Expect(podSpec.Containers[0].Image).To(Equal(defaults.BackupImage))
+		Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "export-volume", MountPath: defaults.ExportPath}))
+		Expect(podSpec.Containers[0].EnvFrom[0].SecretRef.Name).To(Equal("nfs-backup-credentials"))
+		Expect(podSpec.Containers[0].Command[2]).To(ContainSubstring("restic $flags backup"))
+
+		// The job pod runs next to the NFS server, but is not selected by its Service
+		Expect(job.Spec.Template.Labels).NotTo(HaveKey("app"))
+		Expect(podSpec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector.MatchLabels).To(Equal(serverLabels(nfsProvisioner)))
+	})
+
+	It("should pass the restore path through the environment", func() {
+		backup.Spec.Restore = &cachev1alpha1.BackupRestore{Path: "pvc-1234"}
+		job := BuildBackupJob(nfsProvisioner, backup)
+
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Command[2]).To(ContainSubstring("restic $flags restore"))
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "RESTORE_SNAPSHOT", Value: "latest"}))
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "RESTORE_INCLUDE", Value: "/export/pvc-1234"}))
+	})
+
+	DescribeTable("should reject restore paths outside of the export",
+		func(p string, valid bool) {
+			if valid {
+				Expect(ValidateRestorePath(p)).To(Succeed())
+			} else {
+				Expect(ValidateRestorePath(p)).NotTo(Succeed())
+			}
+		},
+		Entry("the export", "", true),
+		Entry("a directory", "pvc-1234", true),
+		Entry("a name starting with dots", "..data", true),
+		Entry("a parent that is cleaned away", "pvc-1234/../pvc-5678", true),
+		Entry("an absolute path", "/etc", false),
+		Entry("the parent", "..", false),
+		Entry("a directory of the parent", "../etc", false),
+		Entry("a path leaving the export", "pvc-1234/../../etc", false),
+	)
+
+	It("should parse the restic summary", func() {
+		summary, err := ParseBackupSummary(`{"message_type":"summary","files_new":3,"files_changed":1,"data_added":2048,"total_bytes_processed":4096,"snapshot_id":"4f1c2a7e"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.SnapshotID).To(Equal("4f1c2a7e"))
+		Expect(summary.DataAdded).To(Equal(int64(2048)))
+
+		_, err = ParseBackupSummary("Fatal: unable to open config file")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should build a CronJob that prunes old snapshots", func() {
+		schedule := &cachev1alpha1.NFSBackupSchedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "test-namespace"},
+			Spec: cachev1alpha1.NFSBackupScheduleSpec{
+				Schedule:       "0 2 * * *",
+				NFSProvisioner: "test-nfs",
+				Repository:     backup.Spec.Repository,
+			},
+		}
+		cronJob := BuildBackupCronJob(nfsProvisioner, schedule)
+		Expect(cronJob.Spec.Schedule).To(Equal("0 2 * * *"))
+		Expect(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("--keep-last 7"))
+	})
+})
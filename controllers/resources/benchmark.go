@@ -0,0 +1,320 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// benchmarkScript runs fio in a directory of its own on the PVC and prints its JSON output.
+// The output goes to a file first, so that the warnings of fio on stderr never break the JSON in the logs.
+const benchmarkScript = `set -e
+dir="/data/pod-${JOB_COMPLETION_INDEX:-0}"
+mkdir -p "$dir"
+if ! fio --name=benchmark --directory="$dir" --rw="$FIO_RW" --bs="$FIO_BS" --numjobs="$FIO_NUMJOBS" \
+  --iodepth="$FIO_IODEPTH" --size="$FIO_SIZE" --runtime="$FIO_RUNTIME" --time_based \
+  --ioengine=libaio --direct=1 --group_reporting --output-format=json --output=/tmp/fio.json 2>/tmp/fio.err; then
+  tail -c 2048 /tmp/fio.err > /dev/termination-log
+  exit 1
+fi
+rm -rf "$dir"
+cat /tmp/fio.json
+`
+
+// fioPatterns maps the patterns of a NFSBenchmark to the --rw option of fio
+var fioPatterns = map[cachev1alpha1.BenchmarkPattern]string{
+	cachev1alpha1.BenchmarkSequentialRead:  "read",
+	cachev1alpha1.BenchmarkSequentialWrite: "write",
+	cachev1alpha1.BenchmarkRandomRead:      "randread",
+	cachev1alpha1.BenchmarkRandomWrite:     "randwrite",
+	cachev1alpha1.BenchmarkRandomReadWrite: "randrw",
+}
+
+// BenchmarkName returns the name of the temporary PVC and of the Job of the benchmark
+func BenchmarkName(name string) string {
+	return truncateName("nfs-benchmark-" + name)
+}
+
+// BenchmarkProfile returns the profile of the benchmark with the default values filled in
+func BenchmarkProfile(benchmark *cachev1alpha1.NFSBenchmark) (cachev1alpha1.BenchmarkProfile, error) {
+	profile := *benchmark.Spec.Profile.DeepCopy()
+	if _, ok := fioPatterns[profile.Pattern]; !ok {
+		return profile, fmt.Errorf("unknown pattern %q", profile.Pattern)
+	}
+	if profile.BlockSize == "" {
+		profile.BlockSize = "4k"
+		if profile.Pattern == cachev1alpha1.BenchmarkSequentialRead || profile.Pattern == cachev1alpha1.BenchmarkSequentialWrite {
+			profile.BlockSize = "1m"
+		}
+	}
+	if profile.Parallelism == 0 {
+		profile.Parallelism = 1
+	}
+	if profile.IODepth == 0 {
+		profile.IODepth = 16
+	}
+	if profile.FileSize == nil {
+		size := resource.MustParse(defaults.BenchmarkFileSize)
+		profile.FileSize = &size
+	}
+	if profile.FileSize.Value() <= 0 {
+		return profile, fmt.Errorf("fileSize must be positive, got %s", profile.FileSize.String())
+	}
+	if profile.Duration == nil {
+		profile.Duration = &metav1.Duration{Duration: defaults.BenchmarkDuration}
+	}
+	if profile.Duration.Duration < time.Second {
+		return profile, fmt.Errorf("duration must be at least 1s, got %s", profile.Duration.Duration)
+	}
+	return profile, nil
+}
+
+// benchmarkPods returns the number of workload pods of the benchmark
+func benchmarkPods(benchmark *cachev1alpha1.NFSBenchmark) int32 {
+	if benchmark.Spec.Pods > 0 {
+		return benchmark.Spec.Pods
+	}
+	return 1
+}
+
+// benchmarkLabels returns the labels of the PVC and Job of a NFSBenchmark of the given NFSProvisioner CR name.
+// They leave out the labels of the NFS server, so that the fio pods are not endpoints of its Service.
+func benchmarkLabels(name, benchmark string) map[string]string {
+	return map[string]string{"nfsprovisioner_cr": name, "component": "benchmark", defaults.BenchmarkLabel: benchmark}
+}
+
+// BuildBenchmarkPVC returns the temporary PVC of the benchmark on the StorageClass of the NFSProvisioner.
+// It requests the size of all the files that fio lays out.
+func BuildBenchmarkPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner, benchmark *cachev1alpha1.NFSBenchmark, profile cachev1alpha1.BenchmarkProfile) *corev1.PersistentVolumeClaim {
+	scName := StorageClassName(nfsProvisioner)
+	labels := benchmarkLabels(nfsProvisioner.Name, benchmark.Name)
+	size := resource.NewQuantity(int64(benchmarkPods(benchmark))*int64(profile.Parallelism)*profile.FileSize.Value(), resource.BinarySI)
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BenchmarkName(benchmark.Name),
+			Namespace: benchmark.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			StorageClassName: &scName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *size},
+			},
+		},
+	}
+}
+
+// BuildBenchmarkJob returns an indexed Job whose pods run the profile at the same time on the temporary PVC.
+// The deadline leaves time for the PVC to be bound and for fio to lay out its files.
+func BuildBenchmarkJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, benchmark *cachev1alpha1.NFSBenchmark, profile cachev1alpha1.BenchmarkProfile) *batchv1.Job {
+	name := BenchmarkName(benchmark.Name)
+	labels := benchmarkLabels(nfsProvisioner.Name, benchmark.Name)
+	pods := benchmarkPods(benchmark)
+	backoffLimit := int32(0)
+	completionMode := batchv1.IndexedCompletion
+	deadline := int64((profile.Duration.Duration + 30*time.Minute) / time.Second)
+
+	image := benchmark.Spec.Image
+	if image == "" {
+		image = defaults.BenchmarkImage
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: benchmark.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			Completions:           &pods,
+			Parallelism:           &pods,
+			CompletionMode:        &completionMode,
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					NodeSelector:  benchmark.Spec.NodeSelector,
+					Containers: []corev1.Container{{
+						Name:    "fio",
+						Image:   image,
+						Command: []string{"/bin/sh", "-c", benchmarkScript},
+						Env: []corev1.EnvVar{
+							{Name: "FIO_RW", Value: fioPatterns[profile.Pattern]},
+							{Name: "FIO_BS", Value: profile.BlockSize},
+							{Name: "FIO_NUMJOBS", Value: strconv.Itoa(int(profile.Parallelism))},
+							{Name: "FIO_IODEPTH", Value: strconv.Itoa(int(profile.IODepth))},
+							{Name: "FIO_SIZE", Value: strconv.FormatInt(profile.FileSize.Value(), 10)},
+							{Name: "FIO_RUNTIME", Value: strconv.FormatInt(int64(profile.Duration.Duration/time.Second), 10)},
+						},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "benchmark",
+							MountPath: "/data",
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "benchmark",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+						},
+					}},
+				},
+			},
+		},
+	}
+	applyOperatorConfig(&job.Spec.Template.Spec)
+	return job
+}
+
+// fioOutput is the part of the JSON output of fio that is recorded
+type fioOutput struct {
+	Jobs []struct {
+		Read  fioStats `json:"read"`
+		Write fioStats `json:"write"`
+	} `json:"jobs"`
+}
+
+type fioStats struct {
+	BandwidthBytes float64 `json:"bw_bytes"`
+	IOPS           float64 `json:"iops"`
+	TotalIOs       int64   `json:"total_ios"`
+	CompletionNs   struct {
+		Mean       float64            `json:"mean"`
+		Percentile map[string]float64 `json:"percentile"`
+	} `json:"clat_ns"`
+}
+
+// fioPercentiles are the keys of the completion latency percentiles in the output of fio
+var fioPercentiles = []string{"50.000000", "90.000000", "99.000000", "99.900000"}
+
+// parseFioOutput parses the JSON output of fio run with --group_reporting and returns the read and write statistics
+func parseFioOutput(logs string) (read, write *cachev1alpha1.BenchmarkResult, ios [2]int64, err error) {
+	start := strings.Index(logs, "{")
+	if start < 0 {
+		return nil, nil, ios, fmt.Errorf("no fio output found")
+	}
+	output := fioOutput{}
+	if err := json.Unmarshal([]byte(logs[start:]), &output); err != nil {
+		return nil, nil, ios, fmt.Errorf("failed to parse the fio output: %v", err)
+	}
+	if len(output.Jobs) != 1 {
+		return nil, nil, ios, fmt.Errorf("expected the results of 1 group of fio jobs, got %d", len(output.Jobs))
+	}
+
+	job := output.Jobs[0]
+	return fioResult(job.Read), fioResult(job.Write), [2]int64{job.Read.TotalIOs, job.Write.TotalIOs}, nil
+}
+
+// fioResult converts the statistics of fio, in nanoseconds, to a result in microseconds
+func fioResult(stats fioStats) *cachev1alpha1.BenchmarkResult {
+	if stats.TotalIOs == 0 {
+		return nil
+	}
+	percentile := func(key string) int64 {
+		return int64(math.Round(stats.CompletionNs.Percentile[key] / 1000))
+	}
+	return &cachev1alpha1.BenchmarkResult{
+		BandwidthBytesPerSecond: int64(stats.BandwidthBytes),
+		IOPS:                    int64(math.Round(stats.IOPS)),
+		LatencyMeanMicroseconds: int64(math.Round(stats.CompletionNs.Mean / 1000)),
+		LatencyP50Microseconds:  percentile(fioPercentiles[0]),
+		LatencyP90Microseconds:  percentile(fioPercentiles[1]),
+		LatencyP99Microseconds:  percentile(fioPercentiles[2]),
+		LatencyP999Microseconds: percentile(fioPercentiles[3]),
+	}
+}
+
+// AggregateBenchmarkResults sums the fio outputs of the workload pods, which ran at the same time.
+// Bandwidth and IOPS add up, the mean latency is weighted by the IOs of each pod and
+// the percentiles are the highest of the pods, since they cannot be merged exactly.
+func AggregateBenchmarkResults(outputs []string) (read, write *cachev1alpha1.BenchmarkResult, err error) {
+	var readIOs, writeIOs int64
+	for _, logs := range outputs {
+		r, w, ios, err := parseFioOutput(logs)
+		if err != nil {
+			return nil, nil, err
+		}
+		read = addBenchmarkResult(read, readIOs, r, ios[0])
+		write = addBenchmarkResult(write, writeIOs, w, ios[1])
+		readIOs += ios[0]
+		writeIOs += ios[1]
+	}
+	return read, write, nil
+}
+
+// addBenchmarkResult adds the result of a pod with podIOs to the total of totalIOs
+func addBenchmarkResult(total *cachev1alpha1.BenchmarkResult, totalIOs int64, pod *cachev1alpha1.BenchmarkResult, podIOs int64) *cachev1alpha1.BenchmarkResult {
+	if pod == nil {
+		return total
+	}
+	if total == nil {
+		return pod.DeepCopy()
+	}
+	sum := *total
+	sum.BandwidthBytesPerSecond += pod.BandwidthBytesPerSecond
+	sum.IOPS += pod.IOPS
+	sum.LatencyMeanMicroseconds = int64(math.Round(
+		(float64(total.LatencyMeanMicroseconds)*float64(totalIOs) + float64(pod.LatencyMeanMicroseconds)*float64(podIOs)) / float64(totalIOs+podIOs)))
+	sum.LatencyP50Microseconds = max(total.LatencyP50Microseconds, pod.LatencyP50Microseconds)
+	sum.LatencyP90Microseconds = max(total.LatencyP90Microseconds, pod.LatencyP90Microseconds)
+	sum.LatencyP99Microseconds = max(total.LatencyP99Microseconds, pod.LatencyP99Microseconds)
+	sum.LatencyP999Microseconds = max(total.LatencyP999Microseconds, pod.LatencyP999Microseconds)
+	return &sum
+}
+
+// BenchmarkFailureMessage returns why the workload Job failed: the errors of fio, or the condition of the Job
+// when no pod finished, e.g. when the deadline was exceeded before the PVC was bound
+func BenchmarkFailureMessage(ctx context.Context, c client.Client, job *batchv1.Job) string {
+	message, err := JobTerminationMessage(ctx, c, job)
+	if err != nil || strings.TrimSpace(message) == "" {
+		message = jobConditionMessage(job)
+	}
+	return strings.TrimSpace(message)
+}
+
+// JobPodLogs returns the logs of every succeeded pod of the Job and the nodes the pods of the Job ran on
+func JobPodLogs(ctx context.Context, c client.Client, kubeClient kubernetes.Interface, job *batchv1.Job) (logs []string, nodes []string, err error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, pod := range pods.Items {
+		if node := pod.Spec.NodeName; node != "" && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		data, err := kubeClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		logs = append(logs, string(data))
+	}
+	sort.Strings(nodes)
+	return logs, nodes, nil
+}
@@ -0,0 +1,134 @@
+package resources
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("NFSBenchmark", func() {
+	var (
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		benchmark      *cachev1alpha1.NFSBenchmark
+	)
+
+	// fioJSON returns the output of fio with --output-format=json for one group of jobs
+	fioJSON := func(readIOs, writeIOs int64, bandwidth, iops, meanNs, p99Ns float64) string {
+		stats := func(ios int64) string {
+			if ios == 0 {
+				return `{"io_bytes": 0, "bw_bytes": 0, "iops": 0.0, "total_ios": 0, "clat_ns": {"mean": 0.0}}`
+			}
+			return fmt.Sprintf(`{"io_bytes": 1, "bw_bytes": %.0f, "iops": %f, "total_ios": %d,
+  "clat_ns": {"mean": %f, "percentile": {"1.000000": 100, "50.000000": 250000, "90.000000": 900000, "99.000000": %f, "99.900000": 8000000}}}`,
+				bandwidth, iops, ios, meanNs, p99Ns)
+		}
+		return fmt.Sprintf(`{"fio version": "fio-3.36", "jobs": [{"jobname": "benchmark", "read": %s, "write": %s}]}`, stats(readIOs), stats(writeIOs))
+	}
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace"},
+		}
+		benchmark = &cachev1alpha1.NFSBenchmark{
+			ObjectMeta: metav1.ObjectMeta{Name: "random", Namespace: "test-namespace"},
+			Spec: cachev1alpha1.NFSBenchmarkSpec{
+				NFSProvisioner: "test-nfs",
+				Profile:        cachev1alpha1.BenchmarkProfile{Pattern: cachev1alpha1.BenchmarkRandomWrite},
+			},
+		}
+	})
+
+	It("should fill in the default profile", func() {
+		profile, err := BenchmarkProfile(benchmark)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(profile.BlockSize).To(Equal("4k"))
+		Expect(profile.Parallelism).To(BeEquivalentTo(1))
+		Expect(profile.IODepth).To(BeEquivalentTo(16))
+		Expect(profile.FileSize.String()).To(Equal(defaults.BenchmarkFileSize))
+		Expect(profile.Duration.Duration).To(Equal(defaults.BenchmarkDuration))
+
+		benchmark.Spec.Profile.Pattern = cachev1alpha1.BenchmarkSequentialRead
+		profile, err = BenchmarkProfile(benchmark)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(profile.BlockSize).To(Equal("1m"))
+
+		benchmark.Spec.Profile.Duration = &metav1.Duration{Duration: 100 * time.Millisecond}
+		_, err = BenchmarkProfile(benchmark)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should run the profile in every pod on a PVC of the StorageClass", func() {
+		size := resource.MustParse("256Mi")
+		benchmark.Spec.Pods = 3
+		benchmark.Spec.Profile.Parallelism = 2
+		benchmark.Spec.Profile.FileSize = &size
+		benchmark.Spec.NodeSelector = map[string]string{"kubernetes.io/hostname": "worker-1"}
+		profile, err := BenchmarkProfile(benchmark)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := BuildBenchmarkPVC(nfsProvisioner, benchmark, profile)
+		Expect(pvc.Name).To(Equal("nfs-benchmark-random"))
+		Expect(*pvc.Spec.StorageClassName).To(Equal(StorageClassName(nfsProvisioner)))
+		Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal("1536Mi"))
+
+		job := BuildBenchmarkJob(nfsProvisioner, benchmark, profile)
+		Expect(job.Name).To(Equal(pvc.Name))
+		Expect(*job.Spec.Completions).To(BeEquivalentTo(3))
+		Expect(*job.Spec.Parallelism).To(BeEquivalentTo(3))
+		Expect(*job.Spec.CompletionMode).To(Equal(batchv1.IndexedCompletion))
+		Expect(job.Labels).To(HaveKeyWithValue(defaults.BenchmarkLabel, "random"))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue(defaults.BenchmarkLabel, "random"))
+		Expect(job.Spec.Template.Labels).NotTo(HaveKey("app"))
+
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.NodeSelector).To(HaveKeyWithValue("kubernetes.io/hostname", "worker-1"))
+		Expect(podSpec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(pvc.Name))
+		Expect(podSpec.Containers[0].Image).To(Equal(defaults.BenchmarkImage))
+		Expect(podSpec.Containers[0].Env).To(ContainElements(
+			corev1.EnvVar{Name: "FIO_RW", Value: "randwrite"},
+			corev1.EnvVar{Name: "FIO_NUMJOBS", Value: "2"},
+			corev1.EnvVar{Name: "FIO_SIZE", Value: "268435456"},
+			corev1.EnvVar{Name: "FIO_RUNTIME", Value: "60"},
+		))
+	})
+
+	It("should record only the direction of the IOs that ran", func() {
+		read, write, err := AggregateBenchmarkResults([]string{fioJSON(0, 1000, 4096000, 1000, 2000000, 9000000)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(BeNil())
+		Expect(write).To(Equal(&cachev1alpha1.BenchmarkResult{
+			BandwidthBytesPerSecond: 4096000,
+			IOPS:                    1000,
+			LatencyMeanMicroseconds: 2000,
+			LatencyP50Microseconds:  250,
+			LatencyP90Microseconds:  900,
+			LatencyP99Microseconds:  9000,
+			LatencyP999Microseconds: 8000,
+		}))
+	})
+
+	It("should sum the pods and keep the highest percentiles", func() {
+		read, write, err := AggregateBenchmarkResults([]string{
+			"fio: some warning\n" + fioJSON(1000, 1000, 4096000, 1000, 1000000, 5000000),
+			fioJSON(3000, 1000, 8192000, 2000, 3000000, 7000000),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.BandwidthBytesPerSecond).To(BeEquivalentTo(12288000))
+		Expect(read.IOPS).To(BeEquivalentTo(3000))
+		Expect(read.LatencyMeanMicroseconds).To(BeEquivalentTo(2500))
+		Expect(read.LatencyP99Microseconds).To(BeEquivalentTo(7000))
+		Expect(write.LatencyMeanMicroseconds).To(BeEquivalentTo(2000))
+
+		_, _, err = AggregateBenchmarkResults([]string{"fio: failed to open file"})
+		Expect(err).To(HaveOccurred())
+	})
+})
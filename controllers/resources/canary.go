@@ -0,0 +1,336 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// ConditionCanaryHealthy is True when the last canary run provisioned, wrote and read a volume of the StorageClass
+const ConditionCanaryHealthy = "CanaryHealthy"
+
+// canaryScript writes 1MiB of random data to the volume, reads it back and writes the durations in milliseconds to the termination message
+const canaryScript = `set -e
+now() { awk '{printf "%d\n", $1 * 1000}' /proc/uptime; }
+dd if=/dev/urandom of=/tmp/canary bs=1024 count=1024 2>/dev/null
+expected=$(sha256sum /tmp/canary | cut -d' ' -f1)
+start=$(now)
+cp /tmp/canary /data/canary
+sync
+written=$(now)
+actual=$(sha256sum /data/canary | cut -d' ' -f1)
+read=$(now)
+rm -f /data/canary
+if [ "$actual" != "$expected" ]; then
+  echo "The checksum of the file read back is $actual instead of $expected" > /dev/termination-log
+  exit 1
+fi
+echo "$((written - start)) $((read - written))" > /dev/termination-log
+`
+
+// CanaryManager checks the StorageClass end to end: each run creates a PVC, writes and reads a file in a pod and deletes both
+type CanaryManager struct {
+	BaseResourceManager
+}
+
+// NewCanaryManager creates a new CanaryManager
+func NewCanaryManager(base BaseResourceManager) *CanaryManager {
+	return &CanaryManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *CanaryManager) GetResourceName() string {
+	return "Canary"
+}
+
+// EnsureResource checks the run in progress and starts a new one when the interval is over
+func (m *CanaryManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	if !canaryEnabled(nfsProvisioner) {
+		if status := nfsProvisioner.Status.Canary; status != nil {
+			if status.Run != "" {
+				if err := m.cleanup(ctx, nfsProvisioner.Namespace, status.Run); err != nil {
+					return err
+				}
+			}
+			clearCanaryMetrics(nfsProvisioner)
+			nfsProvisioner.Status.Canary = nil
+		}
+		meta.RemoveStatusCondition(&nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)
+		return nil
+	}
+
+	if nfsProvisioner.Status.Canary == nil {
+		nfsProvisioner.Status.Canary = &cachev1alpha1.CanaryStatus{}
+	}
+	status := nfsProvisioner.Status.Canary
+
+	if status.Run != "" {
+		return m.checkRun(ctx, nfsProvisioner)
+	}
+
+	// The NFS server is expected to be unavailable during a storage migration
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+
+	config := nfsProvisioner.Spec.Canary
+	interval := defaults.CanaryInterval
+	if config.Interval != nil {
+		interval = config.Interval.Duration
+	}
+	if status.LastRunTime != nil && time.Since(status.LastRunTime.Time) < interval {
+		return nil
+	}
+	return m.startRun(ctx, nfsProvisioner)
+}
+
+// startRun creates the PVC and the Job of a new run
+func (m *CanaryManager) startRun(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	name := truncateName(fmt.Sprintf("nfs-canary-%s-%d", nfsProvisioner.Name, time.Now().Unix()))
+	pvc := m.buildCanaryPVC(nfsProvisioner, name)
+	log.Info("Creating a new PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+	if err := m.Client.Create(ctx, pvc); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create a PVC for NFSProvisioner", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		return err
+	}
+
+	job := m.buildCanaryJob(nfsProvisioner, name)
+	log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := m.Client.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return err
+	}
+
+	now := metav1.Now()
+	status := nfsProvisioner.Status.Canary
+	status.Run = name
+	status.LastRunTime = &now
+	if meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy) == nil {
+		setCondition(nfsProvisioner, ConditionCanaryHealthy, metav1.ConditionUnknown, "Running", "The first canary run is in progress", nfsProvisioner.Generation)
+	}
+	return nil
+}
+
+// checkRun records the result of the run in progress once its Job finished or its timeout is over
+func (m *CanaryManager) checkRun(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Canary
+	name := status.Run
+
+	job := &batchv1.Job{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: nfsProvisioner.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		return m.finishRun(ctx, nfsProvisioner, "Failed", fmt.Sprintf("The canary Job %s is gone", name))
+	} else if err != nil {
+		return err
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: nfsProvisioner.Namespace}, pvc); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	timeout := canaryTimeout(nfsProvisioner)
+	finished, succeeded := JobFinished(job)
+	if !finished && time.Since(status.LastRunTime.Time) < timeout {
+		return nil
+	}
+
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return m.finishRun(ctx, nfsProvisioner, "ProvisioningFailed",
+			fmt.Sprintf("The PVC %s of StorageClass %s was not bound within %s", name, StorageClassName(nfsProvisioner), timeout))
+	}
+	if !finished {
+		return m.finishRun(ctx, nfsProvisioner, "Timeout", fmt.Sprintf("The canary did not write and read its file within %s", timeout))
+	}
+
+	message, err := JobTerminationMessage(ctx, m.Client, job)
+	if err != nil || strings.TrimSpace(message) == "" {
+		message = jobConditionMessage(job)
+	}
+	message = strings.TrimSpace(message)
+	if !succeeded {
+		return m.finishRun(ctx, nfsProvisioner, "ReadWriteFailed", message)
+	}
+
+	write, read, err := ParseCanaryResult(message)
+	if err != nil {
+		return m.finishRun(ctx, nfsProvisioner, "ReadWriteFailed", err.Error())
+	}
+
+	status.WriteMilliseconds = write
+	status.ReadMilliseconds = read
+	status.ProvisionMilliseconds = 0
+	pv := &corev1.PersistentVolume{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName}, pv); err == nil {
+		status.ProvisionMilliseconds = pv.CreationTimestamp.Sub(pvc.CreationTimestamp.Time).Milliseconds()
+	}
+	status.LastSuccessTime = status.LastRunTime
+	return m.finishRun(ctx, nfsProvisioner, "Succeeded",
+		fmt.Sprintf("Provisioned in %dms, wrote 1MiB in %dms and read it back in %dms", status.ProvisionMilliseconds, write, read))
+}
+
+// finishRun records the result of the run in the condition and the metrics, and deletes its PVC and Job
+func (m *CanaryManager) finishRun(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, reason, message string) error {
+	status := nfsProvisioner.Status.Canary
+	labels := []string{nfsProvisioner.Namespace, nfsProvisioner.Name}
+
+	if reason == "Succeeded" {
+		status.ConsecutiveFailures = 0
+		setCondition(nfsProvisioner, ConditionCanaryHealthy, metav1.ConditionTrue, reason, message, nfsProvisioner.Generation)
+		canaryHealthy.WithLabelValues(labels...).Set(1)
+		canaryRuns.WithLabelValues(append(labels, "success")...).Inc()
+		canaryLatencySeconds.WithLabelValues(append(labels, "provision")...).Set(float64(status.ProvisionMilliseconds) / 1000)
+		canaryLatencySeconds.WithLabelValues(append(labels, "write")...).Set(float64(status.WriteMilliseconds) / 1000)
+		canaryLatencySeconds.WithLabelValues(append(labels, "read")...).Set(float64(status.ReadMilliseconds) / 1000)
+	} else {
+		m.Log.Info("The canary run failed", "Run", status.Run, "Reason", reason, "Message", message)
+		status.ConsecutiveFailures++
+		setCondition(nfsProvisioner, ConditionCanaryHealthy, metav1.ConditionFalse, reason, message, nfsProvisioner.Generation)
+		canaryHealthy.WithLabelValues(labels...).Set(0)
+		canaryRuns.WithLabelValues(append(labels, "failure")...).Inc()
+	}
+
+	if err := m.cleanup(ctx, nfsProvisioner.Namespace, status.Run); err != nil {
+		return err
+	}
+	status.Run = ""
+	return nil
+}
+
+// cleanup deletes the Job, its pod and the PVC of a run
+func (m *CanaryManager) cleanup(ctx context.Context, namespace, name string) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if err := m.Client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if err := m.Client.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// canaryLabels returns the labels of the PVCs and Jobs of the canary of the given NFSProvisioner CR name.
+// They leave out the labels of the NFS server, so that canary pods are neither endpoints of its Service nor match its affinity.
+func canaryLabels(name string) map[string]string {
+	return map[string]string{"nfsprovisioner_cr": name, "component": "canary", defaults.CanaryLabel: name}
+}
+
+// buildCanaryPVC returns the smallest PVC of the StorageClass
+func (m *CanaryManager) buildCanaryPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string) *corev1.PersistentVolumeClaim {
+	scName := StorageClassName(nfsProvisioner)
+	labels := canaryLabels(nfsProvisioner.Name)
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			StorageClassName: &scName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Mi")},
+			},
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, pvc, m.Scheme)
+	return pvc
+}
+
+// buildCanaryJob returns a Job that writes and reads a file on the PVC of the run.
+// The pod runs anywhere, like the pods of the users of the StorageClass.
+func (m *CanaryManager) buildCanaryJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string) *batchv1.Job {
+	labels := canaryLabels(nfsProvisioner.Name)
+	backoffLimit := int32(0)
+	deadline := int64(canaryTimeout(nfsProvisioner) / time.Second)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:                     "canary",
+						Image:                    defaults.UtilityImage,
+						Command:                  []string{"/bin/sh", "-c", canaryScript},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "canary",
+							MountPath: "/data",
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "canary",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+						},
+					}},
+				},
+			},
+		},
+	}
+	applyOperatorConfig(&job.Spec.Template.Spec)
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// ParseCanaryResult parses the "<writeMs> <readMs>" output of the canary Job
+func ParseCanaryResult(message string) (write int64, read int64, err error) {
+	fields := strings.Fields(message)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected canary result %q", message)
+	}
+	if write, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if read, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return write, read, nil
+}
+
+// canaryEnabled returns true when the NFSProvisioner runs the canary
+func canaryEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Canary != nil && nfsProvisioner.Spec.Canary.Enabled
+}
+
+// canaryTimeout returns how long a canary run may take
+func canaryTimeout(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if config := nfsProvisioner.Spec.Canary; config != nil && config.Timeout != nil {
+		return config.Timeout.Duration
+	}
+	return defaults.CanaryTimeout
+}
@@ -0,0 +1,158 @@
+package resources
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("CanaryManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		canaryManager  *CanaryManager
+	)
+
+	currentRun := func() (*corev1.PersistentVolumeClaim, *batchv1.Job) {
+		name := nfsProvisioner.Status.Canary.Run
+		Expect(name).NotTo(BeEmpty())
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: "test-namespace"}, pvc)).To(Succeed())
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: "test-namespace"}, job)).To(Succeed())
+		return pvc, job
+	}
+
+	bind := func(pvc *corev1.PersistentVolumeClaim) {
+		Expect(c.Create(ctx, &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-canary"}})).To(Succeed())
+		pvc.Spec.VolumeName = "pvc-canary"
+		Expect(c.Update(ctx, pvc)).To(Succeed())
+		pvc.Status.Phase = corev1.ClaimBound
+		Expect(c.Status().Update(ctx, pvc)).To(Succeed())
+	}
+
+	finish := func(job *batchv1.Job, conditionType batchv1.JobConditionType, message string) {
+		Expect(c.Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-abcde", Namespace: "test-namespace", Labels: map[string]string{"job-name": job.Name}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+			}}},
+		})).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				SCForNFSPvc: "gp3-csi",
+				Canary:      &cachev1alpha1.CanaryConfiguration{Enabled: true},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&corev1.PersistentVolumeClaim{}).Build()
+		canaryManager = NewCanaryManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+	})
+
+	It("should provision, write and read a volume of the StorageClass and clean up", func() {
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pvc, job := currentRun()
+		Expect(*pvc.Spec.StorageClassName).To(Equal("nfs"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(defaults.CanaryLabel, "test-nfs"))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue(defaults.CanaryLabel, "test-nfs"))
+		Expect(job.Spec.Template.Labels).NotTo(HaveKey("app"))
+		Expect(job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(pvc.Name))
+		Expect(*job.Spec.ActiveDeadlineSeconds).To(BeEquivalentTo(300))
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)
+		Expect(condition.Status).To(Equal(metav1.ConditionUnknown))
+
+		bind(pvc)
+		finish(job, batchv1.JobComplete, "120 30\n")
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)).To(BeTrue())
+		status := nfsProvisioner.Status.Canary
+		Expect(status.Run).To(BeEmpty())
+		Expect(status.WriteMilliseconds).To(BeEquivalentTo(120))
+		Expect(status.ReadMilliseconds).To(BeEquivalentTo(30))
+		Expect(status.LastSuccessTime).To(Equal(status.LastRunTime))
+		Expect(c.Get(ctx, types.NamespacedName{Name: pvc.Name, Namespace: "test-namespace"}, &corev1.PersistentVolumeClaim{})).NotTo(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: "test-namespace"}, &batchv1.Job{})).NotTo(Succeed())
+
+		// The next run waits for the interval
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Run).To(BeEmpty())
+	})
+
+	It("should report a PVC that is not bound within the timeout", func() {
+		nfsProvisioner.Spec.Canary.Timeout = &metav1.Duration{Duration: time.Minute}
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		// Nothing is reported before the timeout
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Canary.Run).NotTo(BeEmpty())
+
+		started := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		nfsProvisioner.Status.Canary.LastRunTime = &started
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("ProvisioningFailed"))
+		Expect(nfsProvisioner.Status.Canary.ConsecutiveFailures).To(BeEquivalentTo(1))
+		Expect(nfsProvisioner.Status.Canary.Run).To(BeEmpty())
+
+		// The next run starts after the interval
+		started = metav1.NewTime(time.Now().Add(-defaults.CanaryInterval))
+		nfsProvisioner.Status.Canary.LastRunTime = &started
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Canary.Run).NotTo(BeEmpty())
+	})
+
+	It("should report a failed write or read", func() {
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pvc, job := currentRun()
+		bind(pvc)
+		finish(job, batchv1.JobFailed, "cp: can't create '/data/canary': Permission denied")
+
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("ReadWriteFailed"))
+		Expect(condition.Message).To(ContainSubstring("Permission denied"))
+	})
+
+	It("should remove the run and the condition when the canary is disabled", func() {
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pvc, _ := currentRun()
+
+		nfsProvisioner.Spec.Canary = nil
+		Expect(canaryManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Canary).To(BeNil())
+		Expect(meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)).To(BeNil())
+		Expect(c.Get(ctx, types.NamespacedName{Name: pvc.Name, Namespace: "test-namespace"}, &corev1.PersistentVolumeClaim{})).NotTo(Succeed())
+	})
+})
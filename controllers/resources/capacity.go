@@ -0,0 +1,128 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// CapacityManager measures the free space of the export for the PVC webhook
+type CapacityManager struct {
+	BaseResourceManager
+}
+
+// NewCapacityManager creates a new CapacityManager
+func NewCapacityManager(base BaseResourceManager) *CapacityManager {
+	return &CapacityManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *CapacityManager) GetResourceName() string {
+	return "ExportCapacity"
+}
+
+// EnsureResource records the last capacity check and starts a new one when the interval is over
+func (m *CapacityManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	admission := nfsProvisioner.Spec.Admission
+	if admission == nil || !admission.RequireFreeSpace {
+		nfsProvisioner.Status.Export = nil
+		return nil
+	}
+	if nfsProvisioner.Status.Export == nil {
+		nfsProvisioner.Status.Export = &cachev1alpha1.ExportCapacityStatus{}
+	}
+	status := nfsProvisioner.Status.Export
+
+	job, err := LatestSucceededJob(ctx, m.Client, nfsProvisioner.Namespace, client.MatchingLabels{defaults.CapacityCheckLabel: nfsProvisioner.Name})
+	if err != nil {
+		return err
+	}
+	if job != nil && (status.LastCheckTime == nil || job.Status.CompletionTime.After(status.LastCheckTime.Time)) {
+		message, err := JobTerminationMessage(ctx, m.Client, job)
+		if err == nil {
+			total, available, err := ParseCapacity(message)
+			if err == nil {
+				status.TotalBytes = total
+				status.AvailableBytes = available
+			} else {
+				log.Error(err, "Failed to parse the capacity of the export", "Job.Name", job.Name)
+			}
+		}
+		completionTime := *job.Status.CompletionTime
+		status.LastCheckTime = &completionTime
+	}
+
+	interval := defaults.CapacityCheckInterval
+	if admission.CapacityCheckInterval != nil {
+		interval = admission.CapacityCheckInterval.Duration
+	}
+	if status.LastCheckTime != nil && time.Since(status.LastCheckTime.Time) < interval {
+		return nil
+	}
+
+	// Only one capacity Job runs at a time
+	jobs := &batchv1.JobList{}
+	if err := m.Client.List(ctx, jobs, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabels{defaults.CapacityCheckLabel: nfsProvisioner.Name}); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if finished, _ := JobFinished(&jobs.Items[i]); !finished {
+			return nil
+		}
+	}
+
+	checkJob := m.buildCapacityJob(nfsProvisioner)
+	log.Info("Creating a new Job", "Job.Namespace", checkJob.Namespace, "Job.Name", checkJob.Name)
+	if err := m.Client.Create(ctx, checkJob); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", checkJob.Namespace, "Job.Name", checkJob.Name)
+		return err
+	}
+	return nil
+}
+
+// buildCapacityJob returns a Job that writes the size and the free space of the export in KB to its termination message
+func (m *CapacityManager) buildCapacityJob(nfsProvisioner *cachev1alpha1.NFSProvisioner) *batchv1.Job {
+	container := corev1.Container{
+		Name:    "capacity",
+		Image:   defaults.UtilityImage,
+		Command: []string{"/bin/sh", "-c", "df -Pk " + defaults.ExportPath + " | awk 'NR == 2 {print $2, $4}' > /dev/termination-log"},
+	}
+
+	ttl := int32(60 * 60)
+	job := BuildExportJob(nfsProvisioner, truncateName(fmt.Sprintf("nfs-capacity-%s-%d", nfsProvisioner.Name, time.Now().Unix())), container)
+	job.Labels[defaults.CapacityCheckLabel] = nfsProvisioner.Name
+	job.Spec.TTLSecondsAfterFinished = &ttl
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// ParseCapacity parses the "<totalKB> <availableKB>" output of the capacity Job into bytes
+func ParseCapacity(message string) (total int64, available int64, err error) {
+	fields := strings.Fields(message)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected capacity %q", message)
+	}
+	if total, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if available, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return total * 1024, available * 1024, nil
+}
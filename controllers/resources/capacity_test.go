@@ -0,0 +1,18 @@
+package resources
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CapacityManager", func() {
+	It("should parse the capacity of the export", func() {
+		total, available, err := ParseCapacity("10475520 8388608\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total).To(Equal(int64(10475520 * 1024)))
+		Expect(available).To(Equal(int64(8388608 * 1024)))
+
+		_, _, err = ParseCapacity("")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,268 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// Phases of a cloned PVC in defaults.ClonePhaseAnnotation
+const (
+	ClonePhaseCopying   = "Copying"
+	ClonePhaseSucceeded = "Succeeded"
+	ClonePhaseFailed    = "Failed"
+)
+
+// CloneManager copies the directory of the source PVC into the volume of a PVC that has another PVC as data source.
+// The provisioners of the StorageClass ignore the data source, so the volume is provisioned empty and filled by a Job once it is bound.
+type CloneManager struct {
+	BaseResourceManager
+}
+
+// NewCloneManager creates a new CloneManager
+func NewCloneManager(base BaseResourceManager) *CloneManager {
+	return &CloneManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *CloneManager) GetResourceName() string {
+	return "VolumeClone"
+}
+
+// EnsureResource starts the copy of the bound clones of the StorageClass and records the result on the PVCs
+func (m *CloneManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	// The export is being copied to another volume, a clone would be lost
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := m.Client.List(ctx, pvcs); err != nil {
+		return err
+	}
+
+	scName := StorageClassName(nfsProvisioner)
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != scName || CloneSource(pvc) == "" {
+			continue
+		}
+		if err := m.ensureClone(ctx, nfsProvisioner, pvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureClone moves a cloned PVC one step forward: bound, copying, then succeeded or failed
+func (m *CloneManager) ensureClone(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, pvc *corev1.PersistentVolumeClaim) error {
+	switch pvc.Annotations[defaults.ClonePhaseAnnotation] {
+	case ClonePhaseSucceeded, ClonePhaseFailed:
+		return nil
+	case ClonePhaseCopying:
+		return m.checkCopy(ctx, nfsProvisioner, pvc)
+	}
+
+	// The volume is provisioned before it is filled
+	if pvc.Status.Phase != corev1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return nil
+	}
+
+	source := &corev1.PersistentVolumeClaim{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: CloneSource(pvc), Namespace: pvc.Namespace}, source)
+	if err != nil && errors.IsNotFound(err) {
+		return m.failClone(ctx, pvc, fmt.Sprintf("The source PVC %s does not exist", CloneSource(pvc)))
+	} else if err != nil {
+		return err
+	}
+	if source.Status.Phase != corev1.ClaimBound || source.Spec.VolumeName == "" {
+		return nil
+	}
+
+	exportPath := pvExportPath(nfsProvisioner)
+	sourceDir, err := m.volumeSubPath(ctx, source.Spec.VolumeName, exportPath)
+	if err != nil {
+		return err
+	}
+	if sourceDir == "" {
+		return m.failClone(ctx, pvc, fmt.Sprintf("The source PVC %s is not a volume of NFSProvisioner %s/%s", source.Name, nfsProvisioner.Namespace, nfsProvisioner.Name))
+	}
+	targetDir, err := m.volumeSubPath(ctx, pvc.Spec.VolumeName, exportPath)
+	if err != nil {
+		return err
+	}
+	if targetDir == "" {
+		return m.failClone(ctx, pvc, fmt.Sprintf("The volume %s is not on the export of NFSProvisioner %s/%s", pvc.Spec.VolumeName, nfsProvisioner.Namespace, nfsProvisioner.Name))
+	}
+
+	job := m.buildCloneJob(nfsProvisioner, pvc, sourceDir, targetDir)
+	m.Log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+	if err := m.Client.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		m.Log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return err
+	}
+	if err := setClonePhase(ctx, m.Client, pvc, ClonePhaseCopying); err != nil {
+		return err
+	}
+	return recordClaimEvent(ctx, m.Client, pvc, corev1.EventTypeNormal, "CloneStarted",
+		fmt.Sprintf("Copying the data of PVC %s with Job %s/%s", source.Name, job.Namespace, job.Name))
+}
+
+// checkCopy records the result of the copy Job of the PVC
+func (m *CloneManager) checkCopy(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, pvc *corev1.PersistentVolumeClaim) error {
+	job := &batchv1.Job{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: CloneJobName(pvc), Namespace: nfsProvisioner.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		return m.failClone(ctx, pvc, fmt.Sprintf("The Job %s/%s that copies the data is gone", nfsProvisioner.Namespace, CloneJobName(pvc)))
+	} else if err != nil {
+		return err
+	}
+
+	finished, succeeded := JobFinished(job)
+	if !finished {
+		return nil
+	}
+
+	message, err := JobTerminationMessage(ctx, m.Client, job)
+	if err != nil || strings.TrimSpace(message) == "" {
+		message = jobConditionMessage(job)
+	}
+	message = strings.TrimSpace(message)
+	if !succeeded {
+		return m.failClone(ctx, pvc, "The copy failed: "+message)
+	}
+
+	if err := setClonePhase(ctx, m.Client, pvc, ClonePhaseSucceeded); err != nil {
+		return err
+	}
+	return recordClaimEvent(ctx, m.Client, pvc, corev1.EventTypeNormal, "CloneSucceeded", message)
+}
+
+// failClone marks the PVC as failed. The copy is not retried until the annotation is removed.
+func (m *CloneManager) failClone(ctx context.Context, pvc *corev1.PersistentVolumeClaim, message string) error {
+	m.Log.Info("Failed to clone a PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name, "Reason", message)
+	if err := setClonePhase(ctx, m.Client, pvc, ClonePhaseFailed); err != nil {
+		return err
+	}
+	return recordClaimEvent(ctx, m.Client, pvc, corev1.EventTypeWarning, "CloneFailed", message)
+}
+
+// volumeSubPath returns the path of the PV under the export, or "" when the PV is not on the export
+func (m *CloneManager) volumeSubPath(ctx context.Context, pvName, exportPath string) (string, error) {
+	pv := &corev1.PersistentVolume{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: pvName}, pv); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return exportSubPath(pv, exportPath), nil
+}
+
+// buildCloneJob returns a Job that copies the source directory into the target directory, keeping owners and modes.
+// The PV metadata file of the target is kept, so that an import recreates the clone and not its source.
+func (m *CloneManager) buildCloneJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, pvc *corev1.PersistentVolumeClaim, sourceDir, targetDir string) *batchv1.Job {
+	script := `set -e
+src="` + defaults.ExportPath + `/$SOURCE_DIR"
+dst="` + defaults.ExportPath + `/$TARGET_DIR"
+if [ ! -d "$src" ]; then
+  echo "The source directory $SOURCE_DIR does not exist" > /dev/termination-log
+  exit 1
+fi
+[ -f "$dst/` + defaults.PVMetadataFile + `" ] && mv "$dst/` + defaults.PVMetadataFile + `" /tmp/pv.json
+cp -a "$src/." "$dst/"
+rm -f "$dst/` + defaults.PVMetadataFile + `"
+[ -f /tmp/pv.json ] && mv /tmp/pv.json "$dst/` + defaults.PVMetadataFile + `"
+echo "Copied $(du -sk "$dst" | cut -f1) KiB from $SOURCE_DIR" > /dev/termination-log
+`
+	container := corev1.Container{
+		Name:    "clone",
+		Image:   defaults.UtilityImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{Name: "SOURCE_DIR", Value: sourceDir},
+			{Name: "TARGET_DIR", Value: targetDir},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	ttl := int32(60 * 60)
+	job := BuildExportJob(nfsProvisioner, CloneJobName(pvc), container)
+	job.Labels[defaults.CloneLabel] = nfsProvisioner.Name
+	job.Spec.TTLSecondsAfterFinished = &ttl
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// CloneJobName returns the name of the Job that fills the volume of the cloned PVC
+func CloneJobName(pvc *corev1.PersistentVolumeClaim) string {
+	return truncateName("nfs-clone-" + pvc.Spec.VolumeName)
+}
+
+// CloneSource returns the name of the PVC the PVC is cloned from, or "" when it is not a clone
+func CloneSource(pvc *corev1.PersistentVolumeClaim) string {
+	if ds := pvc.Spec.DataSource; ds != nil && ds.Kind == "PersistentVolumeClaim" && (ds.APIGroup == nil || *ds.APIGroup == "") {
+		return ds.Name
+	}
+	if ref := pvc.Spec.DataSourceRef; ref != nil && ref.Kind == "PersistentVolumeClaim" && (ref.APIGroup == nil || *ref.APIGroup == "") &&
+		(ref.Namespace == nil || *ref.Namespace == pvc.Namespace) {
+		return ref.Name
+	}
+	return ""
+}
+
+// setClonePhase records the phase of the clone in the annotation of the PVC
+func setClonePhase(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim, phase string) error {
+	updated := pvc.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[defaults.ClonePhaseAnnotation] = phase
+	if err := c.Patch(ctx, updated, client.MergeFrom(pvc)); err != nil {
+		return err
+	}
+	*pvc = *updated
+	return nil
+}
+
+// recordClaimEvent creates an event on the PVC, so that its owner sees it with kubectl describe
+func recordClaimEvent(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim, eventType, reason, message string) error {
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pvc.Name + ".",
+			Namespace:    pvc.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            "PersistentVolumeClaim",
+			APIVersion:      "v1",
+			Namespace:       pvc.Namespace,
+			Name:            pvc.Name,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: "nfs-provisioner-operator"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	return c.Create(ctx, event)
+}
@@ -0,0 +1,173 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("CloneManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		cloneManager   *CloneManager
+	)
+
+	nfsPV := func(name, path string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName: "nfs",
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					NFS: &corev1.NFSVolumeSource{Server: "172.30.0.10", Path: path},
+				},
+			},
+		}
+	}
+
+	boundPVC := func(name, volume string, dataSource *corev1.TypedLocalObjectReference) *corev1.PersistentVolumeClaim {
+		scName := "nfs"
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &scName,
+				VolumeName:       volume,
+				DataSource:       dataSource,
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		}
+	}
+
+	clonePhase := func() string {
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "data-copy", Namespace: "app"}, pvc)).To(Succeed())
+		return pvc.Annotations[defaults.ClonePhaseAnnotation]
+	}
+
+	eventReasons := func() []string {
+		events := &corev1.EventList{}
+		Expect(c.List(ctx, events, client.InNamespace("app"))).To(Succeed())
+		reasons := []string{}
+		for _, event := range events.Items {
+			Expect(event.InvolvedObject.Name).To(Equal("data-copy"))
+			reasons = append(reasons, event.Reason)
+		}
+		return reasons
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec:       cachev1alpha1.NFSProvisionerSpec{SCForNFSPvc: "gp3-csi"},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			nfsPV("pvc-source", "/export/app-data-pvc-source"),
+			nfsPV("pvc-copy", "/export/app-data-copy-pvc-copy"),
+			boundPVC("data", "pvc-source", nil),
+			boundPVC("data-copy", "pvc-copy", &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "data"}),
+		).Build()
+		cloneManager = NewCloneManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+	})
+
+	It("should copy the directory of the source PVC into the bound clone and report it on the PVC", func() {
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(Equal(ClonePhaseCopying))
+		Expect(eventReasons()).To(Equal([]string{"CloneStarted"}))
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-clone-pvc-copy", Namespace: "test-namespace"}, job)).To(Succeed())
+		Expect(job.Labels).To(HaveKeyWithValue(defaults.CloneLabel, "test-nfs"))
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Env).To(ConsistOf(
+			corev1.EnvVar{Name: "SOURCE_DIR", Value: "app-data-pvc-source"},
+			corev1.EnvVar{Name: "TARGET_DIR", Value: "app-data-copy-pvc-copy"},
+		))
+		Expect(container.Command[2]).To(ContainSubstring(`cp -a "$src/." "$dst/"`))
+
+		// Nothing changes while the copy runs
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(Equal(ClonePhaseCopying))
+
+		Expect(c.Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs-clone-pvc-copy-abcde", Namespace: "test-namespace", Labels: map[string]string{"job-name": job.Name}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "Copied 2048 KiB from app-data-pvc-source\n"}},
+			}}},
+		})).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(Equal(ClonePhaseSucceeded))
+		Expect(eventReasons()).To(ConsistOf("CloneStarted", "CloneSucceeded"))
+	})
+
+	It("should wait for the clone to be bound", func() {
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "data-copy", Namespace: "app"}, pvc)).To(Succeed())
+		pvc.Spec.VolumeName = ""
+		pvc.Status.Phase = corev1.ClaimPending
+		Expect(c.Update(ctx, pvc)).To(Succeed())
+
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(BeEmpty())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-clone-pvc-copy", Namespace: "test-namespace"}, &batchv1.Job{})).NotTo(Succeed())
+	})
+
+	It("should refuse a source that is not on the export", func() {
+		pv := &corev1.PersistentVolume{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "pvc-source"}, pv)).To(Succeed())
+		pv.Spec.NFS.Path = "/other/app-data-pvc-source"
+		Expect(c.Update(ctx, pv)).To(Succeed())
+
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(Equal(ClonePhaseFailed))
+		Expect(eventReasons()).To(Equal([]string{"CloneFailed"}))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-clone-pvc-copy", Namespace: "test-namespace"}, &batchv1.Job{})).NotTo(Succeed())
+	})
+
+	It("should report a failed copy", func() {
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-clone-pvc-copy", Namespace: "test-namespace"}, job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit"}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+
+		Expect(cloneManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(clonePhase()).To(Equal(ClonePhaseFailed))
+		Expect(eventReasons()).To(ConsistOf("CloneStarted", "CloneFailed"))
+	})
+
+	It("should only clone from a PVC", func() {
+		apiGroup := "snapshot.storage.k8s.io"
+		pvc := &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{
+			DataSource: &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VolumeSnapshot", Name: "snap"},
+		}}
+		Expect(CloneSource(pvc)).To(BeEmpty())
+
+		pvc.Spec.DataSourceRef = &corev1.TypedObjectReference{Kind: "PersistentVolumeClaim", Name: "data"}
+		Expect(CloneSource(pvc)).To(Equal("data"))
+	})
+})
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
@@ -24,6 +25,8 @@ type BaseResourceManager struct {
 	Client client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// KubeClient reads pod logs, which the controller-runtime client can not do
+	KubeClient kubernetes.Interface
 }
 
 // NewBaseResourceManager creates a new BaseResourceManager
@@ -34,3 +37,24 @@ func NewBaseResourceManager(client client.Client, log logr.Logger, scheme *runti
 		Scheme: scheme,
 	}
 }
+
+// removeAnnotations removes the annotations from the NFSProvisioner without touching its in-memory status
+func removeAnnotations(ctx context.Context, c client.Client, nfsProvisioner *cachev1alpha1.NFSProvisioner, keys ...string) error {
+	updated := nfsProvisioner.DeepCopy()
+	for _, key := range keys {
+		delete(updated.Annotations, key)
+	}
+
+	if err := c.Patch(ctx, updated, client.MergeFrom(nfsProvisioner)); err != nil {
+		return err
+	}
+
+	nfsProvisioner.Annotations = updated.Annotations
+	nfsProvisioner.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// isExternalMode returns true when the NFSProvisioner uses an existing NFS server
+func isExternalMode(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Mode == cachev1alpha1.ModeExternal
+}
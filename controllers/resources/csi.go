@@ -0,0 +1,601 @@
+package resources
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+	"github.com/jooho/nfs-provisioner-operator/csidriver"
+	"github.com/jooho/nfs-provisioner-operator/nfsserver"
+)
+
+const (
+	// csiSocketDir is where the CSI plugin and its sidecars share the socket
+	csiSocketDir = "/csi"
+	// csiEndpoint is the socket of the CSI plugin
+	csiEndpoint = "unix://" + csiSocketDir + "/csi.sock"
+	// csiSidecarTimeout leaves time to copy a directory for a snapshot or a clone
+	csiSidecarTimeout = "--timeout=300s"
+	// volumeSnapshotClassCRD is installed with the snapshot controller
+	volumeSnapshotClassCRD = "volumesnapshotclasses.snapshot.storage.k8s.io"
+)
+
+// CSIManager registers the CSI driver of a NFSProvisioner and runs its node plugin.
+// The controller plugin runs with its sidecars in the pod of the NFS server, where the export is mounted, see csiControllerContainers.
+type CSIManager struct {
+	BaseResourceManager
+}
+
+// NewCSIManager creates a new CSIManager
+func NewCSIManager(base BaseResourceManager) *CSIManager {
+	return &CSIManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *CSIManager) GetResourceName() string {
+	return "CSI"
+}
+
+// EnsureResource ensures the CSIDriver, the RBAC of the sidecars, the node plugin and the StorageClass of the driver exist.
+// The StorageClass waits for the ClusterIP of the Service, the nodes mount the volumes from it.
+func (m *CSIManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	if !csiEnabled(nfsProvisioner) {
+		if nfsProvisioner.Status.CSI == nil {
+			return nil
+		}
+		return m.removeCSI(ctx, nfsProvisioner)
+	}
+	if image, _ := csiDriverImage(nfsProvisioner); image == "" {
+		return errNoCSIDriverImage
+	}
+
+	driver := CSIDriverName(nfsProvisioner)
+	objects := []client.Object{
+		m.buildCSIDriver(nfsProvisioner),
+		m.buildClusterRole(nfsProvisioner),
+		m.buildClusterRoleBinding(nfsProvisioner),
+		m.buildRole(nfsProvisioner),
+		m.buildRoleBinding(nfsProvisioner),
+	}
+	for _, object := range objects {
+		if err := m.createIfNotFound(ctx, object); err != nil {
+			return err
+		}
+	}
+
+	ds, err := m.ensureNodeDaemonSet(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+	status := &cachev1alpha1.CSIStatus{
+		Driver:       driver,
+		NodesReady:   ds.Status.NumberReady,
+		NodesDesired: ds.Status.DesiredNumberScheduled,
+	}
+	nfsProvisioner.Status.CSI = status
+
+	svc := &corev1.Service{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.Service, Namespace: nfsProvisioner.Namespace}, svc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		log.Info("Waiting for the ClusterIP of the Service before creating the StorageClass of the CSI driver", "Service.Name", svc.Name)
+		return nil
+	}
+	if err := m.ensureStorageClass(ctx, nfsProvisioner, svc.Spec.ClusterIP); err != nil {
+		return err
+	}
+	status.StorageClass = csiStorageClassName(nfsProvisioner)
+
+	// Snapshots need the snapshot controller, which is not part of every cluster
+	if m.isCRDAvailable(ctx, volumeSnapshotClassCRD) {
+		if err := m.createIfNotFound(ctx, buildVolumeSnapshotClass(nfsProvisioner)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIfNotFound creates the object unless it exists
+func (m *CSIManager) createIfNotFound(ctx context.Context, object client.Object) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	found := object.DeepCopyObject().(client.Object)
+	err := m.Client.Get(ctx, client.ObjectKeyFromObject(object), found)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	kind := fmt.Sprintf("%T", object)
+	if u, ok := object.(*unstructured.Unstructured); ok {
+		kind = u.GetKind()
+	}
+	log.Info("Creating a new CSI resource", "Kind", kind, "Name", object.GetName())
+	if err := m.Client.Create(ctx, object); err != nil {
+		log.Error(err, "Failed to create a CSI resource", "Kind", kind, "Name", object.GetName())
+		return err
+	}
+	return nil
+}
+
+// isCRDAvailable returns true when the CustomResourceDefinition is installed
+func (m *CSIManager) isCRDAvailable(ctx context.Context, name string) bool {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	return m.Client.Get(ctx, types.NamespacedName{Name: name}, crd) == nil
+}
+
+// ensureNodeDaemonSet ensures the node plugin runs on the nodes, and returns its DaemonSet
+func (m *CSIManager) ensureNodeDaemonSet(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (*appsv1.DaemonSet, error) {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	ds := m.buildNodeDaemonSet(nfsProvisioner)
+
+	dsFound := &appsv1.DaemonSet{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace}, dsFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new DaemonSet", "DaemonSet.Namespace", ds.Namespace, "DaemonSet.Name", ds.Name)
+		if err := m.Client.Create(ctx, ds); err != nil {
+			log.Error(err, "Failed to create the CSI node DaemonSet", "DaemonSet.Namespace", ds.Namespace, "DaemonSet.Name", ds.Name)
+			return nil, err
+		}
+		return ds, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if podTemplateChanged(&ds.Spec.Template, &dsFound.Spec.Template) ||
+		!equality.Semantic.DeepEqual(ds.Spec.Template.Spec.Tolerations, dsFound.Spec.Template.Spec.Tolerations) {
+		log.Info("Updating the DaemonSet", "DaemonSet.Namespace", ds.Namespace, "DaemonSet.Name", ds.Name)
+		dsFound.Spec.Template = ds.Spec.Template
+		if err := m.Client.Update(ctx, dsFound); err != nil {
+			log.Error(err, "Failed to update the CSI node DaemonSet", "DaemonSet.Namespace", ds.Namespace, "DaemonSet.Name", ds.Name)
+			return nil, err
+		}
+	}
+	return dsFound, nil
+}
+
+// ensureStorageClass ensures the StorageClass of the driver exists.
+// Its parameters can not be changed, so it is replaced when the address of the server changed. Bound PVs are not affected.
+func (m *CSIManager) ensureStorageClass(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, server string) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	sc := m.buildStorageClass(nfsProvisioner, server)
+
+	scFound := &storagev1.StorageClass{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: sc.Name}, scFound)
+	if err == nil && (scFound.Provisioner != sc.Provisioner || !equality.Semantic.DeepEqual(scFound.Parameters, sc.Parameters)) {
+		log.Info("Replacing the Storageclass of the CSI driver because its parameters changed", "Storageclass.Name", sc.Name)
+		if err = m.Client.Delete(ctx, scFound); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the Storageclass of the CSI driver", "Storageclass.Name", sc.Name)
+			return err
+		}
+		err = errors.NewNotFound(storagev1.Resource("storageclasses"), sc.Name)
+	}
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Storageclass", "Storageclass.Name", sc.Name)
+		if err = m.Client.Create(ctx, sc); err != nil {
+			log.Error(err, "Failed to create the Storageclass of the CSI driver", "Storageclass.Name", sc.Name)
+			return err
+		}
+		return nil
+	}
+	return err
+}
+
+// removeCSI removes the CSI resources once the driver is disabled. The PVs of the driver stay, but can not be mounted anymore.
+func (m *CSIManager) removeCSI(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	status := nfsProvisioner.Status.CSI
+
+	objects := []client.Object{
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: defaults.CSINodeDaemonSet, Namespace: nfsProvisioner.Namespace}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: defaults.CSIRoleBinding, Namespace: nfsProvisioner.Namespace}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: defaults.CSIRole, Namespace: nfsProvisioner.Namespace}},
+	}
+	if status.Driver != "" {
+		objects = append(objects,
+			&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: status.Driver}},
+			&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: status.Driver}},
+			&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: status.Driver}},
+		)
+	}
+	if status.StorageClass != "" {
+		objects = append(objects, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: status.StorageClass}})
+		if m.isCRDAvailable(ctx, volumeSnapshotClassCRD) {
+			snapshotClass := buildVolumeSnapshotClass(nfsProvisioner)
+			snapshotClass.SetName(status.StorageClass)
+			objects = append(objects, snapshotClass)
+		}
+	}
+
+	for _, object := range objects {
+		if err := m.Client.Delete(ctx, object); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		log.Info("Deleted the CSI resource", "Name", object.GetName())
+	}
+	nfsProvisioner.Status.CSI = nil
+	return nil
+}
+
+// buildCSIDriver registers the driver. Volumes are mounted without attaching, and kubelet leaves the ownership
+// of a volume alone like for the StorageClass of the external provisioner.
+func (m *CSIManager) buildCSIDriver(nfsProvisioner *cachev1alpha1.NFSProvisioner) *storagev1.CSIDriver {
+	attachRequired := false
+	podInfoOnMount := false
+	fsGroupPolicy := storagev1.NoneFSGroupPolicy
+
+	csiDriver := &storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   CSIDriverName(nfsProvisioner),
+			Labels: labelsForNFSProvisioner(nfsProvisioner.Name),
+		},
+		Spec: storagev1.CSIDriverSpec{
+			AttachRequired:       &attachRequired,
+			PodInfoOnMount:       &podInfoOnMount,
+			FSGroupPolicy:        &fsGroupPolicy,
+			VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{storagev1.VolumeLifecyclePersistent},
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, csiDriver, m.Scheme)
+	return csiDriver
+}
+
+// buildClusterRole returns the ClusterRole of the external-provisioner, the external-resizer and the external-snapshotter
+func (m *CSIManager) buildClusterRole(nfsProvisioner *cachev1alpha1.NFSProvisioner) *rbacv1.ClusterRole {
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: CSIDriverName(nfsProvisioner),
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"persistentvolumes"},
+				Verbs:     []string{"get", "list", "watch", "create", "delete", "patch"},
+			}, {
+				APIGroups: []string{""},
+				Resources: []string{"persistentvolumeclaims"},
+				Verbs:     []string{"get", "list", "watch", "update"},
+			}, {
+				APIGroups: []string{""},
+				Resources: []string{"persistentvolumeclaims/status"},
+				Verbs:     []string{"update", "patch"},
+			}, {
+				APIGroups: []string{""},
+				Resources: []string{"events"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+			}, {
+				APIGroups: []string{""},
+				Resources: []string{"nodes"},
+				Verbs:     []string{"get", "list", "watch"},
+			}, {
+				APIGroups: []string{"storage.k8s.io"},
+				Resources: []string{"storageclasses", "csinodes", "volumeattachments"},
+				Verbs:     []string{"get", "list", "watch"},
+			}, {
+				APIGroups: []string{"snapshot.storage.k8s.io"},
+				Resources: []string{"volumesnapshotclasses"},
+				Verbs:     []string{"get", "list", "watch"},
+			}, {
+				APIGroups: []string{"snapshot.storage.k8s.io"},
+				Resources: []string{"volumesnapshots", "volumesnapshotcontents"},
+				Verbs:     []string{"get", "list", "watch", "update", "patch"},
+			}, {
+				APIGroups: []string{"snapshot.storage.k8s.io"},
+				Resources: []string{"volumesnapshotcontents/status"},
+				Verbs:     []string{"update", "patch"},
+			},
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, cr, m.Scheme)
+	return cr
+}
+
+// buildClusterRoleBinding binds the ClusterRole of the sidecars to the service account of the NFS server
+func (m *CSIManager) buildClusterRoleBinding(nfsProvisioner *cachev1alpha1.NFSProvisioner) *rbacv1.ClusterRoleBinding {
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: CSIDriverName(nfsProvisioner),
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      defaults.ServiceAccount,
+			Namespace: nfsProvisioner.Namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     CSIDriverName(nfsProvisioner),
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, crb, m.Scheme)
+	return crb
+}
+
+// buildRole returns the Role with the leases the sidecars elect their leader with
+func (m *CSIManager) buildRole(nfsProvisioner *cachev1alpha1.NFSProvisioner) *rbacv1.Role {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CSIRole,
+			Namespace: nfsProvisioner.Namespace,
+		},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		}},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, role, m.Scheme)
+	return role
+}
+
+// buildRoleBinding binds the leases to the service account of the NFS server
+func (m *CSIManager) buildRoleBinding(nfsProvisioner *cachev1alpha1.NFSProvisioner) *rbacv1.RoleBinding {
+	rolebinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CSIRoleBinding,
+			Namespace: nfsProvisioner.Namespace,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      defaults.ServiceAccount,
+			Namespace: nfsProvisioner.Namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     defaults.CSIRole,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, rolebinding, m.Scheme)
+	return rolebinding
+}
+
+// buildNodeDaemonSet returns the node plugin. It mounts the volumes into the pod directories of the kubelet,
+// so it runs privileged in the network of the node, and registers itself with the kubelet.
+func (m *CSIManager) buildNodeDaemonSet(nfsProvisioner *cachev1alpha1.NFSProvisioner) *appsv1.DaemonSet {
+	ls := csiNodeLabels(nfsProvisioner.Name)
+	driver := CSIDriverName(nfsProvisioner)
+	pluginDir := defaults.KubeletDir + "/plugins/" + driver
+	image, pullPolicy := csiDriverImage(nfsProvisioner)
+
+	privileged := true
+	runAsUser := int64(0)
+	bidirectional := corev1.MountPropagationBidirectional
+	directoryOrCreate := corev1.HostPathDirectoryOrCreate
+	directory := corev1.HostPathDirectory
+
+	var tolerations []corev1.Toleration
+	if nfsProvisioner.Spec.CSI != nil {
+		tolerations = nfsProvisioner.Spec.CSI.NodeTolerations
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CSINodeDaemonSet,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    ls,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: ls},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: ls},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: defaults.ServiceAccount,
+					// The kernel NFS client connects from the network of the node
+					HostNetwork: true,
+					DNSPolicy:   corev1.DNSClusterFirstWithHostNet,
+					Tolerations: tolerations,
+					Containers: []corev1.Container{{
+						Name:  "node-driver-registrar",
+						Image: defaults.CSINodeDriverRegistrarImage,
+						Args: []string{
+							"--csi-address=" + csiSocketDir + "/csi.sock",
+							"--kubelet-registration-path=" + pluginDir + "/csi.sock",
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "socket-dir", MountPath: csiSocketDir},
+							{Name: "registration-dir", MountPath: "/registration"},
+						},
+					}, {
+						Name:            "csi-driver",
+						Image:           image,
+						ImagePullPolicy: pullPolicy,
+						Command:         []string{"/csi-driver"},
+						Args: []string{
+							"--endpoint=" + csiEndpoint,
+							"--driver-name=" + driver,
+							"--node-id=$(NODE_NAME)",
+						},
+						Env: []corev1.EnvVar{{
+							Name:      "NODE_NAME",
+							ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+						}},
+						SecurityContext: &corev1.SecurityContext{
+							Privileged: &privileged,
+							RunAsUser:  &runAsUser,
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "socket-dir", MountPath: csiSocketDir},
+							{Name: "pods-mount-dir", MountPath: defaults.KubeletDir + "/pods", MountPropagation: &bidirectional},
+						},
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "socket-dir",
+						VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: pluginDir, Type: &directoryOrCreate}},
+					}, {
+						Name:         "registration-dir",
+						VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: defaults.KubeletDir + "/plugins_registry", Type: &directory}},
+					}, {
+						Name:         "pods-mount-dir",
+						VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: defaults.KubeletDir + "/pods", Type: &directory}},
+					}},
+				},
+			},
+		},
+	}
+	applyOperatorConfig(&ds.Spec.Template.Spec)
+
+	ctrl.SetControllerReference(nfsProvisioner, ds, m.Scheme)
+	return ds
+}
+
+// buildStorageClass returns the StorageClass of the driver. The volumes can be expanded, and are mounted like the
+// volumes of the external provisioner.
+func (m *CSIManager) buildStorageClass(nfsProvisioner *cachev1alpha1.NFSProvisioner, server string) *storagev1.StorageClass {
+	allowVolumeExpansion := true
+	mountOptions := []string{"vers=4.1"}
+	// The go NFS server only serves NFSv3, with MOUNT on the NFS port
+	if goServer(nfsProvisioner) {
+		mountOptions = nfsserver.DefaultMountOptions
+	}
+
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: csiStorageClassName(nfsProvisioner),
+		},
+		Provisioner: CSIDriverName(nfsProvisioner),
+		Parameters: map[string]string{
+			csidriver.ServerParameter: server,
+			csidriver.ShareParameter:  defaults.ExportPath,
+		},
+		MountOptions:         mountOptions,
+		AllowVolumeExpansion: &allowVolumeExpansion,
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, sc, m.Scheme)
+	return sc
+}
+
+// buildVolumeSnapshotClass returns the VolumeSnapshotClass of the driver, named like its StorageClass
+func buildVolumeSnapshotClass(nfsProvisioner *cachev1alpha1.NFSProvisioner) *unstructured.Unstructured {
+	snapshotClass := &unstructured.Unstructured{}
+	snapshotClass.SetAPIVersion("snapshot.storage.k8s.io/v1")
+	snapshotClass.SetKind("VolumeSnapshotClass")
+	snapshotClass.SetName(csiStorageClassName(nfsProvisioner))
+	snapshotClass.SetLabels(labelsForNFSProvisioner(nfsProvisioner.Name))
+	snapshotClass.Object["driver"] = CSIDriverName(nfsProvisioner)
+	snapshotClass.Object["deletionPolicy"] = "Delete"
+	return snapshotClass
+}
+
+// csiControllerContainers returns the controller plugin and its sidecars, which run in the pod of the NFS server.
+// The plugin writes to the export as the NFS server does: as root next to NFS-Ganesha, as the user of the go NFS server otherwise.
+func csiControllerContainers(nfsProvisioner *cachev1alpha1.NFSProvisioner) []corev1.Container {
+	image, pullPolicy := csiDriverImage(nfsProvisioner)
+	socketMount := corev1.VolumeMount{Name: "csi-socket-dir", MountPath: csiSocketDir}
+
+	runAsUser := int64(0)
+	securityContext := &corev1.SecurityContext{RunAsUser: &runAsUser}
+	if goServer(nfsProvisioner) {
+		securityContext = goServerContainer(nfsProvisioner).SecurityContext
+	}
+
+	sidecar := func(name, image string) corev1.Container {
+		return corev1.Container{
+			Name:         name,
+			Image:        image,
+			Args:         []string{"--csi-address=" + csiSocketDir + "/csi.sock", "--leader-election", csiSidecarTimeout},
+			VolumeMounts: []corev1.VolumeMount{socketMount},
+		}
+	}
+	// The claim names the directory of the volume, like with the external provisioner
+	provisioner := sidecar("csi-provisioner", defaults.CSIProvisionerImage)
+	provisioner.Args = append(provisioner.Args, "--extra-create-metadata")
+
+	return []corev1.Container{{
+		Name:            "csi-driver",
+		Image:           image,
+		ImagePullPolicy: pullPolicy,
+		Command:         []string{"/csi-driver"},
+		Args: []string{
+			"--endpoint=" + csiEndpoint,
+			"--driver-name=" + CSIDriverName(nfsProvisioner),
+			"--export=" + defaults.ExportPath,
+		},
+		SecurityContext: securityContext,
+		VolumeMounts: []corev1.VolumeMount{socketMount, {
+			Name:      "export-volume",
+			MountPath: defaults.ExportPath,
+		}},
+	},
+		provisioner,
+		sidecar("csi-resizer", defaults.CSIResizerImage),
+		sidecar("csi-snapshotter", defaults.CSISnapshotterImage),
+	}
+}
+
+// csiSocketVolume returns the volume the controller plugin shares its socket with the sidecars on
+func csiSocketVolume() corev1.Volume {
+	return corev1.Volume{
+		Name:         "csi-socket-dir",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+}
+
+// errNoCSIDriverImage is returned when the operator image of the CSI plugin is not known from RELATED_IMAGE_CSI_DRIVER
+var errNoCSIDriverImage = fmt.Errorf("the image of the CSI driver is not set, set RELATED_IMAGE_CSI_DRIVER of the operator or csi.image")
+
+// csiEnabled returns true when the NFSProvisioner deploys the CSI driver
+func csiEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return !isExternalMode(nfsProvisioner) && nfsProvisioner.Spec.CSI != nil && nfsProvisioner.Spec.CSI.Enabled
+}
+
+// csiDriverImage returns the image of the CSI plugin and its pull policy
+func csiDriverImage(nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, corev1.PullPolicy) {
+	if nfsProvisioner.Spec.CSI != nil && nfsProvisioner.Spec.CSI.Image != "" {
+		return nfsProvisioner.Spec.CSI.Image, corev1.PullIfNotPresent
+	}
+	return defaults.CSIDriverImage, corev1.PullIfNotPresent
+}
+
+// csiNodeLabels returns the labels of the node plugin pods
+func csiNodeLabels(name string) map[string]string {
+	return map[string]string{"app": defaults.CSINodeDaemonSet, "nfsprovisioner_cr": name}
+}
+
+// csiStorageClassName returns the StorageClass of the CSI driver
+func csiStorageClassName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if nfsProvisioner.Spec.CSI != nil && nfsProvisioner.Spec.CSI.StorageClassName != "" {
+		return nfsProvisioner.Spec.CSI.StorageClassName
+	}
+	return StorageClassName(nfsProvisioner) + defaults.CSIStorageClassSuffix
+}
+
+// CSIDriverName returns the CSIDriver of the NFSProvisioner, <name>.<namespace>.nfs.csi.jhouse.com.
+// Each NFSProvisioner has its own driver, so that its controller only provisions the claims of its own StorageClass.
+// A name longer than 63 characters is replaced by a hash.
+func CSIDriverName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	name := nfsProvisioner.Name + "." + nfsProvisioner.Namespace + "." + defaults.CSIDriverSuffix
+	if len(name) > 63 {
+		hash := sha256.Sum256([]byte(nfsProvisioner.Namespace + "/" + nfsProvisioner.Name))
+		name = fmt.Sprintf("%x.%s", hash[:8], defaults.CSIDriverSuffix)
+	}
+	return name
+}
@@ -0,0 +1,161 @@
+package resources
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("CSIManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		base           BaseResourceManager
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		csiManager     *CSIManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(rbacv1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				SCForNFSPvc: "gp3-csi",
+				CSI:         &cachev1alpha1.CSIConfiguration{Enabled: true},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: defaults.Service, Namespace: "test-namespace"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "172.30.0.10"},
+			},
+		).Build()
+		base = NewBaseResourceManager(c, logr.Discard(), scheme)
+		csiManager = NewCSIManager(base)
+
+		image := defaults.CSIDriverImage
+		defaults.CSIDriverImage = "quay.io/jooholee/nfs-provisioner-operator:test"
+		DeferCleanup(func() { defaults.CSIDriverImage = image })
+	})
+
+	It("should register the driver and create its StorageClass next to the one of the external provisioner", func() {
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		driver := "test-nfs.test-namespace.nfs.csi.jhouse.com"
+		csiDriver := &storagev1.CSIDriver{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: driver}, csiDriver)).To(Succeed())
+		Expect(*csiDriver.Spec.AttachRequired).To(BeFalse())
+
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-csi"}, sc)).To(Succeed())
+		Expect(sc.Provisioner).To(Equal(driver))
+		Expect(sc.Parameters).To(Equal(map[string]string{"server": "172.30.0.10", "share": "/export"}))
+		Expect(sc.MountOptions).To(Equal([]string{"vers=4.1"}))
+		Expect(*sc.AllowVolumeExpansion).To(BeTrue())
+
+		Expect(c.Get(ctx, types.NamespacedName{Name: driver}, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.CSIRoleBinding, Namespace: "test-namespace"}, &rbacv1.RoleBinding{})).To(Succeed())
+
+		ds := &appsv1.DaemonSet{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.CSINodeDaemonSet, Namespace: "test-namespace"}, ds)).To(Succeed())
+		Expect(ds.Spec.Template.Spec.HostNetwork).To(BeTrue())
+		Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--kubelet-registration-path=/var/lib/kubelet/plugins/" + driver + "/csi.sock"))
+		Expect(ds.Spec.Template.Spec.Containers[1].Args).To(ContainElement("--node-id=$(NODE_NAME)"))
+
+		Expect(nfsProvisioner.Status.CSI.Driver).To(Equal(driver))
+		Expect(nfsProvisioner.Status.CSI.StorageClass).To(Equal("nfs-csi"))
+	})
+
+	It("should run the controller plugin and its sidecars next to the NFS server", func() {
+		dep := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC")
+		containers := dep.Spec.Template.Spec.Containers
+		Expect(containers).To(HaveLen(5))
+		Expect(containers[1].Name).To(Equal("csi-driver"))
+		Expect(containers[1].Args).To(ContainElement("--export=/export"))
+		Expect(containers[1].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "export-volume", MountPath: "/export"}))
+		Expect(containers[2].Args).To(ContainElement("--extra-create-metadata"))
+		Expect(containers[3].Name).To(Equal("csi-resizer"))
+		Expect(containers[4].Name).To(Equal("csi-snapshotter"))
+		Expect(dep.Spec.Template.Spec.Volumes).To(ContainElement(csiSocketVolume()))
+	})
+
+	It("should mount the volumes of the go NFS server with NFSv3", func() {
+		nfsProvisioner.Spec.ServerImplementation = cachev1alpha1.ServerImplementationGo
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-csi"}, sc)).To(Succeed())
+		Expect(sc.MountOptions).To(ContainElement("nfsvers=3"))
+
+		dep := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC")
+		Expect(*dep.Spec.Template.Spec.Containers[1].SecurityContext.RunAsNonRoot).To(BeTrue())
+	})
+
+	It("should replace the StorageClass when the address of the server changed", func() {
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		svc := &corev1.Service{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Service, Namespace: "test-namespace"}, svc)).To(Succeed())
+		svc.Spec.ClusterIP = "172.30.0.20"
+		Expect(c.Update(ctx, svc)).To(Succeed())
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-csi"}, sc)).To(Succeed())
+		Expect(sc.Parameters["server"]).To(Equal("172.30.0.20"))
+	})
+
+	It("should not deploy the driver without the operator image", func() {
+		defaults.CSIDriverImage = ""
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(MatchError(errNoCSIDriverImage))
+		Expect(NewDeploymentManager(base).EnsureResource(ctx, nfsProvisioner)).To(MatchError(errNoCSIDriverImage))
+
+		nfsProvisioner.Spec.CSI.Image = "registry.example.com/nfs-provisioner-operator:dev"
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+	})
+
+	It("should remove the driver when it is disabled", func() {
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.CSI.Enabled = false
+		Expect(csiManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.CSINodeDaemonSet, Namespace: "test-namespace"}, &appsv1.DaemonSet{})).NotTo(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "test-nfs.test-namespace.nfs.csi.jhouse.com"}, &storagev1.CSIDriver{})).NotTo(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-csi"}, &storagev1.StorageClass{})).NotTo(Succeed())
+		Expect(nfsProvisioner.Status.CSI).To(BeNil())
+
+		dep := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC")
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(1))
+	})
+
+	It("should keep the name of the driver within 63 characters", func() {
+		nfsProvisioner.Namespace = "a-namespace-with-a-rather-long-name"
+		name := CSIDriverName(nfsProvisioner)
+		Expect(len(name)).To(BeNumerically("<=", 63))
+		Expect(strings.HasSuffix(name, "."+defaults.CSIDriverSuffix)).To(BeTrue())
+	})
+})
@@ -2,9 +2,11 @@ package resources
 
 import (
 	"context"
+	"fmt"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -31,7 +33,7 @@ func (m *DeploymentManager) GetResourceName() string {
 	return "Deployment"
 }
 
-// EnsureResource ensures the Deployment exists
+// EnsureResource ensures the Deployment exists and its pod template follows the spec
 func (m *DeploymentManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
 	log := m.Log.WithValues("resource", m.GetResourceName())
 
@@ -41,22 +43,89 @@ func (m *DeploymentManager) EnsureResource(ctx context.Context, nfsProvisioner *
 		storageType = "HOSTPATH"
 	}
 
+	// The operator image of the go NFS server is only known from RELATED_IMAGE_GO_NFS_SERVER
+	if image, _ := specServerImage(nfsProvisioner); image == "" {
+		return fmt.Errorf("the image of the go NFS server is not set, set RELATED_IMAGE_GO_NFS_SERVER of the operator or nfsImageConfiguration.image")
+	}
+	// The controller plugin of the CSI driver runs in the pod of the NFS server
+	if image, _ := csiDriverImage(nfsProvisioner); csiEnabled(nfsProvisioner) && image == "" {
+		return errNoCSIDriverImage
+	}
+
 	// Check if the deployment already exists
 	deployFound := &appsv1.Deployment{}
 	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: nfsProvisioner.Namespace}, deployFound)
 	if err != nil && errors.IsNotFound(err) {
+		// The hostPath volume can not be mounted until the directory is prepared
+		if !hostPathReady(nfsProvisioner) {
+			log.Info("Waiting for the hostPath directory to be prepared", "HostPathDir", nfsProvisioner.Spec.HostPathDir)
+			return nil
+		}
+		// The pod would stay Pending without a node to run on
+		if !nodeSelected(nfsProvisioner) {
+			log.Info("Waiting for a node for the NFS server", "HostPathDir", nfsProvisioner.Spec.HostPathDir)
+			return nil
+		}
+
 		// Define a new deployment
 		dep := m.buildDeployment(nfsProvisioner, storageType)
+		m.setGoServerFSGroup(ctx, nfsProvisioner, &dep.Spec.Template.Spec)
+		// The primary stays fenced after a failover to the standby
+		if standbyActive(nfsProvisioner) {
+			replicas := int32(0)
+			dep.Spec.Replicas = &replicas
+		}
 
 		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 		if err = m.Client.Create(ctx, dep); err != nil {
 			log.Error(err, "Failed to create a Deployment for NFSProvisioner", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 			return err
 		}
+		return nil
 	} else if err != nil {
 		return err
 	}
 
+	// The export volume and the node selector belong to the storage migration, so they are kept as they are
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+	dep := m.buildDeployment(nfsProvisioner, storageType)
+	m.setGoServerFSGroup(ctx, nfsProvisioner, &dep.Spec.Template.Spec)
+	// The options of a removed NFS-Ganesha configuration stay in the configuration file until the defaults are written back
+	if !ganeshaConfigured(nfsProvisioner) && !goServer(nfsProvisioner) && ganeshaConfigApplied(&deployFound.Spec.Template) {
+		restore := &corev1.PodTemplateSpec{}
+		applyGaneshaConfig(restore, nfsProvisioner)
+		applyOperatorConfig(&restore.Spec)
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.InitContainers = append(restore.Spec.InitContainers, podSpec.InitContainers...)
+		podSpec.Volumes = append(podSpec.Volumes, restore.Spec.Volumes...)
+		dep.Spec.Template.Annotations = restore.Annotations
+	}
+	dep.Spec.Template.Spec.NodeSelector = deployFound.Spec.Template.Spec.NodeSelector
+	for _, volume := range deployFound.Spec.Template.Spec.Volumes {
+		if volume.Name == "export-volume" {
+			dep.Spec.Template.Spec.Volumes = setExportVolume(dep.Spec.Template.Spec.Volumes, volume.VolumeSource)
+			// A hostPath export follows the node selection
+			if volume.HostPath != nil {
+				dep.Spec.Template.Spec.NodeSelector = hostPathNodeSelector(nfsProvisioner)
+			}
+		}
+	}
+	fenced := !standbyActive(nfsProvisioner) || (deployFound.Spec.Replicas != nil && *deployFound.Spec.Replicas == 0)
+	if podTemplateChanged(&dep.Spec.Template, &deployFound.Spec.Template) || !fenced {
+		log.Info("Updating the Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		deployFound.Spec.Template = dep.Spec.Template
+		if !fenced {
+			replicas := int32(0)
+			deployFound.Spec.Replicas = &replicas
+		}
+		if err = m.Client.Update(ctx, deployFound); err != nil {
+			log.Error(err, "Failed to update the Deployment for NFSProvisioner", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -64,23 +133,8 @@ func (m *DeploymentManager) EnsureResource(ctx context.Context, nfsProvisioner *
 func (m *DeploymentManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSProvisioner, storageType string) *appsv1.Deployment {
 	ls := labelsForNFSProvisioner(nfsProvisioner.Name)
 
-	nodeSelector := defaults.NodeSelector
-	nfsImage := defaults.NFSImage
-	nfsImagePullPolicy := defaults.NFSImagePullPolicy
-
-	if nfsProvisioner.Spec.NodeSelector != nil {
-		nodeSelector = nfsProvisioner.Spec.NodeSelector
-	}
-
-	if nfsProvisioner.Spec.NFSImageConfiguration != nil {
-		if nfsProvisioner.Spec.NFSImageConfiguration.Image != nil {
-			nfsImage = *nfsProvisioner.Spec.NFSImageConfiguration.Image
-		}
-
-		if nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy != nil {
-			nfsImagePullPolicy = *nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy
-		}
-	}
+	nodeSelector := hostPathNodeSelector(nfsProvisioner)
+	nfsImage, nfsImagePullPolicy := nfsServerImage(nfsProvisioner)
 
 	if storageType == "PVC" {
 		nodeSelector = map[string]string{}
@@ -88,7 +142,12 @@ func (m *DeploymentManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSPro
 
 	sa := defaults.ServiceAccount
 
-	volumeSourceSpec := m.getVolumeSpec(nfsProvisioner, storageType)
+	volumeSourceSpec := exportVolumeSource(nfsProvisioner)
+
+	capabilities := []corev1.Capability{"DAC_READ_SEARCH", "SYS_RESOURCE"}
+	if quotaReady(nfsProvisioner) {
+		capabilities = append(capabilities, "SYS_ADMIN")
+	}
 
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -108,64 +167,16 @@ func (m *DeploymentManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSPro
 						Image:           nfsImage,
 						ImagePullPolicy: nfsImagePullPolicy,
 						Name:            "nfs-provisioner",
-						Ports: []corev1.ContainerPort{
-							{Name: "nfs",
-								ContainerPort: 2049},
-							{Name: "nfs-udp",
-								ContainerPort: 2049,
-								Protocol:      "UDP"},
-							{Name: "nlockmgr",
-								ContainerPort: 32803},
-							{Name: "nlockmgr-udp",
-								ContainerPort: 32803,
-								Protocol:      "UDP"},
-							{Name: "mountd",
-								ContainerPort: 20048},
-							{Name: "mountd-udp",
-								ContainerPort: 20048,
-								Protocol:      "UDP"},
-							{Name: "rquotad",
-								ContainerPort: 875},
-							{Name: "rquotad-udp",
-								ContainerPort: 875,
-								Protocol:      "UDP"},
-							{Name: "rpcbind",
-								ContainerPort: 111},
-							{Name: "rpcbind-udp",
-								ContainerPort: 111,
-								Protocol:      "UDP"},
-							{Name: "statd",
-								ContainerPort: 662},
-							{Name: "statd-udp",
-								ContainerPort: 662,
-								Protocol:      "UDP"},
-						},
+						Ports:           nfsServerPorts(),
 						SecurityContext: &corev1.SecurityContext{
 							Capabilities: &corev1.Capabilities{
-								Add:  []corev1.Capability{"DAC_READ_SEARCH", "SYS_RESOURCE"},
+								Add:  capabilities,
 								Drop: []corev1.Capability{"KILL", "MKNOD", "SYS_CHROOT"},
 							},
 						},
 
-						Args: []string{"'-provisioner=example.com/nfs'"},
-						Env: []corev1.EnvVar{{
-							Name: "POD_IP",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									FieldPath: "status.podIP",
-								},
-							},
-						}, {
-							Name:  "SERVICE_NAME",
-							Value: "nfs-provisioner",
-						}, {
-							Name: "POD_NAMESPACE",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									FieldPath: "metadata.namespace",
-								},
-							},
-						}},
+						Args: ProvisionerArgs(nfsProvisioner),
+						Env:  provisionerEnv(nfsProvisioner),
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      "export-volume",
 							MountPath: "/export",
@@ -182,38 +193,126 @@ func (m *DeploymentManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSPro
 		},
 	}
 
+	// The go NFS server replaces the NFS-Ganesha provisioner and needs no privileges
+	if goServer(nfsProvisioner) {
+		dep.Spec.Template.Spec.Containers = []corev1.Container{goServerContainer(nfsProvisioner)}
+		dep.Spec.Template.Spec.SecurityContext = goServerPodSecurityContext()
+	}
+
+	// The rendered configuration is written into the configuration file of the provisioner before the server starts
+	if ganeshaConfigured(nfsProvisioner) {
+		applyGaneshaConfig(&dep.Spec.Template, nfsProvisioner)
+	}
+
+	// The export is replicated to the standby until the standby is promoted
+	if standbyEnabled(nfsProvisioner) && !standbyActive(nfsProvisioner) {
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.Containers = append(podSpec.Containers, replicationContainer(nfsProvisioner))
+	}
+
+	// The CSI controller works on the export, so it runs next to the NFS server
+	if csiEnabled(nfsProvisioner) {
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.Containers = append(podSpec.Containers, csiControllerContainers(nfsProvisioner)...)
+		podSpec.Volumes = append(podSpec.Volumes, csiSocketVolume())
+	}
+
+	applyPodExtras(&dep.Spec.Template.Spec, nfsProvisioner)
+	applyOperatorConfig(&dep.Spec.Template.Spec)
+
 	// Set NFSProvisioner instance as the owner and controller
 	ctrl.SetControllerReference(nfsProvisioner, dep, m.Scheme)
 	return dep
 }
 
-// getVolumeSpec returns the appropriate volume source based on storage type
-func (m *DeploymentManager) getVolumeSpec(nfsProvisioner *cachev1alpha1.NFSProvisioner, storageType string) *corev1.VolumeSource {
-	log := m.Log.WithName("getVolumeSpec")
-	hostPathDir := nfsProvisioner.Spec.HostPathDir
+// nfsServerImage returns the image of the NFS server and its pull policy.
+// A new image of the spec is only run once the UpgradeManager rolls it out.
+func nfsServerImage(nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, corev1.PullPolicy) {
+	nfsImage, nfsImagePullPolicy := specServerImage(nfsProvisioner)
+	if image := upgradeServerImage(nfsProvisioner); image != "" {
+		nfsImage = image
+	}
+	return nfsImage, nfsImagePullPolicy
+}
 
-	pvcName := defaults.Pvc
+// specServerImage returns the image of the NFS server set in the spec and its pull policy
+func specServerImage(nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, corev1.PullPolicy) {
+	nfsImage := defaults.NFSImage
+	nfsImagePullPolicy := defaults.NFSImagePullPolicy
+	if goServer(nfsProvisioner) {
+		nfsImage = defaults.GoNFSImage
+	}
 
-	hostPathType := corev1.HostPathDirectory
+	if nfsProvisioner.Spec.NFSImageConfiguration != nil {
+		// The built-in NFS-Ganesha image was the default of the field, so it follows the default image of the operator,
+		// e.g. RELATED_IMAGE_NFS_PROVISIONER or the image of the go NFS server
+		if image := nfsProvisioner.Spec.NFSImageConfiguration.Image; image != nil && *image != "" && *image != defaults.BuiltinNFSImage {
+			nfsImage = *nfsProvisioner.Spec.NFSImageConfiguration.Image
+		}
 
-	if nfsProvisioner.Spec.Pvc != "" {
-		pvcName = nfsProvisioner.Spec.Pvc
+		if nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy != nil {
+			nfsImagePullPolicy = *nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy
+		}
 	}
+	return nfsImage, nfsImagePullPolicy
+}
 
-	if storageType == "HOSTPATH" {
-		log.Info("StorageType is HOSTPATH")
-		return &corev1.VolumeSource{
-			HostPath: &corev1.HostPathVolumeSource{
-				Path: hostPathDir,
-				Type: &hostPathType,
-			}}
+// nfsServerPorts returns the ports of the NFS server container, which the Service exposes
+func nfsServerPorts() []corev1.ContainerPort {
+	return []corev1.ContainerPort{
+		{Name: "nfs",
+			ContainerPort: 2049},
+		{Name: "nfs-udp",
+			ContainerPort: 2049,
+			Protocol:      "UDP"},
+		{Name: "nlockmgr",
+			ContainerPort: 32803},
+		{Name: "nlockmgr-udp",
+			ContainerPort: 32803,
+			Protocol:      "UDP"},
+		{Name: "mountd",
+			ContainerPort: 20048},
+		{Name: "mountd-udp",
+			ContainerPort: 20048,
+			Protocol:      "UDP"},
+		{Name: "rquotad",
+			ContainerPort: 875},
+		{Name: "rquotad-udp",
+			ContainerPort: 875,
+			Protocol:      "UDP"},
+		{Name: "rpcbind",
+			ContainerPort: 111},
+		{Name: "rpcbind-udp",
+			ContainerPort: 111,
+			Protocol:      "UDP"},
+		{Name: "statd",
+			ContainerPort: 662},
+		{Name: "statd-udp",
+			ContainerPort: 662,
+			Protocol:      "UDP"},
 	}
+}
 
-	log.Info("StorageType is PVC")
-	return &corev1.VolumeSource{
-		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-			ClaimName: pvcName,
-		}}
+// podTemplateChanged returns true when the desired pod template differs from the one found.
+// DeepDerivative ignores what is not set in the desired template, e.g. a removed argument or the removed
+// NFS-Ganesha configuration, so these are compared by themselves.
+func podTemplateChanged(desired, found *corev1.PodTemplateSpec) bool {
+	if desired.Annotations[defaults.GaneshaConfigHashAnnotation] != found.Annotations[defaults.GaneshaConfigHashAnnotation] {
+		return true
+	}
+	if len(desired.Spec.InitContainers) != len(found.Spec.InitContainers) ||
+		len(desired.Spec.Containers) != len(found.Spec.Containers) ||
+		len(desired.Spec.Volumes) != len(found.Spec.Volumes) {
+		return true
+	}
+	for i := range desired.Spec.Containers {
+		if !equality.Semantic.DeepEqual(desired.Spec.Containers[i].Args, found.Spec.Containers[i].Args) ||
+			len(desired.Spec.Containers[i].Env) != len(found.Spec.Containers[i].Env) ||
+			len(desired.Spec.Containers[i].VolumeMounts) != len(found.Spec.Containers[i].VolumeMounts) {
+			return true
+		}
+	}
+	return !equality.Semantic.DeepDerivative(*desired, *found)
 }
 
 // labelsForNFSProvisioner returns the labels for selecting the resources
@@ -221,3 +320,9 @@ func (m *DeploymentManager) getVolumeSpec(nfsProvisioner *cachev1alpha1.NFSProvi
 func labelsForNFSProvisioner(name string) map[string]string {
 	return map[string]string{"app": "nfs-provisioner", "nfsprovisioner_cr": name}
 }
+
+// jobLabels returns the labels of the Jobs of the given NFSProvisioner CR name and of their pods.
+// They leave out the app label of the NFS server, so that job pods are not endpoints of its Service.
+func jobLabels(name string) map[string]string {
+	return map[string]string{"nfsprovisioner_cr": name, "component": "job"}
+}
@@ -0,0 +1,116 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// operatorContainers are the names of the containers the operator adds to the NFS server pod
+var operatorContainers = map[string]bool{
+	"nfs-provisioner": true,
+	"ganesha-config":  true,
+	"ganesha-exports": true,
+	"replication":     true,
+	"csi-driver":      true,
+	"csi-provisioner": true,
+	"csi-resizer":     true,
+	"csi-snapshotter": true,
+}
+
+// operatorVolumes are the names of the volumes the operator adds to the NFS server pod
+var operatorVolumes = map[string]bool{
+	"export-volume":  true,
+	"ganesha-config": true,
+	"csi-socket-dir": true,
+}
+
+// ValidatePodExtras checks that the extra containers, volumes and mounts do not conflict
+// with the ones the operator adds to the NFS server pod, nor with each other.
+// All conflicts are reported at once.
+func ValidatePodExtras(nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	spec := nfsProvisioner.Spec
+	var errs []error
+
+	containers := map[string]bool{}
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.ExtraContainers...) {
+		switch {
+		case c.Name == "":
+			errs = append(errs, fmt.Errorf("a container of extraContainers or initContainers has no name"))
+		case operatorContainers[c.Name]:
+			errs = append(errs, fmt.Errorf("container %s is managed by the operator", c.Name))
+		case containers[c.Name]:
+			errs = append(errs, fmt.Errorf("container %s is defined more than once", c.Name))
+		}
+		containers[c.Name] = true
+	}
+
+	// Sidecars share the network of the pod, so they can not listen on the ports of the NFS server
+	serverPorts := map[string]bool{}
+	for _, p := range nfsServerPorts() {
+		serverPorts[containerPortKey(p)] = true
+	}
+	for _, c := range spec.ExtraContainers {
+		for _, p := range c.Ports {
+			if serverPorts[containerPortKey(p)] {
+				errs = append(errs, fmt.Errorf("port %s of container %s is used by the NFS server", containerPortKey(p), c.Name))
+			}
+		}
+	}
+
+	volumes := map[string]bool{}
+	for _, v := range spec.ExtraVolumes {
+		switch {
+		case operatorVolumes[v.Name]:
+			errs = append(errs, fmt.Errorf("volume %s is managed by the operator", v.Name))
+		case volumes[v.Name]:
+			errs = append(errs, fmt.Errorf("volume %s is defined more than once", v.Name))
+		}
+		volumes[v.Name] = true
+	}
+
+	mountPaths := map[string]bool{}
+	for _, m := range spec.ExtraVolumeMounts {
+		mountPath := path.Clean(m.MountPath)
+		switch {
+		case !volumes[m.Name]:
+			errs = append(errs, fmt.Errorf("volume mount %s does not refer to a volume of extraVolumes", m.Name))
+		case mountPath == defaults.ExportPath || strings.HasPrefix(mountPath, defaults.ExportPath+"/"):
+			errs = append(errs, fmt.Errorf("volume mount %s can not be mounted on the export %s", m.Name, m.MountPath))
+		case mountPaths[mountPath]:
+			errs = append(errs, fmt.Errorf("mount path %s is used more than once", m.MountPath))
+		}
+		mountPaths[mountPath] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyPodExtras adds the extra containers, volumes and mounts of the NFSProvisioner to the NFS server pod.
+// The init containers of the NFSProvisioner run after the ones of the operator.
+func applyPodExtras(podSpec *corev1.PodSpec, nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	spec := nfsProvisioner.Spec
+	podSpec.InitContainers = append(podSpec.InitContainers, spec.InitContainers...)
+	podSpec.Containers = append(podSpec.Containers, spec.ExtraContainers...)
+	podSpec.Volumes = append(podSpec.Volumes, spec.ExtraVolumes...)
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == "nfs-provisioner" {
+			podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, spec.ExtraVolumeMounts...)
+		}
+	}
+}
+
+// containerPortKey returns the port and protocol of a container port, e.g. 2049/TCP
+func containerPortKey(p corev1.ContainerPort) string {
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	return fmt.Sprintf("%d/%s", p.ContainerPort, protocol)
+}
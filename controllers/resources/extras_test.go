@@ -0,0 +1,99 @@
+package resources
+
+import (
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+)
+
+var _ = Describe("Pod extras", func() {
+	var nfsProvisioner *cachev1alpha1.NFSProvisioner
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				Pvc: "test-pvc",
+				ExtraContainers: []corev1.Container{{
+					Name:  "log-shipper",
+					Image: "fluent/fluent-bit:3.1",
+					Ports: []corev1.ContainerPort{{ContainerPort: 2020}},
+				}},
+				InitContainers: []corev1.Container{{Name: "wait-for-network", Image: "busybox"}},
+				ExtraVolumes: []corev1.Volume{{
+					Name: "ca-bundle",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}},
+					},
+				}},
+				ExtraVolumeMounts: []corev1.VolumeMount{{Name: "ca-bundle", MountPath: "/etc/pki/ca-trust/extracted/pem", ReadOnly: true}},
+			},
+		}
+	})
+
+	It("should merge the extras into the NFS server pod", func() {
+		Expect(ValidatePodExtras(nfsProvisioner)).To(Succeed())
+
+		scheme := runtime.NewScheme()
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+		base := NewBaseResourceManager(fake.NewClientBuilder().WithScheme(scheme).Build(), logr.Discard(), scheme)
+		podSpec := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC").Spec.Template.Spec
+
+		Expect(podSpec.Containers).To(HaveLen(2))
+		Expect(podSpec.Containers[0].Name).To(Equal("nfs-provisioner"))
+		Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(nfsProvisioner.Spec.ExtraVolumeMounts[0]))
+		Expect(podSpec.Containers[1].Name).To(Equal("log-shipper"))
+		Expect(podSpec.InitContainers).To(HaveLen(1))
+		Expect(podSpec.Volumes).To(HaveLen(2))
+	})
+
+	It("should report every conflict with the operator", func() {
+		nfsProvisioner.Spec.ExtraContainers = append(nfsProvisioner.Spec.ExtraContainers, corev1.Container{
+			Name:  "nfs-provisioner",
+			Ports: []corev1.ContainerPort{{ContainerPort: 2049}},
+		})
+		nfsProvisioner.Spec.ExtraVolumes = append(nfsProvisioner.Spec.ExtraVolumes, corev1.Volume{Name: "export-volume"})
+		nfsProvisioner.Spec.ExtraVolumeMounts = append(nfsProvisioner.Spec.ExtraVolumeMounts,
+			corev1.VolumeMount{Name: "ca-bundle", MountPath: "/export/certs"},
+			corev1.VolumeMount{Name: "missing", MountPath: "/data"},
+		)
+
+		err := ValidatePodExtras(nfsProvisioner)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("container nfs-provisioner is managed by the operator"))
+		Expect(err.Error()).To(ContainSubstring("port 2049/TCP of container nfs-provisioner is used by the NFS server"))
+		Expect(err.Error()).To(ContainSubstring("volume export-volume is managed by the operator"))
+		Expect(err.Error()).To(ContainSubstring("can not be mounted on the export"))
+		Expect(err.Error()).To(ContainSubstring("volume mount missing does not refer to a volume"))
+	})
+
+	It("should refuse the replication sidecar of a standby", func() {
+		nfsProvisioner.Spec.Standby = &cachev1alpha1.StandbyConfiguration{Enabled: true}
+		nfsProvisioner.Spec.ExtraContainers = append(nfsProvisioner.Spec.ExtraContainers, corev1.Container{Name: "replication"})
+
+		err := ValidatePodExtras(nfsProvisioner)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("container replication is managed by the operator"))
+	})
+
+	It("should refuse the containers and volumes of the CSI controller", func() {
+		nfsProvisioner.Spec.CSI = &cachev1alpha1.CSIConfiguration{Enabled: true}
+		nfsProvisioner.Spec.ExtraContainers = append(nfsProvisioner.Spec.ExtraContainers,
+			corev1.Container{Name: "csi-driver"},
+			corev1.Container{Name: "csi-snapshotter"},
+		)
+		nfsProvisioner.Spec.ExtraVolumes = append(nfsProvisioner.Spec.ExtraVolumes, corev1.Volume{Name: "csi-socket-dir"})
+
+		err := ValidatePodExtras(nfsProvisioner)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("container csi-driver is managed by the operator"))
+		Expect(err.Error()).To(ContainSubstring("container csi-snapshotter is managed by the operator"))
+		Expect(err.Error()).To(ContainSubstring("volume csi-socket-dir is managed by the operator"))
+	})
+})
@@ -0,0 +1,301 @@
+package resources
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+const (
+	// ganeshaConfigKey is the global configuration of the server
+	ganeshaConfigKey = "ganesha.conf"
+	// ganeshaExportOptionsKey are the options that are set in every EXPORT block
+	ganeshaExportOptionsKey = "export-options.conf"
+	// ganeshaConfigDir is where the init container mounts the ConfigMap
+	ganeshaConfigDir = "/etc/ganesha-operator"
+)
+
+// GaneshaConfigManager manages the ConfigMap that holds the NFS-Ganesha configuration
+type GaneshaConfigManager struct {
+	BaseResourceManager
+}
+
+// NewGaneshaConfigManager creates a new GaneshaConfigManager
+func NewGaneshaConfigManager(base BaseResourceManager) *GaneshaConfigManager {
+	return &GaneshaConfigManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *GaneshaConfigManager) GetResourceName() string {
+	return "GaneshaConfig"
+}
+
+// EnsureResource ensures the ConfigMap holds the configuration rendered from the spec, or the default configuration once the spec has none
+func (m *GaneshaConfigManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	cmFound := &corev1.ConfigMap{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.GaneshaConfigMap, Namespace: nfsProvisioner.Namespace}, cmFound)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	// Once written, the options stay in the configuration file of the provisioner, so the ConfigMap is kept with the
+	// default options to restore them
+	if !ganeshaConfigured(nfsProvisioner) && !found {
+		return nil
+	}
+
+	cm := m.buildConfigMap(nfsProvisioner)
+	if !found {
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err := m.Client.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create a ConfigMap for NFSProvisioner", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return err
+		}
+		return nil
+	}
+
+	if !equality.Semantic.DeepEqual(cm.Data, cmFound.Data) {
+		log.Info("Updating the ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		cmFound.Data = cm.Data
+		if err := m.Client.Update(ctx, cmFound); err != nil {
+			log.Error(err, "Failed to update the ConfigMap for NFSProvisioner", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// buildConfigMap creates the ConfigMap with the rendered configuration
+func (m *GaneshaConfigManager) buildConfigMap(nfsProvisioner *cachev1alpha1.NFSProvisioner) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.GaneshaConfigMap,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    labelsForNFSProvisioner(nfsProvisioner.Name),
+		},
+		Data: RenderGaneshaConfig(nfsProvisioner),
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, cm, m.Scheme)
+	return cm
+}
+
+// RenderGaneshaConfig renders the global configuration of the server and the options of its exports.
+// MNT_Port and fsid_device are the values the provisioner uses, because the Service exposes fixed ports.
+func RenderGaneshaConfig(nfsProvisioner *cachev1alpha1.NFSProvisioner) map[string]string {
+	config := nfsProvisioner.Spec.Ganesha
+	if config == nil {
+		config = &cachev1alpha1.GaneshaConfiguration{}
+	}
+
+	protocols := []string{"3", "4"}
+	if len(config.Protocols) > 0 {
+		protocols = []string{}
+		for _, p := range config.Protocols {
+			protocols = append(protocols, string(p))
+		}
+	}
+
+	global := &strings.Builder{}
+	fmt.Fprintf(global, "NFS_Core_Param\n{\n\tMNT_Port = 20048;\n\tfsid_device = true;\n\tProtocols = %s;\n}\n\n", strings.Join(protocols, ", "))
+	fmt.Fprintf(global, "NFSV4\n{\n\tGrace_Period = 90;\n}\n")
+	if nfsProvisioner.Spec.LogLevel != "" {
+		fmt.Fprintf(global, "\nLOG\n{\n\tDefault_Log_Level = %s;\n}\n", ganeshaLogLevel[nfsProvisioner.Spec.LogLevel])
+	}
+	if config.RawConfig != "" {
+		fmt.Fprintf(global, "\n%s\n", strings.TrimSpace(config.RawConfig))
+	}
+
+	// The provisioner writes the access options into every EXPORT block it adds, so they are replaced there
+	options := &strings.Builder{}
+	squash := map[cachev1alpha1.SquashMode]string{
+		"":                       "No_Root_Squash",
+		cachev1alpha1.SquashNone: "No_Root_Squash",
+		cachev1alpha1.SquashRoot: "Root_Squash",
+		cachev1alpha1.SquashAll:  "All_Squash",
+	}[config.Squash]
+	accessType := "RW"
+	if len(config.Clients) > 0 {
+		accessType = "None"
+	}
+	fmt.Fprintf(options, "\tAccess_Type = %s;\n", accessType)
+	fmt.Fprintf(options, "\tSquash = %s;\n", squash)
+	fmt.Fprintf(options, "\tProtocols = %s;\n", strings.Join(protocols, ", "))
+	if config.AnonymousUID != nil {
+		fmt.Fprintf(options, "\tAnonymous_Uid = %d;\n", *config.AnonymousUID)
+	}
+	if config.AnonymousGID != nil {
+		fmt.Fprintf(options, "\tAnonymous_Gid = %d;\n", *config.AnonymousGID)
+	}
+	for _, client := range config.Clients {
+		access := client.Access
+		if access == "" {
+			access = cachev1alpha1.ClientAccessRW
+		}
+		fmt.Fprintf(options, "\tCLIENT { Clients = %s; Access_Type = %s; }\n", client.CIDR, access)
+	}
+
+	return map[string]string{
+		ganeshaConfigKey:        global.String(),
+		ganeshaExportOptionsKey: options.String(),
+	}
+}
+
+// ganeshaConfigured returns true when the NFSProvisioner needs a rendered NFS-Ganesha configuration
+func ganeshaConfigured(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Ganesha != nil || nfsProvisioner.Spec.LogLevel != ""
+}
+
+// GaneshaConfigHash returns a hash of the rendered configuration
+func GaneshaConfigHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, data[k])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:16]
+}
+
+// ganeshaExportsAwk replaces the access options of every EXPORT block of the configuration file with the rendered ones.
+// Outside of the EXPORT blocks, lines are dropped, or kept as they are with keep=1.
+const ganeshaExportsAwk = `
+BEGIN { while ((getline line < opts) > 0) options = options line "\n" }
+/^EXPORT[ \t]*$/ || /^EXPORT[ \t]*\{/ { export = 1 }
+!export { if (keep) print; next }
+/^[ \t]*(Access_Type|Squash|Protocols|Anonymous_Uid|Anonymous_Gid)[ \t]*=/ || /^[ \t]*CLIENT[ \t]*\{/ { next }
+/^[ \t]*FSAL/ { printf "%s", options }
+{ print }
+/^}/ { export = 0; if (!keep) print "" }
+`
+
+// ganeshaInitContainer rewrites the configuration file of the provisioner before the server starts.
+// The EXPORT blocks the provisioner added are kept with the rendered options, and everything else is replaced.
+func ganeshaInitContainer() corev1.Container {
+	script := `set -e
+conf=` + defaults.ExportPath + `/vfs.conf
+if [ -f "$conf" ]; then
+  awk -v keep=0 -v opts="` + ganeshaConfigDir + `/` + ganeshaExportOptionsKey + `" '` + ganeshaExportsAwk + `' "$conf" > /tmp/exports
+else
+  printf 'EXPORT\n{\n\tExport_Id = 0;\n\tPath = /nonexistent;\n\tPseudo = /nonexistent;\n%s\n\tFSAL {\n\t\tName = VFS;\n\t}\n}\n' "$(cat ` + ganeshaConfigDir + `/` + ganeshaExportOptionsKey + `)" > /tmp/exports
+fi
+cat ` + ganeshaConfigDir + `/` + ganeshaConfigKey + ` > "$conf.new"
+echo >> "$conf.new"
+cat /tmp/exports >> "$conf.new"
+mv "$conf.new" "$conf"
+`
+	return corev1.Container{
+		Name:         "ganesha-config",
+		Image:        defaults.UtilityImage,
+		Command:      []string{"/bin/sh", "-c", script},
+		VolumeMounts: ganeshaConfigMounts(),
+	}
+}
+
+// ganeshaExportsContainer applies the rendered options to the EXPORT blocks the provisioner adds while the server runs,
+// which it writes with its own options (RW, no squashing, any client), and has NFS-Ganesha reload its exports.
+// A block is only rewritten once the file did not change for a poll, because the provisioner exports it right after adding it.
+// A block added while the file is replaced still goes to the replaced file, so it is read from there and appended again.
+func ganeshaExportsContainer() corev1.Container {
+	script := `conf=` + defaults.ExportPath + `/vfs.conf
+last=""
+while sleep 10; do
+  [ -f "$conf" ] || continue
+  exec 3< "$conf"
+  cat <&3 > /tmp/current
+  sum=$(md5sum < /tmp/current)
+  if [ "$sum" != "$last" ]; then
+    last="$sum"
+    exec 3<&-
+    continue
+  fi
+  awk -v keep=1 -v opts="` + ganeshaConfigDir + `/` + ganeshaExportOptionsKey + `" '` + ganeshaExportsAwk + `' /tmp/current > /tmp/rendered
+  if ! cmp -s /tmp/current /tmp/rendered; then
+    cat /tmp/rendered > "$conf.new"
+    mv "$conf.new" "$conf"
+    cat <&3 >> "$conf"
+    echo "Applied the export options to the new exports"
+    pkill -HUP ganesha.nfsd || echo "NFS-Ganesha is not running"
+  fi
+  exec 3<&-
+done
+`
+	return corev1.Container{
+		Name:         "ganesha-exports",
+		Image:        defaults.UtilityImage,
+		Command:      []string{"/bin/sh", "-c", script},
+		VolumeMounts: ganeshaConfigMounts(),
+	}
+}
+
+// ganeshaConfigMounts mounts the export with the configuration file of the provisioner and the ConfigMap
+func ganeshaConfigMounts() []corev1.VolumeMount {
+	return []corev1.VolumeMount{{
+		Name:      "export-volume",
+		MountPath: defaults.ExportPath,
+	}, {
+		Name:      "ganesha-config",
+		MountPath: ganeshaConfigDir,
+		ReadOnly:  true,
+	}}
+}
+
+// applyGaneshaConfig adds the containers that write the rendered configuration into the configuration file of the provisioner.
+// The hash of the configuration rolls the pod only when it changes. The export options of the spec are also applied to the
+// volumes provisioned while the server runs, which needs the process of NFS-Ganesha to be signalled from the sidecar.
+func applyGaneshaConfig(podTemplate *corev1.PodTemplateSpec, nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	podSpec := &podTemplate.Spec
+	podSpec.InitContainers = append(podSpec.InitContainers, ganeshaInitContainer())
+	podSpec.Volumes = append(podSpec.Volumes, ganeshaConfigVolume())
+	if nfsProvisioner.Spec.Ganesha != nil {
+		shareProcessNamespace := true
+		podSpec.ShareProcessNamespace = &shareProcessNamespace
+		podSpec.Containers = append(podSpec.Containers, ganeshaExportsContainer())
+	}
+	podTemplate.Annotations = map[string]string{
+		defaults.GaneshaConfigHashAnnotation: GaneshaConfigHash(RenderGaneshaConfig(nfsProvisioner)),
+	}
+}
+
+// ganeshaConfigApplied returns true when the pod template writes a rendered configuration into the configuration file
+func ganeshaConfigApplied(podTemplate *corev1.PodTemplateSpec) bool {
+	for _, c := range podTemplate.Spec.InitContainers {
+		if c.Name == "ganesha-config" {
+			return true
+		}
+	}
+	return false
+}
+
+// ganeshaConfigVolume returns the volume of the ConfigMap
+func ganeshaConfigVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: "ganesha-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: defaults.GaneshaConfigMap},
+			},
+		},
+	}
+}
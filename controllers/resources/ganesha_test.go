@@ -0,0 +1,120 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("GaneshaConfigManager", func() {
+	var (
+		ctx               context.Context
+		c                 client.Client
+		nfsProvisioner    *cachev1alpha1.NFSProvisioner
+		ganeshaManager    *GaneshaConfigManager
+		deploymentManager *DeploymentManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		anonymousUID := int64(65534)
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				Pvc: "test-pvc",
+				Ganesha: &cachev1alpha1.GaneshaConfiguration{
+					Protocols:    []cachev1alpha1.NFSProtocol{"4"},
+					Squash:       cachev1alpha1.SquashRoot,
+					AnonymousUID: &anonymousUID,
+					Clients: []cachev1alpha1.GaneshaClient{
+						{CIDR: "10.128.0.0/14"},
+						{CIDR: "192.168.1.10", Access: cachev1alpha1.ClientAccessRO},
+					},
+				},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		base := NewBaseResourceManager(c, logr.Discard(), scheme)
+		ganeshaManager = NewGaneshaConfigManager(base)
+		deploymentManager = NewDeploymentManager(base)
+	})
+
+	It("should render the export options", func() {
+		data := RenderGaneshaConfig(nfsProvisioner)
+		Expect(data[ganeshaConfigKey]).To(ContainSubstring("MNT_Port = 20048;"))
+		Expect(data[ganeshaConfigKey]).To(ContainSubstring("Protocols = 4;"))
+		Expect(data[ganeshaExportOptionsKey]).To(ContainSubstring("Access_Type = None;"))
+		Expect(data[ganeshaExportOptionsKey]).To(ContainSubstring("Squash = Root_Squash;"))
+		Expect(data[ganeshaExportOptionsKey]).To(ContainSubstring("Anonymous_Uid = 65534;"))
+		Expect(data[ganeshaExportOptionsKey]).To(ContainSubstring("CLIENT { Clients = 10.128.0.0/14; Access_Type = RW; }"))
+		Expect(data[ganeshaExportOptionsKey]).To(ContainSubstring("CLIENT { Clients = 192.168.1.10; Access_Type = RO; }"))
+	})
+
+	It("should keep the ConfigMap in sync with the spec", func() {
+		Expect(ganeshaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.GaneshaConfigMap, Namespace: "test-namespace"}, cm)).To(Succeed())
+		Expect(cm.Data[ganeshaExportOptionsKey]).To(ContainSubstring("Root_Squash"))
+
+		nfsProvisioner.Spec.Ganesha.Squash = cachev1alpha1.SquashAll
+		Expect(ganeshaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.GaneshaConfigMap, Namespace: "test-namespace"}, cm)).To(Succeed())
+		Expect(cm.Data[ganeshaExportOptionsKey]).To(ContainSubstring("All_Squash"))
+
+		// The default options are kept to restore them in the configuration file
+		nfsProvisioner.Spec.Ganesha = nil
+		Expect(ganeshaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.GaneshaConfigMap, Namespace: "test-namespace"}, cm)).To(Succeed())
+		Expect(cm.Data[ganeshaExportOptionsKey]).To(Equal("\tAccess_Type = RW;\n\tSquash = No_Root_Squash;\n\tProtocols = 3, 4;\n"))
+	})
+
+	It("should roll the server only when the rendered configuration changes", func() {
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		dep := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, dep)).To(Succeed())
+		Expect(dep.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+		// The options are applied to the volumes provisioned while the server runs
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(2))
+		Expect(dep.Spec.Template.Spec.Containers[1].Name).To(Equal("ganesha-exports"))
+		Expect(*dep.Spec.Template.Spec.ShareProcessNamespace).To(BeTrue())
+		hash := dep.Spec.Template.Annotations[defaults.GaneshaConfigHashAnnotation]
+		Expect(hash).NotTo(BeEmpty())
+
+		// Reconciling the same spec keeps the pod template
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, dep)).To(Succeed())
+		Expect(dep.Spec.Template.Annotations[defaults.GaneshaConfigHashAnnotation]).To(Equal(hash))
+
+		nfsProvisioner.Spec.Ganesha.Squash = cachev1alpha1.SquashAll
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, dep)).To(Succeed())
+		Expect(dep.Spec.Template.Annotations[defaults.GaneshaConfigHashAnnotation]).NotTo(Equal(hash))
+
+		// Removing the configuration writes the default options back
+		nfsProvisioner.Spec.Ganesha = nil
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, dep)).To(Succeed())
+		Expect(dep.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(1))
+		Expect(dep.Spec.Template.Annotations[defaults.GaneshaConfigHashAnnotation]).To(Equal(GaneshaConfigHash(RenderGaneshaConfig(nfsProvisioner))))
+	})
+})
@@ -0,0 +1,88 @@
+package resources
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// goServerLogLevel is the --zap-log-level of the go NFS server for each log level
+var goServerLogLevel = map[cachev1alpha1.LogLevel]string{
+	cachev1alpha1.LogLevelError:   "error",
+	cachev1alpha1.LogLevelWarning: "info",
+	cachev1alpha1.LogLevelInfo:    "info",
+	cachev1alpha1.LogLevelDebug:   "debug",
+	cachev1alpha1.LogLevelTrace:   "5",
+}
+
+// goServer returns true when the NFSProvisioner deploys the userspace NFS server of the operator image
+func goServer(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return !isExternalMode(nfsProvisioner) && nfsProvisioner.Spec.ServerImplementation == cachev1alpha1.ServerImplementationGo
+}
+
+// GoServerArgs returns the arguments of the go NFS server
+func GoServerArgs(nfsProvisioner *cachev1alpha1.NFSProvisioner) []string {
+	args := []string{
+		"--provisioner=" + ProvisionerName(nfsProvisioner),
+		"--export=" + defaults.ExportPath,
+	}
+	if nfsProvisioner.Spec.LogLevel != "" {
+		args = append(args, "--zap-log-level="+goServerLogLevel[nfsProvisioner.Spec.LogLevel])
+	}
+	return args
+}
+
+// goServerContainer returns the NFS server container of serverImplementation go.
+// It needs no capabilities and runs as the non-root user of the image.
+func goServerContainer(nfsProvisioner *cachev1alpha1.NFSProvisioner) corev1.Container {
+	image, pullPolicy := nfsServerImage(nfsProvisioner)
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+
+	return corev1.Container{
+		Name:            "nfs-provisioner",
+		Image:           image,
+		ImagePullPolicy: pullPolicy,
+		Command:         []string{"/nfs-server"},
+		Args:            GoServerArgs(nfsProvisioner),
+		Env:             provisionerEnv(nfsProvisioner),
+		Ports: []corev1.ContainerPort{{
+			Name:          "nfs",
+			ContainerPort: 2049,
+		}},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			RunAsNonRoot:             &runAsNonRoot,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "export-volume",
+			MountPath: defaults.ExportPath,
+		}},
+	}
+}
+
+// goServerPodSecurityContext returns the pod security context of the go NFS server
+func goServerPodSecurityContext() *corev1.PodSecurityContext {
+	runAsNonRoot := true
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot:   &runAsNonRoot,
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
+
+// setGoServerFSGroup lets the user of the image write to the export.
+// OpenShift assigns the fsGroup of the namespace, and rejects any other, so it is only set elsewhere.
+func (m *BaseResourceManager) setGoServerFSGroup(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, podSpec *corev1.PodSpec) {
+	if !goServer(nfsProvisioner) || podSpec.SecurityContext == nil || m.isSCCCRDAvailable(ctx) {
+		return
+	}
+	fsGroup := int64(defaults.GoNFSUser)
+	podSpec.SecurityContext.FSGroup = &fsGroup
+}
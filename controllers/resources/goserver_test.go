@@ -0,0 +1,100 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Go NFS server", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		base           BaseResourceManager
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				ServerImplementation: cachev1alpha1.ServerImplementationGo,
+				LogLevel:             cachev1alpha1.LogLevelDebug,
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		base = NewBaseResourceManager(c, logr.Discard(), scheme)
+
+		image := defaults.GoNFSImage
+		defaults.GoNFSImage = "quay.io/jooholee/nfs-provisioner-operator:test"
+		DeferCleanup(func() { defaults.GoNFSImage = image })
+	})
+
+	It("should run the NFS server of the operator image unprivileged", func() {
+		Expect(NewDeploymentManager(base).EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		dep := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, dep)).To(Succeed())
+		podSpec := dep.Spec.Template.Spec
+		Expect(podSpec.Containers).To(HaveLen(1))
+		container := podSpec.Containers[0]
+		Expect(container.Image).To(Equal("quay.io/jooholee/nfs-provisioner-operator:test"))
+		Expect(container.Command).To(Equal([]string{"/nfs-server"}))
+		Expect(container.Args).To(ConsistOf("--provisioner="+defaults.Provisioner, "--export=/export", "--zap-log-level=debug"))
+		Expect(container.SecurityContext.Capabilities.Add).To(BeEmpty())
+		Expect(container.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+		Expect(*container.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+		Expect(*podSpec.SecurityContext.RunAsNonRoot).To(BeTrue())
+
+		// Outside OpenShift the export is made writable for the user of the image
+		Expect(*podSpec.SecurityContext.FSGroup).To(BeEquivalentTo(defaults.GoNFSUser))
+	})
+
+	It("should not deploy the NFS server without the operator image", func() {
+		defaults.GoNFSImage = ""
+		Expect(NewDeploymentManager(base).EnsureResource(ctx, nfsProvisioner)).To(MatchError(ContainSubstring("RELATED_IMAGE_GO_NFS_SERVER")))
+
+		image := "registry.example.com/nfs-provisioner-operator:dev"
+		nfsProvisioner.Spec.NFSImageConfiguration = &cachev1alpha1.ImageConfiguration{Image: &image}
+		Expect(NewDeploymentManager(base).EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+	})
+
+	It("should keep a custom image, but not the NFS-Ganesha default of the field", func() {
+		image := defaults.BuiltinNFSImage
+		nfsProvisioner.Spec.NFSImageConfiguration = &cachev1alpha1.ImageConfiguration{Image: &image}
+		Expect(goServerContainer(nfsProvisioner).Image).To(Equal(defaults.GoNFSImage))
+
+		image = "registry.example.com/nfs-provisioner-operator:dev"
+		Expect(goServerContainer(nfsProvisioner).Image).To(Equal(image))
+	})
+
+	It("should mount the PVs with NFSv3 on the NFS port", func() {
+		Expect(NewStorageClassManager(base).EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.SCForNFSProvisioner}, sc)).To(Succeed())
+		Expect(sc.Parameters["mountOptions"]).To(Equal("nfsvers=3,proto=tcp,port=2049,mountport=2049,nolock"))
+	})
+})
@@ -0,0 +1,163 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// ConditionHostPathReady is the condition type that shows HostPathDir is prepared on the node
+const ConditionHostPathReady = "HostPathReady"
+
+// HostPathManager prepares HostPathDir on the node before the NFS server starts
+type HostPathManager struct {
+	BaseResourceManager
+}
+
+// NewHostPathManager creates a new HostPathManager
+func NewHostPathManager(base BaseResourceManager) *HostPathManager {
+	return &HostPathManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *HostPathManager) GetResourceName() string {
+	return "HostPath"
+}
+
+// EnsureResource runs the preparation Job once per generation and records its result in the HostPathReady condition
+func (m *HostPathManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	if !hostPathPreparationEnabled(nfsProvisioner) {
+		meta.RemoveStatusCondition(&nfsProvisioner.Status.Conditions, ConditionHostPathReady)
+		return nil
+	}
+
+	return m.runGenerationCheck(ctx, nfsProvisioner, generationCheck{
+		Condition:       ConditionHostPathReady,
+		PendingReason:   "Preparing",
+		PendingMessage:  "Preparing " + nfsProvisioner.Spec.HostPathDir + " on the node",
+		SucceededReason: "Prepared",
+		FailedReason:    "PreparationFailed",
+	}, m.buildPreparationJob)
+}
+
+// buildPreparationJob returns a privileged Job that creates the directory on the node of the NFS server,
+// sets its owner and mode, and labels it for SELinux.
+// The Job has a deadline, so that a node that does not match or a path that can not be mounted fails it.
+func (m *HostPathManager) buildPreparationJob(nfsProvisioner *cachev1alpha1.NFSProvisioner) *batchv1.Job {
+	preparation := nfsProvisioner.Spec.HostPathPreparation
+
+	uid, gid := int64(0), int64(0)
+	if preparation.UID != nil {
+		uid = *preparation.UID
+	}
+	if preparation.GID != nil {
+		gid = *preparation.GID
+	}
+	selinuxType := defaults.SELinuxType
+	if preparation.SELinuxType != "" {
+		selinuxType = preparation.SELinuxType
+	}
+	if preparation.SkipSELinuxRelabel {
+		selinuxType = ""
+	}
+
+	script := `dir=/hostpath
+fail() { echo "$1" > /dev/termination-log; exit 1; }
+chown "$OWNER" "$dir" || fail "chown $OWNER $HOST_PATH failed"
+if [ -n "$MODE" ]; then
+  chmod "$MODE" "$dir" || fail "chmod $MODE $HOST_PATH failed"
+fi
+relabel=""
+if [ -n "$SELINUX_TYPE" ]; then
+  if [ -f /sys/fs/selinux/enforce ]; then
+    chcon -R -t "$SELINUX_TYPE" "$dir" || fail "chcon -t $SELINUX_TYPE $HOST_PATH failed"
+    relabel=", labelled $SELINUX_TYPE"
+  else
+    relabel=", SELinux is disabled"
+  fi
+fi
+echo "$HOST_PATH is owned by $OWNER${MODE:+ with mode $MODE}$relabel" > /dev/termination-log
+`
+	image, pullPolicy := nfsServerImage(nfsProvisioner)
+	privileged := true
+	hostPathType := corev1.HostPathDirectoryOrCreate
+
+	backoffLimit := int32(1)
+	deadline := int64(5 * 60)
+	ttl := int32(60 * 60)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      truncateName(fmt.Sprintf("nfs-hostpath-prep-%s-%d", nfsProvisioner.Name, nfsProvisioner.Generation)),
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    jobLabels(nfsProvisioner.Name),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels(nfsProvisioner.Name),
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:            "hostpath-prep",
+						Image:           image,
+						ImagePullPolicy: pullPolicy,
+						Command:         []string{"/bin/sh", "-c", script},
+						Env: []corev1.EnvVar{
+							{Name: "HOST_PATH", Value: nfsProvisioner.Spec.HostPathDir},
+							{Name: "OWNER", Value: strconv.FormatInt(uid, 10) + ":" + strconv.FormatInt(gid, 10)},
+							{Name: "MODE", Value: preparation.Mode},
+							{Name: "SELINUX_TYPE", Value: selinuxType},
+						},
+						SecurityContext: &corev1.SecurityContext{
+							Privileged: &privileged,
+						},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "hostpath",
+							MountPath: "/hostpath",
+						}},
+					}},
+					NodeSelector:       hostPathNodeSelector(nfsProvisioner),
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: defaults.ServiceAccount,
+					Volumes: []corev1.Volume{{
+						Name: "hostpath",
+						VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{
+								Path: nfsProvisioner.Spec.HostPathDir,
+								Type: &hostPathType,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	applyOperatorConfig(&job.Spec.Template.Spec)
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// hostPathPreparationEnabled returns true when the NFSProvisioner asks for HostPathDir to be prepared
+func hostPathPreparationEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.HostPathDir != "" && nfsProvisioner.Spec.HostPathPreparation != nil && nfsProvisioner.Spec.HostPathPreparation.Enabled
+}
+
+// hostPathReady returns false while HostPathDir is being prepared or its preparation failed
+func hostPathReady(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return !hostPathPreparationEnabled(nfsProvisioner) || meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionHostPathReady)
+}
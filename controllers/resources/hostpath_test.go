@@ -0,0 +1,117 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("HostPathManager", func() {
+	var (
+		ctx               context.Context
+		c                 client.Client
+		nfsProvisioner    *cachev1alpha1.NFSProvisioner
+		hostPathManager   *HostPathManager
+		deploymentManager *DeploymentManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		uid := int64(1000)
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid", Generation: 1},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				HostPathDir: "/home/core/nfs",
+				HostPathPreparation: &cachev1alpha1.HostPathPreparation{
+					Enabled: true,
+					UID:     &uid,
+					Mode:    "0775",
+				},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		base := NewBaseResourceManager(c, logr.Discard(), scheme)
+		hostPathManager = NewHostPathManager(base)
+		deploymentManager = NewDeploymentManager(base)
+	})
+
+	getJob := func() *batchv1.Job {
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-hostpath-prep-test-nfs-1", Namespace: "test-namespace"}, job)).To(Succeed())
+		return job
+	}
+
+	It("should prepare the directory before the NFS server is created", func() {
+		Expect(hostPathManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		job := getJob()
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(*container.SecurityContext.Privileged).To(BeTrue())
+		Expect(container.Env).To(ContainElements(
+			corev1.EnvVar{Name: "OWNER", Value: "1000:0"},
+			corev1.EnvVar{Name: "MODE", Value: "0775"},
+			corev1.EnvVar{Name: "SELINUX_TYPE", Value: defaults.SELinuxType},
+		))
+		Expect(*job.Spec.Template.Spec.Volumes[0].HostPath.Type).To(Equal(corev1.HostPathDirectoryOrCreate))
+		Expect(job.Spec.Template.Spec.NodeSelector).To(Equal(defaults.NodeSelector))
+		// The privileged pod must not become an endpoint of the NFS Service
+		Expect(job.Spec.Template.Labels).NotTo(HaveKey("app"))
+
+		// The NFS server waits for the directory
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, &appsv1.Deployment{})).NotTo(Succeed())
+
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+		Expect(hostPathManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionHostPathReady)).To(BeTrue())
+
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, &appsv1.Deployment{})).To(Succeed())
+	})
+
+	It("should report a failed preparation in the condition", func() {
+		Expect(hostPathManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		job := getJob()
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type:    batchv1.JobFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "DeadlineExceeded",
+			Message: "Job was active longer than specified deadline",
+		}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+		Expect(hostPathManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionHostPathReady)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("PreparationFailed"))
+		Expect(condition.Message).To(ContainSubstring("deadline"))
+	})
+
+	It("should skip the SELinux relabel on request", func() {
+		nfsProvisioner.Spec.HostPathPreparation.SkipSELinuxRelabel = true
+		Expect(hostPathManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(getJob().Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "SELINUX_TYPE", Value: ""}))
+	})
+})
@@ -0,0 +1,31 @@
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// applyOperatorConfig points the images of an operand pod to the image mirrors of the operator configuration,
+// and adds its image pull secrets
+func applyOperatorConfig(podSpec *corev1.PodSpec) {
+	for i := range podSpec.InitContainers {
+		podSpec.InitContainers[i].Image = defaults.ResolveImage(podSpec.InitContainers[i].Image)
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Image = defaults.ResolveImage(podSpec.Containers[i].Image)
+	}
+
+	for _, secret := range defaults.Config.ImagePullSecrets {
+		found := false
+		for _, existing := range podSpec.ImagePullSecrets {
+			if existing.Name == secret.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, secret)
+		}
+	}
+}
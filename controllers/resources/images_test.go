@@ -0,0 +1,101 @@
+package resources
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Operand images", func() {
+	var nfsProvisioner *cachev1alpha1.NFSProvisioner
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace"},
+		}
+
+		nfsImage, config := defaults.NFSImage, defaults.Config
+		DeferCleanup(func() {
+			defaults.NFSImage, defaults.Config = nfsImage, config
+		})
+	})
+
+	It("should use RELATED_IMAGE_NFS_PROVISIONER as the default, also for the former default of the field", func() {
+		env := map[string]string{"RELATED_IMAGE_NFS_PROVISIONER": "mirror.example.com/nfs-provisioner@sha256:1234"}
+		applied := defaults.LoadRelatedImages(func(name string) string { return env[name] })
+		Expect(applied).To(Equal(env))
+
+		image, _ := nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("mirror.example.com/nfs-provisioner@sha256:1234"))
+
+		builtin := defaults.BuiltinNFSImage
+		nfsProvisioner.Spec.NFSImageConfiguration = &cachev1alpha1.ImageConfiguration{Image: &builtin}
+		image, _ = nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("mirror.example.com/nfs-provisioner@sha256:1234"))
+
+		custom := "registry.example.com/nfs-provisioner:dev"
+		nfsProvisioner.Spec.NFSImageConfiguration.Image = &custom
+		image, _ = nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal(custom))
+	})
+
+	It("should mirror digests and tags separately, with the most specific source", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(`
+imageDigestMirrors:
+- source: k8s.gcr.io
+  mirrors: [mirror.example.com/k8s]
+- source: k8s.gcr.io/sig-storage
+  mirrors: [mirror.example.com/sig-storage]
+imageTagMirrors:
+- source: docker.io/library
+  mirrors: [mirror.example.com/library/]
+imagePullSecrets:
+- name: mirror-pull-secret
+`), 0o600)).To(Succeed())
+		Expect(defaults.LoadOperatorConfig(path)).To(Succeed())
+
+		Expect(defaults.ResolveImage(defaults.BuiltinNFSImage)).To(HavePrefix("mirror.example.com/sig-storage/nfs-provisioner@sha256:"))
+		Expect(defaults.ResolveImage("k8s.gcr.io/pause@sha256:1234")).To(Equal("mirror.example.com/k8s/pause@sha256:1234"))
+		Expect(defaults.ResolveImage("docker.io/library/busybox:1.36")).To(Equal("mirror.example.com/library/busybox:1.36"))
+		// Digest mirrors do not apply to tags, and a source only matches whole path components
+		Expect(defaults.ResolveImage("k8s.gcr.io/pause:3.9")).To(Equal("k8s.gcr.io/pause:3.9"))
+		Expect(defaults.ResolveImage("docker.io/library-extra/busybox:1.36")).To(Equal("docker.io/library-extra/busybox:1.36"))
+		Expect(defaults.ResolveImage("localhost:5000/busybox")).To(Equal("localhost:5000/busybox"))
+	})
+
+	It("should point operand pods to the mirrors with the pull secrets", func() {
+		defaults.Config = defaults.OperatorConfig{
+			ImageTagMirrors:  []defaults.ImageMirror{{Source: "docker.io", Mirrors: []string{"mirror.example.com"}}},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-pull-secret"}},
+		}
+
+		podSpec := corev1.PodSpec{
+			InitContainers:   []corev1.Container{{Name: "init", Image: "docker.io/library/busybox:1.36"}},
+			Containers:       []corev1.Container{{Name: "main", Image: "quay.io/jooholee/nfs-provisioner-operator:0.0.8"}},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-pull-secret"}},
+		}
+		applyOperatorConfig(&podSpec)
+		Expect(podSpec.InitContainers[0].Image).To(Equal("mirror.example.com/library/busybox:1.36"))
+		Expect(podSpec.Containers[0].Image).To(Equal("quay.io/jooholee/nfs-provisioner-operator:0.0.8"))
+		Expect(podSpec.ImagePullSecrets).To(HaveLen(1))
+
+		job := BuildExportJob(nfsProvisioner, "test-job", corev1.Container{Name: "job", Image: defaults.UtilityImage})
+		Expect(job.Spec.Template.Spec.Containers[0].Image).To(Equal("mirror.example.com/library/busybox:1.36"))
+		Expect(job.Spec.Template.Spec.ImagePullSecrets).To(ConsistOf(corev1.LocalObjectReference{Name: "mirror-pull-secret"}))
+	})
+
+	It("should refuse a mirror without a source", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte("imageTagMirrors:\n- mirrors: [mirror.example.com]\n"), 0o600)).To(Succeed())
+		Expect(defaults.LoadOperatorConfig(path)).To(MatchError(ContainSubstring("needs a source and a mirror")))
+		Expect(defaults.Config.ImageTagMirrors).To(BeEmpty())
+	})
+})
@@ -0,0 +1,278 @@
+package resources
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// importLinePrefix marks the lines of the scan Job logs that describe a directory
+const importLinePrefix = "NFSIMPORT "
+
+// pvNamePattern matches the PV name at the end of directories created by the nfs and subdir provisioners
+var pvNamePattern = regexp.MustCompile(`pvc-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// serverEntries are the entries of the export that belong to the NFS server and not to a PV: the NFSv4 recovery state
+// and the configuration file of NFS-Ganesha, and lost+found. They are never scanned, imported or reclaimed.
+var serverEntries = []string{"lost+found", "v4recov", "v4old", "vfs.conf", "vfs.conf.new"}
+
+// serverEntry returns true when the top-level entry of the export belongs to the NFS server
+func serverEntry(name string) bool {
+	for _, entry := range serverEntries {
+		if name == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// ScannedDirectory is a directory of the export reported by the scan Job
+type ScannedDirectory struct {
+	Directory string
+	SizeBytes int64
+	// Metadata is the original PV read from defaults.PVMetadataFile, if any
+	Metadata *corev1.PersistentVolume
+	// MetadataError is set when the metadata file can not be parsed
+	MetadataError error
+}
+
+// ImportJobName returns the name of the scan Job of the NFSImport
+func ImportJobName(name string) string {
+	return truncateName("nfsimport-" + name)
+}
+
+// BuildImportScanJob returns a Job that prints one line per directory of the export with its size and PV metadata file.
+// The caller is responsible for setting the owner of the Job.
+func BuildImportScanJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, nfsImport *cachev1alpha1.NFSImport) *batchv1.Job {
+	return BuildExportJob(nfsProvisioner, ImportJobName(nfsImport.Name), scanContainer())
+}
+
+// scanContainer returns the container that prints the directories of the export in the format read by ParseImportScan
+func scanContainer() corev1.Container {
+	script := `cd ` + defaults.ExportPath + `
+for d in */; do
+  [ -d "$d" ] || continue
+  d="${d%/}"
+  case "$d" in ` + strings.Join(serverEntries, "|") + `) continue ;; esac
+  size=$(du -sk "$d" 2>/dev/null | cut -f1)
+  meta=-
+  [ -f "$d/` + defaults.PVMetadataFile + `" ] && meta=$(base64 "$d/` + defaults.PVMetadataFile + `" | tr -d '\n')
+  echo "` + importLinePrefix + `${size:-0} $meta $d"
+done
+`
+	return corev1.Container{
+		Name:                     "scan",
+		Image:                    defaults.UtilityImage,
+		Command:                  []string{"/bin/sh", "-c", script},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+}
+
+// ParseImportScan reads the directories from the logs of the scan Job
+func ParseImportScan(logs string) []ScannedDirectory {
+	dirs := []ScannedDirectory{}
+
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, importLinePrefix) {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, importLinePrefix), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		sizeKB, _ := strconv.ParseInt(fields[0], 10, 64)
+		dir := ScannedDirectory{Directory: fields[2], SizeBytes: sizeKB * 1024}
+		if fields[1] != "-" {
+			dir.Metadata, dir.MetadataError = parsePVMetadata(fields[1])
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// parsePVMetadata decodes the base64 encoded PV JSON of a metadata file
+func parsePVMetadata(encoded string) (*corev1.PersistentVolume, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	pv := &corev1.PersistentVolume{}
+	if err := json.Unmarshal(data, pv); err != nil {
+		return nil, err
+	}
+	return pv, nil
+}
+
+// PlanImportedVolume decides the PV that is created for a scanned directory.
+// The metadata file wins; otherwise the PV name and the original PVC come from the directory name and the capacity
+// from the disk usage. namespaces are the namespaces of the cluster, which tell the namespace from the PVC name.
+func PlanImportedVolume(dir ScannedDirectory, storageClassName string, namespaces map[string]bool) cachev1alpha1.ImportedVolume {
+	volume := cachev1alpha1.ImportedVolume{
+		Directory:        dir.Directory,
+		SizeBytes:        dir.SizeBytes,
+		PersistentVolume: pvNamePattern.FindString(dir.Directory),
+		Capacity:         roundUpGi(dir.SizeBytes),
+		AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+		StorageClassName: storageClassName,
+	}
+	volume.ClaimNamespace, volume.ClaimName = claimFromDirectory(dir.Directory, volume.PersistentVolume, namespaces)
+
+	if dir.MetadataError != nil {
+		volume.Message = "Invalid " + defaults.PVMetadataFile + ": " + dir.MetadataError.Error()
+	}
+
+	if meta := dir.Metadata; meta != nil {
+		if meta.Name != "" {
+			volume.PersistentVolume = meta.Name
+		}
+		if storage, ok := meta.Spec.Capacity[corev1.ResourceStorage]; ok {
+			volume.Capacity = storage.String()
+		}
+		if len(meta.Spec.AccessModes) > 0 {
+			volume.AccessModes = meta.Spec.AccessModes
+		}
+		if meta.Spec.StorageClassName != "" {
+			volume.StorageClassName = meta.Spec.StorageClassName
+		}
+		volume.MountOptions = meta.Spec.MountOptions
+		if meta.Spec.ClaimRef != nil {
+			volume.ClaimNamespace = meta.Spec.ClaimRef.Namespace
+			volume.ClaimName = meta.Spec.ClaimRef.Name
+		}
+	}
+
+	if volume.PersistentVolume == "" && volume.Message == "" {
+		volume.Message = "The PV name is unknown. Add " + defaults.PVMetadataFile + " to the directory"
+	}
+
+	return volume
+}
+
+// claimFromDirectory returns the original PVC of a directory named <namespace>-<pvc>-<pv>, as the subdir provisioner,
+// the CSI driver and the pools name them. Both names may contain dashes, so the split must be the only
+// possible one or the only one whose namespace exists. Nothing is returned when the PVC is unknown or ambiguous.
+func claimFromDirectory(directory, pvName string, namespaces map[string]bool) (string, string) {
+	prefix := strings.TrimSuffix(directory, "-"+pvName)
+	if pvName == "" || prefix == directory {
+		return "", ""
+	}
+
+	candidates := [][2]string{}
+	for i := strings.Index(prefix, "-"); i >= 0; i = nextDash(prefix, i) {
+		namespace, claim := prefix[:i], prefix[i+1:]
+		if len(validation.IsDNS1123Label(namespace)) == 0 && len(validation.IsDNS1123Subdomain(claim)) == 0 {
+			candidates = append(candidates, [2]string{namespace, claim})
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0][0], candidates[0][1]
+	}
+
+	existing := [][2]string{}
+	for _, candidate := range candidates {
+		if namespaces[candidate[0]] {
+			existing = append(existing, candidate)
+		}
+	}
+	if len(existing) == 1 {
+		return existing[0][0], existing[0][1]
+	}
+	return "", ""
+}
+
+// nextDash returns the index of the next dash in s after i, or -1
+func nextDash(s string, i int) int {
+	j := strings.Index(s[i+1:], "-")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// BuildImportedPV returns the PV of an imported directory.
+// The PV is retained on release and reserved for its original PVC when it is known.
+func BuildImportedPV(volume cachev1alpha1.ImportedVolume, server, exportPath string) (*corev1.PersistentVolume, error) {
+	capacity, err := resource.ParseQuantity(volume.Capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: volume.PersistentVolume,
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: capacity},
+			AccessModes:                   volume.AccessModes,
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              volume.StorageClassName,
+			MountOptions:                  volume.MountOptions,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: server,
+					Path:   path.Join(exportPath, volume.Directory),
+				},
+			},
+		},
+	}
+
+	if volume.ClaimName != "" {
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  volume.ClaimNamespace,
+			Name:       volume.ClaimName,
+		}
+	}
+
+	return pv, nil
+}
+
+// BuildImportedPVC returns the PVC that binds to an imported PV in its original namespace
+func BuildImportedPVC(pv *corev1.PersistentVolume) *corev1.PersistentVolumeClaim {
+	storageClassName := pv.Spec.StorageClassName
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pv.Spec.ClaimRef.Name,
+			Namespace: pv.Spec.ClaimRef.Namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      pv.Spec.AccessModes,
+			StorageClassName: &storageClassName,
+			VolumeName:       pv.Name,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage]},
+			},
+		},
+	}
+}
+
+// roundUpGi returns the size rounded up to whole GiB, with at least 1Gi
+func roundUpGi(sizeBytes int64) string {
+	const gi = 1024 * 1024 * 1024
+	size := (sizeBytes + gi - 1) / gi
+	if size < 1 {
+		size = 1
+	}
+	return fmt.Sprintf("%dGi", size)
+}
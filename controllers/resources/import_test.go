@@ -0,0 +1,90 @@
+package resources
+
+import (
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Import", func() {
+	const pvName = "pvc-5b0c2a57-3f1e-4d0e-9a55-0c6f1c3e7d21"
+
+	It("should build a scan Job that reads the PV metadata file", func() {
+		nfsProvisioner := &cachev1alpha1.NFSProvisioner{ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace"}}
+		nfsImport := &cachev1alpha1.NFSImport{ObjectMeta: metav1.ObjectMeta{Name: "rebuild", Namespace: "test-namespace"}}
+
+		job := BuildImportScanJob(nfsProvisioner, nfsImport)
+		Expect(job.Name).To(Equal("nfsimport-rebuild"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring(defaults.PVMetadataFile))
+	})
+
+	It("should parse the scan and plan PVs from directory names", func() {
+		logs := "NFSIMPORT 2048 - " + pvName + "\n" +
+			"NFSIMPORT 4 - my data\n" +
+			"du: permission denied\n"
+
+		dirs := ParseImportScan(logs)
+		Expect(dirs).To(HaveLen(2))
+		Expect(dirs[1].Directory).To(Equal("my data"))
+
+		volume := PlanImportedVolume(dirs[0], "nfs", nil)
+		Expect(volume.PersistentVolume).To(Equal(pvName))
+		Expect(volume.SizeBytes).To(Equal(int64(2048 * 1024)))
+		Expect(volume.Capacity).To(Equal("1Gi"))
+		Expect(volume.StorageClassName).To(Equal("nfs"))
+
+		Expect(volume.ClaimName).To(BeEmpty())
+
+		// Directories of the subdir provisioner and the CSI driver are <namespace>-<pvc>-<pv>
+		volume = PlanImportedVolume(ScannedDirectory{Directory: "team-data-" + pvName}, "nfs", nil)
+		Expect(volume.PersistentVolume).To(Equal(pvName))
+		Expect(volume.ClaimNamespace).To(Equal("team"))
+		Expect(volume.ClaimName).To(Equal("data"))
+
+		// With dashes in the names, the existing namespace decides
+		volume = PlanImportedVolume(ScannedDirectory{Directory: "team-a-app-data-" + pvName}, "nfs", map[string]bool{"team-a": true})
+		Expect(volume.ClaimNamespace).To(Equal("team-a"))
+		Expect(volume.ClaimName).To(Equal("app-data"))
+		volume = PlanImportedVolume(ScannedDirectory{Directory: "team-a-app-data-" + pvName}, "nfs", nil)
+		Expect(volume.PersistentVolume).To(Equal(pvName))
+		Expect(volume.ClaimName).To(BeEmpty())
+
+		volume = PlanImportedVolume(dirs[1], "nfs", nil)
+		Expect(volume.PersistentVolume).To(BeEmpty())
+		Expect(volume.Message).To(ContainSubstring("PV name is unknown"))
+	})
+
+	It("should restore the original PV from the metadata file", func() {
+		meta := `{"metadata":{"name":"` + pvName + `"},"spec":{"capacity":{"storage":"5Gi"},"accessModes":["ReadWriteOnce"],` +
+			`"storageClassName":"nfs-old","claimRef":{"namespace":"team-a","name":"data","uid":"1234"}}}`
+		logs := "NFSIMPORT 10 " + base64.StdEncoding.EncodeToString([]byte(meta)) + " " + pvName + "\n"
+
+		volume := PlanImportedVolume(ParseImportScan(logs)[0], "nfs", nil)
+		Expect(volume.Capacity).To(Equal("5Gi"))
+		Expect(volume.StorageClassName).To(Equal("nfs-old"))
+		Expect(volume.ClaimNamespace).To(Equal("team-a"))
+
+		pv, err := BuildImportedPV(volume, "172.30.10.20", defaults.ExportPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pv.Spec.NFS.Path).To(Equal("/export/" + pvName))
+		Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(corev1.PersistentVolumeReclaimRetain))
+		Expect(pv.Spec.ClaimRef.Name).To(Equal("data"))
+		Expect(pv.Spec.ClaimRef.UID).To(BeEmpty())
+
+		pvc := BuildImportedPVC(pv)
+		Expect(pvc.Namespace).To(Equal("team-a"))
+		Expect(pvc.Spec.VolumeName).To(Equal(pvName))
+		Expect(pvc.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+	})
+
+	It("should report an invalid metadata file", func() {
+		volume := PlanImportedVolume(ParseImportScan("NFSIMPORT 10 bm90IGpzb24= " + pvName)[0], "nfs", nil)
+		Expect(volume.Message).To(ContainSubstring("Invalid"))
+	})
+})
@@ -0,0 +1,296 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// exportVolumeSource returns the volume that backs the export of the NFS server
+func exportVolumeSource(nfsProvisioner *cachev1alpha1.NFSProvisioner) *corev1.VolumeSource {
+	if isExternalMode(nfsProvisioner) && nfsProvisioner.Spec.External != nil {
+		return &corev1.VolumeSource{
+			NFS: &corev1.NFSVolumeSource{
+				Server: nfsProvisioner.Spec.External.Server,
+				Path:   nfsProvisioner.Spec.External.Path,
+			}}
+	}
+
+	// After a failover, the standby volume backs the export
+	if standbyActive(nfsProvisioner) {
+		return &corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: defaults.StandbyPvc,
+			}}
+	}
+
+	if nfsProvisioner.Spec.HostPathDir != "" {
+		hostPathType := corev1.HostPathDirectory
+		return &corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: nfsProvisioner.Spec.HostPathDir,
+				Type: &hostPathType,
+			}}
+	}
+
+	pvcName := managedPVCName(nfsProvisioner)
+	if nfsProvisioner.Spec.Pvc != "" {
+		pvcName = nfsProvisioner.Spec.Pvc
+	}
+
+	return &corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: pvcName,
+		}}
+}
+
+// pvExportPath returns the path of the export in the PVs of the NFSProvisioner.
+// It is defaults.ExportPath, except on an external NFS server.
+func pvExportPath(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if isExternalMode(nfsProvisioner) && nfsProvisioner.Spec.External != nil {
+		return nfsProvisioner.Spec.External.Path
+	}
+	return defaults.ExportPath
+}
+
+// BuildExportPodSpec returns a pod spec that mounts the export of the NFSProvisioner at defaults.ExportPath.
+// The pod is scheduled next to the NFS server pod so that hostPath and ReadWriteOnce volumes are reachable.
+// In External mode, the export is mounted over NFS and the pod can run anywhere.
+func BuildExportPodSpec(nfsProvisioner *cachev1alpha1.NFSProvisioner, containers ...corev1.Container) corev1.PodSpec {
+	for i := range containers {
+		containers[i].VolumeMounts = append(containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      "export-volume",
+			MountPath: defaults.ExportPath,
+		})
+	}
+
+	podSpec := corev1.PodSpec{
+		Containers:         containers,
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: defaults.ServiceAccount,
+		Volumes: []corev1.Volume{{
+			Name:         "export-volume",
+			VolumeSource: *exportVolumeSource(nfsProvisioner),
+		}},
+	}
+
+	applyOperatorConfig(&podSpec)
+	if isExternalMode(nfsProvisioner) {
+		return podSpec
+	}
+
+	podSpec.Affinity = &corev1.Affinity{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: serverLabels(nfsProvisioner),
+				},
+				TopologyKey: corev1.LabelHostname,
+			}},
+		},
+	}
+
+	if nfsProvisioner.Spec.HostPathDir != "" && !standbyActive(nfsProvisioner) {
+		podSpec.NodeSelector = hostPathNodeSelector(nfsProvisioner)
+	}
+
+	return podSpec
+}
+
+// BuildExportJob returns a Job that runs the given containers with the export of the NFSProvisioner mounted.
+// The caller is responsible for setting the owner of the Job.
+func BuildExportJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string, containers ...corev1.Container) *batchv1.Job {
+	backoffLimit := int32(2)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    jobLabels(nfsProvisioner.Name),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels(nfsProvisioner.Name),
+				},
+				Spec: BuildExportPodSpec(nfsProvisioner, containers...),
+			},
+		},
+	}
+}
+
+// JobFinished reports whether the Job has completed, and whether it succeeded
+func JobFinished(job *batchv1.Job) (finished bool, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// JobTerminationMessage returns the termination message of the last finished pod of the Job
+func JobTerminationMessage(ctx context.Context, c client.Client, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+
+	var latest *corev1.ContainerStateTerminated
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if t == nil {
+				continue
+			}
+			if latest == nil || latest.FinishedAt.Before(&t.FinishedAt) {
+				latest = t
+			}
+		}
+	}
+
+	if latest == nil {
+		return "", fmt.Errorf("no finished pod found for Job %s", job.Name)
+	}
+	return latest.Message, nil
+}
+
+// JobLogs returns the logs of the pod that completed the Job.
+// Logs are read when the output does not fit in the termination message.
+func JobLogs(ctx context.Context, c client.Client, kubeClient kubernetes.Interface, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		data, err := kubeClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("no succeeded pod found for Job %s", job.Name)
+}
+
+// LatestSucceededJob returns the Job with the given labels that completed last, if any
+func LatestSucceededJob(ctx context.Context, c client.Client, namespace string, labels client.MatchingLabels) (*batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := c.List(ctx, jobs, client.InNamespace(namespace), labels); err != nil {
+		return nil, err
+	}
+
+	var latest *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if finished, succeeded := JobFinished(job); !finished || !succeeded || job.Status.CompletionTime == nil {
+			continue
+		}
+		if latest == nil || latest.Status.CompletionTime.Before(job.Status.CompletionTime) {
+			latest = job
+		}
+	}
+	return latest, nil
+}
+
+// generationCheck describes a Job that runs once per generation of the NFSProvisioner and the condition that records its result
+type generationCheck struct {
+	Condition string
+	// PendingReason and PendingMessage are set until the first Job has finished
+	PendingReason   string
+	PendingMessage  string
+	SucceededReason string
+	FailedReason    string
+}
+
+// runGenerationCheck runs the Job built for the current generation and records its result with the termination message
+// of the Job in the condition. The previous result is kept while a new Job runs, so that nothing depending on it changes for nothing.
+func (m *BaseResourceManager) runGenerationCheck(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, check generationCheck, buildJob func(*cachev1alpha1.NFSProvisioner) *batchv1.Job) error {
+	log := m.Log.WithValues("condition", check.Condition)
+
+	condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, check.Condition)
+	if condition != nil && condition.ObservedGeneration == nfsProvisioner.Generation {
+		return nil
+	}
+
+	job := buildJob(nfsProvisioner)
+	jobFound := &batchv1.Job{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, jobFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := m.Client.Create(ctx, job); err != nil {
+			log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return err
+		}
+		if condition == nil {
+			setCondition(nfsProvisioner, check.Condition, metav1.ConditionUnknown, check.PendingReason, check.PendingMessage, nfsProvisioner.Generation-1)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	finished, succeeded := JobFinished(jobFound)
+	if !finished {
+		return nil
+	}
+
+	message, err := JobTerminationMessage(ctx, m.Client, jobFound)
+	if err != nil {
+		log.Error(err, "Failed to read the termination message of the Job", "Job.Name", jobFound.Name)
+	}
+	// A Job that never ran, e.g. because of its deadline, only has the reason in its condition
+	if strings.TrimSpace(message) == "" {
+		message = jobConditionMessage(jobFound)
+	}
+	if succeeded {
+		setCondition(nfsProvisioner, check.Condition, metav1.ConditionTrue, check.SucceededReason, strings.TrimSpace(message), nfsProvisioner.Generation)
+	} else {
+		setCondition(nfsProvisioner, check.Condition, metav1.ConditionFalse, check.FailedReason, strings.TrimSpace(message), nfsProvisioner.Generation)
+	}
+	return nil
+}
+
+// jobConditionMessage returns the message of the condition that finished the Job
+func jobConditionMessage(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Status == corev1.ConditionTrue && (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) {
+			return c.Message
+		}
+	}
+	return ""
+}
+
+// setCondition records a condition of the NFSProvisioner
+func setCondition(nfsProvisioner *cachev1alpha1.NFSProvisioner, conditionType string, status metav1.ConditionStatus, reason, message string, generation int64) {
+	meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
@@ -12,47 +13,124 @@ import (
 
 // ResourceManagerSet holds all resource managers
 type ResourceManagerSet struct {
+	// Pause runs before the phases, which are skipped while the NFSProvisioner is paused
+	Pause ResourceManager
+	// Phase 1 resources
+	Migration ResourceManager
 	// Phase 2 resources
 	SCC            ResourceManager
 	PVC            ResourceManager
 	ServiceAccount ResourceManager
+	NodeSelection  ResourceManager
+	HostPath       ResourceManager
+	Quota          ResourceManager
 	// Phase 3 resources
-	RBAC         ResourceManager
-	Deployment   ResourceManager
-	Service      ResourceManager
-	StorageClass ResourceManager
+	RBAC              ResourceManager
+	GaneshaConfig     ResourceManager
+	ServerUpgrade     ResourceManager
+	Deployment        ResourceManager
+	Standby           ResourceManager
+	Service           ResourceManager
+	SubdirProvisioner ResourceManager
+	StorageClass      ResourceManager
+	CSI               ResourceManager
+	// Phase 4 resources
+	Clone          ResourceManager
+	OrphanAudit    ResourceManager
+	ExportCapacity ResourceManager
+	UsageReport    ResourceManager
+	Canary         ResourceManager
 }
 
 // NewResourceManagerSet creates a new set of resource managers
-func NewResourceManagerSet(client client.Client, log logr.Logger, scheme *runtime.Scheme) *ResourceManagerSet {
+func NewResourceManagerSet(client client.Client, kubeClient kubernetes.Interface, log logr.Logger, scheme *runtime.Scheme) *ResourceManagerSet {
 	base := NewBaseResourceManager(client, log, scheme)
+	base.KubeClient = kubeClient
 
 	return &ResourceManagerSet{
+		Pause: NewPauseManager(base),
+		// Phase 1 resources
+		Migration: NewMigrationManager(base),
 		// Phase 2 resources
 		SCC:            NewSCCManager(base),
 		PVC:            NewPVCManager(base),
 		ServiceAccount: NewServiceAccountManager(base),
+		NodeSelection:  NewNodeSelectionManager(base),
+		HostPath:       NewHostPathManager(base),
+		Quota:          NewQuotaManager(base),
 		// Phase 3 resources
-		RBAC:         NewRBACManager(base),
-		Deployment:   NewDeploymentManager(base),
-		Service:      NewServiceManager(base),
-		StorageClass: NewStorageClassManager(base),
+		RBAC:              NewRBACManager(base),
+		GaneshaConfig:     NewGaneshaConfigManager(base),
+		ServerUpgrade:     NewUpgradeManager(base),
+		Deployment:        NewDeploymentManager(base),
+		Standby:           NewStandbyManager(base),
+		Service:           NewServiceManager(base),
+		SubdirProvisioner: NewSubdirProvisionerManager(base),
+		StorageClass:      NewStorageClassManager(base),
+		CSI:               NewCSIManager(base),
+		// Phase 4 resources
+		Clone:          NewCloneManager(base),
+		OrphanAudit:    NewOrphanAuditManager(base),
+		ExportCapacity: NewCapacityManager(base),
+		UsageReport:    NewUsageReportManager(base),
+		Canary:         NewCanaryManager(base),
 	}
 }
 
 // EnsureAllResources ensures all managed resources exist in the correct state
 func (r *ResourceManagerSet) EnsureAllResources(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	// A paused NFSProvisioner only records its pause, so that manual changes are not reverted
+	if err := r.Pause.EnsureResource(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+	if PauseMode(nfsProvisioner) != "" {
+		return nil
+	}
+
 	// List of managers to process in order
 	managers := []ResourceManager{
+		// Phase 1 resources
+		r.Migration,
 		// Phase 2 resources
 		r.SCC,
 		r.PVC,
 		r.ServiceAccount,
+		r.NodeSelection,
+		r.HostPath,
+		r.Quota,
 		// Phase 3 resources
 		r.RBAC,
+		r.GaneshaConfig,
+		r.ServerUpgrade,
 		r.Deployment,
+		r.Standby,
 		r.Service,
 		r.StorageClass,
+		r.CSI,
+		// Phase 4 resources
+		r.Clone,
+		r.OrphanAudit,
+		r.ExportCapacity,
+		r.UsageReport,
+		r.Canary,
+	}
+
+	// In External mode, the NFS server already exists and only the subdirectory provisioner is deployed
+	if isExternalMode(nfsProvisioner) {
+		managers = []ResourceManager{
+			// Phase 2 resources
+			r.ServiceAccount,
+			// Phase 3 resources
+			r.RBAC,
+			r.SubdirProvisioner,
+			r.StorageClass,
+			// Phase 4 resources
+			r.Clone,
+			r.OrphanAudit,
+			r.ExportCapacity,
+			r.UsageReport,
+			r.Canary,
+		}
 	}
 
 	// Process each manager
@@ -68,12 +146,27 @@ func (r *ResourceManagerSet) EnsureAllResources(ctx context.Context, nfsProvisio
 // GetManagedResourceNames returns the names of all resources managed by this set
 func (r *ResourceManagerSet) GetManagedResourceNames() []string {
 	return []string{
+		r.Pause.GetResourceName(),
+		r.Migration.GetResourceName(),
 		r.SCC.GetResourceName(),
 		r.PVC.GetResourceName(),
 		r.ServiceAccount.GetResourceName(),
+		r.NodeSelection.GetResourceName(),
+		r.HostPath.GetResourceName(),
+		r.Quota.GetResourceName(),
 		r.RBAC.GetResourceName(),
+		r.GaneshaConfig.GetResourceName(),
+		r.ServerUpgrade.GetResourceName(),
 		r.Deployment.GetResourceName(),
+		r.Standby.GetResourceName(),
 		r.Service.GetResourceName(),
+		r.SubdirProvisioner.GetResourceName(),
 		r.StorageClass.GetResourceName(),
+		r.CSI.GetResourceName(),
+		r.Clone.GetResourceName(),
+		r.OrphanAudit.GetResourceName(),
+		r.ExportCapacity.GetResourceName(),
+		r.UsageReport.GetResourceName(),
+		r.Canary.GetResourceName(),
 	}
 }
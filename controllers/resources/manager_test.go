@@ -16,6 +16,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -93,31 +94,60 @@ var _ = Describe("Resource Manager Set", func() {
 		}
 
 		// Create resource manager set
-		resourceManagerSet = NewResourceManagerSet(client, logr.Discard(), scheme)
+		resourceManagerSet = NewResourceManagerSet(client, kubefake.NewSimpleClientset(), logr.Discard(), scheme)
 		Expect(resourceManagerSet).NotTo(BeNil())
+		Expect(resourceManagerSet.Migration).NotTo(BeNil())
 		Expect(resourceManagerSet.SCC).NotTo(BeNil())
 		Expect(resourceManagerSet.PVC).NotTo(BeNil())
 		Expect(resourceManagerSet.ServiceAccount).NotTo(BeNil())
+		Expect(resourceManagerSet.NodeSelection).NotTo(BeNil())
+		Expect(resourceManagerSet.HostPath).NotTo(BeNil())
+		Expect(resourceManagerSet.Quota).NotTo(BeNil())
 		Expect(resourceManagerSet.RBAC).NotTo(BeNil())
+		Expect(resourceManagerSet.GaneshaConfig).NotTo(BeNil())
 		Expect(resourceManagerSet.Deployment).NotTo(BeNil())
+		Expect(resourceManagerSet.Standby).NotTo(BeNil())
 		Expect(resourceManagerSet.Service).NotTo(BeNil())
+		Expect(resourceManagerSet.SubdirProvisioner).NotTo(BeNil())
 		Expect(resourceManagerSet.StorageClass).NotTo(BeNil())
+		Expect(resourceManagerSet.CSI).NotTo(BeNil())
+		Expect(resourceManagerSet.Clone).NotTo(BeNil())
+		Expect(resourceManagerSet.UsageReport).NotTo(BeNil())
+		Expect(resourceManagerSet.Canary).NotTo(BeNil())
+		Expect(resourceManagerSet.Pause).NotTo(BeNil())
+		Expect(resourceManagerSet.ServerUpgrade).NotTo(BeNil())
+		Expect(resourceManagerSet.OrphanAudit).NotTo(BeNil())
+		Expect(resourceManagerSet.ExportCapacity).NotTo(BeNil())
 	})
 
 	Describe("ResourceManagerSet", func() {
 		It("should create all resource managers", func() {
+			Expect(resourceManagerSet.Migration.GetResourceName()).To(Equal("StorageMigration"))
 			Expect(resourceManagerSet.SCC.GetResourceName()).To(Equal("SecurityContextConstraints"))
 			Expect(resourceManagerSet.PVC.GetResourceName()).To(Equal("PersistentVolumeClaim"))
 			Expect(resourceManagerSet.ServiceAccount.GetResourceName()).To(Equal("ServiceAccount"))
+			Expect(resourceManagerSet.NodeSelection.GetResourceName()).To(Equal("NodeSelection"))
+			Expect(resourceManagerSet.HostPath.GetResourceName()).To(Equal("HostPath"))
+			Expect(resourceManagerSet.Quota.GetResourceName()).To(Equal("Quota"))
 			Expect(resourceManagerSet.RBAC.GetResourceName()).To(Equal("RBAC"))
+			Expect(resourceManagerSet.GaneshaConfig.GetResourceName()).To(Equal("GaneshaConfig"))
 			Expect(resourceManagerSet.Deployment.GetResourceName()).To(Equal("Deployment"))
+			Expect(resourceManagerSet.Standby.GetResourceName()).To(Equal("Standby"))
 			Expect(resourceManagerSet.Service.GetResourceName()).To(Equal("Service"))
 			Expect(resourceManagerSet.StorageClass.GetResourceName()).To(Equal("StorageClass"))
+			Expect(resourceManagerSet.CSI.GetResourceName()).To(Equal("CSI"))
+			Expect(resourceManagerSet.Clone.GetResourceName()).To(Equal("VolumeClone"))
+			Expect(resourceManagerSet.UsageReport.GetResourceName()).To(Equal("UsageReport"))
+			Expect(resourceManagerSet.Canary.GetResourceName()).To(Equal("Canary"))
+			Expect(resourceManagerSet.Pause.GetResourceName()).To(Equal("Pause"))
+			Expect(resourceManagerSet.ServerUpgrade.GetResourceName()).To(Equal("ServerUpgrade"))
+			Expect(resourceManagerSet.OrphanAudit.GetResourceName()).To(Equal("OrphanAudit"))
+			Expect(resourceManagerSet.ExportCapacity.GetResourceName()).To(Equal("ExportCapacity"))
 		})
 
 		It("should return managed resource names", func() {
 			names := resourceManagerSet.GetManagedResourceNames()
-			Expect(names).To(ContainElements("SecurityContextConstraints", "PersistentVolumeClaim", "ServiceAccount", "RBAC", "Deployment", "Service", "StorageClass"))
+			Expect(names).To(ContainElements("StorageMigration", "SecurityContextConstraints", "PersistentVolumeClaim", "ServiceAccount", "NodeSelection", "HostPath", "Quota", "RBAC", "GaneshaConfig", "Deployment", "Standby", "Service", "SubdirProvisioner", "StorageClass", "CSI", "VolumeClone", "OrphanAudit", "ExportCapacity", "UsageReport", "Canary", "Pause", "ServerUpgrade"))
 		})
 
 		It("should ensure all resources successfully", func() {
@@ -165,6 +195,37 @@ var _ = Describe("Resource Manager Set", func() {
 			Expect(scc.Users).To(ContainElement("system:serviceaccount:test-namespace:" + defaults.ServiceAccount))
 			Expect(scc.Users).To(ContainElement("system:serviceaccount:other-namespace:other-sa"))
 		})
+
+		It("should revoke privileged containers once no NFSProvisioner needs them", func() {
+			nfsProvisioner.Spec.HostPathDir = "/tmp/nfs"
+			nfsProvisioner.Spec.HostPathPreparation = &cachev1alpha1.HostPathPreparation{Enabled: true}
+			Expect(sccManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+			scc := &securityv1.SecurityContextConstraints{}
+			Expect(client.Get(ctx, types.NamespacedName{Name: defaults.SecurityContextContrants}, scc)).To(Succeed())
+			Expect(scc.AllowPrivilegedContainer).To(BeTrue())
+
+			// Another NFSProvisioner of the cluster keeps them allowed
+			other := &cachev1alpha1.NFSProvisioner{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-nfs", Namespace: "other-namespace"},
+				Spec: cachev1alpha1.NFSProvisionerSpec{
+					HostPathDir:         "/tmp/nfs",
+					HostPathPreparation: &cachev1alpha1.HostPathPreparation{Enabled: true},
+				},
+			}
+			Expect(client.Create(ctx, other)).To(Succeed())
+
+			nfsProvisioner.Spec.HostPathPreparation = nil
+			Expect(sccManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+			Expect(client.Get(ctx, types.NamespacedName{Name: defaults.SecurityContextContrants}, scc)).To(Succeed())
+			Expect(scc.AllowPrivilegedContainer).To(BeTrue())
+
+			Expect(client.Delete(ctx, other)).To(Succeed())
+			Expect(sccManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+			Expect(client.Get(ctx, types.NamespacedName{Name: defaults.SecurityContextContrants}, scc)).To(Succeed())
+			Expect(scc.AllowPrivilegedContainer).To(BeFalse())
+			Expect(scc.AllowHostNetwork).To(BeFalse())
+		})
 	})
 
 	Describe("PVCManager", func() {
@@ -0,0 +1,92 @@
+package resources
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+)
+
+var (
+	// orphanedDirectories is the number of orphaned directories found by the last audit
+	orphanedDirectories = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_orphaned_directories",
+		Help: "Number of directories on the export that no PV references",
+	}, []string{"namespace", "nfsprovisioner"})
+
+	// orphanedBytes is the size of the orphaned directories found by the last audit
+	orphanedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_orphaned_bytes",
+		Help: "Disk usage of the directories on the export that no PV references",
+	}, []string{"namespace", "nfsprovisioner"})
+
+	// volumeUsedBytes is the disk usage of each volume measured by the last scan, with its PVC
+	volumeUsedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_volume_used_bytes",
+		Help: "Disk usage of the directory of a PV on the export",
+	}, []string{"namespace", "nfsprovisioner", "persistentvolume", "claim_namespace", "persistentvolumeclaim"})
+
+	// volumeCapacityBytes is the requested size of each volume, with its PVC
+	volumeCapacityBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_volume_capacity_bytes",
+		Help: "Capacity of a PV on the export",
+	}, []string{"namespace", "nfsprovisioner", "persistentvolume", "claim_namespace", "persistentvolumeclaim"})
+
+	// namespaceUsedBytes is the disk usage of the volumes claimed in a namespace
+	namespaceUsedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_usage_namespace_used_bytes",
+		Help: "Disk usage of the volumes on the export claimed in a namespace",
+	}, []string{"namespace", "nfsprovisioner", "claim_namespace"})
+
+	// namespaceCapacityBytes is the capacity of the volumes claimed in a namespace
+	namespaceCapacityBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_usage_namespace_capacity_bytes",
+		Help: "Capacity of the volumes on the export claimed in a namespace",
+	}, []string{"namespace", "nfsprovisioner", "claim_namespace"})
+
+	// canaryHealthy is 1 when the last canary run succeeded
+	canaryHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_canary_healthy",
+		Help: "Whether the last canary run provisioned, wrote and read a volume of the StorageClass",
+	}, []string{"namespace", "nfsprovisioner"})
+
+	// canaryRuns counts the finished canary runs by result
+	canaryRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nfs_provisioner_canary_runs_total",
+		Help: "Number of finished canary runs",
+	}, []string{"namespace", "nfsprovisioner", "result"})
+
+	// canaryLatencySeconds is the duration of each step of the last successful canary run
+	canaryLatencySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nfs_provisioner_canary_latency_seconds",
+		Help: "Duration of a step of the last successful canary run: provision, write or read",
+	}, []string{"namespace", "nfsprovisioner", "step"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphanedDirectories, orphanedBytes, volumeUsedBytes, volumeCapacityBytes,
+		namespaceUsedBytes, namespaceCapacityBytes,
+		canaryHealthy, canaryRuns, canaryLatencySeconds)
+}
+
+// clearVolumeUsageMetrics removes the volume metrics of the NFSProvisioner, e.g. for deleted PVs
+func clearVolumeUsageMetrics(nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	labels := prometheus.Labels{"namespace": nfsProvisioner.Namespace, "nfsprovisioner": nfsProvisioner.Name}
+	volumeUsedBytes.DeletePartialMatch(labels)
+	volumeCapacityBytes.DeletePartialMatch(labels)
+}
+
+// clearUsageReportMetrics removes the usage report metrics of the NFSProvisioner, e.g. for deleted PVs
+func clearUsageReportMetrics(nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	labels := prometheus.Labels{"namespace": nfsProvisioner.Namespace, "nfsprovisioner": nfsProvisioner.Name}
+	namespaceUsedBytes.DeletePartialMatch(labels)
+	namespaceCapacityBytes.DeletePartialMatch(labels)
+}
+
+// clearCanaryMetrics removes the canary metrics of the NFSProvisioner when the canary is disabled
+func clearCanaryMetrics(nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	labels := prometheus.Labels{"namespace": nfsProvisioner.Namespace, "nfsprovisioner": nfsProvisioner.Name}
+	canaryHealthy.DeletePartialMatch(labels)
+	canaryRuns.DeletePartialMatch(labels)
+	canaryLatencySeconds.DeletePartialMatch(labels)
+}
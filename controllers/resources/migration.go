@@ -0,0 +1,460 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// ConditionMigrating is the condition type that shows a storage migration is pending or running
+const ConditionMigrating = "Migrating"
+
+// MigrationManager moves the export to a new volume when the storage spec of a running NFSProvisioner changes
+type MigrationManager struct {
+	BaseResourceManager
+}
+
+// NewMigrationManager creates a new MigrationManager
+func NewMigrationManager(base BaseResourceManager) *MigrationManager {
+	return &MigrationManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *MigrationManager) GetResourceName() string {
+	return "StorageMigration"
+}
+
+// EnsureResource detects storage spec changes and drives the migration one step per reconcile
+func (m *MigrationManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	deployFound := &appsv1.Deployment{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: nfsProvisioner.Namespace}, deployFound)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Nothing is running yet, so there is nothing to migrate
+			return nil
+		}
+		return err
+	}
+
+	// Changes of the storage spec are only picked up while no migration is running
+	if migration := nfsProvisioner.Status.Migration; !migrationInProgress(nfsProvisioner) || migration.Phase == cachev1alpha1.MigrationPhasePending {
+		source, err := m.currentVolume(nfsProvisioner, deployFound)
+		if err != nil {
+			return err
+		}
+		target, changed, err := m.desiredVolume(ctx, nfsProvisioner, source)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			// The storage spec was reverted before the migration was acknowledged
+			if migration != nil && migration.Phase == cachev1alpha1.MigrationPhasePending {
+				nfsProvisioner.Status.Migration = nil
+				meta.RemoveStatusCondition(&nfsProvisioner.Status.Conditions, ConditionMigrating)
+			}
+			return nil
+		}
+		// A failed migration stays visible until the user acknowledges a retry
+		if migration != nil && migration.Phase == cachev1alpha1.MigrationPhaseFailed && nfsProvisioner.Annotations[defaults.MigrationAcknowledgedAnnotation] != "true" {
+			return nil
+		}
+
+		if migration == nil || migration.Phase != cachev1alpha1.MigrationPhasePending {
+			log.Info("Storage spec changed", "source", source, "target", target)
+		}
+		nfsProvisioner.Status.Migration = &cachev1alpha1.MigrationStatus{
+			Phase:  cachev1alpha1.MigrationPhasePending,
+			Source: source,
+			Target: target,
+		}
+	}
+
+	migration := nfsProvisioner.Status.Migration
+	switch migration.Phase {
+	case cachev1alpha1.MigrationPhasePending:
+		if nfsProvisioner.Annotations[defaults.MigrationAcknowledgedAnnotation] != "true" {
+			m.setMigrating(nfsProvisioner, "AwaitingAcknowledgement",
+				fmt.Sprintf("The storage spec changed. Set the annotation %s=true to migrate the data to the new volume", defaults.MigrationAcknowledgedAnnotation))
+			return nil
+		}
+		now := metav1.Now()
+		migration.StartTime = &now
+		return m.advance(nfsProvisioner, cachev1alpha1.MigrationPhaseProvisioning, "Provisioning the target volume")
+
+	case cachev1alpha1.MigrationPhaseProvisioning:
+		if err := m.provisionTarget(ctx, nfsProvisioner); err != nil {
+			return err
+		}
+		// A Job left over from a failed attempt would fail the retry right away
+		propagation := metav1.DeletePropagationBackground
+		oldJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: m.copyJobName(nfsProvisioner), Namespace: nfsProvisioner.Namespace}}
+		if err := m.Client.Delete(ctx, oldJob, &client.DeleteOptions{PropagationPolicy: &propagation}); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		return m.advance(nfsProvisioner, cachev1alpha1.MigrationPhaseScalingDown, "Stopping the NFS server")
+
+	case cachev1alpha1.MigrationPhaseScalingDown:
+		stopped, err := m.scaleServer(ctx, nfsProvisioner, deployFound, 0)
+		if err != nil || !stopped {
+			return err
+		}
+		return m.advance(nfsProvisioner, cachev1alpha1.MigrationPhaseCopying, "Copying the data to the target volume")
+
+	case cachev1alpha1.MigrationPhaseCopying:
+		finished, succeeded, message, err := m.copyData(ctx, nfsProvisioner)
+		if err != nil || !finished {
+			return err
+		}
+		if !succeeded {
+			return m.fail(ctx, nfsProvisioner, deployFound, "Copying the data failed: "+message)
+		}
+		return m.advance(nfsProvisioner, cachev1alpha1.MigrationPhaseSwitching, "Switching the NFS server to the target volume")
+
+	case cachev1alpha1.MigrationPhaseSwitching:
+		deployFound.Spec.Template.Spec.Volumes = setExportVolume(deployFound.Spec.Template.Spec.Volumes, migrationVolumeSource(migration.Target))
+		deployFound.Spec.Template.Spec.NodeSelector = map[string]string{}
+		if migration.Target.HostPathDir != "" {
+			deployFound.Spec.Template.Spec.NodeSelector = hostPathNodeSelector(nfsProvisioner)
+		}
+		replicas := int32(1)
+		deployFound.Spec.Replicas = &replicas
+		log.Info("Switching the Deployment to the target volume", "Deployment.Name", deployFound.Name)
+		if err := m.Client.Update(ctx, deployFound); err != nil {
+			log.Error(err, "Failed to update the Deployment", "Deployment.Name", deployFound.Name)
+			return err
+		}
+
+		nfsProvisioner.Status.Pvc = ""
+		if migration.Target.Managed {
+			nfsProvisioner.Status.Pvc = migration.Target.Pvc
+		}
+		return m.advance(nfsProvisioner, cachev1alpha1.MigrationPhaseAwaitingConfirmation,
+			fmt.Sprintf("The NFS server runs on the target volume. Set the annotation %s=true to release the source volume", defaults.MigrationConfirmedAnnotation))
+
+	case cachev1alpha1.MigrationPhaseAwaitingConfirmation:
+		if nfsProvisioner.Annotations[defaults.MigrationConfirmedAnnotation] != "true" {
+			return nil
+		}
+		if err := m.releaseSource(ctx, nfsProvisioner); err != nil {
+			return err
+		}
+		if err := removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.MigrationAcknowledgedAnnotation, defaults.MigrationConfirmedAnnotation); err != nil {
+			return err
+		}
+		migration.Phase = cachev1alpha1.MigrationPhaseCompleted
+		migration.Message = "Migration completed"
+		meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{
+			Type:    ConditionMigrating,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Completed",
+			Message: migration.Message,
+		})
+	}
+
+	return nil
+}
+
+// advance moves the migration to the next phase
+func (m *MigrationManager) advance(nfsProvisioner *cachev1alpha1.NFSProvisioner, phase cachev1alpha1.MigrationPhase, message string) error {
+	m.Log.Info("Storage migration", "phase", phase, "message", message)
+	nfsProvisioner.Status.Migration.Phase = phase
+	nfsProvisioner.Status.Migration.Message = message
+	m.setMigrating(nfsProvisioner, string(phase), message)
+	return nil
+}
+
+// fail restores the NFS server on the source volume and records the failure
+func (m *MigrationManager) fail(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, deployment *appsv1.Deployment, message string) error {
+	if _, err := m.scaleServer(ctx, nfsProvisioner, deployment, 1); err != nil {
+		return err
+	}
+	// The acknowledgement is removed so that re-adding it retries the migration
+	if err := removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.MigrationAcknowledgedAnnotation); err != nil {
+		return err
+	}
+
+	migration := nfsProvisioner.Status.Migration
+	migration.Phase = cachev1alpha1.MigrationPhaseFailed
+	migration.Message = message
+	meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{
+		Type:    ConditionMigrating,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Failed",
+		Message: message,
+	})
+	return nil
+}
+
+// setMigrating sets the Migrating condition to true
+func (m *MigrationManager) setMigrating(nfsProvisioner *cachev1alpha1.NFSProvisioner, reason string, message string) {
+	nfsProvisioner.Status.Migration.Message = message
+	meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{
+		Type:    ConditionMigrating,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// currentVolume returns the volume the running Deployment exports
+func (m *MigrationManager) currentVolume(nfsProvisioner *cachev1alpha1.NFSProvisioner, deployment *appsv1.Deployment) (cachev1alpha1.MigrationVolume, error) {
+	for _, v := range deployment.Spec.Template.Spec.Volumes {
+		if v.Name != "export-volume" {
+			continue
+		}
+		if v.HostPath != nil {
+			return cachev1alpha1.MigrationVolume{HostPathDir: v.HostPath.Path}, nil
+		}
+		if v.PersistentVolumeClaim != nil {
+			claim := v.PersistentVolumeClaim.ClaimName
+			return cachev1alpha1.MigrationVolume{Pvc: claim, Managed: claim == managedPVCName(nfsProvisioner)}, nil
+		}
+	}
+	return cachev1alpha1.MigrationVolume{}, fmt.Errorf("deployment %s has no export volume", deployment.Name)
+}
+
+// desiredVolume returns the volume the spec asks for, and whether it differs from the current one
+func (m *MigrationManager) desiredVolume(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, current cachev1alpha1.MigrationVolume) (cachev1alpha1.MigrationVolume, bool, error) {
+	spec := nfsProvisioner.Spec
+
+	if spec.HostPathDir != "" {
+		target := cachev1alpha1.MigrationVolume{HostPathDir: spec.HostPathDir}
+		return target, current.HostPathDir != spec.HostPathDir, nil
+	}
+
+	if spec.Pvc != "" {
+		target := cachev1alpha1.MigrationVolume{Pvc: spec.Pvc}
+		return target, current.Pvc != spec.Pvc, nil
+	}
+
+	// Dynamic PVC: migrate when the server does not run on a managed PVC of the requested StorageClass
+	target := cachev1alpha1.MigrationVolume{
+		Pvc:     fmt.Sprintf("%s-%d", defaults.Pvc, nfsProvisioner.Generation),
+		Managed: true,
+	}
+	if !current.Managed {
+		return target, true, nil
+	}
+
+	pvcFound := &corev1.PersistentVolumeClaim{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: current.Pvc, Namespace: nfsProvisioner.Namespace}, pvcFound); err != nil {
+		return target, false, err
+	}
+	scName := defaults.SCForNFSPvc
+	if spec.SCForNFSPvc != "" {
+		scName = spec.SCForNFSPvc
+	}
+	if pvcFound.Spec.StorageClassName != nil && *pvcFound.Spec.StorageClassName != scName {
+		return target, true, nil
+	}
+	return target, false, nil
+}
+
+// provisionTarget creates the target PVC, or checks that a user provided PVC exists
+func (m *MigrationManager) provisionTarget(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	target := nfsProvisioner.Status.Migration.Target
+	if target.Pvc == "" {
+		return nil
+	}
+
+	pvcFound := &corev1.PersistentVolumeClaim{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: target.Pvc, Namespace: nfsProvisioner.Namespace}, pvcFound)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	if !target.Managed {
+		m.Log.Error(err, "Specified PVC does not exist", "PVC.Name", target.Pvc)
+		return err
+	}
+
+	pvc := NewPVCManager(m.BaseResourceManager).buildPVC(nfsProvisioner, target.Pvc)
+	m.Log.Info("Creating the target PersistentVolumeClaim", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+	return m.Client.Create(ctx, pvc)
+}
+
+// scaleServer scales the NFS server and reports whether all of its pods are gone when scaling to zero
+func (m *MigrationManager) scaleServer(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, deployment *appsv1.Deployment, replicas int32) (bool, error) {
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != replicas {
+		deployment.Spec.Replicas = &replicas
+		m.Log.Info("Scaling the NFS server", "Deployment.Name", deployment.Name, "replicas", replicas)
+		if err := m.Client.Update(ctx, deployment); err != nil {
+			m.Log.Error(err, "Failed to scale the Deployment", "Deployment.Name", deployment.Name)
+			return false, err
+		}
+	}
+	if replicas > 0 {
+		return true, nil
+	}
+
+	// Only the pods of the Deployment count, Jobs on the export keep running meanwhile
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	pods := &corev1.PodList{}
+	if err := m.Client.List(ctx, pods, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return false, err
+	}
+	return len(pods.Items) == 0, nil
+}
+
+// copyData runs the rsync Job and reports its result
+func (m *MigrationManager) copyData(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (bool, bool, string, error) {
+	migration := nfsProvisioner.Status.Migration
+	jobName := m.copyJobName(nfsProvisioner)
+
+	job := &batchv1.Job{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: jobName, Namespace: nfsProvisioner.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = m.buildCopyJob(nfsProvisioner, jobName)
+		m.Log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := m.Client.Create(ctx, job); err != nil {
+			m.Log.Error(err, "Failed to create the migration Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return false, false, "", err
+		}
+		migration.JobName = job.Name
+		return false, false, "", nil
+	} else if err != nil {
+		return false, false, "", err
+	}
+
+	finished, succeeded := JobFinished(job)
+	if !finished || succeeded {
+		return finished, succeeded, "", nil
+	}
+	message, err := JobTerminationMessage(ctx, m.Client, job)
+	if err != nil {
+		message = err.Error()
+	}
+	return true, false, message, nil
+}
+
+// copyJobName returns the name of the rsync Job
+func (m *MigrationManager) copyJobName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	return truncateName("nfs-migration-" + nfsProvisioner.Name)
+}
+
+// buildCopyJob creates the rsync Job that copies the source volume to the target volume and verifies the checksums
+func (m *MigrationManager) buildCopyJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string) *batchv1.Job {
+	migration := nfsProvisioner.Status.Migration
+	backoffLimit := int32(1)
+
+	// The second rsync only lists differences, compared by checksum, that are left after the copy
+	script := `set -e
+rsync -aH --numeric-ids --delete /source/ /target/
+rsync -aHn --numeric-ids --delete --checksum --itemize-changes /source/ /target/ > /tmp/diff
+if [ -s /tmp/diff ]; then
+  echo "checksum verification failed:"
+  head -n 20 /tmp/diff
+  exit 1
+fi
+`
+	podSpec := corev1.PodSpec{
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: defaults.ServiceAccount,
+		Containers: []corev1.Container{{
+			Name:    "rsync",
+			Image:   defaults.RsyncImage,
+			Command: []string{"/bin/sh", "-c", script},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "source", MountPath: "/source", ReadOnly: true},
+				{Name: "target", MountPath: "/target"},
+			},
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		}},
+		Volumes: []corev1.Volume{
+			{Name: "source", VolumeSource: migrationVolumeSource(migration.Source)},
+			{Name: "target", VolumeSource: migrationVolumeSource(migration.Target)},
+		},
+	}
+
+	if migration.Source.HostPathDir != "" || migration.Target.HostPathDir != "" {
+		podSpec.NodeSelector = hostPathNodeSelector(nfsProvisioner)
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    jobLabels(nfsProvisioner.Name),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels(nfsProvisioner.Name),
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	applyOperatorConfig(&job.Spec.Template.Spec)
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// releaseSource deletes the source PVC when the operator created it. User provided volumes are kept.
+func (m *MigrationManager) releaseSource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	source := nfsProvisioner.Status.Migration.Source
+	if !source.Managed || source.Pvc == "" {
+		m.Log.Info("Keeping the user provided source volume", "source", source)
+		return nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: source.Pvc, Namespace: nfsProvisioner.Namespace}, pvc)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	m.Log.Info("Deleting the source PersistentVolumeClaim", "PersistentVolumeClaim.Name", source.Pvc)
+	return client.IgnoreNotFound(m.Client.Delete(ctx, pvc))
+}
+
+// migrationInProgress reports whether a storage migration has started and not finished
+func migrationInProgress(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	migration := nfsProvisioner.Status.Migration
+	return migration != nil && migration.Phase != cachev1alpha1.MigrationPhaseCompleted && migration.Phase != cachev1alpha1.MigrationPhaseFailed
+}
+
+// migrationVolumeSource returns the volume source of one side of a migration
+func migrationVolumeSource(v cachev1alpha1.MigrationVolume) corev1.VolumeSource {
+	if v.HostPathDir != "" {
+		hostPathType := corev1.HostPathDirectory
+		return corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: v.HostPathDir,
+				Type: &hostPathType,
+			}}
+	}
+	return corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: v.Pvc,
+		}}
+}
+
+// setExportVolume replaces the source of the export volume
+func setExportVolume(volumes []corev1.Volume, source corev1.VolumeSource) []corev1.Volume {
+	for i := range volumes {
+		if volumes[i].Name == "export-volume" {
+			volumes[i].VolumeSource = source
+		}
+	}
+	return volumes
+}
@@ -0,0 +1,131 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("MigrationManager", func() {
+	var (
+		ctx              context.Context
+		c                client.Client
+		nfsProvisioner   *cachev1alpha1.NFSProvisioner
+		migrationManager *MigrationManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		// The NFS server currently runs on a hostPath directory, and the spec asks for a dynamic PVC
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-nfs",
+				Namespace: "test-namespace",
+				UID:       "test-uid",
+			},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				SCForNFSPvc: "gp3",
+			},
+		}
+		running := &cachev1alpha1.NFSProvisioner{Spec: cachev1alpha1.NFSProvisionerSpec{HostPathDir: "/home/core/nfs"}}
+		running.ObjectMeta = nfsProvisioner.ObjectMeta
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(nfsProvisioner.DeepCopy()).Build()
+		base := NewBaseResourceManager(c, logr.Discard(), scheme)
+		Expect(c.Create(ctx, NewDeploymentManager(base).buildDeployment(running, "HOSTPATH"))).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "test-nfs", Namespace: "test-namespace"}, nfsProvisioner)).To(Succeed())
+
+		migrationManager = NewMigrationManager(base)
+	})
+
+	It("should wait for the acknowledgement", func() {
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Migration.Phase).To(Equal(cachev1alpha1.MigrationPhasePending))
+		Expect(nfsProvisioner.Status.Migration.Source.HostPathDir).To(Equal("/home/core/nfs"))
+		Expect(nfsProvisioner.Status.Migration.Target.Managed).To(BeTrue())
+		Expect(nfsProvisioner.Status.Conditions[0].Reason).To(Equal("AwaitingAcknowledgement"))
+
+		// PVCManager does not provision the new volume on its own
+		Expect(NewPVCManager(migrationManager.BaseResourceManager).EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pvcs := &corev1.PersistentVolumeClaimList{}
+		Expect(c.List(ctx, pvcs)).To(Succeed())
+		Expect(pvcs.Items).To(BeEmpty())
+	})
+
+	It("should copy the data and switch the Deployment once acknowledged", func() {
+		nfsProvisioner.Annotations = map[string]string{defaults.MigrationAcknowledgedAnnotation: "true"}
+		Expect(c.Update(ctx, nfsProvisioner)).To(Succeed())
+		// A finished job pod on the export does not hold the migration back
+		jobPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nfs-capacity-test-nfs-abcde", Namespace: "test-namespace", Labels: jobLabels("test-nfs")}}
+		jobPod.Labels[batchv1.JobNameLabel] = "nfs-capacity-test-nfs"
+		Expect(c.Create(ctx, jobPod)).To(Succeed())
+
+		// Pending -> Provisioning -> ScalingDown -> Copying
+		for i := 0; i < 4; i++ {
+			Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		}
+		Expect(nfsProvisioner.Status.Migration.Phase).To(Equal(cachev1alpha1.MigrationPhaseCopying))
+
+		target := nfsProvisioner.Status.Migration.Target.Pvc
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: target, Namespace: "test-namespace"}, pvc)).To(Succeed())
+		Expect(*pvc.Spec.StorageClassName).To(Equal("gp3"))
+
+		deploy := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, deploy)).To(Succeed())
+		Expect(*deploy.Spec.Replicas).To(Equal(int32(0)))
+
+		// The copy Job is created, then finishes
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: nfsProvisioner.Status.Migration.JobName, Namespace: "test-namespace"}, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Volumes[0].HostPath.Path).To(Equal("/home/core/nfs"))
+		Expect(job.Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName).To(Equal(target))
+		Expect(job.Spec.Template.Labels).NotTo(HaveKey("app"))
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+
+		// Copying -> Switching -> AwaitingConfirmation
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Migration.Phase).To(Equal(cachev1alpha1.MigrationPhaseAwaitingConfirmation))
+		Expect(nfsProvisioner.Status.Pvc).To(Equal(target))
+
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, deploy)).To(Succeed())
+		Expect(deploy.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(target))
+		Expect(*deploy.Spec.Replicas).To(Equal(int32(1)))
+
+		// The confirmation completes the migration and removes the annotations
+		nfsProvisioner.Annotations[defaults.MigrationConfirmedAnnotation] = "true"
+		Expect(c.Update(ctx, nfsProvisioner)).To(Succeed())
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Migration.Phase).To(Equal(cachev1alpha1.MigrationPhaseCompleted))
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.MigrationAcknowledgedAnnotation))
+
+		// Nothing is left to migrate
+		Expect(migrationManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Migration.Phase).To(Equal(cachev1alpha1.MigrationPhaseCompleted))
+	})
+})
@@ -0,0 +1,266 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// ConditionNodeSelected is the condition type that shows a node is available for the NFS server in hostPath mode
+const ConditionNodeSelected = "NodeSelected"
+
+// NodeSelectionManager checks that exactly one node can run the NFS server in hostPath mode.
+// In NodeName and Auto mode, it labels the selected node so that the NFS server and its Jobs are scheduled on it.
+type NodeSelectionManager struct {
+	BaseResourceManager
+}
+
+// NewNodeSelectionManager creates a new NodeSelectionManager
+func NewNodeSelectionManager(base BaseResourceManager) *NodeSelectionManager {
+	return &NodeSelectionManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *NodeSelectionManager) GetResourceName() string {
+	return "NodeSelection"
+}
+
+// EnsureResource selects the node of the NFS server and records it in status.selectedNode and the NodeSelected condition
+func (m *NodeSelectionManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	mode := nodeSelectionMode(nfsProvisioner)
+	if nfsProvisioner.Spec.HostPathDir == "" || mode == cachev1alpha1.NodeSelectionSelector {
+		if err := RemoveNodeLabels(ctx, m.Client, nfsProvisioner, ""); err != nil {
+			return err
+		}
+	}
+	if nfsProvisioner.Spec.HostPathDir == "" {
+		meta.RemoveStatusCondition(&nfsProvisioner.Status.Conditions, ConditionNodeSelected)
+		nfsProvisioner.Status.SelectedNode = ""
+		return nil
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := m.Client.List(ctx, nodes); err != nil {
+		return err
+	}
+
+	switch mode {
+	case cachev1alpha1.NodeSelectionNodeName:
+		return m.labelNode(ctx, nfsProvisioner, findNode(nodes.Items, nfsProvisioner.Spec.NodeSelection.NodeName))
+
+	case cachev1alpha1.NodeSelectionAuto:
+		name := nfsProvisioner.Status.SelectedNode
+		if name == "" {
+			// An NFS server that already runs keeps its node, because the data lives there
+			serverNode, err := m.serverNode(ctx, nfsProvisioner)
+			if err != nil {
+				return err
+			}
+			name = serverNode
+		}
+		if name == "" {
+			name = PickNode(nodes.Items, nfsProvisioner.Spec.NodeSelector)
+		}
+		if name == "" {
+			m.setNodeSelected(nfsProvisioner, metav1.ConditionFalse, "NoSchedulableNode", "No schedulable node is available for the NFS server", "")
+			return nil
+		}
+		return m.labelNode(ctx, nfsProvisioner, findNode(nodes.Items, name))
+	}
+
+	selector := hostPathNodeSelector(nfsProvisioner)
+	matched := []string{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if labels.SelectorFromSet(selector).Matches(labels.Set(node.Labels)) && nodeSchedulable(node) {
+			matched = append(matched, node.Name)
+		}
+	}
+	sort.Strings(matched)
+
+	switch len(matched) {
+	case 0:
+		m.setNodeSelected(nfsProvisioner, metav1.ConditionFalse, "NoMatchingNode",
+			fmt.Sprintf("No schedulable node matches nodeSelector %s", labels.SelectorFromSet(selector)), "")
+	case 1:
+		m.setNodeSelected(nfsProvisioner, metav1.ConditionTrue, "NodeMatched",
+			fmt.Sprintf("Node %s matches nodeSelector %s", matched[0], labels.SelectorFromSet(selector)), matched[0])
+	default:
+		// Every restart may land on another node and the export would be split across the hosts
+		serverNode, err := m.serverNode(ctx, nfsProvisioner)
+		if err != nil {
+			return err
+		}
+		message := fmt.Sprintf("Nodes %s match nodeSelector %s, so the NFS server may move to another node and lose its data", strings.Join(matched, ", "), labels.SelectorFromSet(selector))
+		m.Log.Info("Warning: "+message, "resource", m.GetResourceName())
+		m.setNodeSelected(nfsProvisioner, metav1.ConditionTrue, "MultipleNodesMatch", message, serverNode)
+	}
+	return nil
+}
+
+// labelNode labels the node for the NFS server and removes the label from the other nodes
+func (m *NodeSelectionManager) labelNode(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, node *corev1.Node) error {
+	if node == nil {
+		name := nfsProvisioner.Status.SelectedNode
+		if nodeSelectionMode(nfsProvisioner) == cachev1alpha1.NodeSelectionNodeName {
+			name = nfsProvisioner.Spec.NodeSelection.NodeName
+		}
+		m.setNodeSelected(nfsProvisioner, metav1.ConditionFalse, "NodeNotFound", fmt.Sprintf("Node %s does not exist", name), "")
+		return nil
+	}
+
+	if err := RemoveNodeLabels(ctx, m.Client, nfsProvisioner, node.Name); err != nil {
+		return err
+	}
+	key := SelectedNodeLabel(nfsProvisioner)
+	if node.Labels[key] != "true" {
+		m.Log.Info("Labelling the node for the NFS server", "Node.Name", node.Name, "Label", key)
+		patch := client.MergeFrom(node.DeepCopy())
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		node.Labels[key] = "true"
+		if err := m.Client.Patch(ctx, node, patch); err != nil {
+			m.Log.Error(err, "Failed to label the node", "Node.Name", node.Name)
+			return err
+		}
+	}
+
+	if !nodeSchedulable(node) {
+		m.setNodeSelected(nfsProvisioner, metav1.ConditionFalse, "NodeUnschedulable",
+			fmt.Sprintf("Node %s is not ready or not schedulable", node.Name), node.Name)
+		return nil
+	}
+	m.setNodeSelected(nfsProvisioner, metav1.ConditionTrue, "NodeLabelled",
+		fmt.Sprintf("Node %s is labelled %s", node.Name, key), node.Name)
+	return nil
+}
+
+// serverNode returns the node the NFS server pod runs on, or an empty string
+func (m *BaseResourceManager) serverNode(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, error) {
+	pods := &corev1.PodList{}
+	if err := m.Client.List(ctx, pods, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabels(serverLabels(nfsProvisioner))); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" && pod.Status.Phase == corev1.PodRunning {
+			return pod.Spec.NodeName, nil
+		}
+	}
+	return "", nil
+}
+
+// setNodeSelected records the selected node and the NodeSelected condition
+func (m *NodeSelectionManager) setNodeSelected(nfsProvisioner *cachev1alpha1.NFSProvisioner, status metav1.ConditionStatus, reason, message, node string) {
+	nfsProvisioner.Status.SelectedNode = node
+	setCondition(nfsProvisioner, ConditionNodeSelected, status, reason, message, nfsProvisioner.Generation)
+}
+
+// RemoveNodeLabels removes the label of the NFSProvisioner from every node except keep
+func RemoveNodeLabels(ctx context.Context, c client.Client, nfsProvisioner *cachev1alpha1.NFSProvisioner, keep string) error {
+	key := SelectedNodeLabel(nfsProvisioner)
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes, client.HasLabels{key}); err != nil {
+		return err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Name == keep {
+			continue
+		}
+		patch := client.MergeFrom(node.DeepCopy())
+		delete(node.Labels, key)
+		if err := c.Patch(ctx, node, patch); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelectedNodeLabel returns the label the operator puts on the node it selects for the NFSProvisioner
+func SelectedNodeLabel(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	return defaults.SelectedNodeLabelPrefix + truncateName(nfsProvisioner.Namespace+"."+nfsProvisioner.Name)
+}
+
+// PickNode returns the schedulable node matching the selector with the most allocatable ephemeral storage.
+// Ties are broken by the name, so that the choice is stable.
+func PickNode(nodes []corev1.Node, selector map[string]string) string {
+	best := ""
+	var bestStorage int64
+	for i := range nodes {
+		node := &nodes[i]
+		if !nodeSchedulable(node) || !labels.SelectorFromSet(selector).Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		storage := node.Status.Allocatable.StorageEphemeral().Value()
+		if best == "" || storage > bestStorage || (storage == bestStorage && node.Name < best) {
+			best, bestStorage = node.Name, storage
+		}
+	}
+	return best
+}
+
+// nodeSchedulable returns true when the node is ready and takes new pods
+func nodeSchedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// findNode returns the node with the name, or nil
+func findNode(nodes []corev1.Node, name string) *corev1.Node {
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i]
+		}
+	}
+	return nil
+}
+
+// nodeSelectionMode returns the node selection mode of the NFSProvisioner
+func nodeSelectionMode(nfsProvisioner *cachev1alpha1.NFSProvisioner) cachev1alpha1.NodeSelectionMode {
+	if nfsProvisioner.Spec.NodeSelection == nil || nfsProvisioner.Spec.NodeSelection.Mode == "" {
+		return cachev1alpha1.NodeSelectionSelector
+	}
+	return nfsProvisioner.Spec.NodeSelection.Mode
+}
+
+// hostPathNodeSelector returns the node selector of the pods that mount HostPathDir
+func hostPathNodeSelector(nfsProvisioner *cachev1alpha1.NFSProvisioner) map[string]string {
+	if nodeSelectionMode(nfsProvisioner) != cachev1alpha1.NodeSelectionSelector {
+		return map[string]string{SelectedNodeLabel(nfsProvisioner): "true"}
+	}
+	if nfsProvisioner.Spec.NodeSelector != nil {
+		return nfsProvisioner.Spec.NodeSelector
+	}
+	return defaults.NodeSelector
+}
+
+// nodeSelected returns false when no node can run the NFS server in hostPath mode
+func nodeSelected(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return !meta.IsStatusConditionFalse(nfsProvisioner.Status.Conditions, ConditionNodeSelected)
+}
@@ -0,0 +1,167 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("NodeSelectionManager", func() {
+	var (
+		ctx                  context.Context
+		scheme               *runtime.Scheme
+		nfsProvisioner       *cachev1alpha1.NFSProvisioner
+		nodeSelectionManager *NodeSelectionManager
+	)
+
+	newNode := func(name string, labels map[string]string, ready bool, storage string) *corev1.Node {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Status: corev1.NodeStatus{
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+				Allocatable: corev1.ResourceList{corev1.ResourceEphemeralStorage: resource.MustParse(storage)},
+			},
+		}
+	}
+
+	newManager := func(objects ...client.Object) client.Client {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		nodeSelectionManager = NewNodeSelectionManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+		return c
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid", Generation: 1},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				HostPathDir: "/home/core/nfs",
+			},
+		}
+	})
+
+	It("should block the NFS server when no schedulable node matches the selector", func() {
+		c := newManager(newNode("worker-0", defaults.NodeSelector, false, "10Gi"), newNode("worker-1", nil, true, "10Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionNodeSelected)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("NoMatchingNode"))
+		Expect(condition.Message).To(ContainSubstring("app=nfs-provisioner"))
+
+		deploymentManager := NewDeploymentManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+		Expect(deploymentManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		err := c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, &appsv1.Deployment{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should record the only matching node", func() {
+		newManager(newNode("worker-0", defaults.NodeSelector, true, "10Gi"), newNode("worker-1", nil, true, "10Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionNodeSelected)).To(BeTrue())
+		Expect(nfsProvisioner.Status.SelectedNode).To(Equal("worker-0"))
+	})
+
+	It("should warn when several nodes match the selector", func() {
+		newManager(newNode("worker-0", defaults.NodeSelector, true, "10Gi"), newNode("worker-1", defaults.NodeSelector, true, "10Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionNodeSelected)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal("MultipleNodesMatch"))
+		Expect(condition.Message).To(ContainSubstring("worker-0, worker-1"))
+	})
+
+	It("should label the node in NodeName mode and move the label from the previous node", func() {
+		label := SelectedNodeLabel(nfsProvisioner)
+		Expect(label).To(Equal("nfsprovisioner.jhouse.com/test-namespace.test-nfs"))
+
+		nfsProvisioner.Spec.NodeSelection = &cachev1alpha1.NodeSelection{Mode: cachev1alpha1.NodeSelectionNodeName, NodeName: "worker-1"}
+		c := newManager(newNode("worker-0", map[string]string{label: "true"}, true, "10Gi"), newNode("worker-1", nil, true, "10Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionNodeSelected)).To(BeTrue())
+		Expect(nfsProvisioner.Status.SelectedNode).To(Equal("worker-1"))
+		node := &corev1.Node{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "worker-1"}, node)).To(Succeed())
+		Expect(node.Labels).To(HaveKeyWithValue(label, "true"))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "worker-0"}, node)).To(Succeed())
+		Expect(node.Labels).NotTo(HaveKey(label))
+
+		Expect(hostPathNodeSelector(nfsProvisioner)).To(Equal(map[string]string{label: "true"}))
+
+		// The label is removed when the NFSProvisioner is deleted
+		Expect(RemoveNodeLabels(ctx, c, nfsProvisioner, "")).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "worker-1"}, node)).To(Succeed())
+		Expect(node.Labels).NotTo(HaveKey(label))
+	})
+
+	It("should report a missing node in NodeName mode", func() {
+		nfsProvisioner.Spec.NodeSelection = &cachev1alpha1.NodeSelection{Mode: cachev1alpha1.NodeSelectionNodeName, NodeName: "worker-9"}
+		newManager(newNode("worker-0", nil, true, "10Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionNodeSelected)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("NodeNotFound"))
+	})
+
+	It("should pick the node with the most storage in Auto mode and keep it", func() {
+		nfsProvisioner.Spec.NodeSelection = &cachev1alpha1.NodeSelection{Mode: cachev1alpha1.NodeSelectionAuto}
+		newManager(newNode("worker-0", nil, true, "10Gi"), newNode("worker-1", nil, true, "50Gi"), newNode("worker-2", nil, false, "100Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.SelectedNode).To(Equal("worker-1"))
+
+		// A node with more storage does not move the NFS server away from its data
+		newManager(newNode("worker-0", nil, true, "10Gi"), newNode("worker-1", nil, true, "50Gi"), newNode("worker-3", nil, true, "500Gi"))
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.SelectedNode).To(Equal("worker-1"))
+	})
+
+	It("should skip cordoned and tainted nodes when picking a node", func() {
+		cordoned := newNode("worker-0", nil, true, "100Gi")
+		cordoned.Spec.Unschedulable = true
+		tainted := newNode("worker-1", nil, true, "100Gi")
+		tainted.Spec.Taints = []corev1.Taint{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}}
+		nodes := []corev1.Node{*cordoned, *tainted, *newNode("worker-3", nil, true, "10Gi"), *newNode("worker-2", nil, true, "10Gi")}
+
+		Expect(PickNode(nodes, nil)).To(Equal("worker-2"))
+		Expect(PickNode(nodes, map[string]string{"disk": "ssd"})).To(BeEmpty())
+	})
+
+	It("should remove the condition when the export is not a hostPath", func() {
+		nfsProvisioner.Spec.HostPathDir = ""
+		nfsProvisioner.Status.SelectedNode = "worker-0"
+		meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{Type: ConditionNodeSelected, Status: metav1.ConditionTrue, Reason: "NodeMatched"})
+		newManager()
+		Expect(nodeSelectionManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionNodeSelected)).To(BeNil())
+		Expect(nfsProvisioner.Status.SelectedNode).To(BeEmpty())
+	})
+})
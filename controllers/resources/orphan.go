@@ -0,0 +1,415 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+	"github.com/jooho/nfs-provisioner-operator/csidriver"
+)
+
+// OrphanAuditManager finds directories on the export that no PV references and reclaims them when asked to
+type OrphanAuditManager struct {
+	BaseResourceManager
+}
+
+// NewOrphanAuditManager creates a new OrphanAuditManager
+func NewOrphanAuditManager(base BaseResourceManager) *OrphanAuditManager {
+	return &OrphanAuditManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *OrphanAuditManager) GetResourceName() string {
+	return "OrphanAudit"
+}
+
+// EnsureResource schedules audits, records the orphans of the last finished audit and starts their reclaim
+func (m *OrphanAuditManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+
+	// Nothing to do until an audit is configured, requested or recorded
+	if nfsProvisioner.Spec.OrphanAudit == nil && nfsProvisioner.Status.OrphanAudit == nil &&
+		nfsProvisioner.Annotations[defaults.OrphanAuditAnnotation] != "true" {
+		return nil
+	}
+
+	if err := m.ensureCronJob(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+
+	if nfsProvisioner.Annotations[defaults.OrphanAuditAnnotation] == "true" {
+		job := m.buildAuditJob(nfsProvisioner, fmt.Sprintf("%s-%d", OrphanAuditJobName(nfsProvisioner.Name), time.Now().Unix()))
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := m.Client.Create(ctx, job); err != nil {
+			log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return err
+		}
+		if err := removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.OrphanAuditAnnotation); err != nil {
+			return err
+		}
+	}
+
+	job, err := LatestSucceededJob(ctx, m.Client, nfsProvisioner.Namespace, client.MatchingLabels{defaults.OrphanAuditLabel: nfsProvisioner.Name})
+	if err != nil {
+		return err
+	}
+
+	status := nfsProvisioner.Status.OrphanAudit
+	if status == nil {
+		if job == nil {
+			return nil
+		}
+		status = &cachev1alpha1.OrphanAuditStatus{}
+		nfsProvisioner.Status.OrphanAudit = status
+	}
+	defer setOrphanMetrics(nfsProvisioner)
+
+	// Audits wait for a running reclaim so that they do not report directories that are being moved
+	if status.ReclaimJobName != "" {
+		return m.checkReclaim(ctx, nfsProvisioner)
+	}
+
+	if job == nil || status.LastAuditTime != nil && !job.Status.CompletionTime.After(status.LastAuditTime.Time) {
+		return nil
+	}
+
+	if err := m.recordAudit(ctx, nfsProvisioner, job); err != nil {
+		status.Message = err.Error()
+		return nil
+	}
+
+	return m.reclaim(ctx, nfsProvisioner)
+}
+
+// ensureCronJob keeps the scheduled audit in sync with spec.orphanAudit.schedule
+func (m *OrphanAuditManager) ensureCronJob(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	cronJob := m.buildAuditCronJob(nfsProvisioner)
+	cronJobFound := &batchv1.CronJob{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: cronJob.Name, Namespace: cronJob.Namespace}, cronJobFound)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	config := nfsProvisioner.Spec.OrphanAudit
+	if config == nil || config.Schedule == "" {
+		if exists {
+			log.Info("Deleting the CronJob", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+			return client.IgnoreNotFound(m.Client.Delete(ctx, cronJobFound))
+		}
+		return nil
+	}
+
+	if !exists {
+		log.Info("Creating a new CronJob", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+		if err := m.Client.Create(ctx, cronJob); err != nil {
+			log.Error(err, "Failed to create a CronJob for NFSProvisioner", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+			return err
+		}
+	} else if !equality.Semantic.DeepDerivative(cronJob.Spec, cronJobFound.Spec) {
+		log.Info("Updating the CronJob", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+		cronJobFound.Spec = cronJob.Spec
+		if err := m.Client.Update(ctx, cronJobFound); err != nil {
+			log.Error(err, "Failed to update the CronJob for NFSProvisioner", "CronJob.Namespace", cronJob.Namespace, "CronJob.Name", cronJob.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAudit compares the directories found by the audit Job with the PVs and records the orphans
+func (m *OrphanAuditManager) recordAudit(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, job *batchv1.Job) error {
+	status := nfsProvisioner.Status.OrphanAudit
+
+	if m.KubeClient == nil {
+		return fmt.Errorf("pod logs can not be read")
+	}
+	logs, err := JobLogs(ctx, m.Client, m.KubeClient, job)
+	if err != nil {
+		return err
+	}
+
+	referenced, err := m.referencedDirectories(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+
+	firstSeen := map[string]metav1.Time{}
+	for _, orphan := range status.Orphans {
+		firstSeen[orphan.Directory] = orphan.FirstSeen
+	}
+
+	auditTime := *job.Status.CompletionTime
+	orphans := []cachev1alpha1.OrphanedDirectory{}
+	total := int64(0)
+	for _, dir := range ParseImportScan(logs) {
+		if referenced[dir.Directory] || serverEntry(dir.Directory) {
+			continue
+		}
+		seen, ok := firstSeen[dir.Directory]
+		if !ok {
+			seen = auditTime
+		}
+		orphans = append(orphans, cachev1alpha1.OrphanedDirectory{Directory: dir.Directory, SizeBytes: dir.SizeBytes, FirstSeen: seen})
+		total += dir.SizeBytes
+	}
+
+	status.LastAuditTime = &auditTime
+	status.Orphans = orphans
+	status.OrphanedBytes = total
+	status.Message = ""
+	return nil
+}
+
+// referencedDirectories returns the top-level directories of the export that a PV mounts
+func (m *OrphanAuditManager) referencedDirectories(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (map[string]bool, error) {
+	pvs := &corev1.PersistentVolumeList{}
+	if err := m.Client.List(ctx, pvs); err != nil {
+		return nil, err
+	}
+	return ReferencedDirectories(pvs.Items, pvExportPath(nfsProvisioner)), nil
+}
+
+// ReferencedDirectories returns the top-level directories under exportPath that the PVs mount.
+// The NFS server of a PV is ignored on purpose: a changed Service IP must never turn used directories into orphans.
+// The volume handle of a PV of the CSI driver is its directory.
+func ReferencedDirectories(pvs []corev1.PersistentVolume, exportPath string) map[string]bool {
+	referenced := map[string]bool{}
+	for i := range pvs {
+		if dir := exportSubPath(&pvs[i], exportPath); dir != "" {
+			referenced[strings.SplitN(dir, "/", 2)[0]] = true
+		}
+	}
+	return referenced
+}
+
+// exportSubPath returns the path under exportPath that the PV mounts, or "" when the PV is not on the export
+func exportSubPath(pv *corev1.PersistentVolume, exportPath string) string {
+	if csi := pv.Spec.CSI; csi != nil && strings.HasSuffix(csi.Driver, "."+defaults.CSIDriverSuffix) &&
+		path.Clean(csi.VolumeAttributes[csidriver.ShareParameter]) == path.Clean(exportPath) {
+		return csi.VolumeHandle
+	}
+	if pv.Spec.NFS == nil {
+		return ""
+	}
+	prefix := strings.TrimSuffix(path.Clean(exportPath), "/") + "/"
+	p := path.Clean(pv.Spec.NFS.Path)
+	if !strings.HasPrefix(p, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+// reclaim starts a Job that archives or deletes the orphans whose grace period is over
+func (m *OrphanAuditManager) reclaim(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	config := nfsProvisioner.Spec.OrphanAudit
+	if config == nil || config.Action == "" || config.Action == cachev1alpha1.OrphanActionReport {
+		return nil
+	}
+
+	gracePeriod := defaults.OrphanGracePeriod
+	if config.GracePeriod != nil {
+		gracePeriod = config.GracePeriod.Duration
+	}
+
+	// The PVs are checked again, so that a directory a PV was created for since the audit, e.g. by an NFSImport, is kept
+	referenced, err := m.referencedDirectories(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+
+	status := nfsProvisioner.Status.OrphanAudit
+	due := []string{}
+	for _, orphan := range status.Orphans {
+		if referenced[orphan.Directory] || serverEntry(orphan.Directory) {
+			continue
+		}
+		if !orphan.FirstSeen.Add(gracePeriod).After(status.LastAuditTime.Time) {
+			due = append(due, orphan.Directory)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	job := BuildOrphanReclaimJob(nfsProvisioner, config.Action, due)
+	if err := ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme); err != nil {
+		return err
+	}
+
+	// A previous reclaim Job is replaced
+	oldJob := &batchv1.Job{}
+	err = m.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, oldJob)
+	if err == nil {
+		propagation := metav1.DeletePropagationBackground
+		if err := m.Client.Delete(ctx, oldJob, &client.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "Directories", due)
+	if err := m.Client.Create(ctx, job); err != nil {
+		log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return err
+	}
+
+	status.ReclaimJobName = job.Name
+	status.Reclaiming = due
+	return nil
+}
+
+// checkReclaim removes the reclaimed directories from the orphans once the reclaim Job finished
+func (m *OrphanAuditManager) checkReclaim(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.OrphanAudit
+
+	job := &batchv1.Job{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: status.ReclaimJobName, Namespace: nfsProvisioner.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if err == nil {
+		finished, succeeded := JobFinished(job)
+		if !finished {
+			return nil
+		}
+		if !succeeded {
+			message, _ := JobTerminationMessage(ctx, m.Client, job)
+			status.Message = "Reclaim failed: " + message
+		} else {
+			reclaimed := map[string]bool{}
+			for _, dir := range status.Reclaiming {
+				reclaimed[dir] = true
+			}
+			orphans := []cachev1alpha1.OrphanedDirectory{}
+			total := int64(0)
+			for _, orphan := range status.Orphans {
+				if reclaimed[orphan.Directory] {
+					continue
+				}
+				orphans = append(orphans, orphan)
+				total += orphan.SizeBytes
+			}
+			now := metav1.Now()
+			status.Orphans = orphans
+			status.OrphanedBytes = total
+			status.LastReclaimTime = &now
+			status.Message = ""
+		}
+	}
+
+	status.ReclaimJobName = ""
+	status.Reclaiming = nil
+	return nil
+}
+
+// buildAuditJob returns an audit Job owned by the NFSProvisioner
+func (m *OrphanAuditManager) buildAuditJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string) *batchv1.Job {
+	ttl := int32(24 * 60 * 60)
+	job := BuildExportJob(nfsProvisioner, truncateName(name), scanContainer())
+	job.Labels[defaults.OrphanAuditLabel] = nfsProvisioner.Name
+	job.Spec.TTLSecondsAfterFinished = &ttl
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// buildAuditCronJob returns the CronJob of the scheduled audits
+func (m *OrphanAuditManager) buildAuditCronJob(nfsProvisioner *cachev1alpha1.NFSProvisioner) *batchv1.CronJob {
+	job := BuildExportJob(nfsProvisioner, OrphanAuditJobName(nfsProvisioner.Name), scanContainer())
+	job.Labels[defaults.OrphanAuditLabel] = nfsProvisioner.Name
+
+	cronJobMeta := job.ObjectMeta
+	cronJobMeta.Name = truncateCronJobName(cronJobMeta.Name)
+
+	schedule := ""
+	if nfsProvisioner.Spec.OrphanAudit != nil {
+		schedule = nfsProvisioner.Spec.OrphanAudit.Schedule
+	}
+
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: cronJobMeta,
+		Spec: batchv1.CronJobSpec{
+			Schedule:          schedule,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: job.Labels},
+				Spec:       job.Spec,
+			},
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, cronJob, m.Scheme)
+	return cronJob
+}
+
+// OrphanAuditJobName returns the name of the audit CronJob, and the prefix of on-demand audit Jobs
+func OrphanAuditJobName(name string) string {
+	return "nfs-orphan-audit-" + name
+}
+
+// BuildOrphanReclaimJob returns a Job that archives or deletes the top-level directories of the export.
+// Directory names are passed through the environment, one per line, and anything that is not a plain name
+// or that belongs to the NFS server is skipped.
+func BuildOrphanReclaimJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, action cachev1alpha1.OrphanAction, dirs []string) *batchv1.Job {
+	command := `rm -rf -- "$d"`
+	if action == cachev1alpha1.OrphanActionArchive {
+		command = `mkdir -p ` + defaults.OrphanArchiveDir + ` && mv -- "$d" "` + defaults.OrphanArchiveDir + `/$d-$ts"`
+	}
+
+	script := `set -e
+cd ` + defaults.ExportPath + `
+ts=$(date +%Y%m%d%H%M%S)
+echo "$ORPHANS" | while IFS= read -r d; do
+  case "$d" in ""|.|..|*/*|` + strings.Join(serverEntries, "|") + `) continue ;; esac
+  [ -d "$d" ] || continue
+  echo "` + string(action) + ` $d"
+  ` + command + `
+done
+`
+	container := corev1.Container{
+		Name:    "reclaim",
+		Image:   defaults.UtilityImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{{
+			Name:  "ORPHANS",
+			Value: strings.Join(dirs, "\n"),
+		}},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	return BuildExportJob(nfsProvisioner, truncateName("nfs-orphan-reclaim-"+nfsProvisioner.Name), container)
+}
+
+// setOrphanMetrics exports the orphans of the last audit
+func setOrphanMetrics(nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	status := nfsProvisioner.Status.OrphanAudit
+	orphanedDirectories.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name).Set(float64(len(status.Orphans)))
+	orphanedBytes.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name).Set(float64(status.OrphanedBytes))
+}
@@ -0,0 +1,143 @@
+package resources
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("OrphanAuditManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		orphanManager  *OrphanAuditManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-nfs",
+				Namespace:   "test-namespace",
+				UID:         "test-uid",
+				Annotations: map[string]string{defaults.OrphanAuditAnnotation: "true"},
+			},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				OrphanAudit: &cachev1alpha1.OrphanAuditConfiguration{
+					Schedule: "0 3 * * *",
+					Action:   cachev1alpha1.OrphanActionArchive,
+				},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(nfsProvisioner.DeepCopy()).Build()
+		Expect(c.Get(ctx, types.NamespacedName{Name: "test-nfs", Namespace: "test-namespace"}, nfsProvisioner)).To(Succeed())
+
+		base := NewBaseResourceManager(c, logr.Discard(), scheme)
+		base.KubeClient = kubefake.NewSimpleClientset()
+		orphanManager = NewOrphanAuditManager(base)
+	})
+
+	It("should schedule audits and run one on demand", func() {
+		Expect(orphanManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		cronJob := &batchv1.CronJob{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-orphan-audit-test-nfs", Namespace: "test-namespace"}, cronJob)).To(Succeed())
+		Expect(cronJob.Spec.Schedule).To(Equal("0 3 * * *"))
+
+		jobs := &batchv1.JobList{}
+		Expect(c.List(ctx, jobs, client.MatchingLabels{defaults.OrphanAuditLabel: "test-nfs"})).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.OrphanAuditAnnotation))
+
+		// Removing the schedule removes the CronJob
+		nfsProvisioner.Spec.OrphanAudit.Schedule = ""
+		Expect(orphanManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-orphan-audit-test-nfs", Namespace: "test-namespace"}, cronJob)).NotTo(Succeed())
+	})
+
+	It("should find the directories that PVs reference", func() {
+		pvs := []corev1.PersistentVolume{
+			{Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{NFS: &corev1.NFSVolumeSource{Server: "172.30.0.1", Path: "/export/pvc-1"}}}},
+			{Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{NFS: &corev1.NFSVolumeSource{Server: "10.0.0.1", Path: "/export/shared/datasets"}}}},
+			{Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{NFS: &corev1.NFSVolumeSource{Server: "10.0.0.1", Path: "/other/pvc-2"}}}},
+			{Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:           "test-nfs.test-namespace.nfs.csi.jhouse.com",
+				VolumeHandle:     "app-data-pvc-3",
+				VolumeAttributes: map[string]string{"server": "172.30.0.1", "share": "/export"},
+			}}}},
+			{Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:       "ebs.csi.aws.com",
+				VolumeHandle: "vol-0123",
+			}}}},
+		}
+		referenced := ReferencedDirectories(pvs, defaults.ExportPath)
+		Expect(referenced).To(HaveLen(3))
+		Expect(referenced).To(HaveKey("pvc-1"))
+		Expect(referenced).To(HaveKey("shared"))
+		Expect(referenced).To(HaveKey("app-data-pvc-3"))
+	})
+
+	It("should reclaim orphans once the grace period is over", func() {
+		now := metav1.Now()
+		old := metav1.NewTime(now.Add(-8 * 24 * time.Hour))
+		nfsProvisioner.Status.OrphanAudit = &cachev1alpha1.OrphanAuditStatus{
+			LastAuditTime: &now,
+			Orphans: []cachev1alpha1.OrphanedDirectory{
+				{Directory: "pvc-old", SizeBytes: 1024, FirstSeen: old},
+				{Directory: "pvc-new", SizeBytes: 2048, FirstSeen: now},
+				{Directory: "pvc-imported", SizeBytes: 512, FirstSeen: old},
+				{Directory: "v4recov", SizeBytes: 4, FirstSeen: old},
+			},
+			OrphanedBytes: 3588,
+		}
+		// A PV was created for a directory since the audit
+		Expect(c.Create(ctx, &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-imported"},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{Server: "172.30.0.1", Path: "/export/pvc-imported"},
+			}},
+		})).To(Succeed())
+
+		Expect(orphanManager.reclaim(ctx, nfsProvisioner)).To(Succeed())
+		status := nfsProvisioner.Status.OrphanAudit
+		Expect(status.Reclaiming).To(ConsistOf("pvc-old"))
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: status.ReclaimJobName, Namespace: "test-namespace"}, job)).To(Succeed())
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "ORPHANS", Value: "pvc-old"}))
+		Expect(container.Command[2]).To(ContainSubstring("mv -- \"$d\""))
+		Expect(container.Command[2]).To(ContainSubstring("|v4recov|v4old|"))
+
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+
+		Expect(orphanManager.checkReclaim(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.ReclaimJobName).To(BeEmpty())
+		Expect(status.Orphans).To(HaveLen(3))
+		Expect(status.OrphanedBytes).To(Equal(int64(2564)))
+		Expect(status.LastReclaimTime).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,220 @@
+package resources
+
+import (
+	"context"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// PauseManager records the pause of a NFSProvisioner. During a maintenance, it stops the NFS server
+// and marks the StorageClass, and it starts the server again when the maintenance is over.
+// The other managers do not run while the NFSProvisioner is paused.
+type PauseManager struct {
+	BaseResourceManager
+}
+
+// NewPauseManager creates a new PauseManager
+func NewPauseManager(base BaseResourceManager) *PauseManager {
+	return &PauseManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *PauseManager) GetResourceName() string {
+	return "Pause"
+}
+
+// EnsureResource records the pause in the status and scales the NFS server for a maintenance
+func (m *PauseManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	mode := PauseMode(nfsProvisioner)
+	if mode == "" {
+		if nfsProvisioner.Status.Pause == nil {
+			return nil
+		}
+		// The server and the StorageClass are restored before the pause is forgotten, so that an error is retried
+		if err := m.startServer(ctx, nfsProvisioner); err != nil {
+			return err
+		}
+		if err := m.markStorageClass(ctx, nfsProvisioner, false, ""); err != nil {
+			return err
+		}
+		m.Log.Info("Resuming the NFSProvisioner", "NFSProvisioner.Name", nfsProvisioner.Name)
+		nfsProvisioner.Status.Pause = nil
+		return nil
+	}
+
+	status := nfsProvisioner.Status.Pause
+	if status == nil || status.Mode != mode {
+		m.Log.Info("Pausing the NFSProvisioner", "NFSProvisioner.Name", nfsProvisioner.Name, "Mode", mode)
+		now := metav1.Now()
+		status = &cachev1alpha1.PauseStatus{Mode: mode, Since: &now}
+	} else {
+		status = status.DeepCopy()
+	}
+	status.By = nfsProvisioner.Annotations[defaults.PausedByAnnotation]
+	status.Reason = ""
+	status.ServerStopped = false
+
+	// A plain pause leaves everything as it is, including a server stopped by a previous maintenance
+	if mode == cachev1alpha1.PauseModeMaintenance {
+		status.Reason = nfsProvisioner.Spec.Maintenance.Reason
+		stopped, err := m.stopServer(ctx, nfsProvisioner)
+		if err != nil {
+			return err
+		}
+		status.ServerStopped = stopped
+		if err := m.markStorageClass(ctx, nfsProvisioner, true, status.Reason); err != nil {
+			return err
+		}
+	}
+
+	nfsProvisioner.Status.Pause = status
+	return nil
+}
+
+// stopServer scales the Deployments of the NFSProvisioner to zero, keeping their replicas in an annotation.
+// It reports whether all of their pods are gone.
+func (m *PauseManager) stopServer(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (bool, error) {
+	deployments, err := m.ownedDeployments(ctx, nfsProvisioner)
+	if err != nil {
+		return false, err
+	}
+
+	stopped := true
+	for i := range deployments {
+		deployment := &deployments[i]
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 0 {
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			if deployment.Annotations == nil {
+				deployment.Annotations = map[string]string{}
+			}
+			deployment.Annotations[defaults.MaintenanceReplicasAnnotation] = strconv.Itoa(int(replicas))
+			zero := int32(0)
+			deployment.Spec.Replicas = &zero
+
+			m.Log.Info("Scaling down for the maintenance", "Deployment.Name", deployment.Name, "replicas", replicas)
+			if err := m.Client.Update(ctx, deployment); err != nil {
+				m.Log.Error(err, "Failed to scale the Deployment", "Deployment.Name", deployment.Name)
+				return false, err
+			}
+			stopped = false
+			continue
+		}
+		if deployment.Status.ObservedGeneration < deployment.Generation || deployment.Status.Replicas > 0 {
+			stopped = false
+		}
+	}
+	return stopped, nil
+}
+
+// startServer scales the Deployments stopped for a maintenance back to their replicas
+func (m *PauseManager) startServer(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	deployments, err := m.ownedDeployments(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+
+	for i := range deployments {
+		deployment := &deployments[i]
+		value, ok := deployment.Annotations[defaults.MaintenanceReplicasAnnotation]
+		if !ok {
+			continue
+		}
+		replicas, err := strconv.Atoi(value)
+		if err != nil || replicas < 0 {
+			replicas = 1
+		}
+		restored := int32(replicas)
+		deployment.Spec.Replicas = &restored
+		delete(deployment.Annotations, defaults.MaintenanceReplicasAnnotation)
+
+		m.Log.Info("Scaling up after the maintenance", "Deployment.Name", deployment.Name, "replicas", replicas)
+		if err := m.Client.Update(ctx, deployment); err != nil {
+			m.Log.Error(err, "Failed to scale the Deployment", "Deployment.Name", deployment.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// ownedDeployments returns the NFS server, the standby and the subdirectory provisioner of the NFSProvisioner
+func (m *PauseManager) ownedDeployments(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) ([]appsv1.Deployment, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := m.Client.List(ctx, deployments, client.InNamespace(nfsProvisioner.Namespace)); err != nil {
+		return nil, err
+	}
+
+	owned := []appsv1.Deployment{}
+	for _, deployment := range deployments.Items {
+		if metav1.IsControlledBy(&deployment, nfsProvisioner) {
+			owned = append(owned, deployment)
+		}
+	}
+	return owned, nil
+}
+
+// markStorageClass sets or removes defaults.MaintenanceAnnotation on the StorageClasses of the NFSProvisioner
+func (m *PauseManager) markStorageClass(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, maintenance bool, reason string) error {
+	for _, name := range StorageClassNames(nfsProvisioner) {
+		if err := m.markStorageClassNamed(ctx, name, maintenance, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markStorageClassNamed sets or removes defaults.MaintenanceAnnotation on one StorageClass
+func (m *PauseManager) markStorageClassNamed(ctx context.Context, name string, maintenance bool, reason string) error {
+	sc := &storagev1.StorageClass{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: name}, sc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	value, marked := sc.Annotations[defaults.MaintenanceAnnotation]
+	if marked == maintenance && value == reason {
+		return nil
+	}
+
+	updated := sc.DeepCopy()
+	if maintenance {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[defaults.MaintenanceAnnotation] = reason
+	} else {
+		delete(updated.Annotations, defaults.MaintenanceAnnotation)
+	}
+	if err := m.Client.Patch(ctx, updated, client.MergeFrom(sc)); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// PauseMode returns how the NFSProvisioner is paused, or "" when the operator manages its resources
+func PauseMode(nfsProvisioner *cachev1alpha1.NFSProvisioner) cachev1alpha1.PauseMode {
+	if MaintenanceEnabled(nfsProvisioner) {
+		return cachev1alpha1.PauseModeMaintenance
+	}
+	if nfsProvisioner.Spec.Paused || nfsProvisioner.Annotations[defaults.PausedAnnotation] == "true" {
+		return cachev1alpha1.PauseModePaused
+	}
+	return ""
+}
+
+// MaintenanceEnabled returns true when the NFS server is stopped and new PVCs are refused
+func MaintenanceEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Maintenance != nil && nfsProvisioner.Spec.Maintenance.Enabled
+}
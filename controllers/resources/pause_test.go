@@ -0,0 +1,144 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("PauseManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		pauseManager   *PauseManager
+	)
+
+	server := func() *appsv1.Deployment {
+		deployment := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, deployment)).To(Succeed())
+		return deployment
+	}
+
+	storageClass := func() *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: StorageClassName(nfsProvisioner)}, sc)).To(Succeed())
+		return sc
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec:       cachev1alpha1.NFSProvisionerSpec{SCForNFSPvc: "gp3-csi"},
+		}
+		replicas := int32(1)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: defaults.Deployment, Namespace: "test-namespace"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{Replicas: 1},
+		}
+		Expect(ctrl.SetControllerReference(nfsProvisioner, deployment, scheme)).To(Succeed())
+		// Deployments of other instances are not scaled
+		other := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "test-namespace"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			deployment, other,
+			&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: StorageClassName(nfsProvisioner)}},
+		).Build()
+		pauseManager = NewPauseManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+	})
+
+	It("should only record a pause", func() {
+		nfsProvisioner.Annotations = map[string]string{defaults.PausedAnnotation: "true", defaults.PausedByAnnotation: "alice"}
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause.Mode).To(Equal(cachev1alpha1.PauseModePaused))
+		Expect(nfsProvisioner.Status.Pause.By).To(Equal("alice"))
+		Expect(nfsProvisioner.Status.Pause.Since).NotTo(BeNil())
+		Expect(*server().Spec.Replicas).To(BeEquivalentTo(1))
+		Expect(storageClass().Annotations).NotTo(HaveKey(defaults.MaintenanceAnnotation))
+
+		delete(nfsProvisioner.Annotations, defaults.PausedAnnotation)
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause).To(BeNil())
+	})
+
+	It("should stop the server during a maintenance and start it again", func() {
+		nfsProvisioner.Spec.Maintenance = &cachev1alpha1.MaintenanceConfiguration{Enabled: true, Reason: "disk replacement"}
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause.Mode).To(Equal(cachev1alpha1.PauseModeMaintenance))
+		Expect(nfsProvisioner.Status.Pause.Reason).To(Equal("disk replacement"))
+		Expect(nfsProvisioner.Status.Pause.ServerStopped).To(BeFalse())
+		Expect(*server().Spec.Replicas).To(BeEquivalentTo(0))
+		Expect(server().Annotations).To(HaveKeyWithValue(defaults.MaintenanceReplicasAnnotation, "1"))
+		Expect(storageClass().Annotations).To(HaveKeyWithValue(defaults.MaintenanceAnnotation, "disk replacement"))
+
+		other := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "other", Namespace: "test-namespace"}, other)).To(Succeed())
+		Expect(*other.Spec.Replicas).To(BeEquivalentTo(1))
+
+		deployment := server()
+		deployment.Status.Replicas = 0
+		Expect(c.Status().Update(ctx, deployment)).To(Succeed())
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause.ServerStopped).To(BeTrue())
+
+		nfsProvisioner.Spec.Maintenance.Enabled = false
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause).To(BeNil())
+		Expect(*server().Spec.Replicas).To(BeEquivalentTo(1))
+		Expect(server().Annotations).NotTo(HaveKey(defaults.MaintenanceReplicasAnnotation))
+		Expect(storageClass().Annotations).NotTo(HaveKey(defaults.MaintenanceAnnotation))
+	})
+
+	It("should mark the StorageClass of the CSI driver during a maintenance", func() {
+		nfsProvisioner.Spec.CSI = &cachev1alpha1.CSIConfiguration{Enabled: true}
+		Expect(c.Create(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: csiStorageClassName(nfsProvisioner)}})).To(Succeed())
+		csiStorageClass := func() *storagev1.StorageClass {
+			sc := &storagev1.StorageClass{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: csiStorageClassName(nfsProvisioner)}, sc)).To(Succeed())
+			return sc
+		}
+
+		nfsProvisioner.Spec.Maintenance = &cachev1alpha1.MaintenanceConfiguration{Enabled: true, Reason: "disk replacement"}
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(storageClass().Annotations).To(HaveKeyWithValue(defaults.MaintenanceAnnotation, "disk replacement"))
+		Expect(csiStorageClass().Annotations).To(HaveKeyWithValue(defaults.MaintenanceAnnotation, "disk replacement"))
+
+		nfsProvisioner.Spec.Maintenance.Enabled = false
+		Expect(pauseManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(csiStorageClass().Annotations).NotTo(HaveKey(defaults.MaintenanceAnnotation))
+	})
+
+	It("should skip the other managers while paused", func() {
+		nfsProvisioner.Spec.Paused = true
+		set := &ResourceManagerSet{Pause: pauseManager}
+		// The other managers are nil, so they would panic if they ran
+		Expect(set.EnsureAllResources(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Pause).NotTo(BeNil())
+	})
+})
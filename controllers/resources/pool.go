@@ -0,0 +1,256 @@
+package resources
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+const (
+	// PoolNamespaceLabel and PoolNameLabel mark the namespaces, NFSProvisioners, StorageClass, PVs and Jobs that belong to a NFSProvisionerPool
+	PoolNamespaceLabel = "nfsprovisionerpool.jhouse.com/namespace"
+	PoolNameLabel      = "nfsprovisionerpool.jhouse.com/name"
+	// PoolServerLabel is the index of the server a namespace, PV or Job belongs to
+	PoolServerLabel = "nfsprovisionerpool.jhouse.com/server"
+	// PoolClaimLabel is the UID of the PVC a Job provisions or deletes the directory for
+	PoolClaimLabel = "nfsprovisionerpool.jhouse.com/claim"
+
+	// PoolServerAnnotation on a PVC places its PV on the server with the index
+	PoolServerAnnotation = "nfsprovisionerpool.jhouse.com/server"
+	// PoolColocateAnnotation on a PVC places its PV on the server of another PVC in the same namespace
+	PoolColocateAnnotation = "nfsprovisionerpool.jhouse.com/colocate-with"
+	// PoolDirectoryAnnotation on a PV is the directory relative to the export
+	PoolDirectoryAnnotation = "nfsprovisionerpool.jhouse.com/directory"
+)
+
+// PoolLabels returns the labels of the resources of the NFSProvisionerPool
+func PoolLabels(pool *cachev1alpha1.NFSProvisionerPool) map[string]string {
+	return map[string]string{PoolNamespaceLabel: pool.Namespace, PoolNameLabel: pool.Name}
+}
+
+// PoolServerLabels returns the labels of the resources of a server of the NFSProvisionerPool
+func PoolServerLabels(pool *cachev1alpha1.NFSProvisionerPool, index int32) map[string]string {
+	labels := PoolLabels(pool)
+	labels[PoolServerLabel] = strconv.Itoa(int(index))
+	return labels
+}
+
+// PoolServerNamespace returns the namespace of a server.
+// Every server has its own namespace, because the resources of a NFSProvisioner have fixed names.
+func PoolServerNamespace(pool *cachev1alpha1.NFSProvisionerPool, index int32) string {
+	suffix := "-" + strconv.Itoa(int(index))
+	prefix := strings.ReplaceAll(pool.Namespace+"-"+pool.Name, ".", "-")
+	if len(prefix) > 63-len(suffix) {
+		prefix = strings.TrimRight(prefix[:63-len(suffix)], "-")
+	}
+	return prefix + suffix
+}
+
+// PoolStorageClassName returns the StorageClass of the NFSProvisionerPool
+func PoolStorageClassName(pool *cachev1alpha1.NFSProvisionerPool) string {
+	if pool.Spec.StorageClassName != "" {
+		return pool.Spec.StorageClassName
+	}
+	return pool.Name
+}
+
+// PoolProvisionerName returns the provisioner of the StorageClass of the pool.
+// No provisioner pod serves it; the operator creates the PVs.
+func PoolProvisionerName(pool *cachev1alpha1.NFSProvisionerPool) string {
+	return "nfsprovisionerpool.jhouse.com/" + truncateName(pool.Namespace+"."+pool.Name)
+}
+
+// ValidatePoolTemplate checks that every server of the pool can get its own volume
+func ValidatePoolTemplate(pool *cachev1alpha1.NFSProvisionerPool) error {
+	template := pool.Spec.Template
+	if template.Mode == cachev1alpha1.ModeExternal {
+		return fmt.Errorf("template can not use External mode")
+	}
+	if template.HostPathDir != "" || template.Pvc != "" {
+		return fmt.Errorf("template can not set hostPathDir or pvc, every server gets its own PVC")
+	}
+	return nil
+}
+
+// BuildPoolServer returns the NFSProvisioner of a server of the pool.
+// Its StorageClass and provisioner name are unique, so that the servers do not provision each other's claims.
+func BuildPoolServer(pool *cachev1alpha1.NFSProvisionerPool, index int32) *cachev1alpha1.NFSProvisioner {
+	namespace := PoolServerNamespace(pool, index)
+	spec := *pool.Spec.Template.DeepCopy()
+	spec.SCForNFSProvisioner = truncateName(fmt.Sprintf("%s-%d", PoolStorageClassName(pool), index))
+	spec.ProvisionerName = "nfsprovisioner.jhouse.com/" + namespace
+	// The free space of each export is measured for the placement
+	if spec.Admission == nil {
+		spec.Admission = &cachev1alpha1.AdmissionConfiguration{}
+	}
+	spec.Admission.RequireFreeSpace = true
+
+	return &cachev1alpha1.NFSProvisioner{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pool.Name,
+			Namespace: namespace,
+			Labels:    PoolServerLabels(pool, index),
+		},
+		Spec: spec,
+	}
+}
+
+// BuildPoolNamespace returns the namespace of a server of the pool
+func BuildPoolNamespace(pool *cachev1alpha1.NFSProvisionerPool, index int32) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   PoolServerNamespace(pool, index),
+			Labels: PoolServerLabels(pool, index),
+		},
+	}
+}
+
+// BuildPoolStorageClass returns the StorageClass of the pool
+func BuildPoolStorageClass(pool *cachev1alpha1.NFSProvisionerPool) *storagev1.StorageClass {
+	reclaimPolicy := PoolReclaimPolicy(pool)
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   PoolStorageClassName(pool),
+			Labels: PoolLabels(pool),
+		},
+		Provisioner:   PoolProvisionerName(pool),
+		ReclaimPolicy: &reclaimPolicy,
+	}
+}
+
+// PoolReclaimPolicy returns the reclaim policy of the PVs of the pool
+func PoolReclaimPolicy(pool *cachev1alpha1.NFSProvisionerPool) corev1.PersistentVolumeReclaimPolicy {
+	if pool.Spec.ReclaimPolicy != "" {
+		return pool.Spec.ReclaimPolicy
+	}
+	return corev1.PersistentVolumeReclaimDelete
+}
+
+// PoolVolumeName returns the PV of the claim, which is named like a dynamically provisioned PV
+func PoolVolumeName(claim *corev1.PersistentVolumeClaim) string {
+	return "pvc-" + string(claim.UID)
+}
+
+// PoolVolumeDirectory returns the directory of the PV of the claim relative to the export, named like the provisioner names it
+func PoolVolumeDirectory(claim *corev1.PersistentVolumeClaim) string {
+	return fmt.Sprintf("%s-%s-%s", claim.Namespace, claim.Name, PoolVolumeName(claim))
+}
+
+// BuildPoolPV returns the PV of the claim on a server of the pool. It is pre-bound to the claim.
+func BuildPoolPV(pool *cachev1alpha1.NFSProvisionerPool, claim *corev1.PersistentVolumeClaim, index int32, server, exportPath string) *corev1.PersistentVolume {
+	name := PoolVolumeName(claim)
+	directory := PoolVolumeDirectory(claim)
+
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: PoolServerLabels(pool, index),
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": PoolProvisionerName(pool),
+				PoolDirectoryAnnotation:           directory,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: claim.Spec.Resources.Requests[corev1.ResourceStorage]},
+			AccessModes:                   claim.Spec.AccessModes,
+			PersistentVolumeReclaimPolicy: PoolReclaimPolicy(pool),
+			StorageClassName:              PoolStorageClassName(pool),
+			VolumeMode:                    claim.Spec.VolumeMode,
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  claim.Namespace,
+				Name:       claim.Name,
+				UID:        claim.UID,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: server,
+					Path:   path.Join(exportPath, directory),
+				},
+			},
+		},
+	}
+}
+
+// PoolJobName returns the Job that creates the directory of the claim, or removes it when remove is true
+func PoolJobName(claimUID string, remove bool) string {
+	if remove {
+		return truncateName("nfs-pool-delete-" + claimUID)
+	}
+	return truncateName("nfs-pool-" + claimUID)
+}
+
+// BuildPoolDirectoryJob returns a Job on the server that creates the directory of a PV, or removes it when remove is true.
+// The caller is responsible for setting the owner of the Job.
+func BuildPoolDirectoryJob(server *cachev1alpha1.NFSProvisioner, pool *cachev1alpha1.NFSProvisionerPool, index int32, claimUID, directory string, remove bool) *batchv1.Job {
+	script := `set -e
+dir="` + defaults.ExportPath + `/$DIRECTORY"
+[ -d "$dir" ] || { mkdir -p "$dir" && chmod 0777 "$dir"; }
+`
+	if remove {
+		script = `set -e
+rm -rf "` + defaults.ExportPath + `/$DIRECTORY"
+`
+	}
+
+	container := corev1.Container{
+		Name:                     "pool-directory",
+		Image:                    defaults.UtilityImage,
+		Command:                  []string{"/bin/sh", "-c", script},
+		Env:                      []corev1.EnvVar{{Name: "DIRECTORY", Value: path.Clean(directory)}},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	job := BuildExportJob(server, PoolJobName(claimUID, remove), container)
+	for key, value := range PoolServerLabels(pool, index) {
+		job.Labels[key] = value
+	}
+	job.Labels[PoolClaimLabel] = claimUID
+	return job
+}
+
+// PickPoolServer returns the ready server with the least utilisation.
+// The utilisation is the capacity of the PVs against the size of the export, or only the capacity of the PVs while a size is unknown.
+// Ties go to the server with fewer volumes, then to the lower index.
+func PickPoolServer(servers []cachev1alpha1.PoolServerStatus) (int32, bool) {
+	candidates := []cachev1alpha1.PoolServerStatus{}
+	sized := true
+	for _, server := range servers {
+		if !server.Ready || server.Draining {
+			continue
+		}
+		candidates = append(candidates, server)
+		sized = sized && server.TotalBytes > 0
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	utilisation := func(server cachev1alpha1.PoolServerStatus) float64 {
+		if sized {
+			return float64(server.RequestedBytes) / float64(server.TotalBytes)
+		}
+		return float64(server.RequestedBytes)
+	}
+	best := candidates[0]
+	for _, server := range candidates[1:] {
+		switch {
+		case utilisation(server) < utilisation(best):
+			best = server
+		case utilisation(server) > utilisation(best):
+		case server.Volumes < best.Volumes, server.Volumes == best.Volumes && server.Index < best.Index:
+			best = server
+		}
+	}
+	return best.Index, true
+}
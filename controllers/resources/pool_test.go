@@ -0,0 +1,138 @@
+package resources
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Pool", func() {
+	var pool *cachev1alpha1.NFSProvisionerPool
+
+	BeforeEach(func() {
+		pool = &cachev1alpha1.NFSProvisionerPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "ci", Namespace: "storage"},
+			Spec: cachev1alpha1.NFSProvisionerPoolSpec{
+				Servers:          3,
+				StorageClassName: "nfs-pool",
+				Template: cachev1alpha1.NFSProvisionerSpec{
+					SCForNFSPvc: "gp3-csi",
+					StorageSize: "100G",
+				},
+			},
+		}
+	})
+
+	It("should build a server with its own namespace, StorageClass and provisioner", func() {
+		server := BuildPoolServer(pool, 1)
+		Expect(server.Namespace).To(Equal("storage-ci-1"))
+		Expect(server.Name).To(Equal("ci"))
+		Expect(server.Labels).To(Equal(map[string]string{PoolNamespaceLabel: "storage", PoolNameLabel: "ci", PoolServerLabel: "1"}))
+		Expect(server.Spec.SCForNFSPvc).To(Equal("gp3-csi"))
+		Expect(server.Spec.SCForNFSProvisioner).To(Equal("nfs-pool-1"))
+		Expect(ProvisionerName(server)).To(Equal("nfsprovisioner.jhouse.com/storage-ci-1"))
+		Expect(server.Spec.Admission.RequireFreeSpace).To(BeTrue())
+
+		// The template is not changed
+		Expect(pool.Spec.Template.Admission).To(BeNil())
+	})
+
+	It("should keep the index in a long namespace", func() {
+		pool.Name = strings.Repeat("a", 70) + ".b"
+		namespace := PoolServerNamespace(pool, 12)
+		Expect(len(namespace)).To(BeNumerically("<=", 63))
+		Expect(namespace).To(HaveSuffix("a-12"))
+		Expect(namespace).NotTo(ContainSubstring("."))
+	})
+
+	It("should reject a template without its own volume", func() {
+		Expect(ValidatePoolTemplate(pool)).To(Succeed())
+
+		pool.Spec.Template.HostPathDir = "/home/core/nfs"
+		Expect(ValidatePoolTemplate(pool)).NotTo(Succeed())
+
+		pool.Spec.Template.HostPathDir = ""
+		pool.Spec.Template.Mode = cachev1alpha1.ModeExternal
+		Expect(ValidatePoolTemplate(pool)).NotTo(Succeed())
+	})
+
+	It("should build a PV that is pre-bound to the claim", func() {
+		claim := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "ci-jobs", UID: "1234"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")},
+				},
+			},
+		}
+
+		pv := BuildPoolPV(pool, claim, 2, "10.0.0.12", defaults.ExportPath)
+		Expect(pv.Name).To(Equal("pvc-1234"))
+		Expect(pv.Labels[PoolServerLabel]).To(Equal("2"))
+		Expect(pv.Spec.StorageClassName).To(Equal("nfs-pool"))
+		Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(corev1.PersistentVolumeReclaimDelete))
+		Expect(pv.Spec.ClaimRef.UID).To(BeEquivalentTo("1234"))
+		Expect(pv.Spec.NFS.Server).To(Equal("10.0.0.12"))
+		Expect(pv.Spec.NFS.Path).To(Equal("/export/ci-jobs-data-pvc-1234"))
+		Expect(pv.Annotations[PoolDirectoryAnnotation]).To(Equal("ci-jobs-data-pvc-1234"))
+		Expect(pv.Spec.Capacity.Storage().String()).To(Equal("5Gi"))
+	})
+
+	It("should build the directory Job on the server", func() {
+		server := BuildPoolServer(pool, 0)
+		job := BuildPoolDirectoryJob(server, pool, 0, "1234", "ci-jobs-data-pvc-1234", false)
+		Expect(job.Name).To(Equal("nfs-pool-1234"))
+		Expect(job.Namespace).To(Equal("storage-ci-0"))
+		Expect(job.Labels).To(HaveKeyWithValue(PoolClaimLabel, "1234"))
+		Expect(job.Labels).To(HaveKeyWithValue(PoolServerLabel, "0"))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "DIRECTORY", Value: "ci-jobs-data-pvc-1234"}))
+
+		job = BuildPoolDirectoryJob(server, pool, 0, "1234", "ci-jobs-data-pvc-1234", true)
+		Expect(job.Name).To(Equal("nfs-pool-delete-1234"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("rm -rf"))
+	})
+
+	Describe("PickPoolServer", func() {
+		It("should pick the least utilised ready server", func() {
+			index, ok := PickPoolServer([]cachev1alpha1.PoolServerStatus{
+				{Index: 0, Ready: true, RequestedBytes: 60, TotalBytes: 100},
+				{Index: 1, Ready: true, RequestedBytes: 80, TotalBytes: 200},
+				{Index: 2, Ready: false, RequestedBytes: 0, TotalBytes: 100},
+				{Index: 3, Ready: true, Draining: true, RequestedBytes: 0, TotalBytes: 100},
+			})
+			Expect(ok).To(BeTrue())
+			Expect(index).To(BeEquivalentTo(1))
+		})
+
+		It("should compare the requested bytes while a size is unknown", func() {
+			index, ok := PickPoolServer([]cachev1alpha1.PoolServerStatus{
+				{Index: 0, Ready: true, RequestedBytes: 60, TotalBytes: 1000},
+				{Index: 1, Ready: true, RequestedBytes: 50},
+			})
+			Expect(ok).To(BeTrue())
+			Expect(index).To(BeEquivalentTo(1))
+		})
+
+		It("should break ties by the number of volumes and the index", func() {
+			index, _ := PickPoolServer([]cachev1alpha1.PoolServerStatus{
+				{Index: 0, Ready: true, Volumes: 2},
+				{Index: 1, Ready: true, Volumes: 1},
+				{Index: 2, Ready: true, Volumes: 1},
+			})
+			Expect(index).To(BeEquivalentTo(1))
+		})
+
+		It("should report that no server is ready", func() {
+			_, ok := PickPoolServer([]cachev1alpha1.PoolServerStatus{{Index: 0}})
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
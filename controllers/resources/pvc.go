@@ -41,8 +41,14 @@ func (m *PVCManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1
 		return nil
 	}
 
+	// The migration manager provisions the target volume itself
+	if migrationInProgress(nfsProvisioner) {
+		log.Info("Skipping PVC reconciliation - storage migration in progress")
+		return nil
+	}
+
 	// Determine PVC name
-	pvcName := defaults.Pvc
+	pvcName := managedPVCName(nfsProvisioner)
 	if nfsProvisioner.Spec.Pvc != "" {
 		pvcName = nfsProvisioner.Spec.Pvc
 	}
@@ -55,7 +61,7 @@ func (m *PVCManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1
 		if errors.IsNotFound(err) {
 			// Only create PVC if we're supposed to manage it (not using existing PVC)
 			if nfsProvisioner.Spec.Pvc == "" {
-				pvc := m.buildPVC(nfsProvisioner)
+				pvc := m.buildPVC(nfsProvisioner, pvcName)
 				log.Info("Creating a new PersistentVolumeClaim", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
 
 				if err := m.Client.Create(ctx, pvc); err != nil {
@@ -76,7 +82,7 @@ func (m *PVCManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1
 }
 
 // buildPVC creates a new PersistentVolumeClaim object
-func (m *PVCManager) buildPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *corev1.PersistentVolumeClaim {
+func (m *PVCManager) buildPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner, name string) *corev1.PersistentVolumeClaim {
 	// Determine storage class name
 	scName := defaults.SCForNFSPvc
 	if nfsProvisioner.Spec.SCForNFSPvc != "" {
@@ -91,7 +97,7 @@ func (m *PVCManager) buildPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *cor
 
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      defaults.Pvc,
+			Name:      name,
 			Namespace: nfsProvisioner.Namespace,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
@@ -109,3 +115,11 @@ func (m *PVCManager) buildPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *cor
 	ctrl.SetControllerReference(nfsProvisioner, pvc, m.Scheme)
 	return pvc
 }
+
+// managedPVCName returns the name of the operator managed PVC that backs the export
+func managedPVCName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if nfsProvisioner.Status.Pvc != "" {
+		return nfsProvisioner.Status.Pvc
+	}
+	return defaults.Pvc
+}
@@ -0,0 +1,108 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// ConditionQuotaReady is the condition type that shows the export supports XFS project quotas
+const ConditionQuotaReady = "QuotaReady"
+
+// QuotaManager verifies that the export supports XFS project quotas.
+// The usage of the volumes is measured by the scan of the UsageReportManager.
+type QuotaManager struct {
+	BaseResourceManager
+}
+
+// NewQuotaManager creates a new QuotaManager
+func NewQuotaManager(base BaseResourceManager) *QuotaManager {
+	return &QuotaManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *QuotaManager) GetResourceName() string {
+	return "Quota"
+}
+
+// EnsureResource checks the export filesystem once per generation
+func (m *QuotaManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	if !quotaEnabled(nfsProvisioner) {
+		meta.RemoveStatusCondition(&nfsProvisioner.Status.Conditions, ConditionQuotaReady)
+		nfsProvisioner.Status.Quota = nil
+		return nil
+	}
+
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+	return m.checkFilesystem(ctx, nfsProvisioner)
+}
+
+// checkFilesystem runs the preflight Job for the current generation and records its result in the QuotaReady condition
+func (m *QuotaManager) checkFilesystem(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	return m.runGenerationCheck(ctx, nfsProvisioner, generationCheck{
+		Condition:       ConditionQuotaReady,
+		PendingReason:   "Checking",
+		PendingMessage:  "Checking that the export is XFS mounted with prjquota",
+		SucceededReason: "Supported",
+		FailedReason:    "Unsupported",
+	}, m.buildPreflightJob)
+}
+
+// buildPreflightJob returns the Job that checks the export filesystem for the current generation
+func (m *QuotaManager) buildPreflightJob(nfsProvisioner *cachev1alpha1.NFSProvisioner) *batchv1.Job {
+	script := `fs=$(stat -f -c %T ` + defaults.ExportPath + `)
+opts=$(awk '$2 == "` + defaults.ExportPath + `" {print $4}' /proc/mounts | tail -n 1)
+if [ "$fs" != "xfs" ]; then
+  echo "The export is on $fs, not xfs" > /dev/termination-log
+  exit 1
+fi
+case ",$opts," in
+  *,prjquota,*|*,pquota,*) echo "The export is xfs mounted with $opts" > /dev/termination-log ;;
+  *) echo "The export is xfs but not mounted with prjquota: $opts" > /dev/termination-log; exit 1 ;;
+esac
+`
+	container := corev1.Container{
+		Name:    "quota-check",
+		Image:   defaults.UtilityImage,
+		Command: []string{"/bin/sh", "-c", script},
+	}
+
+	ttl := int32(60 * 60)
+	job := BuildExportJob(nfsProvisioner, truncateName(fmt.Sprintf("nfs-quota-check-%s-%d", nfsProvisioner.Name, nfsProvisioner.Generation)), container)
+	job.Spec.TTLSecondsAfterFinished = &ttl
+	backoffLimit := int32(0)
+	job.Spec.BackoffLimit = &backoffLimit
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// quotaEnabled returns true when the NFSProvisioner asks for XFS project quotas
+func quotaEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Quota != nil && nfsProvisioner.Spec.Quota.Enabled
+}
+
+// quotaReady returns true when the provisioner can be started with XFS project quotas
+func quotaReady(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return quotaEnabled(nfsProvisioner) && meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionQuotaReady)
+}
+
+// quotaUsageInterval returns how often the usage of the volumes is measured for the quotas
+func quotaUsageInterval(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if nfsProvisioner.Spec.Quota.UsageInterval != nil {
+		return nfsProvisioner.Spec.Quota.UsageInterval.Duration
+	}
+	return defaults.QuotaUsageInterval
+}
@@ -0,0 +1,130 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("QuotaManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		base           BaseResourceManager
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		quotaManager   *QuotaManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "test-nfs",
+				Namespace:  "test-namespace",
+				UID:        "test-uid",
+				Generation: 2,
+			},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				Pvc:   "test-pvc",
+				Quota: &cachev1alpha1.QuotaConfiguration{Enabled: true},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		base = NewBaseResourceManager(c, logr.Discard(), scheme)
+		base.KubeClient = kubefake.NewSimpleClientset()
+		quotaManager = NewQuotaManager(base)
+	})
+
+	finishPreflight := func(condition batchv1.JobConditionType) {
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-quota-check-test-nfs-2", Namespace: "test-namespace"}, job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+		Expect(quotaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+	}
+
+	It("should enable quotas on the provisioner once the export is checked", func() {
+		Expect(quotaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionQuotaReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionUnknown))
+
+		deploymentManager := NewDeploymentManager(base)
+		container := deploymentManager.buildDeployment(nfsProvisioner, "PVC").Spec.Template.Spec.Containers[0]
+		Expect(container.Args).To(Equal([]string{"-provisioner=" + defaults.Provisioner}))
+
+		finishPreflight(batchv1.JobComplete)
+		Expect(meta.IsStatusConditionTrue(nfsProvisioner.Status.Conditions, ConditionQuotaReady)).To(BeTrue())
+
+		container = deploymentManager.buildDeployment(nfsProvisioner, "PVC").Spec.Template.Spec.Containers[0]
+		Expect(container.Args).To(ContainElement("-enable-xfs-quota=true"))
+		Expect(container.SecurityContext.Capabilities.Add).To(ContainElement(corev1.Capability("SYS_ADMIN")))
+
+		// The usage of the volumes is measured right away by the scan of the usage report
+		Expect(NewUsageReportManager(base).EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		jobs := &batchv1.JobList{}
+		Expect(c.List(ctx, jobs, client.MatchingLabels{defaults.UsageScanLabel: "test-nfs"})).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(nfsProvisioner.Status.Usage).To(BeNil())
+	})
+
+	It("should not enable quotas when the export does not support them", func() {
+		Expect(quotaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		finishPreflight(batchv1.JobFailed)
+
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionQuotaReady)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+
+		container := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC").Spec.Template.Spec.Containers[0]
+		Expect(container.Args).NotTo(ContainElement("-enable-xfs-quota=true"))
+	})
+
+	It("should remove the condition when quotas are disabled", func() {
+		Expect(quotaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		nfsProvisioner.Spec.Quota.Enabled = false
+		Expect(quotaManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionQuotaReady)).To(BeNil())
+	})
+
+	It("should match the measured directories with the PVs", func() {
+		pvs := []corev1.PersistentVolume{{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					NFS: &corev1.NFSVolumeSource{Server: "10.0.0.1", Path: "/export/pvc-1"},
+				},
+			},
+		}}
+		dirs := ParseImportScan("NFSIMPORT 2048 - pvc-1\nNFSIMPORT 10 - unknown\n")
+
+		usages := VolumeUsages(dirs, pvs, defaults.ExportPath)
+		Expect(usages).To(HaveLen(1))
+		Expect(usages[0].PersistentVolume).To(Equal("pvc-1"))
+		Expect(usages[0].UsedBytes).To(Equal(int64(2048 * 1024)))
+		Expect(usages[0].CapacityBytes).To(Equal(int64(1024 * 1024 * 1024)))
+	})
+})
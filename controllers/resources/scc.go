@@ -34,7 +34,7 @@ func (m *SCCManager) GetResourceName() string {
 }
 
 // isSCCCRDAvailable checks if SecurityContextConstraints CRD exists in the cluster
-func (m *SCCManager) isSCCCRDAvailable(ctx context.Context) bool {
+func (m *BaseResourceManager) isSCCCRDAvailable(ctx context.Context) bool {
 	crd := &apiextensionsv1.CustomResourceDefinition{}
 	err := m.Client.Get(ctx, types.NamespacedName{Name: "securitycontextconstraints.security.openshift.io"}, crd)
 	return err == nil
@@ -44,6 +44,12 @@ func (m *SCCManager) isSCCCRDAvailable(ctx context.Context) bool {
 func (m *SCCManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
 	log := m.Log.WithValues("resource", m.GetResourceName())
 
+	// The go NFS server runs under the restricted SCC, unless the CSI node plugin needs this one
+	if goServer(nfsProvisioner) && !csiEnabled(nfsProvisioner) {
+		log.Info("Skipping SCC - the go NFS server runs under the restricted SCC")
+		return nil
+	}
+
 	// Check if SecurityContextConstraints CRD is available in the cluster
 	if !m.isSCCCRDAvailable(ctx) {
 		log.Info("SecurityContextConstraints CRD is not available in cluster, skipping SCC creation")
@@ -79,9 +85,36 @@ func (m *SCCManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1
 		}
 	}
 
-	if !userExists {
-		sccFound.Users = append(sccFound.Users, userToAdd)
-		log.Info("Adding user to existing SecurityContextConstraints", "user", userToAdd)
+	// XFS project quotas need SYS_ADMIN, which is only allowed once a NFSProvisioner asks for it
+	sccChanged := false
+	for _, capability := range sccAllowedCapabilities(nfsProvisioner) {
+		if !containsCapability(sccFound.AllowedCapabilities, capability) {
+			sccFound.AllowedCapabilities = append(sccFound.AllowedCapabilities, capability)
+			sccChanged = true
+		}
+	}
+
+	// The hostPath preparation Job runs privileged to label the directory for SELinux, and the CSI node plugin
+	// mounts the volumes for the kubelet from the network of the node. Both are revoked once nothing needs them.
+	// The SCC is shared by every NFSProvisioner of the cluster, so a flag is only revoked when none of them needs it.
+	privileged, hostNetwork, err := m.sccPrivileges(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+	if sccFound.AllowPrivilegedContainer != privileged {
+		sccFound.AllowPrivilegedContainer = privileged
+		sccChanged = true
+	}
+	if sccFound.AllowHostNetwork != hostNetwork {
+		sccFound.AllowHostNetwork = hostNetwork
+		sccChanged = true
+	}
+
+	if !userExists || sccChanged {
+		if !userExists {
+			sccFound.Users = append(sccFound.Users, userToAdd)
+			log.Info("Adding user to existing SecurityContextConstraints", "user", userToAdd)
+		}
 
 		if err := m.Client.Update(ctx, sccFound); err != nil {
 			log.Error(err, "Failed to update SecurityContextConstraints", "SecurityContextConstraints.Name", sccFound.Name)
@@ -100,11 +133,11 @@ func (m *SCCManager) buildSCC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *sec
 		},
 		AllowHostDirVolumePlugin: true,
 		AllowHostIPC:             false,
-		AllowHostNetwork:         false,
+		AllowHostNetwork:         csiEnabled(nfsProvisioner),
 		AllowHostPID:             false,
 		AllowHostPorts:           false,
-		AllowPrivilegedContainer: false,
-		AllowedCapabilities:      []corev1.Capability{"DAC_READ_SEARCH", "SYS_RESOURCE"},
+		AllowPrivilegedContainer: sccAllowPrivileged(nfsProvisioner),
+		AllowedCapabilities:      sccAllowedCapabilities(nfsProvisioner),
 		DefaultAddCapabilities:   nil,
 		Priority:                 nil,
 		ReadOnlyRootFilesystem:   false,
@@ -136,3 +169,47 @@ func (m *SCCManager) buildSCC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *sec
 	ctrl.SetControllerReference(nfsProvisioner, scc, m.Scheme)
 	return scc
 }
+
+// sccAllowPrivileged returns whether the NFSProvisioner runs privileged pods with the ServiceAccount of the SCC
+func sccAllowPrivileged(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return hostPathPreparationEnabled(nfsProvisioner) || csiEnabled(nfsProvisioner)
+}
+
+// sccPrivileges returns whether any NFSProvisioner of the cluster needs privileged containers and the host network
+func (m *SCCManager) sccPrivileges(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (privileged bool, hostNetwork bool, err error) {
+	nfsProvisioners := &cachev1alpha1.NFSProvisionerList{}
+	if err := m.Client.List(ctx, nfsProvisioners); err != nil {
+		return false, false, err
+	}
+
+	// The NFSProvisioner being reconciled may be newer than the listed one
+	privileged, hostNetwork = sccAllowPrivileged(nfsProvisioner), csiEnabled(nfsProvisioner)
+	for i := range nfsProvisioners.Items {
+		other := &nfsProvisioners.Items[i]
+		if other.Namespace == nfsProvisioner.Namespace && other.Name == nfsProvisioner.Name {
+			continue
+		}
+		privileged = privileged || sccAllowPrivileged(other)
+		hostNetwork = hostNetwork || csiEnabled(other)
+	}
+	return privileged, hostNetwork, nil
+}
+
+// sccAllowedCapabilities returns the capabilities the NFS server of the NFSProvisioner needs
+func sccAllowedCapabilities(nfsProvisioner *cachev1alpha1.NFSProvisioner) []corev1.Capability {
+	capabilities := []corev1.Capability{"DAC_READ_SEARCH", "SYS_RESOURCE"}
+	if quotaEnabled(nfsProvisioner) {
+		capabilities = append(capabilities, "SYS_ADMIN")
+	}
+	return capabilities
+}
+
+// containsCapability returns true when the capability is in the list
+func containsCapability(capabilities []corev1.Capability, capability corev1.Capability) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
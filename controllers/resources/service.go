@@ -4,6 +4,7 @@ import (
 	"context"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -30,7 +31,7 @@ func (m *ServiceManager) GetResourceName() string {
 	return "Service"
 }
 
-// EnsureResource ensures the Service exists
+// EnsureResource ensures the Service exists and selects the active NFS server
 func (m *ServiceManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
 	log := m.Log.WithValues("resource", m.GetResourceName())
 
@@ -47,10 +48,21 @@ func (m *ServiceManager) EnsureResource(ctx context.Context, nfsProvisioner *cac
 			log.Error(err, "Failed to create a Service for NFSProvisioner", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
 			return err
 		}
+		return nil
 	} else if err != nil {
 		return err
 	}
 
+	// The selector moves to the standby after a failover
+	if selector := serverLabels(nfsProvisioner); !equality.Semantic.DeepEqual(svcFound.Spec.Selector, selector) {
+		log.Info("Updating the Service selector", "Service.Namespace", svcFound.Namespace, "Service.Name", svcFound.Name, "Selector", selector)
+		svcFound.Spec.Selector = selector
+		if err = m.Client.Update(ctx, svcFound); err != nil {
+			log.Error(err, "Failed to update the Service for NFSProvisioner", "Service.Namespace", svcFound.Namespace, "Service.Name", svcFound.Name)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -96,7 +108,7 @@ func (m *ServiceManager) buildService(nfsProvisioner *cachev1alpha1.NFSProvision
 					Port:     662,
 					Protocol: "UDP"},
 			},
-			Selector: ls,
+			Selector: serverLabels(nfsProvisioner),
 		},
 	}
 
@@ -0,0 +1,186 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+const (
+	// ShareNamespaceLabel and ShareNameLabel mark the PVs and PVCs that belong to a NFSShare
+	ShareNamespaceLabel = "nfsshare.jhouse.com/namespace"
+	ShareNameLabel      = "nfsshare.jhouse.com/name"
+)
+
+// NFSServerAddress returns the server and the exported path that PVs use to mount the export.
+// The internal server is reached through the ClusterIP of its Service because kubelet does not resolve cluster DNS names.
+func NFSServerAddress(ctx context.Context, c client.Client, nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, string, error) {
+	if isExternalMode(nfsProvisioner) {
+		if nfsProvisioner.Spec.External == nil {
+			return "", "", fmt.Errorf("external NFS server is not set")
+		}
+		return nfsProvisioner.Spec.External.Server, nfsProvisioner.Spec.External.Path, nil
+	}
+
+	svc := &corev1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Name: defaults.Service, Namespace: nfsProvisioner.Namespace}, svc); err != nil {
+		return "", "", err
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", "", fmt.Errorf("service %s has no cluster IP", defaults.Service)
+	}
+	return svc.Spec.ClusterIP, defaults.ExportPath, nil
+}
+
+// ValidateSharePath checks that the shared directory is a subdirectory of the export
+func ValidateSharePath(p string) error {
+	if p == "" || path.Clean(p) == "." {
+		return fmt.Errorf("share path must be a subdirectory of the export")
+	}
+	if c := path.Clean(p); path.IsAbs(p) || c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf("share path %q must be relative to the export", p)
+	}
+	return nil
+}
+
+// ShareLabels returns the labels of the PVs and PVCs of the NFSShare
+func ShareLabels(share *cachev1alpha1.NFSShare) map[string]string {
+	return map[string]string{ShareNamespaceLabel: share.Namespace, ShareNameLabel: share.Name}
+}
+
+// SharePVName returns the name of the static PV of the NFSShare for the target namespace
+func SharePVName(share *cachev1alpha1.NFSShare, namespace string) string {
+	return fmt.Sprintf("nfsshare-%s-%s-%s", share.Namespace, share.Name, namespace)
+}
+
+// ShareClaimName returns the name of the PVC in each target namespace
+func ShareClaimName(share *cachev1alpha1.NFSShare) string {
+	if share.Spec.ClaimName != "" {
+		return share.Spec.ClaimName
+	}
+	return share.Name
+}
+
+// ShareJobName returns the name of the Job that creates or deletes the shared directory
+func ShareJobName(share *cachev1alpha1.NFSShare, remove bool) string {
+	if remove {
+		return truncateName("nfsshare-delete-" + share.Name)
+	}
+	return truncateName("nfsshare-" + share.Name)
+}
+
+// shareAccessMode returns the access mode of the NFSShare
+func shareAccessMode(share *cachev1alpha1.NFSShare) corev1.PersistentVolumeAccessMode {
+	if share.Spec.AccessMode != "" {
+		return share.Spec.AccessMode
+	}
+	return corev1.ReadOnlyMany
+}
+
+// shareCapacity returns the capacity shown on the PVs and PVCs of the NFSShare
+func shareCapacity(share *cachev1alpha1.NFSShare) (resource.Quantity, error) {
+	capacity := defaults.ShareCapacity
+	if share.Spec.Capacity != "" {
+		capacity = share.Spec.Capacity
+	}
+	return resource.ParseQuantity(capacity)
+}
+
+// BuildSharePV returns the static PV that points at the shared directory and is reserved for the PVC in the namespace
+func BuildSharePV(share *cachev1alpha1.NFSShare, server, exportPath, namespace string) (*corev1.PersistentVolume, error) {
+	capacity, err := shareCapacity(share)
+	if err != nil {
+		return nil, err
+	}
+	accessMode := shareAccessMode(share)
+
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   SharePVName(share, namespace),
+			Labels: ShareLabels(share),
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: capacity},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{accessMode},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              "",
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  namespace,
+				Name:       ShareClaimName(share),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server:   server,
+					Path:     path.Join(exportPath, share.Spec.Path),
+					ReadOnly: accessMode == corev1.ReadOnlyMany,
+				},
+			},
+		},
+	}, nil
+}
+
+// BuildSharePVC returns the PVC in the namespace that is pre-bound to the static PV of the NFSShare
+func BuildSharePVC(share *cachev1alpha1.NFSShare, namespace string) (*corev1.PersistentVolumeClaim, error) {
+	capacity, err := shareCapacity(share)
+	if err != nil {
+		return nil, err
+	}
+	storageClassName := ""
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ShareClaimName(share),
+			Namespace: namespace,
+			Labels:    ShareLabels(share),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{shareAccessMode(share)},
+			StorageClassName: &storageClassName,
+			VolumeName:       SharePVName(share, namespace),
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: capacity},
+			},
+		},
+	}, nil
+}
+
+// BuildShareDirectoryJob returns a Job that creates the shared directory, or removes it when remove is true.
+// A new directory is writable by everyone like the directories of dynamically provisioned PVs; an existing one is left untouched.
+// The caller is responsible for setting the owner of the Job.
+func BuildShareDirectoryJob(nfsProvisioner *cachev1alpha1.NFSProvisioner, share *cachev1alpha1.NFSShare, remove bool) *batchv1.Job {
+	script := `set -e
+dir="` + defaults.ExportPath + `/$SHARE_PATH"
+[ -d "$dir" ] || { mkdir -p "$dir" && chmod 0777 "$dir"; }
+`
+	if remove {
+		script = `set -e
+rm -rf "` + defaults.ExportPath + `/$SHARE_PATH"
+`
+	}
+
+	container := corev1.Container{
+		Name:    "share-directory",
+		Image:   defaults.UtilityImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{{
+			Name:  "SHARE_PATH",
+			Value: path.Clean(share.Spec.Path),
+		}},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	return BuildExportJob(nfsProvisioner, ShareJobName(share, remove), container)
+}
@@ -0,0 +1,107 @@
+package resources
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("Share", func() {
+	var (
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		share          *cachev1alpha1.NFSShare
+	)
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-nfs",
+				Namespace: "test-namespace",
+			},
+		}
+		share = &cachev1alpha1.NFSShare{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "datasets",
+				Namespace: "test-namespace",
+			},
+			Spec: cachev1alpha1.NFSShareSpec{
+				NFSProvisioner: "test-nfs",
+				Path:           "shared/datasets",
+				Namespaces:     []string{"team-a", "team-b"},
+			},
+		}
+	})
+
+	It("should use the ClusterIP of the internal server", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: defaults.Service, Namespace: "test-namespace"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "172.30.10.20"},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build()
+
+		server, exportPath, err := NFSServerAddress(context.Background(), c, nfsProvisioner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server).To(Equal("172.30.10.20"))
+		Expect(exportPath).To(Equal(defaults.ExportPath))
+	})
+
+	It("should build a read-only PV reserved for the PVC of the namespace", func() {
+		pv, err := BuildSharePV(share, "172.30.10.20", defaults.ExportPath, "team-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pv.Name).To(Equal("nfsshare-test-namespace-datasets-team-a"))
+		Expect(pv.Spec.AccessModes).To(ConsistOf(corev1.ReadOnlyMany))
+		Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(corev1.PersistentVolumeReclaimRetain))
+		Expect(pv.Spec.NFS.Path).To(Equal("/export/shared/datasets"))
+		Expect(pv.Spec.NFS.ReadOnly).To(BeTrue())
+		Expect(pv.Spec.ClaimRef.Namespace).To(Equal("team-a"))
+		Expect(pv.Spec.ClaimRef.Name).To(Equal("datasets"))
+		Expect(pv.Labels).To(HaveKeyWithValue(ShareNameLabel, "datasets"))
+
+		pvc, err := BuildSharePVC(share, "team-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pvc.Spec.VolumeName).To(Equal(pv.Name))
+		Expect(*pvc.Spec.StorageClassName).To(BeEmpty())
+		Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal(defaults.ShareCapacity))
+	})
+
+	It("should build a writable PV for ReadWriteMany", func() {
+		share.Spec.AccessMode = corev1.ReadWriteMany
+		pv, err := BuildSharePV(share, "nas.example.com", "/volume1/k8s", "team-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pv.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteMany))
+		Expect(pv.Spec.NFS.ReadOnly).To(BeFalse())
+		Expect(pv.Spec.NFS.Path).To(Equal("/volume1/k8s/shared/datasets"))
+	})
+
+	It("should reject paths outside of the export", func() {
+		Expect(ValidateSharePath("shared/datasets")).To(Succeed())
+		Expect(ValidateSharePath("..shared")).To(Succeed())
+		Expect(ValidateSharePath("")).NotTo(Succeed())
+		Expect(ValidateSharePath(".")).NotTo(Succeed())
+		Expect(ValidateSharePath("/etc")).NotTo(Succeed())
+		Expect(ValidateSharePath("shared/../../etc")).NotTo(Succeed())
+		Expect(ValidateSharePath("..")).NotTo(Succeed())
+	})
+
+	It("should pass the path to the directory Job through the environment", func() {
+		job := BuildShareDirectoryJob(nfsProvisioner, share, false)
+		Expect(job.Name).To(Equal("nfsshare-datasets"))
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "SHARE_PATH", Value: "shared/datasets"}))
+		Expect(container.Command[2]).To(ContainSubstring("mkdir -p"))
+
+		job = BuildShareDirectoryJob(nfsProvisioner, share, true)
+		Expect(job.Name).To(Equal("nfsshare-delete-datasets"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("rm -rf"))
+	})
+})
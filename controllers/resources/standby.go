@@ -0,0 +1,527 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// StandbyManager runs the standby NFS server, tracks the replication and fails over to the standby
+type StandbyManager struct {
+	BaseResourceManager
+}
+
+// NewStandbyManager creates a new StandbyManager
+func NewStandbyManager(base BaseResourceManager) *StandbyManager {
+	return &StandbyManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *StandbyManager) GetResourceName() string {
+	return "Standby"
+}
+
+// EnsureResource ensures the standby volume, Service and Deployment exist and fails over when the primary is unavailable.
+// The primary is fenced, i.e. scaled down until its pods are gone, before the standby is promoted,
+// so that two servers never export at the same time.
+func (m *StandbyManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	if !standbyEnabled(nfsProvisioner) {
+		// A promoted standby is the NFS server, so it is never removed
+		if standbyActive(nfsProvisioner) {
+			return nil
+		}
+		return m.removeStandby(ctx, nfsProvisioner)
+	}
+
+	// The export volume of the primary belongs to the storage migration
+	if migrationInProgress(nfsProvisioner) {
+		log.Info("Skipping the standby - storage migration in progress")
+		return nil
+	}
+
+	if nfsProvisioner.Status.Standby == nil {
+		nfsProvisioner.Status.Standby = &cachev1alpha1.StandbyStatus{Active: cachev1alpha1.StandbyRolePrimary}
+	}
+	status := nfsProvisioner.Status.Standby
+
+	if err := m.ensurePVC(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+	if err := m.ensureService(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+	if err := m.ensureDeployment(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+
+	if standbyActive(nfsProvisioner) {
+		status.Phase = cachev1alpha1.StandbyPhaseFailedOver
+		return nil
+	}
+
+	primary := &appsv1.Deployment{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: nfsProvisioner.Namespace}, primary)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	primaryAvailable := err == nil && primary.Status.AvailableReplicas > 0
+
+	now := time.Now()
+	if primaryAvailable {
+		status.PrimaryUnavailableSince = nil
+		if err := m.updateLag(ctx, nfsProvisioner, now); err != nil {
+			log.Error(err, "Failed to read the replication log")
+		}
+	} else if status.PrimaryUnavailableSince == nil {
+		status.PrimaryUnavailableSince = &metav1.Time{Time: now}
+	}
+
+	if status.Phase == cachev1alpha1.StandbyPhaseFencing || m.failoverRequested(nfsProvisioner, now) {
+		return m.failover(ctx, nfsProvisioner)
+	}
+
+	interval := standbySyncInterval(nfsProvisioner)
+	switch {
+	case !primaryAvailable:
+		status.Phase = cachev1alpha1.StandbyPhaseDegraded
+		status.Message = fmt.Sprintf("The primary NFS server is unavailable since %s", status.PrimaryUnavailableSince.Format(time.RFC3339))
+	case status.LastSyncTime == nil:
+		status.Phase = cachev1alpha1.StandbyPhaseDegraded
+		status.Message = "Waiting for the first replication to the standby"
+	case time.Duration(status.LagSeconds)*time.Second > 3*interval:
+		status.Phase = cachev1alpha1.StandbyPhaseDegraded
+		status.Message = fmt.Sprintf("The standby is %ds behind the primary", status.LagSeconds)
+	default:
+		status.Phase = cachev1alpha1.StandbyPhaseReplicating
+		status.Message = ""
+	}
+	return nil
+}
+
+// failoverRequested returns true when the failover annotation is set, or when the primary is unavailable for FailoverAfter
+// with Automatic failover. An automatic failover needs a replication that succeeded, otherwise the standby is empty.
+func (m *StandbyManager) failoverRequested(nfsProvisioner *cachev1alpha1.NFSProvisioner, now time.Time) bool {
+	if nfsProvisioner.Annotations[defaults.FailoverAnnotation] == "true" {
+		return true
+	}
+	status := nfsProvisioner.Status.Standby
+	if nfsProvisioner.Spec.Standby.Failover == cachev1alpha1.FailoverManual ||
+		status.PrimaryUnavailableSince == nil || status.LastSyncTime == nil {
+		return false
+	}
+	return now.Sub(status.PrimaryUnavailableSince.Time) >= standbyFailoverAfter(nfsProvisioner)
+}
+
+// failover fences the primary and promotes the standby.
+// A pod on a lost node stays Terminating until the node is back or is tainted out-of-service, and the failover waits for it.
+func (m *StandbyManager) failover(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	status := nfsProvisioner.Status.Standby
+
+	if status.Phase != cachev1alpha1.StandbyPhaseFencing {
+		log.Info("Failing over to the standby NFS server", "NFSProvisioner.Namespace", nfsProvisioner.Namespace, "NFSProvisioner.Name", nfsProvisioner.Name)
+	}
+	status.Phase = cachev1alpha1.StandbyPhaseFencing
+	status.Message = "Stopping the primary NFS server"
+
+	primary := &appsv1.Deployment{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: nfsProvisioner.Namespace}, primary)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && (primary.Spec.Replicas == nil || *primary.Spec.Replicas != 0) {
+		replicas := int32(0)
+		primary.Spec.Replicas = &replicas
+		if err := m.Client.Update(ctx, primary); err != nil {
+			log.Error(err, "Failed to scale down the primary NFS server", "Deployment.Namespace", primary.Namespace, "Deployment.Name", primary.Name)
+			return err
+		}
+	}
+
+	pods, err := m.primaryPods(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		status.Message = fmt.Sprintf("Waiting for the primary pods %s to terminate. If their node is lost, taint it with node.kubernetes.io/out-of-service=nodeshutdown:NoExecute",
+			strings.Join(pods, ", "))
+		return nil
+	}
+
+	status.Active = cachev1alpha1.StandbyRoleStandby
+	status.Phase = cachev1alpha1.StandbyPhaseFailedOver
+	status.FailoverTime = &metav1.Time{Time: time.Now()}
+	status.Message = "The standby NFS server is active"
+	return m.ensureDeployment(ctx, nfsProvisioner)
+}
+
+// primaryPods returns the names of the pods of the primary NFS server, including terminating ones
+func (m *StandbyManager) primaryPods(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := m.Client.List(ctx, pods, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabels(labelsForNFSProvisioner(nfsProvisioner.Name))); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	return names, nil
+}
+
+// updateLag reads the last replication run from the log of the replication sidecar
+func (m *StandbyManager) updateLag(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, now time.Time) error {
+	if m.KubeClient == nil {
+		return nil
+	}
+	pods := &corev1.PodList{}
+	if err := m.Client.List(ctx, pods, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabels(labelsForNFSProvisioner(nfsProvisioner.Name))); err != nil {
+		return err
+	}
+
+	status := nfsProvisioner.Status.Standby
+	tailLines := int64(20)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		data, err := m.KubeClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: "replication",
+			TailLines: &tailLines,
+		}).DoRaw(ctx)
+		if err != nil {
+			return err
+		}
+		if synced, ok := LastSyncTime(string(data)); ok {
+			status.LastSyncTime = &metav1.Time{Time: synced}
+		}
+		break
+	}
+	if status.LastSyncTime != nil {
+		status.LagSeconds = int64(now.Sub(status.LastSyncTime.Time).Seconds())
+	}
+	return nil
+}
+
+// LastSyncTime returns the time of the last "synced <unix time>" line the replication sidecar printed
+func LastSyncTime(logs string) (time.Time, bool) {
+	lines := strings.Split(strings.TrimSpace(logs), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		fields := strings.Fields(lines[i])
+		if len(fields) != 2 || fields[0] != "synced" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		return time.Unix(seconds, 0), true
+	}
+	return time.Time{}, false
+}
+
+// ensurePVC ensures the volume of the standby exists
+func (m *StandbyManager) ensurePVC(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.StandbyPvc, Namespace: nfsProvisioner.Namespace}, &corev1.PersistentVolumeClaim{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	pvc := m.buildPVC(nfsProvisioner)
+	log.Info("Creating a new PersistentVolumeClaim", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+	if err := m.Client.Create(ctx, pvc); err != nil {
+		log.Error(err, "Failed to create the standby PersistentVolumeClaim", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+		return err
+	}
+	return nil
+}
+
+// buildPVC creates the PVC of the standby. It falls back to the storage settings of the primary.
+func (m *StandbyManager) buildPVC(nfsProvisioner *cachev1alpha1.NFSProvisioner) *corev1.PersistentVolumeClaim {
+	standby := nfsProvisioner.Spec.Standby
+
+	scName := defaults.SCForNFSPvc
+	if nfsProvisioner.Spec.SCForNFSPvc != "" {
+		scName = nfsProvisioner.Spec.SCForNFSPvc
+	}
+	if standby.SCForNFSPvc != "" {
+		scName = standby.SCForNFSPvc
+	}
+
+	pvcSize := defaults.StorageSize
+	if nfsProvisioner.Spec.StorageSize != "" {
+		pvcSize = nfsProvisioner.Spec.StorageSize
+	}
+	if standby.StorageSize != "" {
+		pvcSize = standby.StorageSize
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.StandbyPvc,
+			Namespace: nfsProvisioner.Namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(pvcSize),
+				},
+			},
+			StorageClassName: &scName,
+		},
+	}
+
+	ctrl.SetControllerReference(nfsProvisioner, pvc, m.Scheme)
+	return pvc
+}
+
+// ensureService ensures the Service the replication sidecar sends the export to
+func (m *StandbyManager) ensureService(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	err := m.Client.Get(ctx, types.NamespacedName{Name: defaults.StandbyService, Namespace: nfsProvisioner.Namespace}, &corev1.Service{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	ls := standbyLabels(nfsProvisioner.Name)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.StandbyService,
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports:    []corev1.ServicePort{{Name: "rsync", Port: 873}},
+			Selector: ls,
+		},
+	}
+	ctrl.SetControllerReference(nfsProvisioner, svc, m.Scheme)
+
+	log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+	if err := m.Client.Create(ctx, svc); err != nil {
+		log.Error(err, "Failed to create the standby Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		return err
+	}
+	return nil
+}
+
+// ensureDeployment ensures the standby Deployment exists and runs the rsync daemon, or the NFS server once it is promoted
+func (m *StandbyManager) ensureDeployment(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	dep := m.buildDeployment(nfsProvisioner)
+
+	deployFound := &appsv1.Deployment{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, deployFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		if err := m.Client.Create(ctx, dep); err != nil {
+			log.Error(err, "Failed to create the standby Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if podTemplateChanged(&dep.Spec.Template, &deployFound.Spec.Template) {
+		log.Info("Updating the Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		deployFound.Spec.Template = dep.Spec.Template
+		if err := m.Client.Update(ctx, deployFound); err != nil {
+			log.Error(err, "Failed to update the standby Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDeployment creates the standby Deployment. It runs on another node than the primary.
+// Once the standby is promoted, its pod is the NFS server with the standby volume.
+func (m *StandbyManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSProvisioner) *appsv1.Deployment {
+	ls := standbyLabels(nfsProvisioner.Name)
+
+	var dep *appsv1.Deployment
+	if standbyActive(nfsProvisioner) {
+		dep = (&DeploymentManager{BaseResourceManager: m.BaseResourceManager}).buildDeployment(nfsProvisioner, "PVC")
+		dep.Name = defaults.StandbyDeployment
+		dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: ls}
+		dep.Spec.Template.Labels = ls
+	} else {
+		dep = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      defaults.StandbyDeployment,
+				Namespace: nfsProvisioner.Namespace,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: ls,
+				},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: ls,
+					},
+					Spec: corev1.PodSpec{
+						Containers:         []corev1.Container{rsyncDaemonContainer()},
+						ServiceAccountName: defaults.ServiceAccount,
+						Volumes: []corev1.Volume{{
+							Name: "export-volume",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: defaults.StandbyPvc},
+							},
+						}},
+					},
+				},
+			},
+		}
+		applyOperatorConfig(&dep.Spec.Template.Spec)
+		ctrl.SetControllerReference(nfsProvisioner, dep, m.Scheme)
+	}
+
+	// The standby never runs on the node of the primary
+	if dep.Spec.Template.Spec.Affinity == nil {
+		dep.Spec.Template.Spec.Affinity = &corev1.Affinity{}
+	}
+	dep.Spec.Template.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: labelsForNFSProvisioner(nfsProvisioner.Name),
+			},
+			TopologyKey: corev1.LabelHostname,
+		}},
+	}
+	return dep
+}
+
+// rsyncDaemonContainer returns the container that receives the replication into the standby volume
+func rsyncDaemonContainer() corev1.Container {
+	script := `cat > /tmp/rsyncd.conf <<EOF
+uid = root
+gid = root
+use chroot = no
+log file = /dev/stdout
+[export]
+path = ` + defaults.ExportPath + `
+read only = false
+EOF
+exec rsync --daemon --no-detach --port=873 --config=/tmp/rsyncd.conf
+`
+	return corev1.Container{
+		Name:    "rsyncd",
+		Image:   defaults.RsyncImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Ports:   []corev1.ContainerPort{{Name: "rsync", ContainerPort: 873}},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "export-volume",
+			MountPath: defaults.ExportPath,
+		}},
+	}
+}
+
+// replicationContainer returns the sidecar of the primary that copies the export to the standby.
+// It prints "synced <unix time>" after every run, which the operator reads for the replication lag.
+func replicationContainer(nfsProvisioner *cachev1alpha1.NFSProvisioner) corev1.Container {
+	script := `while true; do
+  if rsync -aH --numeric-ids --delete ` + defaults.ExportPath + `/ "rsync://$STANDBY/export/"; then
+    echo "synced $(date +%s)"
+  else
+    echo "replication failed"
+  fi
+  sleep "$INTERVAL"
+done
+`
+	return corev1.Container{
+		Name:    "replication",
+		Image:   defaults.RsyncImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{Name: "STANDBY", Value: defaults.StandbyService},
+			{Name: "INTERVAL", Value: strconv.Itoa(int(standbySyncInterval(nfsProvisioner).Seconds()))},
+		},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "export-volume",
+			MountPath: defaults.ExportPath,
+			ReadOnly:  true,
+		}},
+	}
+}
+
+// removeStandby deletes the standby resources when the standby is disabled
+func (m *StandbyManager) removeStandby(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	objects := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: defaults.StandbyDeployment, Namespace: nfsProvisioner.Namespace}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: defaults.StandbyService, Namespace: nfsProvisioner.Namespace}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: defaults.StandbyPvc, Namespace: nfsProvisioner.Namespace}},
+	}
+	for _, object := range objects {
+		if err := m.Client.Delete(ctx, object); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		log.Info("Deleted the standby resource", "Name", object.GetName())
+	}
+	nfsProvisioner.Status.Standby = nil
+	return nil
+}
+
+// standbyEnabled returns true when the NFSProvisioner runs a standby NFS server
+func standbyEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return !isExternalMode(nfsProvisioner) && nfsProvisioner.Spec.Standby != nil && nfsProvisioner.Spec.Standby.Enabled
+}
+
+// standbyActive returns true after a failover to the standby
+func standbyActive(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Status.Standby != nil && nfsProvisioner.Status.Standby.Active == cachev1alpha1.StandbyRoleStandby
+}
+
+// standbySyncInterval returns the pause between two replication runs
+func standbySyncInterval(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if nfsProvisioner.Spec.Standby != nil && nfsProvisioner.Spec.Standby.SyncInterval != nil {
+		return nfsProvisioner.Spec.Standby.SyncInterval.Duration
+	}
+	return defaults.StandbySyncInterval
+}
+
+// standbyFailoverAfter returns how long the primary is unavailable before an automatic failover
+func standbyFailoverAfter(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if nfsProvisioner.Spec.Standby != nil && nfsProvisioner.Spec.Standby.FailoverAfter != nil {
+		return nfsProvisioner.Spec.Standby.FailoverAfter.Duration
+	}
+	return defaults.StandbyFailoverAfter
+}
+
+// standbyLabels returns the labels of the standby NFS server
+func standbyLabels(name string) map[string]string {
+	return map[string]string{"app": "nfs-provisioner-standby", "nfsprovisioner_cr": name}
+}
+
+// serverLabels returns the labels of the pods of the active NFS server
+func serverLabels(nfsProvisioner *cachev1alpha1.NFSProvisioner) map[string]string {
+	if standbyActive(nfsProvisioner) {
+		return standbyLabels(nfsProvisioner.Name)
+	}
+	return labelsForNFSProvisioner(nfsProvisioner.Name)
+}
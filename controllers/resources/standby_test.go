@@ -0,0 +1,172 @@
+package resources
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("StandbyManager", func() {
+	var (
+		ctx            context.Context
+		scheme         *runtime.Scheme
+		c              client.Client
+		base           BaseResourceManager
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		standbyManager *StandbyManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				SCForNFSPvc: "gp3-csi",
+				Standby:     &cachev1alpha1.StandbyConfiguration{Enabled: true, StorageSize: "20G"},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: defaults.Deployment, Namespace: "test-namespace"},
+				Status:     appsv1.DeploymentStatus{AvailableReplicas: 0},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "nfs-provisioner-abc", Namespace: "test-namespace", Labels: labelsForNFSProvisioner("test-nfs")},
+				Spec:       corev1.PodSpec{NodeName: "worker-0"},
+			},
+		).Build()
+		base = NewBaseResourceManager(c, logr.Discard(), scheme)
+		standbyManager = NewStandbyManager(base)
+	})
+
+	getDeployment := func(name string) *appsv1.Deployment {
+		dep := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: "test-namespace"}, dep)).To(Succeed())
+		return dep
+	}
+
+	// lastSynced marks the primary unavailable since long ago, after a replication that succeeded
+	lastSynced := func() {
+		past := metav1.NewTime(time.Now().Add(-time.Hour))
+		nfsProvisioner.Status.Standby = &cachev1alpha1.StandbyStatus{
+			Active:                  cachev1alpha1.StandbyRolePrimary,
+			LastSyncTime:            &past,
+			PrimaryUnavailableSince: &past,
+		}
+	}
+
+	It("should run the rsync daemon on another node with its own volume", func() {
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.StandbyPvc, Namespace: "test-namespace"}, pvc)).To(Succeed())
+		Expect(*pvc.Spec.StorageClassName).To(Equal("gp3-csi"))
+		Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal("20G"))
+
+		svc := &corev1.Service{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.StandbyService, Namespace: "test-namespace"}, svc)).To(Succeed())
+		Expect(svc.Spec.Selector).To(Equal(standbyLabels("test-nfs")))
+
+		dep := getDeployment(defaults.StandbyDeployment)
+		Expect(dep.Spec.Template.Spec.Containers[0].Name).To(Equal("rsyncd"))
+		Expect(dep.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector.MatchLabels).
+			To(Equal(labelsForNFSProvisioner("test-nfs")))
+
+		Expect(nfsProvisioner.Status.Standby.Active).To(Equal(cachev1alpha1.StandbyRolePrimary))
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseDegraded))
+	})
+
+	It("should add the replication sidecar to the primary", func() {
+		dep := NewDeploymentManager(base).buildDeployment(nfsProvisioner, "PVC")
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(2))
+		Expect(dep.Spec.Template.Spec.Containers[1].Name).To(Equal("replication"))
+		Expect(dep.Spec.Template.Spec.Containers[1].Env).To(ContainElement(corev1.EnvVar{Name: "INTERVAL", Value: "60"}))
+	})
+
+	It("should fence the primary before it promotes the standby", func() {
+		lastSynced()
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		// The primary pod is still there
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseFencing))
+		Expect(nfsProvisioner.Status.Standby.Message).To(ContainSubstring("nfs-provisioner-abc"))
+		Expect(*getDeployment(defaults.Deployment).Spec.Replicas).To(BeEquivalentTo(0))
+		Expect(getDeployment(defaults.StandbyDeployment).Spec.Template.Spec.Containers[0].Name).To(Equal("rsyncd"))
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-provisioner-abc", Namespace: "test-namespace"}, pod)).To(Succeed())
+		Expect(c.Delete(ctx, pod)).To(Succeed())
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(nfsProvisioner.Status.Standby.Active).To(Equal(cachev1alpha1.StandbyRoleStandby))
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseFailedOver))
+		dep := getDeployment(defaults.StandbyDeployment)
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(1))
+		Expect(dep.Spec.Template.Spec.Containers[0].Name).To(Equal("nfs-provisioner"))
+		Expect(dep.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(defaults.StandbyPvc))
+
+		// The Service selects the standby
+		serviceManager := NewServiceManager(base)
+		Expect(serviceManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		svc := &corev1.Service{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Service, Namespace: "test-namespace"}, svc)).To(Succeed())
+		Expect(svc.Spec.Selector).To(Equal(standbyLabels("test-nfs")))
+	})
+
+	It("should only fail over on request with Manual failover", func() {
+		nfsProvisioner.Spec.Standby.Failover = cachev1alpha1.FailoverManual
+		lastSynced()
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseDegraded))
+
+		nfsProvisioner.Annotations = map[string]string{defaults.FailoverAnnotation: "true"}
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseFencing))
+	})
+
+	It("should not fail over automatically before the first replication", func() {
+		past := metav1.NewTime(time.Now().Add(-time.Hour))
+		nfsProvisioner.Status.Standby = &cachev1alpha1.StandbyStatus{Active: cachev1alpha1.StandbyRolePrimary, PrimaryUnavailableSince: &past}
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Standby.Phase).To(Equal(cachev1alpha1.StandbyPhaseDegraded))
+	})
+
+	It("should remove the standby when it is disabled", func() {
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.Standby.Enabled = false
+		Expect(standbyManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		err := c.Get(ctx, types.NamespacedName{Name: defaults.StandbyDeployment, Namespace: "test-namespace"}, &appsv1.Deployment{})
+		Expect(err).To(HaveOccurred())
+		Expect(nfsProvisioner.Status.Standby).To(BeNil())
+	})
+
+	It("should read the last replication run from the sidecar log", func() {
+		synced, ok := LastSyncTime("synced 1700000000\nreplication failed\nsynced 1700000060\nreplication failed\n")
+		Expect(ok).To(BeTrue())
+		Expect(synced.Unix()).To(BeEquivalentTo(1700000060))
+
+		_, ok = LastSyncTime("replication failed\n")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -2,8 +2,11 @@ package resources
 
 import (
 	"context"
+	"strings"
 
+	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -11,6 +14,7 @@ import (
 
 	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
 	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+	"github.com/jooho/nfs-provisioner-operator/nfsserver"
 )
 
 // StorageClassManager manages StorageClass resources
@@ -30,23 +34,45 @@ func (m *StorageClassManager) GetResourceName() string {
 	return "StorageClass"
 }
 
-// EnsureResource ensures the StorageClass exists
+// EnsureResource ensures the StorageClass exists.
+// With topology enabled, the StorageClass is restricted to the zone of the node the NFS server runs on.
 func (m *StorageClassManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
 	log := m.Log.WithValues("resource", m.GetResourceName())
 
 	// Check if the storageclass already exists
-	scName := defaults.SCForNFSProvisioner
+	scName := StorageClassName(nfsProvisioner)
+	sc := m.buildStorageClass(nfsProvisioner)
 
-	if nfsProvisioner.Spec.SCForNFSProvisioner != "" {
-		scName = nfsProvisioner.Spec.SCForNFSProvisioner
+	restricted := nfsProvisioner.Status.Topology != nil
+	if topologyEnabled(nfsProvisioner) {
+		topology, err := m.discoverTopology(ctx, nfsProvisioner)
+		if err != nil {
+			return err
+		}
+		nfsProvisioner.Status.Topology = topology
+		if topology == nil {
+			// The allowed topologies of a StorageClass can not be changed, so it waits for the node
+			log.Info("Waiting for the NFS server to be scheduled before creating the StorageClass", "Storageclass.Name", scName)
+			return nil
+		}
+		setAllowedTopology(sc, topology)
+		restricted = true
+	} else {
+		nfsProvisioner.Status.Topology = nil
 	}
 
 	scFound := &storagev1.StorageClass{}
 	err := m.Client.Get(ctx, types.NamespacedName{Name: scName, Namespace: ""}, scFound)
+	if err == nil && restricted && storageClassTopologyChanged(sc, scFound) {
+		// The topology fields of a StorageClass are immutable, so it is replaced. Bound PVs are not affected.
+		log.Info("Replacing the Storageclass because its topology changed", "Storageclass.Name", scName)
+		if err = m.Client.Delete(ctx, scFound); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the Storageclass for NFSProvisioner", "Storageclass.Name", scName)
+			return err
+		}
+		err = errors.NewNotFound(storagev1.Resource("storageclasses"), scName)
+	}
 	if err != nil && errors.IsNotFound(err) {
-		// Define a new storageclass
-		sc := m.buildStorageClass(nfsProvisioner)
-
 		log.Info("Creating a new Storageclass", "Storageclass.Name", sc.Name)
 
 		if err = m.Client.Create(ctx, sc); err != nil {
@@ -62,19 +88,115 @@ func (m *StorageClassManager) EnsureResource(ctx context.Context, nfsProvisioner
 
 // buildStorageClass creates a new StorageClass object
 func (m *StorageClassManager) buildStorageClass(nfsProvisioner *cachev1alpha1.NFSProvisioner) *storagev1.StorageClass {
-	scName := defaults.SCForNFSProvisioner
-
-	if nfsProvisioner.Spec.SCForNFSProvisioner != "" {
-		scName = nfsProvisioner.Spec.SCForNFSProvisioner
-	}
+	scName := StorageClassName(nfsProvisioner)
 	sc := &storagev1.StorageClass{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: scName,
 		},
-		Provisioner: "example.com/nfs",
+		Provisioner: ProvisionerName(nfsProvisioner),
 		Parameters:  map[string]string{"mountOptions": "vers=4.1"},
 	}
+	// The go NFS server only serves NFSv3, with MOUNT on the NFS port
+	if goServer(nfsProvisioner) {
+		sc.Parameters["mountOptions"] = strings.Join(nfsserver.DefaultMountOptions, ",")
+	}
 
 	ctrl.SetControllerReference(nfsProvisioner, sc, m.Scheme)
 	return sc
 }
+
+// discoverTopology returns the zone of the node the NFS server runs on, or nil while the node is unknown.
+// The running pod is preferred, so that a zonal PVC is followed as well as a hostPath node.
+func (m *StorageClassManager) discoverTopology(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (*cachev1alpha1.TopologyStatus, error) {
+	nodeName, err := m.serverNode(ctx, nfsProvisioner)
+	if err != nil {
+		return nil, err
+	}
+	if nodeName == "" {
+		nodeName = nfsProvisioner.Status.SelectedNode
+	}
+	if nodeName == "" {
+		return nil, nil
+	}
+
+	node := &corev1.Node{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key := topologyKey(nfsProvisioner)
+	return &cachev1alpha1.TopologyStatus{Node: nodeName, Key: key, Value: node.Labels[key]}, nil
+}
+
+// setAllowedTopology restricts the StorageClass to the zone, and delays the binding until a consumer is scheduled
+func setAllowedTopology(sc *storagev1.StorageClass, topology *cachev1alpha1.TopologyStatus) {
+	// A node without the zone label leaves the StorageClass unrestricted
+	if topology.Value == "" {
+		return
+	}
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	sc.VolumeBindingMode = &bindingMode
+	sc.AllowedTopologies = []corev1.TopologySelectorTerm{{
+		MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{{
+			Key:    topology.Key,
+			Values: []string{topology.Value},
+		}},
+	}}
+}
+
+// storageClassTopologyChanged returns true when the binding mode or the allowed topologies differ
+func storageClassTopologyChanged(desired, found *storagev1.StorageClass) bool {
+	bindingMode := func(sc *storagev1.StorageClass) storagev1.VolumeBindingMode {
+		if sc.VolumeBindingMode == nil {
+			return storagev1.VolumeBindingImmediate
+		}
+		return *sc.VolumeBindingMode
+	}
+	if bindingMode(desired) != bindingMode(found) {
+		return true
+	}
+	if len(desired.AllowedTopologies) == 0 && len(found.AllowedTopologies) == 0 {
+		return false
+	}
+	return !equality.Semantic.DeepEqual(desired.AllowedTopologies, found.AllowedTopologies)
+}
+
+// topologyEnabled returns true when the StorageClass follows the zone of the NFS server
+func topologyEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.Topology != nil && nfsProvisioner.Spec.Topology.Enabled
+}
+
+// topologyKey returns the node label that holds the zone
+func topologyKey(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if nfsProvisioner.Spec.Topology != nil && nfsProvisioner.Spec.Topology.Key != "" {
+		return nfsProvisioner.Spec.Topology.Key
+	}
+	return corev1.LabelTopologyZone
+}
+
+// StorageClassName returns the name of the StorageClass served by the NFSProvisioner
+func StorageClassName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if nfsProvisioner.Spec.SCForNFSProvisioner != "" {
+		return nfsProvisioner.Spec.SCForNFSProvisioner
+	}
+	return defaults.SCForNFSProvisioner
+}
+
+// StorageClassNames returns the StorageClasses served by the NFSProvisioner: its own and the one of the CSI driver when it is enabled
+func StorageClassNames(nfsProvisioner *cachev1alpha1.NFSProvisioner) []string {
+	names := []string{StorageClassName(nfsProvisioner)}
+	if csiEnabled(nfsProvisioner) {
+		names = append(names, csiStorageClassName(nfsProvisioner))
+	}
+	return names
+}
+
+// ProvisionerName returns the provisioner of the StorageClass served by the NFSProvisioner
+func ProvisionerName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	if nfsProvisioner.Spec.ProvisionerName != "" {
+		return nfsProvisioner.Spec.ProvisionerName
+	}
+	return defaults.Provisioner
+}
@@ -0,0 +1,119 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("StorageClassManager", func() {
+	var (
+		ctx                 context.Context
+		c                   client.Client
+		nfsProvisioner      *cachev1alpha1.NFSProvisioner
+		storageClassManager *StorageClassManager
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				HostPathDir: "/home/core/nfs",
+				Topology:    &cachev1alpha1.TopologyConfiguration{Enabled: true},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Labels: map[string]string{corev1.LabelTopologyZone: "us-east-1a"}}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{corev1.LabelTopologyZone: "us-east-1b"}}},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "nfs-provisioner-abc", Namespace: "test-namespace", Labels: labelsForNFSProvisioner("test-nfs")},
+				Spec:       corev1.PodSpec{NodeName: "worker-0"},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			},
+		).Build()
+		storageClassManager = NewStorageClassManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+	})
+
+	getStorageClass := func() *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.SCForNFSProvisioner}, sc)).To(Succeed())
+		return sc
+	}
+
+	It("should restrict the StorageClass to the zone of the NFS server", func() {
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := getStorageClass()
+		Expect(*sc.VolumeBindingMode).To(Equal(storagev1.VolumeBindingWaitForFirstConsumer))
+		Expect(sc.AllowedTopologies).To(HaveLen(1))
+		Expect(sc.AllowedTopologies[0].MatchLabelExpressions).To(ConsistOf(corev1.TopologySelectorLabelRequirement{
+			Key: corev1.LabelTopologyZone, Values: []string{"us-east-1a"},
+		}))
+		Expect(nfsProvisioner.Status.Topology).To(Equal(&cachev1alpha1.TopologyStatus{Node: "worker-0", Key: corev1.LabelTopologyZone, Value: "us-east-1a"}))
+	})
+
+	It("should replace the StorageClass when the NFS server moves to another zone", func() {
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-provisioner-abc", Namespace: "test-namespace"}, pod)).To(Succeed())
+		Expect(c.Delete(ctx, pod)).To(Succeed())
+		nfsProvisioner.Status.SelectedNode = "worker-1"
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(getStorageClass().AllowedTopologies[0].MatchLabelExpressions[0].Values).To(Equal([]string{"us-east-1b"}))
+		Expect(nfsProvisioner.Status.Topology.Value).To(Equal("us-east-1b"))
+	})
+
+	It("should remove the restriction when topology is disabled", func() {
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.Topology.Enabled = false
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := getStorageClass()
+		Expect(sc.VolumeBindingMode).To(BeNil())
+		Expect(sc.AllowedTopologies).To(BeEmpty())
+		Expect(nfsProvisioner.Status.Topology).To(BeNil())
+	})
+
+	It("should wait for the NFS server to be scheduled", func() {
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-provisioner-abc", Namespace: "test-namespace"}, pod)).To(Succeed())
+		Expect(c.Delete(ctx, pod)).To(Succeed())
+
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		err := c.Get(ctx, types.NamespacedName{Name: defaults.SCForNFSProvisioner}, &storagev1.StorageClass{})
+		Expect(err).To(HaveOccurred())
+		Expect(nfsProvisioner.Status.Topology).To(BeNil())
+	})
+
+	It("should not restrict the StorageClass when the node has no zone", func() {
+		nfsProvisioner.Spec.Topology.Key = "example.com/rack"
+		Expect(storageClassManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		sc := getStorageClass()
+		Expect(sc.AllowedTopologies).To(BeEmpty())
+		Expect(nfsProvisioner.Status.Topology).To(Equal(&cachev1alpha1.TopologyStatus{Node: "worker-0", Key: "example.com/rack"}))
+	})
+})
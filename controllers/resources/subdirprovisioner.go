@@ -0,0 +1,141 @@
+package resources
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// SubdirProvisionerManager manages the subdirectory provisioner Deployment that is used in External mode
+type SubdirProvisionerManager struct {
+	BaseResourceManager
+}
+
+// NewSubdirProvisionerManager creates a new SubdirProvisionerManager
+func NewSubdirProvisionerManager(base BaseResourceManager) *SubdirProvisionerManager {
+	return &SubdirProvisionerManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *SubdirProvisionerManager) GetResourceName() string {
+	return "SubdirProvisioner"
+}
+
+// EnsureResource ensures the subdirectory provisioner Deployment exists and points at the external NFS server
+func (m *SubdirProvisionerManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	if !isExternalMode(nfsProvisioner) || nfsProvisioner.Spec.External == nil {
+		return nil
+	}
+
+	dep := m.buildDeployment(nfsProvisioner)
+
+	deployFound := &appsv1.Deployment{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, deployFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		if err = m.Client.Create(ctx, dep); err != nil {
+			log.Error(err, "Failed to create a Deployment for NFSProvisioner", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The external server or path can change, so the pod template follows the spec
+	if !equality.Semantic.DeepDerivative(dep.Spec.Template, deployFound.Spec.Template) {
+		log.Info("Updating the Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		deployFound.Spec.Template = dep.Spec.Template
+		if err = m.Client.Update(ctx, deployFound); err != nil {
+			log.Error(err, "Failed to update the Deployment for NFSProvisioner", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildDeployment creates the subdirectory provisioner Deployment.
+// It mounts the external export and creates a directory in it for each PV of the StorageClass.
+func (m *SubdirProvisionerManager) buildDeployment(nfsProvisioner *cachev1alpha1.NFSProvisioner) *appsv1.Deployment {
+	ls := labelsForNFSProvisioner(nfsProvisioner.Name)
+	ls["component"] = "subdir-provisioner"
+
+	external := nfsProvisioner.Spec.External
+	image := defaults.SubdirProvisionerImage
+	if external.Image != "" {
+		image = external.Image
+	}
+
+	imagePullPolicy := defaults.NFSImagePullPolicy
+	if nfsProvisioner.Spec.NFSImageConfiguration != nil && nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy != nil {
+		imagePullPolicy = *nfsProvisioner.Spec.NFSImageConfiguration.ImagePullPolicy
+	}
+
+	replicas := int32(1)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.SubdirProvisionerDeployment,
+			Namespace: nfsProvisioner.Namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image:           image,
+						Name:            "nfs-subdir-provisioner",
+						ImagePullPolicy: imagePullPolicy,
+						Env: []corev1.EnvVar{{
+							Name:  "PROVISIONER_NAME",
+							Value: ProvisionerName(nfsProvisioner),
+						}, {
+							Name:  "NFS_SERVER",
+							Value: external.Server,
+						}, {
+							Name:  "NFS_PATH",
+							Value: external.Path,
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "export-volume",
+							MountPath: "/persistentvolumes",
+						}},
+					}},
+					NodeSelector:       nfsProvisioner.Spec.NodeSelector,
+					ServiceAccountName: defaults.ServiceAccount,
+					Volumes: []corev1.Volume{{
+						Name:         "export-volume",
+						VolumeSource: *exportVolumeSource(nfsProvisioner),
+					}},
+				},
+			},
+		},
+	}
+	applyOperatorConfig(&dep.Spec.Template.Spec)
+
+	// Set NFSProvisioner instance as the owner and controller
+	ctrl.SetControllerReference(nfsProvisioner, dep, m.Scheme)
+	return dep
+}
@@ -0,0 +1,99 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("External mode", func() {
+	var (
+		ctx                context.Context
+		c                  client.Client
+		nfsProvisioner     *cachev1alpha1.NFSProvisioner
+		resourceManagerSet *ResourceManagerSet
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(rbacv1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		resourceManagerSet = NewResourceManagerSet(c, kubefake.NewSimpleClientset(), logr.Discard(), scheme)
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-nfs",
+				Namespace: "test-namespace",
+				UID:       "test-uid",
+			},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				Mode: cachev1alpha1.ModeExternal,
+				External: &cachev1alpha1.ExternalNFSServer{
+					Server: "nas.example.com",
+					Path:   "/volume1/k8s",
+				},
+			},
+		}
+	})
+
+	It("should deploy only the subdirectory provisioner", func() {
+		Expect(resourceManagerSet.EnsureAllResources(ctx, nfsProvisioner)).To(Succeed())
+
+		deploy := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.SubdirProvisionerDeployment, Namespace: "test-namespace"}, deploy)).To(Succeed())
+		podSpec := deploy.Spec.Template.Spec
+		Expect(podSpec.Containers[0].Image).To(Equal(defaults.SubdirProvisionerImage))
+		Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "NFS_SERVER", Value: "nas.example.com"}))
+		Expect(podSpec.Volumes[0].NFS.Path).To(Equal("/volume1/k8s"))
+		Expect(podSpec.ServiceAccountName).To(Equal(defaults.ServiceAccount))
+
+		// The NFS server, its Service and PVC are not created
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, &appsv1.Deployment{})).NotTo(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Service, Namespace: "test-namespace"}, &corev1.Service{})).NotTo(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Pvc, Namespace: "test-namespace"}, &corev1.PersistentVolumeClaim{})).NotTo(Succeed())
+
+		// The StorageClass is served by the subdirectory provisioner
+		sc := &storagev1.StorageClass{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.SCForNFSProvisioner}, sc)).To(Succeed())
+		Expect(sc.Provisioner).To(Equal(defaults.Provisioner))
+	})
+
+	It("should follow a change of the external server", func() {
+		Expect(resourceManagerSet.EnsureAllResources(ctx, nfsProvisioner)).To(Succeed())
+
+		nfsProvisioner.Spec.External.Server = "10.0.0.20"
+		Expect(resourceManagerSet.EnsureAllResources(ctx, nfsProvisioner)).To(Succeed())
+
+		deploy := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.SubdirProvisionerDeployment, Namespace: "test-namespace"}, deploy)).To(Succeed())
+		Expect(deploy.Spec.Template.Spec.Volumes[0].NFS.Server).To(Equal("10.0.0.20"))
+	})
+
+	It("should mount the external export in export Jobs", func() {
+		podSpec := BuildExportPodSpec(nfsProvisioner, corev1.Container{Name: "test"})
+		Expect(podSpec.Volumes[0].NFS.Server).To(Equal("nas.example.com"))
+		Expect(podSpec.Affinity).To(BeNil())
+	})
+})
@@ -0,0 +1,518 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// maxUpgradeSteps is the number of steps kept in the status
+const maxUpgradeSteps = 20
+
+// imagePullErrors are the waiting reasons of a container whose image can not be pulled
+var imagePullErrors = map[string]bool{
+	"ErrImagePull":        true,
+	"ImagePullBackOff":    true,
+	"InvalidImageName":    true,
+	"ErrImageNeverPull":   true,
+	"RegistryUnavailable": true,
+}
+
+// UpgradeManager rolls a new image of the NFS server out step by step: it checks that the image can be pulled,
+// waits for the maintenance window, backs up the export, rolls the server and verifies it with the canary.
+// The NFS server runs status.upgrade.currentImage until the rollout, and is rolled back to it when the new image fails.
+type UpgradeManager struct {
+	BaseResourceManager
+}
+
+// NewUpgradeManager creates a new UpgradeManager
+func NewUpgradeManager(base BaseResourceManager) *UpgradeManager {
+	return &UpgradeManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *UpgradeManager) GetResourceName() string {
+	return "ServerUpgrade"
+}
+
+// EnsureResource moves the upgrade in progress one step forward, or starts one when the image of the spec changed
+func (m *UpgradeManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	// The NFS server is stopped and moved to another volume meanwhile
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+
+	desired, _ := specServerImage(nfsProvisioner)
+	implementation := serverImplementation(nfsProvisioner)
+	status := nfsProvisioner.Status.Upgrade
+	if status == nil || status.CurrentImage == "" || status.ServerImplementation != implementation {
+		// A new server, or one of another implementation, starts on the image of the spec
+		current := desired
+		if status != nil && status.CurrentImage != "" {
+			m.Log.Info("Replacing the NFS server without an upgrade", "ServerImplementation", implementation, "Image", desired)
+			if err := m.deleteCheckPod(ctx, nfsProvisioner); err != nil {
+				return err
+			}
+		} else {
+			running, err := m.runningImage(ctx, nfsProvisioner)
+			if err != nil {
+				return err
+			}
+			// The Deployment runs the image of the spec after the image mirrors
+			if running != "" && running != defaults.ResolveImage(desired) {
+				current = running
+			}
+		}
+		nfsProvisioner.Status.Upgrade = &cachev1alpha1.UpgradeStatus{CurrentImage: current, ServerImplementation: implementation}
+		status = nfsProvisioner.Status.Upgrade
+	}
+
+	switch status.Phase {
+	case cachev1alpha1.UpgradePhasePullCheck, cachev1alpha1.UpgradePhaseWaitingForWindow, cachev1alpha1.UpgradePhaseBackup:
+		// Until the rollout, a new change of the image replaces the upgrade
+		if desired != status.TargetImage {
+			if err := m.deleteCheckPod(ctx, nfsProvisioner); err != nil {
+				return err
+			}
+			if desired == status.CurrentImage {
+				m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseFailed, "The upgrade was cancelled, the image is "+desired+" again")
+				return nil
+			}
+			return m.start(ctx, nfsProvisioner, desired)
+		}
+		switch status.Phase {
+		case cachev1alpha1.UpgradePhasePullCheck:
+			return m.checkPull(ctx, nfsProvisioner)
+		case cachev1alpha1.UpgradePhaseWaitingForWindow:
+			if inUpgradeWindow(nfsProvisioner.Spec.Upgrade, time.Now()) {
+				return m.next(ctx, nfsProvisioner, status.Phase)
+			}
+			return nil
+		default:
+			return m.checkBackup(ctx, nfsProvisioner)
+		}
+	case cachev1alpha1.UpgradePhaseRolling:
+		return m.checkRollout(ctx, nfsProvisioner)
+	case cachev1alpha1.UpgradePhaseVerifying:
+		return m.verify(ctx, nfsProvisioner)
+	case cachev1alpha1.UpgradePhaseRollingBack:
+		return m.checkRollback(ctx, nfsProvisioner)
+	}
+
+	if desired == status.CurrentImage {
+		return nil
+	}
+	// A failed image is only tried again on request
+	if desired == status.TargetImage && (status.Phase == cachev1alpha1.UpgradePhaseFailed || status.Phase == cachev1alpha1.UpgradePhaseRolledBack) {
+		if nfsProvisioner.Annotations[defaults.UpgradeRetryAnnotation] != "true" {
+			return nil
+		}
+		if err := removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.UpgradeRetryAnnotation); err != nil {
+			return err
+		}
+	}
+	return m.start(ctx, nfsProvisioner, desired)
+}
+
+// start begins the upgrade to image with the pull check
+func (m *UpgradeManager) start(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, image string) error {
+	m.Log.Info("Starting the upgrade of the NFS server", "From", nfsProvisioner.Status.Upgrade.CurrentImage, "To", image)
+	now := metav1.Now()
+	status := nfsProvisioner.Status.Upgrade
+	status.TargetImage = image
+	status.StartTime = &now
+	status.CompletionTime = nil
+	status.Backup = ""
+	status.Steps = nil
+	addUpgradeStep(status, cachev1alpha1.UpgradePhasePullCheck, "Checking that "+image+" can be pulled")
+	return m.createCheckPod(ctx, nfsProvisioner)
+}
+
+// next enters the step after phase: the window, the backup and the rollout are run when they apply
+func (m *UpgradeManager) next(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, phase cachev1alpha1.UpgradePhase) error {
+	config := nfsProvisioner.Spec.Upgrade
+	status := nfsProvisioner.Status.Upgrade
+
+	if phase == cachev1alpha1.UpgradePhasePullCheck && !inUpgradeWindow(config, time.Now()) {
+		addUpgradeStep(status, cachev1alpha1.UpgradePhaseWaitingForWindow, fmt.Sprintf("Waiting for the window at %s UTC", config.Window.Start))
+		return nil
+	}
+	if phase != cachev1alpha1.UpgradePhaseBackup && config != nil && config.Backup != nil {
+		return m.startBackup(ctx, nfsProvisioner)
+	}
+
+	addUpgradeStep(status, cachev1alpha1.UpgradePhaseRolling, "Rolling the NFS server to "+status.TargetImage)
+	return nil
+}
+
+// createCheckPod starts a pod with the new image on the nodes of the NFS server
+func (m *UpgradeManager) createCheckPod(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      upgradeCheckPodName(nfsProvisioner),
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    map[string]string{defaults.UpgradeLabel: nfsProvisioner.Name},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:      corev1.RestartPolicyNever,
+			ServiceAccountName: defaults.ServiceAccount,
+			Containers: []corev1.Container{{
+				Name:  "image-check",
+				Image: nfsProvisioner.Status.Upgrade.TargetImage,
+				// The image is pulled even when it is in the cache of the node, and nothing in it is run
+				ImagePullPolicy: corev1.PullAlways,
+				Command:         []string{"/bin/sh", "-c", "exit 0"},
+			}},
+		},
+	}
+
+	// The pod is scheduled where the NFS server can run, with its pull secrets
+	deployment, err := m.serverDeployment(ctx, nfsProvisioner)
+	if err != nil {
+		return err
+	}
+	if deployment != nil {
+		podSpec := deployment.Spec.Template.Spec
+		pod.Spec.NodeSelector = podSpec.NodeSelector
+		pod.Spec.Affinity = podSpec.Affinity
+		pod.Spec.Tolerations = podSpec.Tolerations
+		pod.Spec.ImagePullSecrets = podSpec.ImagePullSecrets
+	}
+	applyOperatorConfig(&pod.Spec)
+
+	ctrl.SetControllerReference(nfsProvisioner, pod, m.Scheme)
+	m.Log.Info("Creating a new Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "Image", pod.Spec.Containers[0].Image)
+	if err := m.Client.Create(ctx, pod); err != nil && !errors.IsAlreadyExists(err) {
+		m.Log.Error(err, "Failed to create a Pod for NFSProvisioner", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		return err
+	}
+	return nil
+}
+
+// checkPull continues the upgrade once the image of the check pod is pulled, and fails it when it can not be pulled
+func (m *UpgradeManager) checkPull(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	pod := &corev1.Pod{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: upgradeCheckPodName(nfsProvisioner), Namespace: nfsProvisioner.Namespace}, pod)
+	if err != nil && errors.IsNotFound(err) {
+		return m.createCheckPod(ctx, nfsProvisioner)
+	} else if err != nil {
+		return err
+	}
+
+	pulled, failure := false, ""
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.ImageID != "" {
+			pulled = true
+		} else if waiting := container.State.Waiting; waiting != nil && imagePullErrors[waiting.Reason] {
+			failure = fmt.Sprintf("%s can not be pulled: %s %s", status.TargetImage, waiting.Reason, waiting.Message)
+		}
+	}
+	if !pulled && failure == "" && time.Since(upgradeStepTime(status)) > upgradeTimeout(nfsProvisioner) {
+		failure = fmt.Sprintf("%s was not pulled within %s", status.TargetImage, upgradeTimeout(nfsProvisioner))
+	}
+	if !pulled && failure == "" {
+		return nil
+	}
+
+	if err := m.deleteCheckPod(ctx, nfsProvisioner); err != nil {
+		return err
+	}
+	if !pulled {
+		m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseFailed, strings.TrimSpace(failure))
+		return nil
+	}
+	return m.next(ctx, nfsProvisioner, cachev1alpha1.UpgradePhasePullCheck)
+}
+
+// deleteCheckPod removes the pod of the pull check
+func (m *UpgradeManager) deleteCheckPod(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: upgradeCheckPodName(nfsProvisioner), Namespace: nfsProvisioner.Namespace}}
+	if err := m.Client.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// startBackup creates the NFSBackup of the export taken before the rollout
+func (m *UpgradeManager) startBackup(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	backup := &cachev1alpha1.NFSBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      truncateName(fmt.Sprintf("%s-pre-upgrade-%d", nfsProvisioner.Name, time.Now().Unix())),
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    map[string]string{defaults.UpgradeLabel: nfsProvisioner.Name},
+		},
+		Spec: cachev1alpha1.NFSBackupSpec{
+			NFSProvisioner: nfsProvisioner.Name,
+			Repository:     *nfsProvisioner.Spec.Upgrade.Backup,
+		},
+	}
+	ctrl.SetControllerReference(nfsProvisioner, backup, m.Scheme)
+
+	m.Log.Info("Creating a new NFSBackup", "NFSBackup.Namespace", backup.Namespace, "NFSBackup.Name", backup.Name)
+	if err := m.Client.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+		m.Log.Error(err, "Failed to create a NFSBackup for NFSProvisioner", "NFSBackup.Namespace", backup.Namespace, "NFSBackup.Name", backup.Name)
+		return err
+	}
+	status.Backup = backup.Name
+	addUpgradeStep(status, cachev1alpha1.UpgradePhaseBackup, "Backing up the export with NFSBackup "+backup.Name)
+	return nil
+}
+
+// checkBackup continues the upgrade once the pre-upgrade backup succeeded
+func (m *UpgradeManager) checkBackup(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	backup := &cachev1alpha1.NFSBackup{}
+	err := m.Client.Get(ctx, types.NamespacedName{Name: status.Backup, Namespace: nfsProvisioner.Namespace}, backup)
+	if err != nil && errors.IsNotFound(err) {
+		m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseFailed, "The pre-upgrade NFSBackup "+status.Backup+" is gone")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch backup.Status.Phase {
+	case cachev1alpha1.BackupPhaseSucceeded:
+		return m.next(ctx, nfsProvisioner, cachev1alpha1.UpgradePhaseBackup)
+	case cachev1alpha1.BackupPhaseFailed:
+		m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseFailed, "The pre-upgrade NFSBackup "+status.Backup+" failed: "+backup.Status.Message)
+	}
+	return nil
+}
+
+// checkRollout waits for the NFS server to be ready on the new image, then verifies it with the canary
+func (m *UpgradeManager) checkRollout(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	ready, message, err := m.rolledOut(ctx, nfsProvisioner, status.TargetImage)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		if time.Since(upgradeStepTime(status)) > upgradeTimeout(nfsProvisioner) {
+			m.rollBack(nfsProvisioner, fmt.Sprintf("The NFS server was not ready on %s within %s: %s", status.TargetImage, upgradeTimeout(nfsProvisioner), message))
+		}
+		return nil
+	}
+
+	if !canaryEnabled(nfsProvisioner) {
+		m.succeed(nfsProvisioner)
+		return nil
+	}
+	addUpgradeStep(status, cachev1alpha1.UpgradePhaseVerifying, "Waiting for a canary run on "+status.TargetImage)
+	return nil
+}
+
+// verify waits for a canary run that started after the rollout and rolls back when it failed
+func (m *UpgradeManager) verify(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	if !canaryEnabled(nfsProvisioner) {
+		m.succeed(nfsProvisioner)
+		return nil
+	}
+
+	verifying := upgradeStepTime(status)
+	canary := nfsProvisioner.Status.Canary
+	if canary != nil && canary.Run == "" && canary.LastRunTime != nil && canary.LastRunTime.Unix() >= verifying.Unix() {
+		condition := meta.FindStatusCondition(nfsProvisioner.Status.Conditions, ConditionCanaryHealthy)
+		if condition != nil && condition.Status == metav1.ConditionTrue {
+			m.succeed(nfsProvisioner)
+			return nil
+		}
+		message := "the canary failed"
+		if condition != nil {
+			message = fmt.Sprintf("the canary failed (%s): %s", condition.Reason, condition.Message)
+		}
+		m.rollBack(nfsProvisioner, fmt.Sprintf("The NFS server on %s is not healthy, %s", status.TargetImage, message))
+		return nil
+	}
+
+	if time.Since(verifying) > upgradeTimeout(nfsProvisioner) {
+		m.rollBack(nfsProvisioner, fmt.Sprintf("No canary run finished on %s within %s", status.TargetImage, upgradeTimeout(nfsProvisioner)))
+		return nil
+	}
+
+	// The canary runs every interval, so the run after the rollout is started right away by the canary manager
+	if canary != nil && canary.Run == "" && canary.LastRunTime != nil && canary.LastRunTime.Unix() < verifying.Unix() {
+		canary.LastRunTime = nil
+	}
+	return nil
+}
+
+// checkRollback waits for the NFS server to be ready on the previous image again
+func (m *UpgradeManager) checkRollback(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	status := nfsProvisioner.Status.Upgrade
+	ready, message, err := m.rolledOut(ctx, nfsProvisioner, status.CurrentImage)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		m.Log.Info("Waiting for the rollback of the NFS server", "Image", status.CurrentImage, "Reason", message)
+		return nil
+	}
+
+	// The reason of the rollback stays the message of the upgrade
+	reason := status.Message
+	m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseRolledBack, "The NFS server runs "+status.CurrentImage+" again")
+	status.Message = reason
+	return nil
+}
+
+// rolledOut reports whether every pod of the NFS server runs image and is available
+func (m *UpgradeManager) rolledOut(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, image string) (bool, string, error) {
+	deployment, err := m.serverDeployment(ctx, nfsProvisioner)
+	if err != nil || deployment == nil {
+		return false, "the Deployment of the NFS server does not exist", err
+	}
+	if len(deployment.Spec.Template.Spec.Containers) == 0 || deployment.Spec.Template.Spec.Containers[0].Image != defaults.ResolveImage(image) {
+		return false, "the Deployment is not updated yet", nil
+	}
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false, "the Deployment is not observed yet", nil
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if deployment.Status.UpdatedReplicas < replicas || deployment.Status.AvailableReplicas < replicas || deployment.Status.Replicas > replicas {
+		return false, fmt.Sprintf("%d of %d pods are updated and %d available", deployment.Status.UpdatedReplicas, replicas, deployment.Status.AvailableReplicas), nil
+	}
+	return true, "", nil
+}
+
+// serverDeployment returns the Deployment of the active NFS server, or nil
+func (m *UpgradeManager) serverDeployment(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (*appsv1.Deployment, error) {
+	name := defaults.Deployment
+	if standbyActive(nfsProvisioner) {
+		name = defaults.StandbyDeployment
+	}
+	deployment := &appsv1.Deployment{}
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: nfsProvisioner.Namespace}, deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// runningImage returns the image of the existing NFS server, so that an existing server is not rolled by the first reconciliation
+func (m *UpgradeManager) runningImage(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) (string, error) {
+	deployment, err := m.serverDeployment(ctx, nfsProvisioner)
+	if err != nil || deployment == nil || len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return "", err
+	}
+	return deployment.Spec.Template.Spec.Containers[0].Image, nil
+}
+
+// rollBack rolls the NFS server back to the current image
+func (m *UpgradeManager) rollBack(nfsProvisioner *cachev1alpha1.NFSProvisioner, reason string) {
+	status := nfsProvisioner.Status.Upgrade
+	m.Log.Info("Rolling back the upgrade of the NFS server", "Image", status.CurrentImage, "Reason", reason)
+	addUpgradeStep(status, cachev1alpha1.UpgradePhaseRollingBack, reason)
+}
+
+// succeed makes the new image the current one
+func (m *UpgradeManager) succeed(nfsProvisioner *cachev1alpha1.NFSProvisioner) {
+	status := nfsProvisioner.Status.Upgrade
+	status.PreviousImage = status.CurrentImage
+	status.CurrentImage = status.TargetImage
+	m.finish(nfsProvisioner, cachev1alpha1.UpgradePhaseSucceeded, "The NFS server runs "+status.CurrentImage)
+}
+
+// finish records the last step of the upgrade
+func (m *UpgradeManager) finish(nfsProvisioner *cachev1alpha1.NFSProvisioner, phase cachev1alpha1.UpgradePhase, message string) {
+	status := nfsProvisioner.Status.Upgrade
+	m.Log.Info("The upgrade of the NFS server finished", "Phase", phase, "Message", message)
+	now := metav1.Now()
+	status.CompletionTime = &now
+	addUpgradeStep(status, phase, message)
+}
+
+// addUpgradeStep enters phase and records it in the steps of the upgrade
+func addUpgradeStep(status *cachev1alpha1.UpgradeStatus, phase cachev1alpha1.UpgradePhase, message string) {
+	status.Phase = phase
+	status.Message = message
+	status.Steps = append(status.Steps, cachev1alpha1.UpgradeStep{Phase: phase, Time: metav1.Now(), Message: message})
+	if len(status.Steps) > maxUpgradeSteps {
+		status.Steps = status.Steps[len(status.Steps)-maxUpgradeSteps:]
+	}
+}
+
+// upgradeStepTime returns when the upgrade entered its current phase
+func upgradeStepTime(status *cachev1alpha1.UpgradeStatus) time.Time {
+	if len(status.Steps) == 0 {
+		return time.Time{}
+	}
+	return status.Steps[len(status.Steps)-1].Time.Time
+}
+
+// upgradeTimeout returns how long each step of an upgrade may take
+func upgradeTimeout(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if config := nfsProvisioner.Spec.Upgrade; config != nil && config.Timeout != nil {
+		return config.Timeout.Duration
+	}
+	return defaults.UpgradeTimeout
+}
+
+// inUpgradeWindow returns true when there is no window, or when now is in the daily window
+func inUpgradeWindow(config *cachev1alpha1.UpgradeConfiguration, now time.Time) bool {
+	if config == nil || config.Window == nil {
+		return true
+	}
+	start, err := time.Parse("15:04", config.Window.Start)
+	if err != nil {
+		return false
+	}
+
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+	// A window that opened yesterday may still be open after midnight
+	for _, opening := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !now.Before(opening) && now.Before(opening.Add(config.Window.Duration.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeServerImage returns the image the NFS server is rolled to, or "" before the first upgrade status.
+// It is the new image during the rollout and its verification, and the current image otherwise.
+func upgradeServerImage(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	status := nfsProvisioner.Status.Upgrade
+	if status == nil || status.CurrentImage == "" || status.ServerImplementation != serverImplementation(nfsProvisioner) {
+		return ""
+	}
+	if status.Phase == cachev1alpha1.UpgradePhaseRolling || status.Phase == cachev1alpha1.UpgradePhaseVerifying {
+		return status.TargetImage
+	}
+	return status.CurrentImage
+}
+
+// serverImplementation returns the implementation of the NFS server
+func serverImplementation(nfsProvisioner *cachev1alpha1.NFSProvisioner) cachev1alpha1.ServerImplementation {
+	if goServer(nfsProvisioner) {
+		return cachev1alpha1.ServerImplementationGo
+	}
+	return cachev1alpha1.ServerImplementationGanesha
+}
+
+// upgradeCheckPodName returns the name of the pod that checks the new image
+func upgradeCheckPodName(nfsProvisioner *cachev1alpha1.NFSProvisioner) string {
+	return truncateName("nfs-upgrade-check-" + nfsProvisioner.Name)
+}
@@ -0,0 +1,199 @@
+package resources
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("UpgradeManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		upgradeManager *UpgradeManager
+	)
+
+	setImage := func(image string) {
+		nfsProvisioner.Spec.NFSImageConfiguration = &cachev1alpha1.ImageConfiguration{Image: &image}
+	}
+
+	// rollOut runs the server on image, as the Deployment controller would
+	rollOut := func(image string) {
+		deployment := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: defaults.Deployment, Namespace: "test-namespace"}, deployment)).To(Succeed())
+		deployment.Spec.Template.Spec.Containers[0].Image = image
+		Expect(c.Update(ctx, deployment)).To(Succeed())
+		deployment.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		Expect(c.Status().Update(ctx, deployment)).To(Succeed())
+	}
+
+	// pullImage reports the image of the check pod as pulled, or waiting with reason
+	pullImage := func(reason string) {
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: upgradeCheckPodName(nfsProvisioner), Namespace: "test-namespace"}, pod)).To(Succeed())
+		status := corev1.ContainerStatus{Name: "image-check"}
+		if reason == "" {
+			status.ImageID = "registry/nfs@sha256:1234"
+		} else {
+			status.State.Waiting = &corev1.ContainerStateWaiting{Reason: reason}
+		}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{status}
+		Expect(c.Status().Update(ctx, pod)).To(Succeed())
+	}
+
+	checkPodExists := func() bool {
+		err := c.Get(ctx, types.NamespacedName{Name: upgradeCheckPodName(nfsProvisioner), Namespace: "test-namespace"}, &corev1.Pod{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec:       cachev1alpha1.NFSProvisionerSpec{SCForNFSPvc: "gp3-csi"},
+		}
+		setImage("registry/nfs:v1")
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: defaults.Deployment, Namespace: "test-namespace"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers:       []corev1.Container{{Name: "nfs-provisioner", Image: "registry/nfs:v1"}},
+					NodeSelector:     map[string]string{"app": "nfs-provisioner"},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+				}},
+			},
+			Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(nfsProvisioner.DeepCopy(), deployment).Build()
+		upgradeManager = NewUpgradeManager(NewBaseResourceManager(c, logr.Discard(), scheme))
+
+		// The first reconciliation records the running image
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Upgrade.CurrentImage).To(Equal("registry/nfs:v1"))
+		Expect(nfsProvisioner.Status.Upgrade.Phase).To(BeEmpty())
+	})
+
+	It("should check the pull and roll the new image out", func() {
+		setImage("registry/nfs:v2")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		status := nfsProvisioner.Status.Upgrade
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhasePullCheck))
+		Expect(status.TargetImage).To(Equal("registry/nfs:v2"))
+
+		// The check pod runs where the server runs, and the server keeps its image meanwhile
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: upgradeCheckPodName(nfsProvisioner), Namespace: "test-namespace"}, pod)).To(Succeed())
+		Expect(pod.Spec.Containers[0].Image).To(Equal("registry/nfs:v2"))
+		Expect(pod.Spec.Containers[0].ImagePullPolicy).To(Equal(corev1.PullAlways))
+		Expect(pod.Spec.NodeSelector).To(HaveKeyWithValue("app", "nfs-provisioner"))
+		Expect(pod.Spec.ImagePullSecrets).To(ConsistOf(corev1.LocalObjectReference{Name: "pull-secret"}))
+		Expect(pod.Labels).NotTo(HaveKey("app"))
+		image, _ := nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("registry/nfs:v1"))
+
+		pullImage("")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseRolling))
+		Expect(checkPodExists()).To(BeFalse())
+		image, _ = nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("registry/nfs:v2"))
+
+		// The server is not ready yet
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseRolling))
+
+		rollOut("registry/nfs:v2")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseSucceeded))
+		Expect(status.CurrentImage).To(Equal("registry/nfs:v2"))
+		Expect(status.PreviousImage).To(Equal("registry/nfs:v1"))
+		Expect(status.CompletionTime).NotTo(BeNil())
+		Expect(status.Steps).To(HaveLen(3))
+	})
+
+	It("should fail when the image can not be pulled and only retry on request", func() {
+		setImage("registry/nfs:missing")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pullImage("ErrImagePull")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		status := nfsProvisioner.Status.Upgrade
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseFailed))
+		Expect(status.Message).To(ContainSubstring("ErrImagePull"))
+		Expect(checkPodExists()).To(BeFalse())
+		image, _ := nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("registry/nfs:v1"))
+
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseFailed))
+
+		nfsProvisioner.Annotations = map[string]string{defaults.UpgradeRetryAnnotation: "true"}
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhasePullCheck))
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.UpgradeRetryAnnotation))
+	})
+
+	It("should roll back when the new image is not ready in time", func() {
+		setImage("registry/nfs:v2")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		pullImage("")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		status := nfsProvisioner.Status.Upgrade
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseRolling))
+
+		// The rollout started longer than the timeout ago
+		status.Steps[len(status.Steps)-1].Time = metav1.NewTime(time.Now().Add(-defaults.UpgradeTimeout - time.Minute))
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseRollingBack))
+		image, _ := nfsServerImage(nfsProvisioner)
+		Expect(image).To(Equal("registry/nfs:v1"))
+
+		rollOut("registry/nfs:v1")
+		Expect(upgradeManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(status.Phase).To(Equal(cachev1alpha1.UpgradePhaseRolledBack))
+		Expect(status.Message).To(ContainSubstring("was not ready on registry/nfs:v2"))
+		Expect(status.CurrentImage).To(Equal("registry/nfs:v1"))
+	})
+
+	It("should only open the window at its daily time", func() {
+		config := &cachev1alpha1.UpgradeConfiguration{Window: &cachev1alpha1.UpgradeWindow{
+			Start:    "23:00",
+			Duration: metav1.Duration{Duration: 2 * time.Hour},
+		}}
+		at := func(hour, minute int) time.Time {
+			return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+		}
+
+		Expect(inUpgradeWindow(nil, at(12, 0))).To(BeTrue())
+		Expect(inUpgradeWindow(config, at(23, 30))).To(BeTrue())
+		Expect(inUpgradeWindow(config, at(0, 59))).To(BeTrue())
+		Expect(inUpgradeWindow(config, at(1, 0))).To(BeFalse())
+		Expect(inUpgradeWindow(config, at(22, 59))).To(BeFalse())
+	})
+})
@@ -0,0 +1,434 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+// UsageReportManager measures the disk usage of every volume for the quotas and the usage report.
+// It summarises the usage per namespace and writes reports on demand.
+type UsageReportManager struct {
+	BaseResourceManager
+}
+
+// NewUsageReportManager creates a new UsageReportManager
+func NewUsageReportManager(base BaseResourceManager) *UsageReportManager {
+	return &UsageReportManager{
+		BaseResourceManager: base,
+	}
+}
+
+// GetResourceName returns the name of the resource this manager handles
+func (m *UsageReportManager) GetResourceName() string {
+	return "UsageReport"
+}
+
+// UsageReport is the content of a JSON usage report
+type UsageReport struct {
+	NFSProvisioner string                         `json:"nfsProvisioner"`
+	Namespace      string                         `json:"namespace"`
+	ScanTime       metav1.Time                    `json:"scanTime"`
+	Namespaces     []cachev1alpha1.NamespaceUsage `json:"namespaces"`
+	Volumes        []cachev1alpha1.VolumeUsage    `json:"volumes"`
+}
+
+// EnsureResource records the last scan, writes a requested report and starts a new scan when the interval is over
+func (m *UsageReportManager) EnsureResource(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	requested := nfsProvisioner.Annotations[defaults.UsageReportAnnotation]
+	if !usageReportEnabled(nfsProvisioner) {
+		// Only the last requested report stays in the status
+		if status := nfsProvisioner.Status.Usage; status != nil && (status.LastScanTime != nil || status.Report == nil) {
+			clearUsageReportMetrics(nfsProvisioner)
+			nfsProvisioner.Status.Usage = nil
+			if status.Report != nil {
+				nfsProvisioner.Status.Usage = &cachev1alpha1.UsageStatus{Report: status.Report}
+			}
+		}
+	}
+	if !usageReportEnabled(nfsProvisioner) && !quotaReady(nfsProvisioner) {
+		clearVolumeUsageMetrics(nfsProvisioner)
+		if requested == "" {
+			return nil
+		}
+	}
+
+	if migrationInProgress(nfsProvisioner) {
+		return nil
+	}
+
+	if nfsProvisioner.Status.Usage == nil && (usageReportEnabled(nfsProvisioner) || requested != "") {
+		nfsProvisioner.Status.Usage = &cachev1alpha1.UsageStatus{}
+	}
+
+	job, err := LatestSucceededJob(ctx, m.Client, nfsProvisioner.Namespace, client.MatchingLabels{defaults.UsageScanLabel: nfsProvisioner.Name})
+	if err != nil {
+		return err
+	}
+	if job != nil {
+		m.recordScan(ctx, nfsProvisioner, job)
+	}
+
+	if requested != "" {
+		return m.writeReport(ctx, nfsProvisioner, job, requested)
+	}
+
+	if !scanDue(nfsProvisioner) {
+		return nil
+	}
+	return m.startScan(ctx, nfsProvisioner)
+}
+
+// recordScan records the volumes measured by the scan Job for the quotas and the usage report that have not seen it yet
+func (m *UsageReportManager) recordScan(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, job *batchv1.Job) {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	completionTime := *job.Status.CompletionTime
+
+	var quota *cachev1alpha1.QuotaStatus
+	if quotaReady(nfsProvisioner) {
+		if nfsProvisioner.Status.Quota == nil {
+			nfsProvisioner.Status.Quota = &cachev1alpha1.QuotaStatus{}
+		}
+		if last := nfsProvisioner.Status.Quota.LastUsageTime; last == nil || completionTime.After(last.Time) {
+			quota = nfsProvisioner.Status.Quota
+			quota.LastUsageTime = &completionTime
+		}
+	}
+	var usage *cachev1alpha1.UsageStatus
+	if usageReportEnabled(nfsProvisioner) {
+		if last := nfsProvisioner.Status.Usage.LastScanTime; last == nil || completionTime.After(last.Time) {
+			usage = nfsProvisioner.Status.Usage
+			usage.LastScanTime = &completionTime
+		}
+	}
+	if quota == nil && usage == nil {
+		return
+	}
+
+	volumes, err := m.readScan(ctx, nfsProvisioner, job)
+	if err != nil {
+		log.Error(err, "Failed to record the usage of the volumes", "Job.Name", job.Name)
+		if usage != nil {
+			usage.Message = err.Error()
+		}
+		return
+	}
+
+	clearVolumeUsageMetrics(nfsProvisioner)
+	for _, volume := range volumes {
+		volumeUsedBytes.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name, volume.PersistentVolume, volume.ClaimNamespace, volume.ClaimName).Set(float64(volume.UsedBytes))
+		volumeCapacityBytes.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name, volume.PersistentVolume, volume.ClaimNamespace, volume.ClaimName).Set(float64(volume.CapacityBytes))
+	}
+	if quota != nil {
+		quota.Volumes = volumes
+	}
+	if usage != nil {
+		m.summariseScan(nfsProvisioner, volumes)
+	}
+}
+
+// summariseScan records the totals, the largest volumes and the usage per namespace of a scan in the status and the metrics
+func (m *UsageReportManager) summariseScan(nfsProvisioner *cachev1alpha1.NFSProvisioner, volumes []cachev1alpha1.VolumeUsage) {
+	status := nfsProvisioner.Status.Usage
+	status.Message = ""
+	status.Volumes = int32(len(volumes))
+	status.CapacityBytes, status.UsedBytes = 0, 0
+	for _, volume := range volumes {
+		status.CapacityBytes += volume.CapacityBytes
+		status.UsedBytes += volume.UsedBytes
+	}
+	status.Namespaces = NamespaceUsages(volumes)
+
+	top := int(defaults.UsageTopConsumers)
+	if config := nfsProvisioner.Spec.UsageReport; config.TopConsumers != nil {
+		top = int(*config.TopConsumers)
+	}
+	status.TopConsumers = nil
+	if top > 0 {
+		status.TopConsumers = volumes[:min(top, len(volumes))]
+	}
+
+	clearUsageReportMetrics(nfsProvisioner)
+	for _, ns := range status.Namespaces {
+		namespaceUsedBytes.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name, ns.Namespace).Set(float64(ns.UsedBytes))
+		namespaceCapacityBytes.WithLabelValues(nfsProvisioner.Namespace, nfsProvisioner.Name, ns.Namespace).Set(float64(ns.CapacityBytes))
+	}
+}
+
+// readScan matches the directories measured by the scan Job with the PVs of the export
+func (m *UsageReportManager) readScan(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, job *batchv1.Job) ([]cachev1alpha1.VolumeUsage, error) {
+	if m.KubeClient == nil {
+		return nil, fmt.Errorf("pod logs can not be read")
+	}
+	logs, err := JobLogs(ctx, m.Client, m.KubeClient, job)
+	if err != nil {
+		return nil, err
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := m.Client.List(ctx, pvs); err != nil {
+		return nil, err
+	}
+	return VolumeUsages(ParseImportScan(logs), pvs.Items, pvExportPath(nfsProvisioner)), nil
+}
+
+// writeReport writes the usage of every volume of the last scan to the report ConfigMap and removes the annotation.
+// Without a scan to report, a scan is started and the annotation is kept until it finished.
+func (m *UsageReportManager) writeReport(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner, job *batchv1.Job, requested string) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+	status := nfsProvisioner.Status.Usage
+
+	format := cachev1alpha1.UsageReportFormat(strings.ToLower(requested))
+	if format != cachev1alpha1.UsageReportCSV && format != cachev1alpha1.UsageReportJSON {
+		status.Message = fmt.Sprintf("Unknown usage report format %q, use csv or json", requested)
+		return removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.UsageReportAnnotation)
+	}
+
+	if job == nil {
+		return m.startScan(ctx, nfsProvisioner)
+	}
+
+	volumes, err := m.readScan(ctx, nfsProvisioner, job)
+	if err != nil {
+		log.Error(err, "Failed to read the usage of the volumes", "Job.Name", job.Name)
+		status.Message = err.Error()
+		return removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.UsageReportAnnotation)
+	}
+
+	report := UsageReport{
+		NFSProvisioner: nfsProvisioner.Name,
+		Namespace:      nfsProvisioner.Namespace,
+		ScanTime:       *job.Status.CompletionTime,
+		Namespaces:     NamespaceUsages(volumes),
+		Volumes:        volumes,
+	}
+	data, err := FormatUsageReport(report, format)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      UsageReportConfigMapName(nfsProvisioner.Name),
+			Namespace: nfsProvisioner.Namespace,
+			Labels:    labelsForNFSProvisioner(nfsProvisioner.Name),
+		},
+		Data: map[string]string{"usage." + string(format): data},
+	}
+	ctrl.SetControllerReference(nfsProvisioner, configMap, m.Scheme)
+
+	configMapFound := &corev1.ConfigMap{}
+	err = m.Client.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}, configMapFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", configMap.Namespace, "ConfigMap.Name", configMap.Name)
+		if err := m.Client.Create(ctx, configMap); err != nil {
+			log.Error(err, "Failed to create a ConfigMap for NFSProvisioner", "ConfigMap.Namespace", configMap.Namespace, "ConfigMap.Name", configMap.Name)
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		log.Info("Updating the ConfigMap", "ConfigMap.Namespace", configMap.Namespace, "ConfigMap.Name", configMap.Name)
+		configMapFound.Data = configMap.Data
+		if err := m.Client.Update(ctx, configMapFound); err != nil {
+			log.Error(err, "Failed to update the ConfigMap for NFSProvisioner", "ConfigMap.Namespace", configMap.Namespace, "ConfigMap.Name", configMap.Name)
+			return err
+		}
+	}
+
+	status.Report = &cachev1alpha1.UsageReportStatus{
+		ConfigMap: configMap.Name,
+		Format:    format,
+		ScanTime:  report.ScanTime,
+	}
+	return removeAnnotations(ctx, m.Client, nfsProvisioner, defaults.UsageReportAnnotation)
+}
+
+// startScan creates a scan Job unless one is already running
+func (m *UsageReportManager) startScan(ctx context.Context, nfsProvisioner *cachev1alpha1.NFSProvisioner) error {
+	log := m.Log.WithValues("resource", m.GetResourceName())
+
+	jobs := &batchv1.JobList{}
+	if err := m.Client.List(ctx, jobs, client.InNamespace(nfsProvisioner.Namespace), client.MatchingLabels{defaults.UsageScanLabel: nfsProvisioner.Name}); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if finished, _ := JobFinished(&jobs.Items[i]); !finished {
+			return nil
+		}
+	}
+
+	scanJob := m.buildScanJob(nfsProvisioner)
+	log.Info("Creating a new Job", "Job.Namespace", scanJob.Namespace, "Job.Name", scanJob.Name)
+	if err := m.Client.Create(ctx, scanJob); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create a Job for NFSProvisioner", "Job.Namespace", scanJob.Namespace, "Job.Name", scanJob.Name)
+		return err
+	}
+	return nil
+}
+
+// buildScanJob returns a Job that measures the disk usage of the directories of the export with the lowest CPU and IO priority.
+// The Job is kept for an interval, so that a report can be written from it without scanning again.
+func (m *UsageReportManager) buildScanJob(nfsProvisioner *cachev1alpha1.NFSProvisioner) *batchv1.Job {
+	container := scanContainer()
+	container.Command = append([]string{"nice", "-n", "19", "ionice", "-c", "3"}, container.Command...)
+
+	ttl := int32((scanInterval(nfsProvisioner) + time.Hour) / time.Second)
+	job := BuildExportJob(nfsProvisioner, truncateName(fmt.Sprintf("nfs-usage-scan-%s-%d", nfsProvisioner.Name, time.Now().Unix())), container)
+	job.Labels[defaults.UsageScanLabel] = nfsProvisioner.Name
+	job.Spec.TTLSecondsAfterFinished = &ttl
+
+	ctrl.SetControllerReference(nfsProvisioner, job, m.Scheme)
+	return job
+}
+
+// VolumeUsages returns the usage of the directories that a PV mounts, largest first
+func VolumeUsages(dirs []ScannedDirectory, pvs []corev1.PersistentVolume, exportPath string) []cachev1alpha1.VolumeUsage {
+	byDirectory := map[string]*corev1.PersistentVolume{}
+	for i := range pvs {
+		// PVs of a subdirectory share the usage of their parent
+		if dir := exportSubPath(&pvs[i], exportPath); dir != "" && !strings.Contains(dir, "/") {
+			byDirectory[dir] = &pvs[i]
+		}
+	}
+
+	volumes := []cachev1alpha1.VolumeUsage{}
+	for _, dir := range dirs {
+		pv, ok := byDirectory[dir.Directory]
+		if !ok {
+			continue
+		}
+		capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+		volume := cachev1alpha1.VolumeUsage{
+			PersistentVolume: pv.Name,
+			Directory:        dir.Directory,
+			CapacityBytes:    capacity.Value(),
+			UsedBytes:        dir.SizeBytes,
+		}
+		if pv.Spec.ClaimRef != nil {
+			volume.ClaimNamespace = pv.Spec.ClaimRef.Namespace
+			volume.ClaimName = pv.Spec.ClaimRef.Name
+		}
+		volumes = append(volumes, volume)
+	}
+
+	sort.SliceStable(volumes, func(i, j int) bool {
+		if volumes[i].UsedBytes != volumes[j].UsedBytes {
+			return volumes[i].UsedBytes > volumes[j].UsedBytes
+		}
+		return volumes[i].PersistentVolume < volumes[j].PersistentVolume
+	})
+	return volumes
+}
+
+// NamespaceUsages sums the usage of the volumes per namespace of their PVC, largest first
+func NamespaceUsages(volumes []cachev1alpha1.VolumeUsage) []cachev1alpha1.NamespaceUsage {
+	byNamespace := map[string]*cachev1alpha1.NamespaceUsage{}
+	for _, volume := range volumes {
+		usage, ok := byNamespace[volume.ClaimNamespace]
+		if !ok {
+			usage = &cachev1alpha1.NamespaceUsage{Namespace: volume.ClaimNamespace}
+			byNamespace[volume.ClaimNamespace] = usage
+		}
+		usage.Volumes++
+		usage.CapacityBytes += volume.CapacityBytes
+		usage.UsedBytes += volume.UsedBytes
+	}
+
+	usages := []cachev1alpha1.NamespaceUsage{}
+	for _, usage := range byNamespace {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].UsedBytes != usages[j].UsedBytes {
+			return usages[i].UsedBytes > usages[j].UsedBytes
+		}
+		return usages[i].Namespace < usages[j].Namespace
+	})
+	return usages
+}
+
+// FormatUsageReport renders the report as CSV, one line per volume, or as JSON
+func FormatUsageReport(report UsageReport, format cachev1alpha1.UsageReportFormat) (string, error) {
+	if format == cachev1alpha1.UsageReportJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		return string(data), err
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"claimNamespace", "claimName", "persistentVolume", "directory", "capacityBytes", "usedBytes"})
+	for _, volume := range report.Volumes {
+		w.Write([]string{
+			volume.ClaimNamespace,
+			volume.ClaimName,
+			volume.PersistentVolume,
+			volume.Directory,
+			strconv.FormatInt(volume.CapacityBytes, 10),
+			strconv.FormatInt(volume.UsedBytes, 10),
+		})
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// UsageReportConfigMapName returns the name of the ConfigMap of the usage report of the NFSProvisioner
+func UsageReportConfigMapName(name string) string {
+	return truncateName("nfs-usage-report-" + name)
+}
+
+// usageReportEnabled returns true when the NFSProvisioner scans the usage of the volumes periodically
+func usageReportEnabled(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	return nfsProvisioner.Spec.UsageReport != nil && nfsProvisioner.Spec.UsageReport.Enabled
+}
+
+// usageScanInterval returns how often the export is scanned for the usage report
+func usageScanInterval(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	if config := nfsProvisioner.Spec.UsageReport; config != nil && config.Interval != nil {
+		return config.Interval.Duration
+	}
+	return defaults.UsageScanInterval
+}
+
+// scanInterval returns the shortest interval of the quotas and the usage report, which the scan Jobs are kept for
+func scanInterval(nfsProvisioner *cachev1alpha1.NFSProvisioner) time.Duration {
+	interval := usageScanInterval(nfsProvisioner)
+	if quotaReady(nfsProvisioner) {
+		interval = min(interval, quotaUsageInterval(nfsProvisioner))
+	}
+	return interval
+}
+
+// scanDue returns true when the quotas or the usage report have not seen a scan for their interval
+func scanDue(nfsProvisioner *cachev1alpha1.NFSProvisioner) bool {
+	due := func(last *metav1.Time, interval time.Duration) bool {
+		return last == nil || time.Since(last.Time) >= interval
+	}
+	if quotaReady(nfsProvisioner) {
+		var last *metav1.Time
+		if nfsProvisioner.Status.Quota != nil {
+			last = nfsProvisioner.Status.Quota.LastUsageTime
+		}
+		if due(last, quotaUsageInterval(nfsProvisioner)) {
+			return true
+		}
+	}
+	return usageReportEnabled(nfsProvisioner) && due(nfsProvisioner.Status.Usage.LastScanTime, usageScanInterval(nfsProvisioner))
+}
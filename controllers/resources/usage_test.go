@@ -0,0 +1,222 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("UsageReportManager", func() {
+	var (
+		ctx            context.Context
+		c              client.Client
+		nfsProvisioner *cachev1alpha1.NFSProvisioner
+		usageManager   *UsageReportManager
+	)
+
+	scanJobs := func() []batchv1.Job {
+		jobs := &batchv1.JobList{}
+		Expect(c.List(ctx, jobs, client.MatchingLabels{defaults.UsageScanLabel: "test-nfs"})).To(Succeed())
+		return jobs.Items
+	}
+
+	finishScan := func(job *batchv1.Job) {
+		now := metav1.Now()
+		job.Status.CompletionTime = &now
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+		Expect(c.Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-abcde", Namespace: "test-namespace", Labels: map[string]string{"job-name": job.Name}},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+		})).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "test-namespace", UID: "test-uid"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				SCForNFSPvc: "gp3-csi",
+				UsageReport: &cachev1alpha1.UsageReportConfiguration{Enabled: true},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(nfsProvisioner.DeepCopy()).Build()
+		Expect(c.Get(ctx, types.NamespacedName{Name: "test-nfs", Namespace: "test-namespace"}, nfsProvisioner)).To(Succeed())
+
+		base := NewBaseResourceManager(c, logr.Discard(), scheme)
+		base.KubeClient = kubefake.NewSimpleClientset()
+		usageManager = NewUsageReportManager(base)
+	})
+
+	It("should scan the export with a low priority once per interval", func() {
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		jobs := scanJobs()
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].Spec.Template.Spec.Containers[0].Command[:6]).To(Equal([]string{"nice", "-n", "19", "ionice", "-c", "3"}))
+		Expect(*jobs[0].Spec.TTLSecondsAfterFinished).To(BeEquivalentTo(7 * 60 * 60))
+
+		// Only one scan runs at a time
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(scanJobs()).To(HaveLen(1))
+
+		finishScan(&jobs[0])
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Usage.LastScanTime).NotTo(BeNil())
+		Expect(nfsProvisioner.Status.Usage.Message).To(BeEmpty())
+		Expect(scanJobs()).To(HaveLen(1))
+	})
+
+	It("should share the scan between the quotas and the usage report", func() {
+		nfsProvisioner.Spec.Quota = &cachev1alpha1.QuotaConfiguration{Enabled: true}
+		meta.SetStatusCondition(&nfsProvisioner.Status.Conditions, metav1.Condition{Type: ConditionQuotaReady, Status: metav1.ConditionTrue, Reason: "Supported"})
+
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		jobs := scanJobs()
+		Expect(jobs).To(HaveLen(1))
+		// The scan Jobs are kept for the shorter interval of the quotas
+		Expect(*jobs[0].Spec.TTLSecondsAfterFinished).To(BeEquivalentTo(2 * 60 * 60))
+
+		finishScan(&jobs[0])
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Quota.LastUsageTime).To(Equal(jobs[0].Status.CompletionTime))
+		Expect(nfsProvisioner.Status.Usage.LastScanTime).To(Equal(jobs[0].Status.CompletionTime))
+		Expect(scanJobs()).To(HaveLen(1))
+
+		// Without the usage report, the scan still runs for the quotas
+		nfsProvisioner.Spec.UsageReport = nil
+		lastUsageTime := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		jobs[0].Status.CompletionTime = &lastUsageTime
+		Expect(c.Status().Update(ctx, &jobs[0])).To(Succeed())
+		nfsProvisioner.Status.Quota.LastUsageTime = &lastUsageTime
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Usage).To(BeNil())
+		Expect(scanDue(nfsProvisioner)).To(BeTrue())
+	})
+
+	It("should write the requested report from the last scan and remove the annotation", func() {
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		finishScan(&scanJobs()[0])
+
+		nfsProvisioner.Annotations = map[string]string{defaults.UsageReportAnnotation: "csv"}
+		Expect(c.Update(ctx, nfsProvisioner)).To(Succeed())
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "nfs-usage-report-test-nfs", Namespace: "test-namespace"}, configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("usage.csv", "claimNamespace,claimName,persistentVolume,directory,capacityBytes,usedBytes\n"))
+		Expect(nfsProvisioner.Status.Usage.Report.ConfigMap).To(Equal("nfs-usage-report-test-nfs"))
+		Expect(nfsProvisioner.Status.Usage.Report.Format).To(Equal(cachev1alpha1.UsageReportCSV))
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.UsageReportAnnotation))
+	})
+
+	It("should scan before a report when the usage report is disabled", func() {
+		nfsProvisioner.Spec.UsageReport = nil
+		nfsProvisioner.Annotations = map[string]string{defaults.UsageReportAnnotation: "json"}
+		Expect(c.Update(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(scanJobs()).To(HaveLen(1))
+		Expect(nfsProvisioner.Annotations).To(HaveKey(defaults.UsageReportAnnotation))
+
+		finishScan(&scanJobs()[0])
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.UsageReportAnnotation))
+		Expect(nfsProvisioner.Status.Usage.LastScanTime).To(BeNil())
+		Expect(nfsProvisioner.Status.Usage.Report.Format).To(Equal(cachev1alpha1.UsageReportJSON))
+
+		// The report stays in the status
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Usage.Report).NotTo(BeNil())
+	})
+
+	It("should refuse an unknown report format", func() {
+		nfsProvisioner.Annotations = map[string]string{defaults.UsageReportAnnotation: "xlsx"}
+		Expect(c.Update(ctx, nfsProvisioner)).To(Succeed())
+
+		Expect(usageManager.EnsureResource(ctx, nfsProvisioner)).To(Succeed())
+		Expect(nfsProvisioner.Status.Usage.Message).To(ContainSubstring(`"xlsx"`))
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.UsageReportAnnotation))
+	})
+
+	It("should summarise the volumes per namespace and render the report", func() {
+		pv := func(name, path, namespace, claim string, capacity string) corev1.PersistentVolume {
+			volume := corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec: corev1.PersistentVolumeSpec{
+					Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)},
+					PersistentVolumeSource: corev1.PersistentVolumeSource{
+						NFS: &corev1.NFSVolumeSource{Server: "172.30.0.10", Path: path},
+					},
+				},
+			}
+			if claim != "" {
+				volume.Spec.ClaimRef = &corev1.ObjectReference{Namespace: namespace, Name: claim}
+			}
+			return volume
+		}
+		pvs := []corev1.PersistentVolume{
+			pv("pvc-a", "/export/team-a-db-pvc-a", "team-a", "db", "10Gi"),
+			pv("pvc-b", "/export/team-a-logs-pvc-b", "team-a", "logs", "1Gi"),
+			pv("pvc-c", "/export/team-b-data-pvc-c", "team-b", "data", "5Gi"),
+			pv("pvc-released", "/export/pvc-released", "", "", "1Gi"),
+			// A share of a subdirectory is counted with its parent
+			pv("pvc-share", "/export/team-a-db-pvc-a/shared", "team-c", "shared", "1Gi"),
+		}
+		dirs := []ScannedDirectory{
+			{Directory: "team-a-db-pvc-a", SizeBytes: 2048},
+			{Directory: "team-a-logs-pvc-b", SizeBytes: 1024},
+			{Directory: "team-b-data-pvc-c", SizeBytes: 4096},
+			{Directory: "pvc-released", SizeBytes: 512},
+			{Directory: "orphan", SizeBytes: 8192},
+		}
+
+		volumes := VolumeUsages(dirs, pvs, defaults.ExportPath)
+		Expect(volumes).To(HaveLen(4))
+		Expect(volumes[0].PersistentVolume).To(Equal("pvc-c"))
+		Expect(volumes[0].ClaimNamespace).To(Equal("team-b"))
+		Expect(volumes[0].ClaimName).To(Equal("data"))
+		Expect(volumes[0].CapacityBytes).To(BeEquivalentTo(5 << 30))
+
+		namespaces := NamespaceUsages(volumes)
+		Expect(namespaces).To(Equal([]cachev1alpha1.NamespaceUsage{
+			{Namespace: "team-b", Volumes: 1, CapacityBytes: 5 << 30, UsedBytes: 4096},
+			{Namespace: "team-a", Volumes: 2, CapacityBytes: 11 << 30, UsedBytes: 3072},
+			{Namespace: "", Volumes: 1, CapacityBytes: 1 << 30, UsedBytes: 512},
+		}))
+
+		report := UsageReport{NFSProvisioner: "test-nfs", Namespace: "test-namespace", ScanTime: metav1.NewTime(time.Now()), Namespaces: namespaces, Volumes: volumes}
+		data, err := FormatUsageReport(report, cachev1alpha1.UsageReportCSV)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(ContainSubstring("\nteam-a,db,pvc-a,team-a-db-pvc-a,10737418240,2048\n"))
+
+		data, err = FormatUsageReport(report, cachev1alpha1.UsageReportJSON)
+		Expect(err).NotTo(HaveOccurred())
+		parsed := UsageReport{}
+		Expect(json.Unmarshal([]byte(data), &parsed)).To(Succeed())
+		Expect(parsed.Volumes).To(Equal(volumes))
+	})
+})
@@ -0,0 +1,461 @@
+package csidriver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// ServerParameter is the StorageClass parameter with the address of the NFS server. The nodes mount it, so it is an IP.
+	ServerParameter = "server"
+	// ShareParameter is the StorageClass parameter with the path the NFS server exports. It defaults to the export of the controller.
+	ShareParameter = "share"
+
+	// The parameters the external-provisioner adds with --extra-create-metadata
+	pvcNameParameter      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceParameter = "csi.storage.k8s.io/pvc/namespace"
+	pvNameParameter       = "csi.storage.k8s.io/pv/name"
+)
+
+// controllerServer creates the volumes and the snapshots as directories of the export
+type controllerServer struct {
+	csi.UnimplementedControllerServer
+	driver *Driver
+	locks  operationLocks
+}
+
+func (s *controllerServer) store() (store, error) {
+	st := newStore(s.driver.ExportPath)
+	if err := st.init(); err != nil {
+		return st, status.Errorf(codes.Unavailable, "export %s is not ready: %v", s.driver.ExportPath, err)
+	}
+	return st, nil
+}
+
+func (s *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	capabilities := []*csi.ControllerServiceCapability{}
+	for _, c := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: c}},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+// CreateVolume creates the directory of the volume, empty or as a copy of a snapshot or another volume.
+// The directory is named like the NFS-Ganesha provisioner names it when the claim is known.
+func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if err := validateCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+	capacity, err := requestedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+
+	params := req.GetParameters()
+	if params[ServerParameter] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter %s is required", ServerParameter)
+	}
+	share := params[ShareParameter]
+	if share == "" {
+		share = s.driver.ExportPath
+	}
+
+	id := volumeID(req.GetName(), params)
+	if !validID(id) {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a valid directory name", id)
+	}
+	if !s.locks.tryLock(id) {
+		return nil, status.Errorf(codes.Aborted, "an operation on volume %s is in progress", id)
+	}
+	defer s.locks.unlock(id)
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &volumeRecord{
+		Name:          req.GetName(),
+		CapacityBytes: capacity,
+		Context:       map[string]string{ServerParameter: params[ServerParameter], ShareParameter: share},
+	}
+	if source := req.GetVolumeContentSource(); source != nil {
+		record.SourceSnapshot = source.GetSnapshot().GetSnapshotId()
+		record.SourceVolume = source.GetVolume().GetVolumeId()
+	}
+
+	existing, err := st.getVolume(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if existing != nil {
+		if existing.Name != record.Name || !capacityFits(existing.CapacityBytes, req.GetCapacityRange()) ||
+			existing.SourceSnapshot != record.SourceSnapshot || existing.SourceVolume != record.SourceVolume {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with another size or source", id)
+		}
+		return &csi.CreateVolumeResponse{Volume: existing.volume(id, req.GetVolumeContentSource())}, nil
+	}
+
+	if err := s.populate(st, id, record); err != nil {
+		return nil, err
+	}
+	if err := st.putVolume(id, record); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.driver.Log.Info("created volume", "volume", id, "name", record.Name, "snapshot", record.SourceSnapshot, "source", record.SourceVolume)
+	return &csi.CreateVolumeResponse{Volume: record.volume(id, req.GetVolumeContentSource())}, nil
+}
+
+// populate creates the directory of a new volume. A copy left by an interrupted call is started over.
+func (s *controllerServer) populate(st store, id string, record *volumeRecord) error {
+	dir := st.volumePath(id)
+
+	source := ""
+	switch {
+	case record.SourceSnapshot != "":
+		snapshot, err := st.getSnapshot(record.SourceSnapshot)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if !validID(record.SourceSnapshot) || snapshot == nil {
+			return status.Errorf(codes.NotFound, "snapshot %s does not exist", record.SourceSnapshot)
+		}
+		source = st.snapshotPath(record.SourceSnapshot)
+	case record.SourceVolume != "":
+		volume, err := st.getVolume(record.SourceVolume)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if !validID(record.SourceVolume) || volume == nil {
+			return status.Errorf(codes.NotFound, "volume %s does not exist", record.SourceVolume)
+		}
+		source = st.volumePath(record.SourceVolume)
+	}
+
+	if source == "" {
+		if err := os.MkdirAll(dir, directoryMode); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		// The umask of the plugin is not applied
+		if err := os.Chmod(dir, directoryMode); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := copyTree(source, dir); err != nil {
+		return status.Errorf(codes.Internal, "failed to copy %s: %v", source, err)
+	}
+	return nil
+}
+
+// DeleteVolume removes the directory of the volume. Snapshots of the volume are copies and stay.
+func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	id := req.GetVolumeId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	// An ID the driver never returned has nothing to delete
+	if !validID(id) {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if !s.locks.tryLock(id) {
+		return nil, status.Errorf(codes.Aborted, "an operation on volume %s is in progress", id)
+	}
+	defer s.locks.unlock(id)
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	record, err := st.getVolume(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// Only the directories of the driver are removed, never one of another provisioner
+	if record == nil {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err := st.deleteVolume(id); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.driver.Log.Info("deleted volume", "volume", id)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ControllerExpandVolume records the new size. A directory has no size of its own, so the node has nothing to do.
+func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	id := req.GetVolumeId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "capacity range is required")
+	}
+	capacity, err := requestedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	if cap := req.GetVolumeCapability(); cap != nil {
+		if err := validateCapabilities([]*csi.VolumeCapability{cap}); err != nil {
+			return nil, err
+		}
+	}
+	if !validID(id) {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", id)
+	}
+	if !s.locks.tryLock(id) {
+		return nil, status.Errorf(codes.Aborted, "an operation on volume %s is in progress", id)
+	}
+	defer s.locks.unlock(id)
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	record, err := st.getVolume(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if record == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", id)
+	}
+
+	// A volume never shrinks
+	if capacity > record.CapacityBytes {
+		record.CapacityBytes = capacity
+		if err := st.putVolume(id, record); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		s.driver.Log.Info("expanded volume", "volume", id, "capacity", capacity)
+	}
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: record.CapacityBytes, NodeExpansionRequired: false}, nil
+}
+
+// CreateSnapshot copies the directory of the volume. The copy is ready when the call returns.
+func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	source := req.GetSourceVolumeId()
+	if source == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume ID is required")
+	}
+	id := req.GetName()
+	if !validID(id) {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a valid directory name", id)
+	}
+	if !s.locks.tryLock(snapshotLock(id)) {
+		return nil, status.Errorf(codes.Aborted, "an operation on snapshot %s is in progress", id)
+	}
+	defer s.locks.unlock(snapshotLock(id))
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := st.getSnapshot(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if existing != nil {
+		if existing.SourceVolume != source {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for volume %s", id, existing.SourceVolume)
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: existing.snapshot(id)}, nil
+	}
+
+	volume, err := st.getVolume(source)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !validID(source) || volume == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", source)
+	}
+
+	// The volume is not written by the driver while it is copied
+	if !s.locks.tryLock(source) {
+		return nil, status.Errorf(codes.Aborted, "an operation on volume %s is in progress", source)
+	}
+	defer s.locks.unlock(source)
+
+	dir := st.snapshotPath(id)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	creationTime := time.Now()
+	if err := copyTree(st.volumePath(source), dir); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to copy volume %s: %v", source, err)
+	}
+	size, err := treeSize(dir)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	record := &snapshotRecord{Name: req.GetName(), SourceVolume: source, CreationTime: creationTime, SizeBytes: size}
+	if err := st.putSnapshot(id, record); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.driver.Log.Info("created snapshot", "snapshot", id, "volume", source, "size", size)
+	return &csi.CreateSnapshotResponse{Snapshot: record.snapshot(id)}, nil
+}
+
+// DeleteSnapshot removes the copy of the snapshot
+func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	id := req.GetSnapshotId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot ID is required")
+	}
+	if !validID(id) {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if !s.locks.tryLock(snapshotLock(id)) {
+		return nil, status.Errorf(codes.Aborted, "an operation on snapshot %s is in progress", id)
+	}
+	defer s.locks.unlock(snapshotLock(id))
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	if err := st.deleteSnapshot(id); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.driver.Log.Info("deleted snapshot", "snapshot", id)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ValidateVolumeCapabilities confirms the capabilities of an existing volume
+func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	id := req.GetVolumeId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+	if !validID(id) {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", id)
+	}
+
+	st, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	record, err := st.getVolume(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if record == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", id)
+	}
+
+	if err := validateCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// volume returns the CSI volume of the record
+func (r *volumeRecord) volume(id string, source *csi.VolumeContentSource) *csi.Volume {
+	return &csi.Volume{
+		VolumeId:      id,
+		CapacityBytes: r.CapacityBytes,
+		VolumeContext: r.Context,
+		ContentSource: source,
+	}
+}
+
+// snapshot returns the CSI snapshot of the record
+func (r *snapshotRecord) snapshot(id string) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     id,
+		SourceVolumeId: r.SourceVolume,
+		SizeBytes:      r.SizeBytes,
+		CreationTime:   timestamppb.New(r.CreationTime),
+		ReadyToUse:     true,
+	}
+}
+
+// volumeID returns the directory of a volume: <namespace>-<claim>-<pv> for a claim, else the name of the request
+func volumeID(name string, params map[string]string) string {
+	if params[pvcNamespaceParameter] != "" && params[pvcNameParameter] != "" && params[pvNameParameter] != "" {
+		return fmt.Sprintf("%s-%s-%s", params[pvcNamespaceParameter], params[pvcNameParameter], params[pvNameParameter])
+	}
+	return name
+}
+
+// snapshotLock keeps the locks of the snapshots apart from the locks of the volumes
+func snapshotLock(id string) string {
+	return "snapshot/" + id
+}
+
+// requestedCapacity returns the size of a new volume: the required bytes, else the limit, else unknown
+func requestedCapacity(capacityRange *csi.CapacityRange) (int64, error) {
+	required := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity can not be negative")
+	}
+	if limit > 0 && required > limit {
+		return 0, status.Errorf(codes.OutOfRange, "required bytes %d exceed the limit %d", required, limit)
+	}
+	if required > 0 {
+		return required, nil
+	}
+	return limit, nil
+}
+
+// capacityFits returns true when an existing volume satisfies the capacity range
+func capacityFits(capacity int64, capacityRange *csi.CapacityRange) bool {
+	if capacity < capacityRange.GetRequiredBytes() {
+		return false
+	}
+	return capacityRange.GetLimitBytes() == 0 || capacity <= capacityRange.GetLimitBytes()
+}
+
+// validateCapabilities accepts file system volumes with any access mode, a NFS directory can be shared by all nodes
+func validateCapabilities(capabilities []*csi.VolumeCapability) error {
+	if len(capabilities) == 0 {
+		return status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+	for _, c := range capabilities {
+		if c.GetBlock() != nil {
+			return status.Error(codes.InvalidArgument, "block volumes are not supported")
+		}
+		if c.GetMount() == nil {
+			return status.Error(codes.InvalidArgument, "access type mount is required")
+		}
+		if c.GetAccessMode() == nil || c.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_UNKNOWN {
+			return status.Error(codes.InvalidArgument, "access mode is required")
+		}
+	}
+	return nil
+}
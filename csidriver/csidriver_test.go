@@ -0,0 +1,188 @@
+package csidriver
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr"
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+)
+
+func TestCSIDriver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CSI Driver Suite")
+}
+
+// fakeMounter remembers the mounts instead of mounting
+type fakeMounter struct {
+	mu     sync.Mutex
+	mounts map[string]string
+}
+
+func (m *fakeMounter) Mount(server, path, target string, options []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mounts == nil {
+		m.mounts = map[string]string{}
+	}
+	m.mounts[target] = server + ":" + path
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMountPoint(target string) (bool, error) {
+	if _, err := os.Stat(target); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.mounts[target]
+	return ok, nil
+}
+
+var (
+	sanityDir    = filepath.Join(os.TempDir(), fmt.Sprintf("csi-sanity-%d", os.Getpid()))
+	sanityServer *grpc.Server
+)
+
+var _ = BeforeSuite(func() {
+	Expect(os.MkdirAll(filepath.Join(sanityDir, "export"), 0755)).To(Succeed())
+	driver := &Driver{
+		Name:       "test.nfs.csi.jhouse.com",
+		Version:    "test",
+		ExportPath: filepath.Join(sanityDir, "export"),
+		NodeID:     "worker-0",
+		Mounter:    &fakeMounter{},
+		Log:        logr.Discard(),
+	}
+	listener, err := Listen("unix://" + filepath.Join(sanityDir, "csi.sock"))
+	Expect(err).NotTo(HaveOccurred())
+	sanityServer = driver.NewServer()
+	go sanityServer.Serve(listener)
+})
+
+var _ = AfterSuite(func() {
+	sanityServer.Stop()
+	os.RemoveAll(sanityDir)
+})
+
+// The csi-sanity suite runs against the controller and the node service of the driver
+var _ = Describe("CSI sanity", func() {
+	config := sanity.NewTestConfig()
+	config.Address = "unix://" + filepath.Join(sanityDir, "csi.sock")
+	config.TargetPath = filepath.Join(sanityDir, "target")
+	config.StagingPath = filepath.Join(sanityDir, "staging")
+	config.TestVolumeParameters = map[string]string{ServerParameter: "172.30.0.10"}
+	config.TestVolumeExpandSize = 2 * config.TestVolumeSize
+	sanity.GinkgoTest(&config)
+})
+
+var _ = Describe("Driver", func() {
+	var (
+		exportPath string
+		controller *controllerServer
+		capability []*csi.VolumeCapability
+	)
+
+	BeforeEach(func() {
+		exportPath = GinkgoT().TempDir()
+		controller = &controllerServer{driver: &Driver{ExportPath: exportPath, Log: logr.Discard()}}
+		capability = []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}}
+	})
+
+	createVolume := func(name string, params map[string]string, source *csi.VolumeContentSource) *csi.Volume {
+		resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:                name,
+			VolumeCapabilities:  capability,
+			Parameters:          params,
+			VolumeContentSource: source,
+			CapacityRange:       &csi.CapacityRange{RequiredBytes: 1 << 30},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return resp.GetVolume()
+	}
+
+	It("should name the directory of a claim like the NFS-Ganesha provisioner", func() {
+		volume := createVolume("pvc-1234", map[string]string{
+			ServerParameter:       "172.30.0.10",
+			pvcNamespaceParameter: "app",
+			pvcNameParameter:      "data",
+			pvNameParameter:       "pvc-1234",
+		}, nil)
+
+		Expect(volume.GetVolumeId()).To(Equal("app-data-pvc-1234"))
+		Expect(volume.GetVolumeContext()).To(Equal(map[string]string{ServerParameter: "172.30.0.10", ShareParameter: exportPath}))
+		info, err := os.Stat(filepath.Join(exportPath, "app-data-pvc-1234"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(fs.FileMode(0777)))
+	})
+
+	It("should restore a snapshot and clone a volume as copies of the directory", func() {
+		params := map[string]string{ServerParameter: "172.30.0.10"}
+		volume := createVolume("pvc-source", params, nil)
+		Expect(os.MkdirAll(filepath.Join(exportPath, volume.GetVolumeId(), "db"), 0500)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(exportPath, volume.GetVolumeId(), "data.txt"), []byte("v1"), 0640)).To(Succeed())
+
+		snapshot, err := controller.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: volume.GetVolumeId()})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot.GetSnapshot().GetReadyToUse()).To(BeTrue())
+		Expect(snapshot.GetSnapshot().GetSizeBytes()).To(BeEquivalentTo(2))
+
+		// The snapshot does not change with the volume
+		Expect(os.WriteFile(filepath.Join(exportPath, volume.GetVolumeId(), "data.txt"), []byte("v2"), 0640)).To(Succeed())
+
+		restored := createVolume("pvc-restored", params, &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot-1"}},
+		})
+		data, err := os.ReadFile(filepath.Join(exportPath, restored.GetVolumeId(), "data.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("v1"))
+		info, err := os.Stat(filepath.Join(exportPath, restored.GetVolumeId(), "db"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(fs.FileMode(0500)))
+
+		clone := createVolume("pvc-clone", params, &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: volume.GetVolumeId()}},
+		})
+		data, err = os.ReadFile(filepath.Join(exportPath, clone.GetVolumeId(), "data.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("v2"))
+	})
+
+	It("should only delete the directories it created", func() {
+		Expect(os.Mkdir(filepath.Join(exportPath, "default-legacy-pvc-1"), 0777)).To(Succeed())
+		_, err := controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "default-legacy-pvc-1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(exportPath, "default-legacy-pvc-1")).To(BeADirectory())
+
+		_, err = controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: ".."})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exportPath).To(BeADirectory())
+	})
+
+	It("should pass the NFS options to the kernel with the address of the server", func() {
+		flags, data := nfsMountOptions(net.ParseIP("172.30.0.10"), []string{"vers=4.1", "noatime,hard", "ro"})
+		Expect(flags).To(Equal(uintptr(unix.MS_NOATIME | unix.MS_RDONLY)))
+		Expect(data).To(Equal("vers=4.1,hard,addr=172.30.0.10"))
+	})
+})
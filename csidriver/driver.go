@@ -0,0 +1,95 @@
+package csidriver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+)
+
+// Driver is the CSI plugin of the operator. It provisions directories on the export of a NFSProvisioner.
+// The controller service runs next to the NFS server and works on the mounted export,
+// the node service runs on every node and mounts the directories over NFS.
+type Driver struct {
+	// Name is the name of the CSIDriver
+	Name string
+	// Version is reported by GetPluginInfo
+	Version string
+	// ExportPath is the export mounted in the controller. The controller service is only served when it is set.
+	ExportPath string
+	// NodeID is the node the plugin runs on. The node service is only served when it is set.
+	NodeID string
+	// Mounter mounts the volumes on the node
+	Mounter Mounter
+	Log     logr.Logger
+}
+
+// Run serves the CSI services on the endpoint, e.g. unix:///csi/csi.sock, until the context is done
+func (d *Driver) Run(ctx context.Context, endpoint string) error {
+	listener, err := Listen(endpoint)
+	if err != nil {
+		return err
+	}
+
+	server := d.NewServer()
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	d.Log.Info("serving CSI", "driver", d.Name, "endpoint", endpoint, "controller", d.ExportPath != "", "node", d.NodeID != "")
+	return server.Serve(listener)
+}
+
+// NewServer returns a gRPC server with the services of the driver
+func (d *Driver) NewServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(d.logCall))
+	csi.RegisterIdentityServer(server, &identityServer{driver: d})
+	if d.ExportPath != "" {
+		csi.RegisterControllerServer(server, &controllerServer{driver: d})
+	}
+	if d.NodeID != "" {
+		csi.RegisterNodeServer(server, &nodeServer{driver: d})
+	}
+	return server
+}
+
+// logCall logs each call and its error. The requests are not logged, they may carry secrets.
+func (d *Driver) logCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	log := d.Log.WithValues("method", info.FullMethod)
+	log.V(1).Info("call")
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Error(err, "call failed")
+	}
+	return resp, err
+}
+
+// Listen listens on a unix:// or tcp:// endpoint. A socket left over by a previous plugin is removed.
+func Listen(endpoint string) (net.Listener, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		address := u.Path
+		if address == "" {
+			address = u.Host
+		}
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", address)
+	case "tcp":
+		return net.Listen("tcp", u.Host)
+	default:
+		return nil, fmt.Errorf("endpoint %q must be unix:// or tcp://", endpoint)
+	}
+}
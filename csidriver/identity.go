@@ -0,0 +1,50 @@
+package csidriver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// identityServer reports the name and the capabilities of the driver
+type identityServer struct {
+	csi.UnimplementedIdentityServer
+	driver *Driver
+}
+
+func (s *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{
+		Name:          s.driver.Name,
+		VendorVersion: s.driver.Version,
+	}, nil
+}
+
+func (s *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	capabilities := []*csi.PluginCapability{{
+		// A directory grows without any change on the node
+		Type: &csi.PluginCapability_VolumeExpansion_{
+			VolumeExpansion: &csi.PluginCapability_VolumeExpansion{Type: csi.PluginCapability_VolumeExpansion_ONLINE},
+		},
+	}}
+	if s.driver.ExportPath != "" {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{Type: csi.PluginCapability_Service_CONTROLLER_SERVICE},
+			},
+		})
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+// Probe reports the controller ready once the export is mounted
+func (s *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	ready := true
+	if s.driver.ExportPath != "" {
+		if err := newStore(s.driver.ExportPath).init(); err != nil {
+			s.driver.Log.Error(err, "export is not ready", "export", s.driver.ExportPath)
+			ready = false
+		}
+	}
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(ready)}, nil
+}
@@ -0,0 +1,97 @@
+package csidriver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/moby/sys/mountinfo"
+	"golang.org/x/sys/unix"
+)
+
+// Mounter mounts NFS exports on the node
+type Mounter interface {
+	// Mount mounts <server>:<path> on the target with the NFS mount options
+	Mount(server, path, target string, options []string) error
+	Unmount(target string) error
+	// IsMountPoint returns true when something is mounted on the target, and an error wrapping fs.ErrNotExist when it does not exist
+	IsMountPoint(target string) (bool, error)
+}
+
+// mountFlags are the options of mount(2) itself, the others are passed to the NFS client of the kernel
+var mountFlags = map[string]uintptr{
+	"ro":         unix.MS_RDONLY,
+	"nosuid":     unix.MS_NOSUID,
+	"nodev":      unix.MS_NODEV,
+	"noexec":     unix.MS_NOEXEC,
+	"sync":       unix.MS_SYNCHRONOUS,
+	"dirsync":    unix.MS_DIRSYNC,
+	"noatime":    unix.MS_NOATIME,
+	"nodiratime": unix.MS_NODIRATIME,
+	"relatime":   unix.MS_RELATIME,
+	"rw":         0,
+	"defaults":   0,
+}
+
+// kernelMounter mounts with mount(2) and the text options of the NFS client of the kernel, so the image needs no mount.nfs
+type kernelMounter struct{}
+
+// NewMounter returns the Mounter of the node plugin
+func NewMounter() Mounter {
+	return kernelMounter{}
+}
+
+func (kernelMounter) Mount(server, path, target string, options []string) error {
+	ip := net.ParseIP(server)
+	if ip == nil {
+		// The kernel does not resolve names
+		addrs, err := net.LookupIP(server)
+		if err != nil || len(addrs) == 0 {
+			return fmt.Errorf("failed to resolve %s: %v", server, err)
+		}
+		ip = addrs[0]
+	}
+
+	flags, data := nfsMountOptions(ip, options)
+	source := server + ":" + path
+	if ip.To4() == nil {
+		source = "[" + server + "]:" + path
+	}
+	return unix.Mount(source, target, "nfs", flags, data)
+}
+
+func (kernelMounter) Unmount(target string) error {
+	return unix.Unmount(target, 0)
+}
+
+func (kernelMounter) IsMountPoint(target string) (bool, error) {
+	return mountinfo.Mounted(target)
+}
+
+// nfsMountOptions splits the mount options into the flags of mount(2) and the data of the NFS client, with the address of the server.
+// Options of mount.nfs that the kernel does not know are dropped.
+func nfsMountOptions(server net.IP, options []string) (uintptr, string) {
+	var flags uintptr
+	data := []string{}
+	hasAddr := false
+	for _, option := range options {
+		for _, o := range strings.Split(option, ",") {
+			o = strings.TrimSpace(o)
+			if o == "" || o == "_netdev" || o == "auto" || o == "noauto" {
+				continue
+			}
+			if flag, ok := mountFlags[o]; ok {
+				flags |= flag
+				continue
+			}
+			if strings.HasPrefix(o, "addr=") {
+				hasAddr = true
+			}
+			data = append(data, o)
+		}
+	}
+	if !hasAddr {
+		data = append(data, "addr="+server.String())
+	}
+	return flags, strings.Join(data, ",")
+}
@@ -0,0 +1,116 @@
+package csidriver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodeServer mounts the directory of a volume from the NFS server into the pods
+type nodeServer struct {
+	csi.UnimplementedNodeServer
+	driver *Driver
+	locks  operationLocks
+}
+
+func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: s.driver.NodeID}, nil
+}
+
+// NodeGetCapabilities reports no capability: a NFS directory is mounted straight into the pod, without staging
+func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{}}, nil
+}
+
+// NodePublishVolume mounts <server>:<share>/<volume> on the target path with the mount options of the StorageClass
+func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	id := req.GetVolumeId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is required")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+	if err := validateCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, err
+	}
+	if !validID(id) {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", id)
+	}
+	server := req.GetVolumeContext()[ServerParameter]
+	share := req.GetVolumeContext()[ShareParameter]
+	if server == "" || share == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume context %s and %s are required", ServerParameter, ShareParameter)
+	}
+
+	if !s.locks.tryLock(target) {
+		return nil, status.Errorf(codes.Aborted, "an operation on %s is in progress", target)
+	}
+	defer s.locks.unlock(target)
+
+	mounted, err := s.driver.Mounter.IsMountPoint(target)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	options := req.GetVolumeCapability().GetMount().GetMountFlags()
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+	dir := path.Join(share, id)
+	if err := s.driver.Mounter.Mount(server, dir, target, options); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to mount %s:%s: %v", server, dir, err)
+	}
+	s.driver.Log.Info("published volume", "volume", id, "server", server, "path", dir, "target", target)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unmounts the target path and removes it
+func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is required")
+	}
+
+	if !s.locks.tryLock(target) {
+		return nil, status.Errorf(codes.Aborted, "an operation on %s is in progress", target)
+	}
+	defer s.locks.unlock(target)
+
+	mounted, err := s.driver.Mounter.IsMountPoint(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		if err := s.driver.Mounter.Unmount(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", target, err)
+		}
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.driver.Log.Info("unpublished volume", "volume", req.GetVolumeId(), "target", target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
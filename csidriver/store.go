@@ -0,0 +1,307 @@
+package csidriver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// metadataDir is the hidden directory on the export with the records and the snapshots of the driver.
+	// The scans of the operator skip hidden directories, so it is never reported as a volume or an orphan.
+	metadataDir = ".csi"
+	// directoryMode lets every client write to a volume, like the directories of the NFS-Ganesha provisioner
+	directoryMode = 0777
+)
+
+// volumeRecord is what the driver knows about a volume besides its directory
+type volumeRecord struct {
+	Name          string            `json:"name"`
+	CapacityBytes int64             `json:"capacityBytes"`
+	Context       map[string]string `json:"context"`
+	// SourceSnapshot or SourceVolume is the content the volume was created from
+	SourceSnapshot string `json:"sourceSnapshot,omitempty"`
+	SourceVolume   string `json:"sourceVolume,omitempty"`
+}
+
+// snapshotRecord describes a snapshot, a copy of the directory of a volume
+type snapshotRecord struct {
+	Name         string    `json:"name"`
+	SourceVolume string    `json:"sourceVolume"`
+	CreationTime time.Time `json:"creationTime"`
+	SizeBytes    int64     `json:"sizeBytes"`
+}
+
+// store keeps the records of the volumes and the snapshots on the export.
+// A volume is the directory named by its ID right below the export, a snapshot a directory below metadataDir.
+type store struct {
+	exportPath string
+}
+
+func newStore(exportPath string) store {
+	return store{exportPath: exportPath}
+}
+
+// init creates the metadata directories
+func (s store) init() error {
+	for _, dir := range []string{"volumes", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(s.exportPath, metadataDir, dir), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumePath returns the directory of the volume
+func (s store) volumePath(id string) string {
+	return filepath.Join(s.exportPath, id)
+}
+
+// snapshotPath returns the copy of the volume in the snapshot
+func (s store) snapshotPath(id string) string {
+	return filepath.Join(s.exportPath, metadataDir, "snapshots", id)
+}
+
+func (s store) volumeRecordPath(id string) string {
+	return filepath.Join(s.exportPath, metadataDir, "volumes", id+".json")
+}
+
+func (s store) snapshotRecordPath(id string) string {
+	return filepath.Join(s.exportPath, metadataDir, "snapshots", id+".json")
+}
+
+// getVolume returns the record of the volume, or nil when there is none
+func (s store) getVolume(id string) (*volumeRecord, error) {
+	record := &volumeRecord{}
+	if found, err := readRecord(s.volumeRecordPath(id), record); !found {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s store) putVolume(id string, record *volumeRecord) error {
+	return writeRecord(s.volumeRecordPath(id), record)
+}
+
+// deleteVolume removes the directory of the volume, then its record
+func (s store) deleteVolume(id string) error {
+	if err := os.RemoveAll(s.volumePath(id)); err != nil {
+		return err
+	}
+	return removeFile(s.volumeRecordPath(id))
+}
+
+// getSnapshot returns the record of the snapshot, or nil when there is none
+func (s store) getSnapshot(id string) (*snapshotRecord, error) {
+	record := &snapshotRecord{}
+	if found, err := readRecord(s.snapshotRecordPath(id), record); !found {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s store) putSnapshot(id string, record *snapshotRecord) error {
+	return writeRecord(s.snapshotRecordPath(id), record)
+}
+
+// deleteSnapshot removes the copy of the snapshot, then its record
+func (s store) deleteSnapshot(id string) error {
+	if err := os.RemoveAll(s.snapshotPath(id)); err != nil {
+		return err
+	}
+	return removeFile(s.snapshotRecordPath(id))
+}
+
+// readRecord reads a JSON record, and returns false when it does not exist
+func readRecord(path string, record interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeRecord replaces a JSON record, so that a crash never leaves half of it
+func writeRecord(path string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// validID returns true when the ID of a volume or a snapshot is a plain, visible directory name
+func validID(id string) bool {
+	return id != "" && len(id) <= 255 && !strings.ContainsAny(id, "/\x00") && !strings.HasPrefix(id, ".")
+}
+
+// copyTree copies the directory src to dst, which must not exist yet.
+// Modes, symbolic links and, when the driver may set them, owners are kept.
+func copyTree(src, dst string) error {
+	// Directories get their mode once their content is copied, a read-only directory would refuse it
+	type directory struct {
+		path string
+		mode fs.FileMode
+	}
+	directories := []directory{}
+
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			directories = append(directories, directory{path: target, mode: fileMode(info)})
+			return chown(target, info)
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return chown(target, info)
+		case entry.Type().IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		default:
+			// Sockets, devices and pipes are not data
+			return nil
+		}
+
+		if err := chown(target, info); err != nil {
+			return err
+		}
+		return os.Chmod(target, fileMode(info))
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		if err := os.Chmod(directories[i].path, directories[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileMode returns the permissions of a file with its setuid, setgid and sticky bits
+func fileMode(info fs.FileInfo) fs.FileMode {
+	return info.Mode().Perm() | info.Mode()&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+}
+
+// copyFile copies the content of a regular file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// chown gives the copy the owner of the original. Without the permission to do so, the copy keeps the owner of the driver.
+func chown(path string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(path, int(stat.Uid), int(stat.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	return nil
+}
+
+// treeSize returns the bytes of the regular files below the directory
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// operationLocks lets only one operation at a time work on a volume or a snapshot
+type operationLocks struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// tryLock returns false when another operation holds the key
+func (l *operationLocks) tryLock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked == nil {
+		l.locked = map[string]bool{}
+	}
+	if l.locked[key] {
+		return false
+	}
+	l.locked[key] = true
+	return true
+}
+
+func (l *operationLocks) unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.locked, key)
+}
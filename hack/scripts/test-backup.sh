@@ -0,0 +1,30 @@
+# Deploy MinIO as a local S3 stand-in, back up the export and restore it.
+oc apply -f ${TEMPLATE_DIR}/minio.yaml
+oc rollout status deployment/minio -n minio
+
+oc create secret generic nfs-backup-credentials -n ${NAMESPACE} \
+  --from-literal=AWS_ACCESS_KEY_ID=minioadmin \
+  --from-literal=AWS_SECRET_ACCESS_KEY=minioadmin \
+  --from-literal=RESTIC_PASSWORD=nfs-backup || true
+
+oc create -n ${NAMESPACE} -f config/samples/cache_v1alpha1_nfsbackup.yaml
+oc wait -n ${NAMESPACE} nfsbackup/nfsbackup-sample --for=jsonpath='{.status.phase}'=Succeeded --timeout=300s
+SNAPSHOT=$(oc get -n ${NAMESPACE} nfsbackup/nfsbackup-sample -o jsonpath='{.status.snapshotID}')
+echo "Snapshot: ${SNAPSHOT}"
+
+# Restore the snapshot into the export
+cat <<EOF | oc create -n ${NAMESPACE} -f -
+apiVersion: cache.jhouse.com/v1alpha1
+kind: NFSBackup
+metadata:
+  name: nfsbackup-sample-restore
+spec:
+  nfsProvisioner: nfsprovisioner-sample
+  repository:
+    endpoint: http://minio.minio.svc:9000
+    bucket: nfs-backup
+    credentialsSecret: nfs-backup-credentials
+  restore:
+    snapshot: ${SNAPSHOT}
+EOF
+oc wait -n ${NAMESPACE} nfsbackup/nfsbackup-sample-restore --for=jsonpath='{.status.phase}'=Succeeded --timeout=300s
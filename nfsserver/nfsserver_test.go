@@ -0,0 +1,139 @@
+package nfsserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNFSServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NFS Server Suite")
+}
+
+var _ = Describe("RelativePath", func() {
+	It("should only accept the export and the paths below it", func() {
+		dir, ok := RelativePath("/export", "/export/")
+		Expect(ok).To(BeTrue())
+		Expect(dir).To(BeEmpty())
+		dir, ok = RelativePath("/export", "/export/app-data-pvc-1")
+		Expect(ok).To(BeTrue())
+		Expect(dir).To(Equal("app-data-pvc-1"))
+
+		_, ok = RelativePath("/export", "/export2")
+		Expect(ok).To(BeFalse())
+		_, ok = RelativePath("/export", "/export/../etc")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Provisioner", func() {
+	var (
+		ctx         context.Context
+		c           client.Client
+		exportPath  string
+		provisioner *Provisioner
+		claim       *corev1.PersistentVolumeClaim
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		exportPath = GinkgoT().TempDir()
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+
+		scName := "nfs"
+		claim = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "app", UID: "1234"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &scName,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			claim,
+			&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "nfs"}, Provisioner: "example.com/nfs"},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "nfs-provisioner", Namespace: "storage"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.10"},
+			},
+		).Build()
+		provisioner = &Provisioner{
+			Client:     c,
+			APIReader:  c,
+			Log:        logr.Discard(),
+			Name:       "example.com/nfs",
+			ExportPath: exportPath,
+			Namespace:  "storage",
+			Service:    "nfs-provisioner",
+		}
+	})
+
+	It("should create the directory and the PV of a claim", func() {
+		_, err := provisioner.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "data", Namespace: "app"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		info, err := os.Stat(filepath.Join(exportPath, "app-data-pvc-1234"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0777)))
+
+		pv := &corev1.PersistentVolume{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "pvc-1234"}, pv)).To(Succeed())
+		Expect(pv.Spec.NFS.Server).To(Equal("10.0.0.10"))
+		Expect(pv.Spec.NFS.Path).To(Equal(filepath.Join(exportPath, "app-data-pvc-1234")))
+		Expect(pv.Spec.MountOptions).To(Equal(DefaultMountOptions))
+		Expect(pv.Spec.ClaimRef.UID).To(BeEquivalentTo("1234"))
+		Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(corev1.PersistentVolumeReclaimDelete))
+	})
+
+	It("should ignore a claim of another provisioner", func() {
+		provisioner.Name = "example.com/other"
+		_, err := provisioner.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "data", Namespace: "app"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "pvc-1234"}, &corev1.PersistentVolume{})).NotTo(Succeed())
+	})
+
+	It("should remove the directory and the PV when the PV is released", func() {
+		_, err := provisioner.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "data", Namespace: "app"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		pv := &corev1.PersistentVolume{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "pvc-1234"}, pv)).To(Succeed())
+		pv.Status.Phase = corev1.VolumeReleased
+		Expect(c.Status().Update(ctx, pv)).To(Succeed())
+
+		_, err = provisioner.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "pvc-1234"}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = os.Stat(filepath.Join(exportPath, "app-data-pvc-1234"))
+		Expect(err).To(MatchError(os.ErrNotExist))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "pvc-1234"}, &corev1.PersistentVolume{})).NotTo(Succeed())
+	})
+
+	It("should take the mount options of the StorageClass", func() {
+		sc := &storagev1.StorageClass{Parameters: map[string]string{"mountOptions": "nfsvers=3,port=2049"}}
+		Expect(MountOptions(sc)).To(Equal([]string{"nfsvers=3", "port=2049"}))
+
+		sc.MountOptions = []string{"nfsvers=3", "hard"}
+		Expect(MountOptions(sc)).To(Equal([]string{"nfsvers=3", "hard"}))
+	})
+})
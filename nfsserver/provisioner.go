@@ -0,0 +1,225 @@
+package nfsserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+const (
+	// provisionedByAnnotation is the provisioner that created a PV
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	// selectedNodeAnnotation is set by the scheduler on a claim of a WaitForFirstConsumer StorageClass
+	selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+	// mountOptionsParameter is the StorageClass parameter with comma separated mount options
+	mountOptionsParameter = "mountOptions"
+)
+
+// DefaultMountOptions are the mount options of a PV when the StorageClass has none.
+// The server only speaks NFSv3 over TCP, and serves MOUNT on the NFS port without a portmapper or a lock manager.
+var DefaultMountOptions = []string{"nfsvers=3", "proto=tcp", "port=2049", "mountport=2049", "nolock"}
+
+// Provisioner creates a directory on the export and a PV for each claim of its StorageClasses, and removes them when the PV is released
+type Provisioner struct {
+	client.Client
+	// APIReader reads the Service without a cluster wide watch
+	APIReader client.Reader
+	Log       logr.Logger
+	// Name is the provisioner of the StorageClasses
+	Name string
+	// ExportPath is the directory the server exports
+	ExportPath string
+	// Namespace and Service are the Service of the server, whose ClusterIP goes into the PVs
+	Namespace string
+	Service   string
+}
+
+// Reconcile provisions a claim, or deletes a released PV
+func (r *Provisioner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// A PV and a claim are never named the same, and PVs are cluster scoped
+	if req.Namespace == "" {
+		return ctrl.Result{}, r.reconcileVolume(ctx, req.Name)
+	}
+	return ctrl.Result{}, r.reconcileClaim(ctx, req.NamespacedName)
+}
+
+// reconcileClaim creates the directory and the PV of an unbound claim of a StorageClass of the provisioner
+func (r *Provisioner) reconcileClaim(ctx context.Context, name types.NamespacedName) error {
+	log := r.Log.WithValues("PersistentVolumeClaim", name)
+
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, name, claim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if claim.Spec.VolumeName != "" || claim.DeletionTimestamp != nil || claim.Spec.StorageClassName == nil {
+		return nil
+	}
+
+	sc := &storagev1.StorageClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: *claim.Spec.StorageClassName}, sc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if sc.Provisioner != r.Name {
+		return nil
+	}
+	// The claim is provisioned once its first pod is scheduled
+	if sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer && claim.Annotations[selectedNodeAnnotation] == "" {
+		return nil
+	}
+
+	pvName := VolumeName(claim)
+	err := r.Get(ctx, types.NamespacedName{Name: pvName}, &corev1.PersistentVolume{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	server, err := r.serverAddress(ctx)
+	if err != nil {
+		return err
+	}
+
+	directory := VolumeDirectory(claim)
+	if err := r.createDirectory(directory); err != nil {
+		return err
+	}
+
+	pv := BuildVolume(claim, sc, r.Name, server, path.Join(r.ExportPath, directory))
+	log.Info("Creating a new PersistentVolume", "PersistentVolume.Name", pv.Name, "Directory", directory)
+	if err := r.Create(ctx, pv); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create the PersistentVolume", "PersistentVolume.Name", pv.Name)
+		return err
+	}
+	return nil
+}
+
+// reconcileVolume removes the directory of a released PV of the provisioner with the Delete reclaim policy, then the PV
+func (r *Provisioner) reconcileVolume(ctx context.Context, name string) error {
+	log := r.Log.WithValues("PersistentVolume", name)
+
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, pv); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if pv.Annotations[provisionedByAnnotation] != r.Name || pv.Spec.NFS == nil ||
+		pv.Status.Phase != corev1.VolumeReleased || pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
+		return nil
+	}
+
+	directory, ok := RelativePath(r.ExportPath, pv.Spec.NFS.Path)
+	// Only a directory right below the export is removed, never the export itself
+	if !ok || directory == "" || strings.Contains(directory, "/") {
+		log.Info("Keeping the directory of the PersistentVolume, it is not a directory of the export", "Path", pv.Spec.NFS.Path)
+	} else {
+		log.Info("Removing the directory of the PersistentVolume", "Directory", directory)
+		if err := os.RemoveAll(filepath.Join(r.ExportPath, directory)); err != nil {
+			return err
+		}
+	}
+
+	if err := r.Delete(ctx, pv); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the PersistentVolume")
+		return err
+	}
+	return nil
+}
+
+// createDirectory creates the directory of a PV, writable by every client
+func (r *Provisioner) createDirectory(directory string) error {
+	dir := filepath.Join(r.ExportPath, directory)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	// The umask of the server is not applied
+	return os.Chmod(dir, 0777)
+}
+
+// serverAddress returns the ClusterIP of the Service of the server
+func (r *Provisioner) serverAddress(ctx context.Context) (string, error) {
+	svc := &corev1.Service{}
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: r.Service, Namespace: r.Namespace}, svc); err != nil {
+		return "", err
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", fmt.Errorf("service %s/%s has no ClusterIP", r.Namespace, r.Service)
+	}
+	return svc.Spec.ClusterIP, nil
+}
+
+// VolumeName returns the PV of the claim, named like a dynamically provisioned PV
+func VolumeName(claim *corev1.PersistentVolumeClaim) string {
+	return "pvc-" + string(claim.UID)
+}
+
+// VolumeDirectory returns the directory of the PV of the claim relative to the export, named like the NFS-Ganesha provisioner names it
+func VolumeDirectory(claim *corev1.PersistentVolumeClaim) string {
+	return fmt.Sprintf("%s-%s-%s", claim.Namespace, claim.Name, VolumeName(claim))
+}
+
+// BuildVolume returns the PV of the claim. It is pre-bound to the claim.
+func BuildVolume(claim *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, provisioner, server, exportPath string) *corev1.PersistentVolume {
+	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
+	if sc.ReclaimPolicy != nil {
+		reclaimPolicy = *sc.ReclaimPolicy
+	}
+
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        VolumeName(claim),
+			Annotations: map[string]string{provisionedByAnnotation: provisioner},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: claim.Spec.Resources.Requests[corev1.ResourceStorage]},
+			AccessModes:                   claim.Spec.AccessModes,
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              sc.Name,
+			MountOptions:                  MountOptions(sc),
+			VolumeMode:                    claim.Spec.VolumeMode,
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  claim.Namespace,
+				Name:       claim.Name,
+				UID:        claim.UID,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: server,
+					Path:   exportPath,
+				},
+			},
+		},
+	}
+}
+
+// MountOptions returns the mount options of the StorageClass, its mountOptions parameter, or DefaultMountOptions
+func MountOptions(sc *storagev1.StorageClass) []string {
+	if len(sc.MountOptions) > 0 {
+		return sc.MountOptions
+	}
+	if options := sc.Parameters[mountOptionsParameter]; options != "" {
+		return strings.Split(options, ",")
+	}
+	return DefaultMountOptions
+}
+
+// SetupWithManager watches the claims and the PVs
+func (r *Provisioner) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nfs-server-provisioner").
+		For(&corev1.PersistentVolumeClaim{}).
+		Watches(&corev1.PersistentVolume{}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
@@ -0,0 +1,112 @@
+package nfsserver
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	nfs "github.com/willscott/go-nfs"
+	nfshelper "github.com/willscott/go-nfs/helpers"
+	"golang.org/x/sys/unix"
+)
+
+// handleLimit is the number of file handles the server keeps. Older handles become stale.
+const handleLimit = 65536
+
+// exportHandler serves the export and its subdirectories. A client mounts the export or the directory of a PV below it.
+type exportHandler struct {
+	nfs.Handler
+	root       billy.Filesystem
+	exportPath string
+}
+
+// NewHandler returns the NFS handler of the export directory, e.g. /export.
+// Mount requests are for paths below exportPath, like the paths in the PVs.
+func NewHandler(exportPath string) nfs.Handler {
+	root := osfs.New(exportPath, osfs.WithBoundOS())
+	handler := &exportHandler{
+		Handler:    nfshelper.NewNullAuthHandler(root),
+		root:       root,
+		exportPath: path.Clean(exportPath),
+	}
+	return nfshelper.NewCachingHandler(handler, handleLimit)
+}
+
+// Mount returns the directory of the mount request
+func (h *exportHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	dir, ok := RelativePath(h.exportPath, string(req.Dirpath))
+	if !ok {
+		return nfs.MountStatusErrNoEnt, nil, nil
+	}
+	if dir == "" {
+		return nfs.MountStatusOk, h.root, []nfs.AuthFlavor{nfs.AuthFlavorNull}
+	}
+
+	info, err := h.root.Stat(dir)
+	if err != nil {
+		return nfs.MountStatusErrNoEnt, nil, nil
+	}
+	if !info.IsDir() {
+		return nfs.MountStatusErrNotDir, nil, nil
+	}
+	fs, err := h.root.Chroot(dir)
+	if err != nil {
+		return nfs.MountStatusErrServerFault, nil, nil
+	}
+	return nfs.MountStatusOk, fs, []nfs.AuthFlavor{nfs.AuthFlavorNull}
+}
+
+// Change returns the attribute changes of the mounted directory, so that paths are relative to it
+func (h *exportHandler) Change(fs billy.Filesystem) billy.Change {
+	if change, ok := fs.(billy.Change); ok {
+		return change
+	}
+	return nil
+}
+
+// FSStat reports the size of the file system of the export, so that df on a client shows it
+func (h *exportHandler) FSStat(ctx context.Context, fs billy.Filesystem, s *nfs.FSStat) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(h.exportPath, &stat); err != nil {
+		return err
+	}
+	blockSize := uint64(stat.Bsize)
+	s.TotalSize = stat.Blocks * blockSize
+	s.FreeSize = stat.Bfree * blockSize
+	s.AvailableSize = stat.Bavail * blockSize
+	s.TotalFiles = stat.Files
+	s.FreeFiles = stat.Ffree
+	s.AvailableFiles = stat.Ffree
+	return nil
+}
+
+// RelativePath returns the path of dir relative to the export, or false when dir is not the export or below it
+func RelativePath(exportPath, dir string) (string, bool) {
+	exportPath = path.Clean(exportPath)
+	dir = path.Clean("/" + dir)
+	if dir == exportPath {
+		return "", true
+	}
+	if !strings.HasPrefix(dir, exportPath+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(dir, exportPath+"/"), true
+}
+
+// Serve serves NFSv3 and its MOUNT protocol on the listener until the context is done
+func Serve(ctx context.Context, listener net.Listener, handler nfs.Handler) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	server := &nfs.Server{Handler: handler, Context: ctx}
+	err := server.Serve(listener)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// NFSProvisionerPath is where the NFSProvisioner webhook is served
+const NFSProvisionerPath = "/mutate-cache-jhouse-com-v1alpha1-nfsprovisioner"
+
+// Only the admission request knows the user, so the webhook records who paused a NFSProvisioner.
+// Without the webhook, the NFSProvisioner is paused all the same and the user is not recorded.
+// +kubebuilder:webhook:path=/mutate-cache-jhouse-com-v1alpha1-nfsprovisioner,mutating=true,failurePolicy=ignore,sideEffects=None,groups=cache.jhouse.com,resources=nfsprovisioners,verbs=create;update,versions=v1alpha1,name=mnfsprovisioner.jhouse.com,admissionReviewVersions=v1
+
+// NFSProvisionerPauseRecorder sets defaults.PausedByAnnotation to the user who paused a NFSProvisioner
+type NFSProvisionerPauseRecorder struct {
+	Log     logr.Logger
+	decoder admission.Decoder
+}
+
+// SetupNFSProvisionerWebhook registers the NFSProvisioner webhook with the webhook server of the manager
+func SetupNFSProvisionerWebhook(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(NFSProvisionerPath, &webhook.Admission{
+		Handler: &NFSProvisionerPauseRecorder{
+			Log:     ctrl.Log.WithName("webhooks").WithName("NFSProvisioner"),
+			decoder: admission.NewDecoder(mgr.GetScheme()),
+		},
+	})
+}
+
+// Handle records the user of the request when it pauses the NFSProvisioner
+func (r *NFSProvisionerPauseRecorder) Handle(ctx context.Context, req admission.Request) admission.Response {
+	nfsProvisioner := &cachev1alpha1.NFSProvisioner{}
+	if err := r.decoder.Decode(req, nfsProvisioner); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var oldNFSProvisioner *cachev1alpha1.NFSProvisioner
+	if len(req.OldObject.Raw) > 0 {
+		oldNFSProvisioner = &cachev1alpha1.NFSProvisioner{}
+		if err := r.decoder.DecodeRaw(req.OldObject, oldNFSProvisioner); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	if !RecordPausedBy(nfsProvisioner, oldNFSProvisioner, req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+	r.Log.Info("Recording the pause", "NFSProvisioner.Namespace", nfsProvisioner.Namespace, "NFSProvisioner.Name", nfsProvisioner.Name,
+		"Mode", resources.PauseMode(nfsProvisioner), "User", req.UserInfo.Username)
+
+	raw, err := json.Marshal(nfsProvisioner)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
+
+// RecordPausedBy sets defaults.PausedByAnnotation to username when the request pauses the NFSProvisioner or changes
+// its pause mode. While the mode stays the same, the recorded user is kept, and the annotation can not be forged.
+// It returns true when the annotations were changed.
+func RecordPausedBy(nfsProvisioner, oldNFSProvisioner *cachev1alpha1.NFSProvisioner, username string) bool {
+	mode := resources.PauseMode(nfsProvisioner)
+
+	by, recorded := username, mode != ""
+	if mode != "" && oldNFSProvisioner != nil && resources.PauseMode(oldNFSProvisioner) == mode {
+		by, recorded = oldNFSProvisioner.Annotations[defaults.PausedByAnnotation]
+	}
+
+	current, found := nfsProvisioner.Annotations[defaults.PausedByAnnotation]
+	if found == recorded && current == by {
+		return false
+	}
+	if !recorded {
+		delete(nfsProvisioner.Annotations, defaults.PausedByAnnotation)
+		return true
+	}
+	if nfsProvisioner.Annotations == nil {
+		nfsProvisioner.Annotations = map[string]string{}
+	}
+	nfsProvisioner.Annotations[defaults.PausedByAnnotation] = by
+	return true
+}
@@ -0,0 +1,52 @@
+package webhooks
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/defaults"
+)
+
+var _ = Describe("NFSProvisioner webhook", func() {
+	var nfsProvisioner *cachev1alpha1.NFSProvisioner
+
+	BeforeEach(func() {
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "nfs"},
+		}
+	})
+
+	It("should record the user who pauses the NFSProvisioner", func() {
+		old := nfsProvisioner.DeepCopy()
+		nfsProvisioner.Spec.Paused = true
+		Expect(RecordPausedBy(nfsProvisioner, old, "alice")).To(BeTrue())
+		Expect(nfsProvisioner.Annotations).To(HaveKeyWithValue(defaults.PausedByAnnotation, "alice"))
+
+		// Another user can not take over or forge the pause
+		old = nfsProvisioner.DeepCopy()
+		nfsProvisioner.Annotations[defaults.PausedByAnnotation] = "mallory"
+		Expect(RecordPausedBy(nfsProvisioner, old, "bob")).To(BeTrue())
+		Expect(nfsProvisioner.Annotations).To(HaveKeyWithValue(defaults.PausedByAnnotation, "alice"))
+		Expect(RecordPausedBy(nfsProvisioner, old, "bob")).To(BeFalse())
+
+		// A maintenance is a new pause
+		old = nfsProvisioner.DeepCopy()
+		nfsProvisioner.Spec.Maintenance = &cachev1alpha1.MaintenanceConfiguration{Enabled: true}
+		Expect(RecordPausedBy(nfsProvisioner, old, "bob")).To(BeTrue())
+		Expect(nfsProvisioner.Annotations).To(HaveKeyWithValue(defaults.PausedByAnnotation, "bob"))
+
+		old = nfsProvisioner.DeepCopy()
+		nfsProvisioner.Spec.Paused = false
+		nfsProvisioner.Spec.Maintenance = nil
+		Expect(RecordPausedBy(nfsProvisioner, old, "bob")).To(BeTrue())
+		Expect(nfsProvisioner.Annotations).NotTo(HaveKey(defaults.PausedByAnnotation))
+	})
+
+	It("should pause with the annotation", func() {
+		nfsProvisioner.Annotations = map[string]string{defaults.PausedAnnotation: "true"}
+		Expect(RecordPausedBy(nfsProvisioner, nil, "alice")).To(BeTrue())
+		Expect(nfsProvisioner.Annotations).To(HaveKeyWithValue(defaults.PausedByAnnotation, "alice"))
+	})
+})
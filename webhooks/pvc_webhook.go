@@ -0,0 +1,245 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+	"github.com/jooho/nfs-provisioner-operator/controllers/resources"
+)
+
+// PersistentVolumeClaimPath is where the PVC webhook is served
+const PersistentVolumeClaimPath = "/validate-v1-persistentvolumeclaim"
+
+const (
+	// defaultStorageClassAnnotation marks the default StorageClass of the cluster
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// betaDefaultStorageClassAnnotation is the former annotation of the default StorageClass
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// The webhook ignores failures, so that a stopped operator does not block the PVCs of every StorageClass.
+// +kubebuilder:webhook:path=/validate-v1-persistentvolumeclaim,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=persistentvolumeclaims,verbs=create;update,versions=v1,name=vpersistentvolumeclaim.jhouse.com,admissionReviewVersions=v1
+
+// PersistentVolumeClaimValidator enforces the admission limits of a NFSProvisioner on the PVCs of its StorageClass
+type PersistentVolumeClaimValidator struct {
+	Client  client.Client
+	Log     logr.Logger
+	decoder admission.Decoder
+}
+
+// SetupPersistentVolumeClaimWebhook registers the PVC webhook with the webhook server of the manager
+func SetupPersistentVolumeClaimWebhook(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(PersistentVolumeClaimPath, &webhook.Admission{
+		Handler: &PersistentVolumeClaimValidator{
+			Client:  mgr.GetClient(),
+			Log:     ctrl.Log.WithName("webhooks").WithName("PersistentVolumeClaim"),
+			decoder: admission.NewDecoder(mgr.GetScheme()),
+		},
+	})
+}
+
+// Handle validates a created or updated PVC
+func (v *PersistentVolumeClaimValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := v.decoder.Decode(req, claim); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var oldClaim *corev1.PersistentVolumeClaim
+	if len(req.OldObject.Raw) > 0 {
+		oldClaim = &corev1.PersistentVolumeClaim{}
+		if err := v.decoder.DecodeRaw(req.OldObject, oldClaim); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	nfsProvisioner, err := v.nfsProvisionerFor(ctx, claim)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if nfsProvisioner == nil || (nfsProvisioner.Spec.Admission == nil && !resources.MaintenanceEnabled(nfsProvisioner)) {
+		return admission.Allowed("")
+	}
+
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := v.Client.List(ctx, claims, client.InNamespace(claim.Namespace)); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if err := ValidateClaim(nfsProvisioner, claim, oldClaim, claims.Items); err != nil {
+		v.Log.Info("Refusing the PVC", "PersistentVolumeClaim.Namespace", claim.Namespace, "PersistentVolumeClaim.Name", claim.Name, "reason", err.Error())
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// nfsProvisionerFor returns the NFSProvisioner whose StorageClass, or the StorageClass of its CSI driver, the PVC uses, or nil.
+// A PVC without a StorageClass uses the default StorageClass of the cluster.
+func (v *PersistentVolumeClaimValidator) nfsProvisionerFor(ctx context.Context, claim *corev1.PersistentVolumeClaim) (*cachev1alpha1.NFSProvisioner, error) {
+	storageClassName := claimStorageClassName(claim)
+	if storageClassName == "" {
+		// An empty StorageClass asks for a PV without a class
+		if claim.Spec.StorageClassName != nil {
+			return nil, nil
+		}
+		defaultClass, err := v.defaultStorageClassName(ctx)
+		if err != nil || defaultClass == "" {
+			return nil, err
+		}
+		storageClassName = defaultClass
+	}
+
+	nfsProvisioners := &cachev1alpha1.NFSProvisionerList{}
+	if err := v.Client.List(ctx, nfsProvisioners); err != nil {
+		return nil, err
+	}
+	for i := range nfsProvisioners.Items {
+		for _, name := range resources.StorageClassNames(&nfsProvisioners.Items[i]) {
+			if name == storageClassName {
+				return &nfsProvisioners.Items[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// defaultStorageClassName returns the default StorageClass of the cluster, the newest one when several are marked, or ""
+func (v *PersistentVolumeClaimValidator) defaultStorageClassName(ctx context.Context) (string, error) {
+	storageClasses := &storagev1.StorageClassList{}
+	if err := v.Client.List(ctx, storageClasses); err != nil {
+		return "", err
+	}
+
+	var newest *storagev1.StorageClass
+	for i := range storageClasses.Items {
+		sc := &storageClasses.Items[i]
+		if sc.Annotations[defaultStorageClassAnnotation] != "true" && sc.Annotations[betaDefaultStorageClassAnnotation] != "true" {
+			continue
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&sc.CreationTimestamp) {
+			newest = sc
+		}
+	}
+	if newest == nil {
+		return "", nil
+	}
+	return newest.Name, nil
+}
+
+// ValidateClaim checks a PVC against the admission limits of the NFSProvisioner, and refuses new PVCs during a maintenance.
+// oldClaim is nil on creation, and claims are the PVCs of the namespace of the PVC.
+func ValidateClaim(nfsProvisioner *cachev1alpha1.NFSProvisioner, claim, oldClaim *corev1.PersistentVolumeClaim, claims []corev1.PersistentVolumeClaim) error {
+	// The existing PVCs are not blocked, so that their pods can be deleted or recreated
+	if oldClaim == nil && resources.MaintenanceEnabled(nfsProvisioner) {
+		storageClassName := claimStorageClassName(claim)
+		if storageClassName == "" {
+			storageClassName = resources.StorageClassName(nfsProvisioner)
+		}
+		message := fmt.Sprintf("StorageClass %s of NFSProvisioner %s/%s is under maintenance", storageClassName, nfsProvisioner.Namespace, nfsProvisioner.Name)
+		if reason := nfsProvisioner.Spec.Maintenance.Reason; reason != "" {
+			message += ": " + reason
+		}
+		return fmt.Errorf("%s", message)
+	}
+
+	limits := nfsProvisioner.Spec.Admission
+	if limits == nil {
+		return nil
+	}
+	requested := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	// An update is only checked when it asks for more storage
+	var previous resource.Quantity
+	if oldClaim != nil {
+		previous = oldClaim.Spec.Resources.Requests[corev1.ResourceStorage]
+		if requested.Cmp(previous) <= 0 {
+			return nil
+		}
+	}
+
+	if oldClaim == nil && len(limits.AllowedAccessModes) > 0 {
+		for _, mode := range claim.Spec.AccessModes {
+			if !containsAccessMode(limits.AllowedAccessModes, mode) {
+				return fmt.Errorf("access mode %s is not allowed by NFSProvisioner %s/%s, allowed access modes are %v", mode, nfsProvisioner.Namespace, nfsProvisioner.Name, limits.AllowedAccessModes)
+			}
+		}
+	}
+
+	if limits.MaxClaimSize != nil && requested.Cmp(*limits.MaxClaimSize) > 0 {
+		return fmt.Errorf("requested size %s is larger than the maximum size %s of NFSProvisioner %s/%s", requested.String(), limits.MaxClaimSize.String(), nfsProvisioner.Namespace, nfsProvisioner.Name)
+	}
+
+	if limits.MaxNamespaceCapacity != nil {
+		// The PVCs of both StorageClasses of the NFSProvisioner use its export
+		storageClassNames := resources.StorageClassNames(nfsProvisioner)
+		total := requested.DeepCopy()
+		for i := range claims {
+			other := &claims[i]
+			if other.Name == claim.Name || other.DeletionTimestamp != nil || !slices.Contains(storageClassNames, claimStorageClassName(other)) {
+				continue
+			}
+			total.Add(other.Spec.Resources.Requests[corev1.ResourceStorage])
+		}
+		if total.Cmp(*limits.MaxNamespaceCapacity) > 0 {
+			return fmt.Errorf("the PVCs of namespace %s would request %s, more than the maximum capacity %s of NFSProvisioner %s/%s", claim.Namespace, total.String(), limits.MaxNamespaceCapacity.String(), nfsProvisioner.Namespace, nfsProvisioner.Name)
+		}
+	}
+
+	// The free space is unknown until the first capacity check has finished
+	export := nfsProvisioner.Status.Export
+	if limits.RequireFreeSpace && export != nil && export.LastCheckTime != nil {
+		growth := requested.DeepCopy()
+		growth.Sub(previous)
+		if growth.Value() > export.AvailableBytes {
+			return fmt.Errorf("requested size %s is larger than the free space %s of the export of NFSProvisioner %s/%s", growth.String(), resource.NewQuantity(export.AvailableBytes, resource.BinarySI).String(), nfsProvisioner.Namespace, nfsProvisioner.Name)
+		}
+	}
+
+	return nil
+}
+
+// claimStorageClassName returns the StorageClass of a PVC, including the deprecated annotation
+func claimStorageClassName(claim *corev1.PersistentVolumeClaim) string {
+	if claim.Spec.StorageClassName != nil {
+		return *claim.Spec.StorageClassName
+	}
+	return claim.Annotations[corev1.BetaStorageClassAnnotation]
+}
+
+// containsAccessMode returns true when the access mode is in the list
+func containsAccessMode(modes []corev1.PersistentVolumeAccessMode, mode corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
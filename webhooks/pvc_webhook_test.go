@@ -0,0 +1,163 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cachev1alpha1 "github.com/jooho/nfs-provisioner-operator/api/v1alpha1"
+)
+
+func TestWebhooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Webhook Suite")
+}
+
+func newClaim(name, size string, modes ...corev1.PersistentVolumeAccessMode) *corev1.PersistentVolumeClaim {
+	storageClassName := "nfs"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			AccessModes:      modes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+	}
+}
+
+var _ = Describe("PersistentVolumeClaim webhook", func() {
+	var nfsProvisioner *cachev1alpha1.NFSProvisioner
+
+	BeforeEach(func() {
+		maxClaimSize := resource.MustParse("10Gi")
+		maxNamespaceCapacity := resource.MustParse("15Gi")
+		nfsProvisioner = &cachev1alpha1.NFSProvisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-nfs", Namespace: "nfs"},
+			Spec: cachev1alpha1.NFSProvisionerSpec{
+				Admission: &cachev1alpha1.AdmissionConfiguration{
+					MaxClaimSize:         &maxClaimSize,
+					MaxNamespaceCapacity: &maxNamespaceCapacity,
+					AllowedAccessModes:   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+					RequireFreeSpace:     true,
+				},
+			},
+		}
+	})
+
+	It("should enforce the size and access mode limits", func() {
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), nil, nil)).To(Succeed())
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "11Gi", corev1.ReadWriteMany), nil, nil)).To(MatchError(ContainSubstring("maximum size")))
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "1Gi", corev1.ReadWriteOnce), nil, nil)).To(MatchError(ContainSubstring("access mode")))
+	})
+
+	It("should enforce the capacity of the namespace", func() {
+		others := []corev1.PersistentVolumeClaim{*newClaim("b", "8Gi"), *newClaim("c", "8Gi")}
+		others[1].Spec.StorageClassName = nil
+
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "7Gi", corev1.ReadWriteMany), nil, others)).To(Succeed())
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "8Gi", corev1.ReadWriteMany), nil, others)).To(MatchError(ContainSubstring("maximum capacity")))
+	})
+
+	It("should refuse claims larger than the free space of the export", func() {
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), nil, nil)).To(Succeed())
+
+		nfsProvisioner.Status.Export = &cachev1alpha1.ExportCapacityStatus{
+			LastCheckTime:  &metav1.Time{Time: time.Now()},
+			AvailableBytes: 4 * 1024 * 1024 * 1024,
+		}
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), nil, nil)).To(MatchError(ContainSubstring("free space")))
+
+		// Growing a claim only needs the additional space
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), newClaim("a", "2Gi", corev1.ReadWriteMany), nil)).To(Succeed())
+	})
+
+	It("should refuse new claims during a maintenance", func() {
+		nfsProvisioner.Spec.Admission = nil
+		nfsProvisioner.Spec.Maintenance = &cachev1alpha1.MaintenanceConfiguration{Enabled: true, Reason: "disk replacement"}
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), nil, nil)).To(MatchError(ContainSubstring("under maintenance: disk replacement")))
+
+		// Existing claims can still be updated
+		Expect(ValidateClaim(nfsProvisioner, newClaim("a", "5Gi", corev1.ReadWriteMany), newClaim("a", "2Gi", corev1.ReadWriteMany), nil)).To(Succeed())
+	})
+
+	newValidator := func(objects ...client.Object) *PersistentVolumeClaimValidator {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(storagev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objects, nfsProvisioner)...).Build()
+		return &PersistentVolumeClaimValidator{Client: c, Log: logr.Discard(), decoder: admission.NewDecoder(scheme)}
+	}
+
+	request := func(claim *corev1.PersistentVolumeClaim) admission.Request {
+		raw, err := json.Marshal(claim)
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	withStorageClass := func(claim *corev1.PersistentVolumeClaim, storageClassName *string) *corev1.PersistentVolumeClaim {
+		claim.Spec.StorageClassName = storageClassName
+		return claim
+	}
+
+	It("should only validate the claims of the StorageClass", func() {
+		validator := newValidator()
+
+		response := validator.Handle(context.Background(), request(newClaim("a", "20Gi", corev1.ReadWriteMany)))
+		Expect(response.Allowed).To(BeFalse())
+
+		storageClassName := "gp3"
+		response = validator.Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteOnce), &storageClassName)))
+		Expect(response.Allowed).To(BeTrue())
+	})
+
+	It("should validate the claims of the StorageClass of the CSI driver", func() {
+		storageClassName := "nfs-csi"
+		response := newValidator().Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteMany), &storageClassName)))
+		Expect(response.Allowed).To(BeTrue())
+
+		nfsProvisioner.Spec.CSI = &cachev1alpha1.CSIConfiguration{Enabled: true}
+		response = newValidator().Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteMany), &storageClassName)))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring("maximum size"))
+
+		// Both StorageClasses count for the capacity of the namespace
+		others := []corev1.PersistentVolumeClaim{*newClaim("b", "8Gi")}
+		Expect(ValidateClaim(nfsProvisioner, withStorageClass(newClaim("a", "8Gi", corev1.ReadWriteMany), &storageClassName), nil, others)).To(MatchError(ContainSubstring("maximum capacity")))
+	})
+
+	It("should validate the claims without a StorageClass when the StorageClass is the default", func() {
+		defaultClass := &storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "nfs", Annotations: map[string]string{"storageclass.kubernetes.io/is-default-class": "true"}},
+			Provisioner: "example.com/nfs",
+		}
+		response := newValidator(defaultClass).Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteMany), nil)))
+		Expect(response.Allowed).To(BeFalse())
+
+		// Without a default StorageClass, or with an empty StorageClass, the claim is not of the NFSProvisioner
+		response = newValidator().Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteMany), nil)))
+		Expect(response.Allowed).To(BeTrue())
+		empty := ""
+		response = newValidator(defaultClass).Handle(context.Background(), request(withStorageClass(newClaim("a", "20Gi", corev1.ReadWriteMany), &empty)))
+		Expect(response.Allowed).To(BeTrue())
+	})
+})